@@ -5,6 +5,7 @@ dispatcher and thence to the worker pool.
 package main
 
 import (
+	"context"
 	"flag"
 	"log/slog"
 	"os"
@@ -19,6 +20,7 @@ var totalRequests int
 var logger *slog.Logger
 var waitAvgSeconds float64
 var waitStdDevSeconds float64
+var retries int
 
 // Setup Flags to receive values from CLI arguments and start up logger
 func startUp() {
@@ -29,6 +31,7 @@ func startUp() {
 	flag.IntVar(&totalRequests, "requests", 200, "Total number of requests to send")
 	flag.Float64Var(&waitAvgSeconds, "wait-avg", 1.0, "Average wait time between requests in seconds")
 	flag.Float64Var(&waitStdDevSeconds, "wait-stddev", 0.5, "Standard deviation of time between requests in seconds")
+	flag.IntVar(&retries, "retries", 0, "Number of times to retry requests rejected by the dispatcher")
 	flag.Parse()
 
 	if waitStdDevSeconds <= 0 || waitAvgSeconds <= 0 {
@@ -54,6 +57,13 @@ func main() {
 	defer saveSettings()
 	logger = slog.New(slog.NewJSONHandler(logFile, nil))
 
+	shutdownTracing, err := setupTracing(context.Background())
+	if err != nil {
+		logger.Error("Error setting up tracing", "error", err)
+		os.Exit(1)
+	}
+	defer shutdownTracing(context.Background())
+
 	// Start concurrent producers
 	c := make(chan int)
 	wg := sync.WaitGroup{}
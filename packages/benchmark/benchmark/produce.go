@@ -1,15 +1,23 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"math/rand"
 	"net/http"
 	"os"
 	"sync"
 	"time"
+
+	"dispatcherclient"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type produceSettings struct {
@@ -19,74 +27,107 @@ type produceSettings struct {
 	TotalRequests     int     `json:"total_requests"`
 	WaitAvgSeconds    float64 `json:"wait_avg_seconds"`
 	WaitStdDevSeconds float64 `json:"wait_std_dev_seconds"`
+	Retries           int     `json:"retries"`
 }
 
 type producer struct {
-	rng *rand.Rand
+	rng    *rand.Rand
+	client *dispatcherclient.Client
 }
 
-type taskRequest struct {
-	TaskID       string `json:"task_id"`
-	TaskType     string `json:"task_type"`
-	Label        string `json:"label"`
-	Parameters   string `json:"parameters_json"`
-	ReturnResult bool   `json:"return_result"`
-}
-
-// Initialize a new producer with a random number generator and wait parameters
+// Initialize a new producer with a random number generator and a dispatcher client. The trace context
+// of each request is propagated with the traceparent header.
 func newProducer() *producer {
 	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	client := dispatcherclient.New(
+		os.Getenv("DISPATCHER_URL"),
+		dispatcherclient.WithRetryPolicy(retryPolicy()),
+		dispatcherclient.WithRequestHook(func(r *http.Request) {
+			otel.GetTextMapPropagator().Inject(r.Context(), propagation.HeaderCarrier(r.Header))
+		}),
+	)
 	return &producer{
-		rng: rng,
+		rng:    rng,
+		client: client,
 	}
 }
 
-// Create a task request with some random parameters
-func (p *producer) createTaskRequest() *taskRequest {
+// Retry policy for the producers. Retries are disabled by default, so that rejected requests show up in the results.
+func retryPolicy() dispatcherclient.RetryPolicy {
+	if retries <= 0 {
+		return dispatcherclient.NoRetry
+	}
+	p := dispatcherclient.DefaultRetryPolicy
+	p.MaxAttempts = retries + 1
+	return p
+}
+
+// Create a task with some random parameters, and whether to wait for its result
+func (p *producer) createTask() (dispatcherclient.Task, bool) {
 	taskId := fmt.Sprintf("%d-%d", p.rng.Intn(1000000), p.rng.Intn(1000000))
 
-	tr := taskRequest{
-		TaskID:       taskId,
-		ReturnResult: p.rng.Intn(2) == 0,
-		Label:        fmt.Sprintf("label-%d", p.rng.Intn(numLabels)),
-		Parameters:   "{}",
-		TaskType:     fmt.Sprintf("sample_task_%d", p.rng.Intn(2)+1),
+	t := dispatcherclient.Task{
+		ID:         taskId,
+		Label:      fmt.Sprintf("label-%d", p.rng.Intn(numLabels)),
+		Parameters: "{}",
+		Type:       fmt.Sprintf("sample_task_%d", p.rng.Intn(2)+1),
 	}
 
-	return &tr
+	return t, p.rng.Intn(2) == 0
 }
 
-// Run a single request to the dispatcher
+// Run a single request to the dispatcher. Each request starts a new trace.
 func (p *producer) runRequest() {
-	tr := p.createTaskRequest()
+	tr, returnResult := p.createTask()
 	var ep string
-	if tr.ReturnResult {
+	if returnResult {
 		ep = "run-task"
 	} else {
 		ep = "send-task"
 	}
-	url := fmt.Sprintf("%s/%s", os.Getenv("DISPATCHER_URL"), ep)
-	jsonBody, jsonErr := json.Marshal(tr)
-	if jsonErr != nil {
-		logger.Error("Error marshalling task request", "error", jsonErr, "task_id", tr.TaskID)
-		return
-	}
+	c, span := tracer.Start(
+		context.Background(),
+		ep,
+		trace.WithNewRoot(),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("task.id", tr.ID),
+			attribute.String("task.label", tr.Label),
+		),
+	)
+	defer span.End()
 
-	rsp, err := http.Post(url, "application/json", bytes.NewReader(jsonBody))
-	if err != nil {
-		logger.Error("Error sending request to dispatcher", "error", err, "task_id", tr.TaskID)
-		return
+	var statusCode int
+	var response string
+	var err error
+	if returnResult {
+		var rsp *dispatcherclient.RunResult
+		if rsp, err = p.client.Run(c, tr); err == nil {
+			statusCode, response = rsp.StatusCode, rsp.Result
+		}
+	} else {
+		var rsp *dispatcherclient.SendResult
+		if rsp, err = p.client.Send(c, tr); err == nil {
+			statusCode, response = rsp.StatusCode, rsp.Message
+		}
 	}
-	defer rsp.Body.Close()
-	c, readErr := io.ReadAll(rsp.Body)
-	if readErr != nil {
-		logger.Error("Error reading response from dispatcher", "error", readErr, "task_id", tr.TaskID)
+
+	// Error responses from the dispatcher are still completed requests, logged with their status code
+	var se *dispatcherclient.StatusError
+	if errors.As(err, &se) {
+		statusCode, response = se.StatusCode, se.Message
+		span.SetStatus(codes.Error, se.Error())
+	} else if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		logger.Error("Error sending request to dispatcher", "error", err, "task_id", tr.ID)
 		return
 	}
+	span.SetAttributes(attribute.Int("http.response.status_code", statusCode))
 	logger.Info(
-		fmt.Sprintf("Request completed [%d][%s]", rsp.StatusCode, ep),
-		"response", string(c),
-		"task_id", tr.TaskID,
+		fmt.Sprintf("Request completed [%d][%s]", statusCode, ep),
+		"response", response,
+		"task_id", tr.ID,
+		"trace_id", span.SpanContext().TraceID().String(),
 	)
 }
 
@@ -114,6 +155,7 @@ func saveSettings() {
 		TotalRequests:     totalRequests,
 		WaitAvgSeconds:    waitAvgSeconds,
 		WaitStdDevSeconds: waitStdDevSeconds,
+		Retries:           retries,
 	}
 
 	file, err := os.Create(settingsPath)
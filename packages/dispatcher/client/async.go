@@ -0,0 +1,69 @@
+package dispatcherclient
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Default number of concurrent requests for SendBatch.
+const DefaultBatchConcurrency = 8
+
+// Queue several tasks, with at most concurrency requests in flight (DefaultBatchConcurrency if not
+// positive). Returns one error per task, in the same order, which is nil for tasks that were queued.
+func (c *Client) SendBatch(ctx context.Context, tasks []Task, concurrency int) []error {
+	if concurrency <= 0 {
+		concurrency = DefaultBatchConcurrency
+	}
+	errs := make([]error, len(tasks))
+	sem := make(chan struct{}, concurrency)
+	wg := sync.WaitGroup{}
+	for i, t := range tasks {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			_, errs[i] = c.Send(ctx, t)
+		}()
+	}
+	wg.Wait()
+	return errs
+}
+
+// Poll the status of a queued task until it finishes or the context is done. Returns the final
+// status, with an error wrapping ErrTaskFailed if the task failed.
+func (c *Client) Wait(ctx context.Context, id string, interval time.Duration) (*TaskStatus, error) {
+	for {
+		s, err := c.GetTask(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if s.Status == StatusFailed {
+			return s, fmt.Errorf("%w: %s", ErrTaskFailed, s.Result)
+		}
+		if s.Done() {
+			return s, nil
+		}
+		if err := sleep(ctx, interval); err != nil {
+			return s, err
+		}
+	}
+}
+
+// Wait for several tasks, and return their final statuses keyed by task ID. Stops at the first
+// error, returning the statuses collected so far.
+func (c *Client) WaitAll(ctx context.Context, ids []string, interval time.Duration) (map[string]*TaskStatus, error) {
+	out := make(map[string]*TaskStatus, len(ids))
+	for _, id := range ids {
+		s, err := c.Wait(ctx, id, interval)
+		if err != nil {
+			return out, fmt.Errorf("task %s: %w", id, err)
+		}
+		out[id] = s
+	}
+	return out, nil
+}
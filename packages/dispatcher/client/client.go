@@ -0,0 +1,226 @@
+/*
+Package dispatcherclient is a Go client for the dispatcher HTTP API. It sends tasks synchronously or
+asynchronously, retries requests the dispatcher rejected before processing them, and waits for the
+results of asynchronous tasks through the task status API.
+*/
+package dispatcherclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Returned when the dispatcher has no record of a task.
+var ErrTaskNotFound = errors.New("task not found")
+
+// Returned by Wait when the task failed on the worker.
+var ErrTaskFailed = errors.New("task failed")
+
+// Error returned when the dispatcher responds with an error status.
+type StatusError struct {
+	StatusCode int
+	Message    string
+	retryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("dispatcher returned %d: %s", e.StatusCode, e.Message)
+}
+
+// Whether the request can be retried safely. The dispatcher answers 429 and 503 before doing any
+// work, for instance while draining. A proxy may answer 502 or 504 after the dispatcher queued the
+// task, so those are only retried for reads.
+func (e *StatusError) retryable(method string) bool {
+	switch e.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return method == http.MethodGet
+	}
+	return false
+}
+
+// Whether a failed request can be retried without running the task twice: either the response has a
+// status that is retryable for the method, or the connection could not be established.
+func retryable(method string, err error) bool {
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.retryable(method)
+	}
+	var oe *net.OpError
+	return errors.As(err, &oe) && oe.Op == "dial"
+}
+
+// Result of an asynchronous task submission.
+type SendResult struct {
+	StatusCode int
+	Message    string
+}
+
+// Result of a synchronous task.
+type RunResult struct {
+	StatusCode int
+	Result     string
+	// Results that are not text, such as msgpack or raw bytes, are in Data instead of Result, with the
+	// content type the worker published them with.
+	Data        []byte
+	ContentType string
+	// Whether the dispatcher served the result from its result cache, without running the task.
+	Cached bool
+}
+
+// Client for the dispatcher HTTP API. It is safe for concurrent use.
+type Client struct {
+	baseURL string
+	http    *http.Client
+	retry   RetryPolicy
+	hooks   []func(*http.Request)
+}
+
+// Option to configure a Client.
+type Option func(*Client)
+
+// Use the given HTTP client, for instance to set up TLS. The default is http.DefaultClient.
+func WithHTTPClient(h *http.Client) Option {
+	return func(c *Client) { c.http = h }
+}
+
+// Use the given retry policy. The default is DefaultRetryPolicy.
+func WithRetryPolicy(p RetryPolicy) Option {
+	return func(c *Client) { c.retry = p }
+}
+
+// Call the hook on every request before it is sent, for instance to add trace context headers.
+func WithRequestHook(h func(*http.Request)) Option {
+	return func(c *Client) { c.hooks = append(c.hooks, h) }
+}
+
+// Create a client for the dispatcher at the given base URL, such as http://localhost:8080.
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		http:    http.DefaultClient,
+		retry:   DefaultRetryPolicy,
+	}
+	for _, o := range opts {
+		o(c)
+	}
+	return c
+}
+
+// Queue a task without waiting for it to run. Use Wait to get its result.
+func (c *Client) Send(ctx context.Context, t Task) (*SendResult, error) {
+	var out messageResponse
+	code, err := c.do(ctx, http.MethodPost, "/send-task", t.request(false), &out)
+	if err != nil {
+		return nil, err
+	}
+	return &SendResult{StatusCode: code, Message: out.Message}, nil
+}
+
+// Run a task and wait for its result. The wait is bounded by the context and by the dispatcher's
+// task timeout.
+func (c *Client) Run(ctx context.Context, t Task) (*RunResult, error) {
+	var out messageResponse
+	code, err := c.do(ctx, http.MethodPost, "/run-task", t.request(true), &out)
+	if err != nil {
+		return nil, err
+	}
+	return &RunResult{StatusCode: code, Result: out.Message, Data: out.Data, ContentType: out.ContentType, Cached: out.Cached}, nil
+}
+
+// Get the last known status of a task. Returns ErrTaskNotFound if the dispatcher has no record of
+// it; records expire some time after the task finishes.
+func (c *Client) GetTask(ctx context.Context, id string) (*TaskStatus, error) {
+	var out TaskStatus
+	_, err := c.do(ctx, http.MethodGet, "/tasks/"+url.PathEscape(id), nil, &out)
+	var se *StatusError
+	if errors.As(err, &se) && se.StatusCode == http.StatusNotFound {
+		return nil, ErrTaskNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// Send a request, retrying according to the retry policy, and decode the JSON response into out.
+// Returns the response status code.
+func (c *Client) do(ctx context.Context, method, path string, body, out any) (int, error) {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return 0, err
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		code, err := c.once(ctx, method, path, payload, out)
+		if err == nil || attempt >= c.retry.MaxAttempts || ctx.Err() != nil {
+			return code, err
+		}
+		if !retryable(method, err) {
+			return code, err
+		}
+		wait := c.retry.backoff(attempt)
+		var se *StatusError
+		if errors.As(err, &se) {
+			wait = max(wait, se.retryAfter)
+		}
+		if err := sleep(ctx, wait); err != nil {
+			return code, err
+		}
+	}
+}
+
+// Send a single request.
+func (c *Client) once(ctx context.Context, method, path string, payload []byte, out any) (int, error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return 0, err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for _, h := range c.hooks {
+		h(req)
+	}
+
+	rsp, err := c.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer rsp.Body.Close()
+	raw, err := io.ReadAll(rsp.Body)
+	if err != nil {
+		return rsp.StatusCode, err
+	}
+	if rsp.StatusCode >= 300 {
+		se := &StatusError{StatusCode: rsp.StatusCode, Message: strings.TrimSpace(string(raw))}
+		if s, err := strconv.Atoi(rsp.Header.Get("Retry-After")); err == nil {
+			se.retryAfter = time.Duration(s) * time.Second
+		}
+		return rsp.StatusCode, se
+	}
+	if out != nil {
+		if err := json.Unmarshal(raw, out); err != nil {
+			return rsp.StatusCode, fmt.Errorf("decoding response: %w", err)
+		}
+	}
+	return rsp.StatusCode, nil
+}
@@ -0,0 +1,198 @@
+package dispatcherclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Fast retries for tests.
+var testRetry = RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond, Multiplier: 2}
+
+// Fake dispatcher that records the tasks it receives and reports them as queued, then completed on
+// the second status lookup. While failures is positive, requests to the task endpoints get a 503.
+// Tasks with the ID "cached" get results marked as cached, and tasks with binary parameters get them
+// back as a binary result.
+type fakeDispatcher struct {
+	mu       sync.Mutex
+	tasks    map[string]taskRequest
+	lookups  map[string]int
+	failures atomic.Int32
+}
+
+func newFakeDispatcher(t *testing.T) (*fakeDispatcher, *Client) {
+	d := &fakeDispatcher{tasks: map[string]taskRequest{}, lookups: map[string]int{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /send-task", d.task(http.StatusAccepted, "Task dispatched successfully"))
+	mux.HandleFunc("POST /run-task", d.task(http.StatusOK, "result"))
+	mux.HandleFunc("GET /tasks/{id}", d.status)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return d, New(srv.URL, WithRetryPolicy(testRetry))
+}
+
+func (d *fakeDispatcher) task(code int, msg string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if d.failures.Add(-1) >= 0 {
+			w.Header().Set("Retry-After", "0")
+			http.Error(w, "Dispatcher is shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		var tr taskRequest
+		if err := json.NewDecoder(r.Body).Decode(&tr); err != nil || tr.TaskID == "bad" {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		d.mu.Lock()
+		d.tasks[tr.TaskID] = tr
+		d.mu.Unlock()
+		w.WriteHeader(code)
+		out := messageResponse{Message: msg, Cached: tr.TaskID == "cached"}
+		if tr.ParametersType != "" {
+			out = messageResponse{Data: tr.ParametersData, ContentType: tr.ParametersType}
+		}
+		json.NewEncoder(w).Encode(out)
+	}
+}
+
+func (d *fakeDispatcher) status(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	id := r.PathValue("id")
+	if _, ok := d.tasks[id]; !ok {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+	d.lookups[id]++
+	s := TaskStatus{TaskID: id, Status: StatusQueued, Queue: "all"}
+	if d.lookups[id] > 1 {
+		s.Status, s.WorkerID, s.Result = StatusCompleted, "w1", "done"
+		if id == "fails" {
+			s.Status, s.Result = StatusFailed, "boom"
+		}
+	}
+	json.NewEncoder(w).Encode(s)
+}
+
+func TestSendAndRun(t *testing.T) {
+	d, client := newFakeDispatcher(t)
+	c := context.Background()
+
+	sent, err := client.Send(c, Task{ID: "a", Type: "sample", Label: "l1"})
+	if err != nil || sent.StatusCode != http.StatusAccepted {
+		t.Fatalf("Expected task to be accepted, got %+v %v", sent, err)
+	}
+	if tr := d.tasks["a"]; tr.ReturnResult || tr.Parameters != "{}" || tr.Label != "l1" {
+		t.Errorf("Unexpected task request: %+v", tr)
+	}
+
+	sel := &Selector{Required: map[string]string{"pool": "highmem"}}
+	run, err := client.Run(c, Task{ID: "b", Type: "sample", Labels: []string{"embed", "rerank"}, Selector: sel, Tenant: "acme"})
+	if err != nil || run.Result != "result" || run.Cached {
+		t.Fatalf("Expected result, got %+v %v", run, err)
+	}
+	if tr := d.tasks["b"]; !tr.ReturnResult || len(tr.Labels) != 2 || tr.Selector.Required["pool"] != "highmem" || tr.Tenant != "acme" {
+		t.Errorf("Expected synchronous task to request its result with its labels and selector, got %+v", tr)
+	}
+	if run, err := client.Run(c, Task{ID: "cached", Type: "sample"}); err != nil || !run.Cached {
+		t.Errorf("Expected a cached result, got %+v %v", run, err)
+	}
+	binary := Task{ID: "bin", Type: "sample", ParametersData: []byte{0, 0xff}, ParametersType: "application/octet-stream"}
+	if run, err := client.Run(c, binary); err != nil || string(run.Data) != "\x00\xff" || run.ContentType != "application/octet-stream" {
+		t.Errorf("Expected the binary result, got %+v %v", run, err)
+	}
+	if tr := d.tasks["bin"]; tr.Parameters != "" {
+		t.Errorf("Expected no JSON parameters with binary ones, got %q", tr.Parameters)
+	}
+
+	var se *StatusError
+	if _, err := client.Send(c, Task{ID: "bad"}); !errors.As(err, &se) || se.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected a 400 status error, got %v", err)
+	}
+}
+
+// Test that rejected requests are retried up to the policy's attempts
+func TestRetries(t *testing.T) {
+	d, client := newFakeDispatcher(t)
+	c := context.Background()
+
+	d.failures.Store(2)
+	if _, err := client.Send(c, Task{ID: "a"}); err != nil {
+		t.Errorf("Expected success after 2 retries, got %v", err)
+	}
+
+	d.failures.Store(3)
+	var se *StatusError
+	if _, err := client.Send(c, Task{ID: "b"}); !errors.As(err, &se) || se.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 after running out of attempts, got %v", err)
+	}
+
+	// Connection failures are retried too, until the context is done
+	client = New("http://127.0.0.1:1", WithRetryPolicy(RetryPolicy{MaxAttempts: 100, InitialBackoff: 10 * time.Millisecond}))
+	ctx, cancel := context.WithTimeout(c, 50*time.Millisecond)
+	defer cancel()
+	if _, err := client.Send(ctx, Task{ID: "c"}); err == nil {
+		t.Error("Expected an error without a dispatcher")
+	}
+	if ctx.Err() == nil {
+		t.Error("Expected retries to continue until the context was done")
+	}
+}
+
+// Test that gateway errors are retried for reads, but not for task submissions that may have been
+// queued already
+func TestGatewayErrorRetries(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.Error(w, "Bad gateway", http.StatusBadGateway)
+	}))
+	defer srv.Close()
+	client := New(srv.URL, WithRetryPolicy(testRetry))
+	c := context.Background()
+
+	if _, err := client.Send(c, Task{ID: "a"}); err == nil || requests.Load() != 1 {
+		t.Errorf("Expected the submission to fail without retries, got %d requests %v", requests.Load(), err)
+	}
+	requests.Store(0)
+	if _, err := client.GetTask(c, "a"); err == nil || requests.Load() != int32(testRetry.MaxAttempts) {
+		t.Errorf("Expected the lookup to be retried, got %d requests %v", requests.Load(), err)
+	}
+}
+
+func TestSendBatchAndWait(t *testing.T) {
+	_, client := newFakeDispatcher(t)
+	c := context.Background()
+
+	tasks := []Task{{ID: "a"}, {ID: "bad"}, {ID: "c"}, {ID: "fails"}}
+	errs := client.SendBatch(c, tasks, 2)
+	if errs[0] != nil || errs[1] == nil || errs[2] != nil || errs[3] != nil {
+		t.Fatalf("Unexpected batch errors: %v", errs)
+	}
+
+	statuses, err := client.WaitAll(c, []string{"a", "c"}, time.Millisecond)
+	if err != nil || len(statuses) != 2 || statuses["a"].Result != "done" || !statuses["c"].Done() {
+		t.Errorf("Unexpected statuses: %v %v", statuses, err)
+	}
+	if s, err := client.Wait(c, "fails", time.Millisecond); !errors.Is(err, ErrTaskFailed) || s.Result != "boom" {
+		t.Errorf("Expected failed task, got %+v %v", s, err)
+	}
+	if _, err := client.Wait(c, "unknown", time.Millisecond); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("Expected ErrTaskNotFound, got %v", err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 2}
+	for attempt, want := range map[int]time.Duration{1: 100 * time.Millisecond, 3: 400 * time.Millisecond, 6: time.Second} {
+		if got := p.backoff(attempt); got != want {
+			t.Errorf("Attempt %d: expected %v, got %v", attempt, want, got)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package dispatcherclient
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+// Retry policy for requests the dispatcher did not process: connection failures, and 429, 502, 503
+// and 504 responses. A Retry-After header from the dispatcher extends the backoff.
+type RetryPolicy struct {
+	// Total number of attempts, including the first one. 1 disables retries.
+	MaxAttempts int
+	// Backoff before the first retry.
+	InitialBackoff time.Duration
+	// Upper bound for the backoff.
+	MaxBackoff time.Duration
+	// Factor applied to the backoff after each retry.
+	Multiplier float64
+	// Random fraction of the backoff, between 0 and 1, added or removed to spread out retries.
+	Jitter float64
+}
+
+// Default retry policy: up to 4 attempts, with exponential backoff from 100ms to 2s.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    4,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     2 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+// Policy that sends every request once.
+var NoRetry = RetryPolicy{MaxAttempts: 1}
+
+// Backoff before the retry following the given attempt, starting at 1.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := float64(p.InitialBackoff)
+	for range attempt - 1 {
+		d *= max(p.Multiplier, 1)
+	}
+	if p.MaxBackoff > 0 {
+		d = min(d, float64(p.MaxBackoff))
+	}
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(d)
+}
+
+// Sleep for the given duration, or until the context is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
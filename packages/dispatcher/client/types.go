@@ -0,0 +1,107 @@
+package dispatcherclient
+
+// A task to run on the worker pool.
+type Task struct {
+	// Unique ID of the task, used to match results and look up its status.
+	ID string
+	// Task type, which selects the handler on the worker.
+	Type string
+	// Label of the resources the task needs. Tasks are routed to workers that already hold it.
+	Label string
+	// Labels the task needs together on one worker, in addition to Label.
+	Labels []string
+	// JSON encoded parameters for the handler.
+	Parameters string
+	// Parameters in another format, or compressed, instead of Parameters: ParametersType is
+	// "application/msgpack" or "application/octet-stream" (JSON by default), and ParametersEncoding
+	// is empty or "zstd". The client sends them base64 encoded in the JSON request.
+	ParametersData     []byte
+	ParametersType     string
+	ParametersEncoding string
+	// Worker attributes the task requires or prefers. Tasks without one run in the default pool.
+	Selector *Selector
+	// Tenant the task is scheduled for when it waits in a common queue, if the dispatcher schedules
+	// tenants fairly. Tasks without one share the default tenant.
+	Tenant string
+}
+
+// Worker attributes a task is matched against. Workers must have every required attribute; workers
+// with the most preferred attributes are tried first. The "pool" attribute selects the worker pool.
+type Selector struct {
+	Required  map[string]string `json:"required,omitempty"`
+	Preferred map[string]string `json:"preferred,omitempty"`
+}
+
+// Task request as sent to the dispatcher.
+type taskRequest struct {
+	TaskID       string    `json:"task_id"`
+	TaskType     string    `json:"task_type"`
+	Label        string    `json:"label"`
+	Labels       []string  `json:"labels,omitempty"`
+	Parameters   string    `json:"parameters_json"`
+	ReturnResult bool      `json:"return_result"`
+	Selector     *Selector `json:"selector,omitempty"`
+	Tenant       string    `json:"tenant,omitempty"`
+
+	ParametersData     []byte `json:"parameters_data,omitempty"`
+	ParametersType     string `json:"parameters_type,omitempty"`
+	ParametersEncoding string `json:"parameters_encoding,omitempty"`
+}
+
+func (t Task) request(returnResult bool) taskRequest {
+	params := t.Parameters
+	if params == "" && len(t.ParametersData) == 0 {
+		params = "{}"
+	}
+	return taskRequest{
+		TaskID:       t.ID,
+		TaskType:     t.Type,
+		Label:        t.Label,
+		Labels:       t.Labels,
+		Parameters:   params,
+		ReturnResult: returnResult,
+		Selector:     t.Selector,
+		Tenant:       t.Tenant,
+
+		ParametersData:     t.ParametersData,
+		ParametersType:     t.ParametersType,
+		ParametersEncoding: t.ParametersEncoding,
+	}
+}
+
+// Task states reported by the dispatcher.
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+)
+
+// Last known status of a task.
+type TaskStatus struct {
+	TaskID string `json:"task_id"`
+	Status string `json:"status"`
+	// Queue the task was sent to: a worker ID, or "all" or "pool:<name>" for a common queue.
+	Queue string `json:"queue"`
+	// Worker that picked up the task, once it is running.
+	WorkerID string `json:"worker_id,omitempty"`
+	// Result of a completed task, or the error of a failed one.
+	Result string `json:"result,omitempty"`
+	// Results that are not text, such as msgpack or raw bytes, with their content type.
+	ResultData  []byte `json:"result_data,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+}
+
+// Whether the task has finished, successfully or not.
+func (s *TaskStatus) Done() bool {
+	return s.Status == StatusCompleted || s.Status == StatusFailed
+}
+
+// Message response returned by the task endpoints. /run-task returns results that are not text in
+// Data, and marks results served from the dispatcher's result cache as Cached.
+type messageResponse struct {
+	Message     string `json:"message"`
+	Data        []byte `json:"data,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Cached      bool   `json:"cached,omitempty"`
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"slices"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Errors returned by the admin operations, mapped to HTTP statuses by the handlers.
+var (
+	errUnknownWorker     = errors.New("unknown worker")
+	errWorkerNotDraining = errors.New("worker is not draining")
+	errLabelNotHeld      = errors.New("worker does not hold label")
+)
+
+// Drain state of a worker, returned by the /admin/workers/{id}/drain endpoints. A worker is drained
+// once it is draining, its queue is empty and it is not running a task.
+type drainStatus struct {
+	ID         string `json:"id"`
+	Draining   bool   `json:"draining"`
+	QueueDepth int64  `json:"queue_depth"`
+	Drained    bool   `json:"drained"`
+}
+
+// Get the drain state of a worker.
+func getDrainStatus(r *redisClient, c context.Context, wid workerId) (*drainStatus, error) {
+	ctx, cancel := context.WithTimeout(c, opTimeout())
+	defer cancel()
+	pipe := r.Pipeline()
+	draining := pipe.SIsMember(ctx, r.keys.draining(), string(wid))
+	available := pipe.SIsMember(ctx, r.keys.available(), string(wid))
+	depth := pipe.LLen(ctx, r.keys.queue(wid))
+	if _, err := pipe.Exec(ctx); err != nil {
+		observeRedisError("drain_status", err)
+		return nil, err
+	}
+	return &drainStatus{
+		ID:         string(wid),
+		Draining:   draining.Val(),
+		QueueDepth: depth.Val(),
+		Drained:    draining.Val() && depth.Val() == 0 && available.Val(),
+	}, nil
+}
+
+// Mark a running worker as draining, so routing stops selecting it and it stops taking tasks from
+// the common queue, or return it to rotation.
+func setDraining(r *redisClient, c context.Context, wid workerId, on bool) (*drainStatus, error) {
+	if on {
+		// Only running workers can be drained; stopping a drain is always allowed, for cleanup
+		running, err := r.runningWorkers(c)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(running, wid) {
+			return nil, errUnknownWorker
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(c, opTimeout())
+	defer cancel()
+	var err error
+	if on {
+		err = r.SAdd(ctx, r.keys.draining(), string(wid)).Err()
+	} else {
+		err = r.SRem(ctx, r.keys.draining(), string(wid)).Err()
+	}
+	if err != nil {
+		observeRedisError("set_draining", err)
+		slog.Error("Unable to update worker drain state!", "error", err, "worker", wid)
+		return nil, err
+	}
+	slog.Info("Updated worker drain state", "worker", wid, "draining", on)
+	return getDrainStatus(r, c, wid)
+}
+
+// Ask a worker to drop a label, through its control queue. The worker deregisters the label itself,
+// so that its own label cache stays consistent with Redis.
+func evictLabel(r *redisClient, c context.Context, wid workerId, label string) error {
+	ctx, cancel := context.WithTimeout(c, opTimeout())
+	defer cancel()
+	held, err := r.SIsMember(ctx, r.keys.label(label), string(wid)).Result()
+	if err != nil {
+		observeRedisError("evict_label", err)
+		return err
+	}
+	if !held {
+		return errLabelNotHeld
+	}
+
+	cmd, err := json.Marshal(workerCommand{Command: commandEvictLabel, Label: label})
+	if err != nil {
+		return err
+	}
+	if err := r.RPush(ctx, r.keys.control(wid), cmd).Err(); err != nil {
+		observeRedisError("evict_label", err)
+		slog.Error("Unable to send control command!", "error", err, "worker", wid)
+		return err
+	}
+	slog.Info("Requested label eviction", "worker", wid, "label", label)
+	return nil
+}
+
+// Move the tasks queued for a draining worker to other workers through normal routing. Returns the
+// number of tasks moved to each queue. On error, the task being moved is put back at the head of the
+// worker's queue, and the counts so far are returned with the error.
+func redistributeQueue(r *redisClient, c context.Context, wid workerId) (map[string]int, error) {
+	moved := map[string]int{}
+	status, err := getDrainStatus(r, c, wid)
+	if err != nil {
+		return moved, err
+	}
+	if !status.Draining {
+		return moved, errWorkerNotDraining
+	}
+
+	for {
+		raw, err := popTask(r, c, wid)
+		if errors.Is(err, redis.Nil) {
+			return moved, nil
+		}
+		if err != nil {
+			return moved, err
+		}
+
+		var t taskRequest
+		if err := json.Unmarshal([]byte(raw), &t); err != nil {
+			// The task cannot be routed, so leave it for any worker to reject
+			slog.Warn("Moving malformed task to the common queue", "worker", wid, "error", err)
+			t = taskRequest{}
+		}
+		target := workerId("all")
+		if t.TaskID != "" {
+			target, err = selectWorkerQueue(&t, r, c)
+			if err == nil {
+				err = target.sendTask(&t, r, c)
+			}
+		} else {
+			err = pushRaw(r, c, r.keys.queue(target), raw, false)
+		}
+		if err != nil {
+			if pushErr := pushRaw(r, c, r.keys.queue(wid), raw, true); pushErr != nil {
+				slog.Error("Lost task while redistributing!", "error", pushErr, "worker", wid, "task", raw)
+			}
+			return moved, err
+		}
+		moved[string(target)]++
+	}
+}
+
+// Pop the next task from a worker's queue. Returns redis.Nil when the queue is empty.
+func popTask(r *redisClient, c context.Context, wid workerId) (string, error) {
+	ctx, cancel := context.WithTimeout(c, opTimeout())
+	defer cancel()
+	raw, err := r.LPop(ctx, r.keys.queue(wid)).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		observeRedisError("pop_task", err)
+		slog.Error("Unable to pop task!", "error", err, "worker", wid)
+	}
+	return raw, err
+}
+
+// Push an already serialized task onto a queue, at the head or at the tail.
+func pushRaw(r *redisClient, c context.Context, queue, raw string, head bool) error {
+	ctx, cancel := context.WithTimeout(c, opTimeout())
+	defer cancel()
+	var err error
+	if head {
+		err = r.LPush(ctx, queue, raw).Err()
+	} else {
+		err = r.RPush(ctx, queue, raw).Err()
+	}
+	if err != nil {
+		observeRedisError("push_task", err)
+	}
+	return err
+}
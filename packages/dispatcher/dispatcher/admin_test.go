@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Send a request to the test server and decode the JSON response if out is not nil.
+func doJSON(t *testing.T, method, url string, out any) int {
+	t.Helper()
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rsp.Body.Close()
+	if out != nil && rsp.StatusCode < 300 {
+		if err := json.NewDecoder(rsp.Body).Decode(out); err != nil {
+			t.Fatalf("Error decoding response: %v", err)
+		}
+	}
+	return rsp.StatusCode
+}
+
+// Test that a draining worker is excluded from routing until it is returned to rotation
+func TestDrainWorker(t *testing.T) {
+	r, c := mockRedis(true)
+	defer r.Close()
+	srv := httptest.NewServer(newRouter(r))
+	defer srv.Close()
+
+	var status drainStatus
+	if code := doJSON(t, http.MethodPost, srv.URL+"/admin/workers/work1/drain", &status); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	if !status.Draining || !status.Drained || status.QueueDepth != 0 {
+		t.Errorf("Expected idle worker to be drained, got %+v", status)
+	}
+
+	// work1 holds label-1 but is draining, so the task goes to work2, which has label capacity
+	wid, err := selectWorkerQueue(&taskRequest{TaskID: "t", Label: "label-1"}, r, c)
+	if err != nil || wid != "work2" {
+		t.Errorf("Expected draining worker to be skipped, got %s %v", wid, err)
+	}
+	var info workerInfo
+	if getJSON(t, srv.URL+"/workers/work1", &info); !info.Draining {
+		t.Errorf("Expected worker info to report draining, got %+v", info)
+	}
+
+	if code := doJSON(t, http.MethodDelete, srv.URL+"/admin/workers/work1/drain", &status); code != http.StatusOK || status.Draining {
+		t.Fatalf("Expected worker to leave drain, got %d %+v", code, status)
+	}
+	if wid, _ := selectWorkerQueue(&taskRequest{TaskID: "t", Label: "label-1"}, r, c); wid != "work1" {
+		t.Errorf("Expected worker back in rotation, got %s", wid)
+	}
+
+	if code := doJSON(t, http.MethodPost, srv.URL+"/admin/workers/unknown/drain", nil); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown worker, got %d", code)
+	}
+}
+
+// Test that label eviction is sent on the worker's control queue
+func TestEvictLabel(t *testing.T) {
+	r, c := mockRedis(true)
+	defer r.Close()
+	srv := httptest.NewServer(newRouter(r))
+	defer srv.Close()
+
+	if code := doJSON(t, http.MethodPost, srv.URL+"/admin/workers/work1/labels/label-1/evict", nil); code != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d", code)
+	}
+	raw, err := r.LPop(c, r.keys.control(workerId("work1"))).Result()
+	if err != nil {
+		t.Fatalf("Expected command on control queue: %v", err)
+	}
+	var cmd workerCommand
+	if err := json.Unmarshal([]byte(raw), &cmd); err != nil || cmd.Command != commandEvictLabel || cmd.Label != "label-1" {
+		t.Errorf("Unexpected control command: %s", raw)
+	}
+
+	if code := doJSON(t, http.MethodPost, srv.URL+"/admin/workers/work1/labels/label-2/evict", nil); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for label not held, got %d", code)
+	}
+}
+
+// Test that the tasks queued for a draining worker are routed to other workers
+func TestRedistributeQueue(t *testing.T) {
+	r, c := mockRedis(true)
+	defer r.Close()
+	srv := httptest.NewServer(newRouter(r))
+	defer srv.Close()
+
+	for _, l := range []string{"label-1", "label-2"} {
+		if err := workerId("work1").sendTask(&taskRequest{TaskID: l, Label: l}, r, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.RPush(c, r.keys.queue(workerId("work1")), "not json")
+
+	if code := doJSON(t, http.MethodPost, srv.URL+"/admin/workers/work1/redistribute", nil); code != http.StatusConflict {
+		t.Errorf("Expected 409 for worker that is not draining, got %d", code)
+	}
+
+	doJSON(t, http.MethodPost, srv.URL+"/admin/workers/work1/drain", nil)
+	var out struct {
+		Moved map[string]int `json:"moved"`
+	}
+	if code := doJSON(t, http.MethodPost, srv.URL+"/admin/workers/work1/redistribute", &out); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	if out.Moved["work2"] != 2 || out.Moved["all"] != 1 {
+		t.Errorf("Unexpected redistribution: %v", out.Moved)
+	}
+	if n, _ := r.LLen(c, r.keys.queue(workerId("work1"))).Result(); n != 0 {
+		t.Errorf("Expected drained worker queue to be empty, got %d tasks", n)
+	}
+	if n, _ := r.LLen(c, r.keys.queue(workerId("work2"))).Result(); n != 2 {
+		t.Errorf("Expected 2 tasks on work2, got %d", n)
+	}
+}
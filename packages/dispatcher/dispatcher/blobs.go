@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// Blob keys are "<namespace>/<name>", written by the dispatcher and the workers alike. The pattern
+// keeps them safe to use as file paths and object names.
+var blobKeyPattern = regexp.MustCompile(`^[A-Za-z0-9._-]+/[A-Za-z0-9._-]+$`)
+
+func validBlobKey(key string) bool {
+	return blobKeyPattern.MatchString(key) && !strings.Contains(key, "..")
+}
+
+// Storage for payloads too large to pass through the broker, shared with the workers.
+type blobStore interface {
+	put(c context.Context, key string, data []byte) error
+	get(c context.Context, key string) ([]byte, error)
+	// Keys of the blobs under the prefix stored before the given time.
+	expired(c context.Context, prefix string, before time.Time) ([]string, error)
+	// Delete the blobs, and return how many were deleted.
+	remove(c context.Context, keys []string) (int, error)
+}
+
+// Blob store on a directory, such as a volume mounted by the dispatcher and the workers.
+type fsBlobStore struct {
+	dir string
+}
+
+// Write the blob to a temporary file and rename it, so that readers never see a partial blob.
+func (s *fsBlobStore) put(_ context.Context, key string, data []byte) error {
+	path := filepath.Join(s.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func (s *fsBlobStore) get(_ context.Context, key string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(s.dir, filepath.FromSlash(key)))
+}
+
+func (s *fsBlobStore) expired(_ context.Context, prefix string, before time.Time) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(s.dir, prefix))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	keys := []string{}
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || e.IsDir() || !info.ModTime().Before(before) {
+			continue
+		}
+		keys = append(keys, prefix+"/"+e.Name())
+	}
+	return keys, nil
+}
+
+func (s *fsBlobStore) remove(_ context.Context, keys []string) (int, error) {
+	n := 0
+	var errs []error
+	for _, key := range keys {
+		if err := os.Remove(filepath.Join(s.dir, filepath.FromSlash(key))); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, err)
+			continue
+		}
+		n++
+	}
+	return n, errors.Join(errs...)
+}
+
+// Blob store on a bucket of an S3-compatible object store.
+type s3BlobStore struct {
+	client *minio.Client
+	bucket string
+}
+
+func newS3BlobStore(cfg s3Config) (*s3BlobStore, error) {
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKeyID, cfg.SecretAccessKey, ""),
+		Secure: !cfg.Insecure,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &s3BlobStore{client: client, bucket: cfg.Bucket}, nil
+}
+
+func (s *s3BlobStore) put(c context.Context, key string, data []byte) error {
+	_, err := s.client.PutObject(c, s.bucket, key, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{})
+	return err
+}
+
+func (s *s3BlobStore) get(c context.Context, key string) ([]byte, error) {
+	obj, err := s.client.GetObject(c, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+	return io.ReadAll(obj)
+}
+
+func (s *s3BlobStore) expired(c context.Context, prefix string, before time.Time) ([]string, error) {
+	keys := []string{}
+	for obj := range s.client.ListObjects(c, s.bucket, minio.ListObjectsOptions{Prefix: prefix + "/"}) {
+		if obj.Err != nil {
+			return keys, obj.Err
+		}
+		if obj.LastModified.Before(before) {
+			keys = append(keys, obj.Key)
+		}
+	}
+	return keys, nil
+}
+
+func (s *s3BlobStore) remove(c context.Context, keys []string) (int, error) {
+	objects := make(chan minio.ObjectInfo)
+	go func() {
+		defer close(objects)
+		for _, key := range keys {
+			objects <- minio.ObjectInfo{Key: key}
+		}
+	}()
+	n := 0
+	var errs []error
+	for r := range s.client.RemoveObjectsWithResult(c, s.bucket, objects, minio.RemoveObjectsOptions{}) {
+		if r.Err != nil {
+			errs = append(errs, r.Err)
+			continue
+		}
+		n++
+	}
+	return n, errors.Join(errs...)
+}
+
+func newBlobStore(cfg blobsConfig) (blobStore, error) {
+	if cfg.Backend == blobBackendS3 {
+		return newS3BlobStore(cfg.S3)
+	}
+	return &fsBlobStore{dir: cfg.Dir}, nil
+}
+
+// Offloads large task parameters to the blob store and resolves the results the workers offloaded.
+// Blobs are named under the broker's namespace, so deployments sharing a store stay apart.
+type payloadStore struct {
+	store     blobStore
+	namespace string
+	// Parameters larger than this are offloaded. Zero disables offloading.
+	threshold int
+	ttl       time.Duration
+}
+
+// Payload store of the running dispatcher. Nil in tests that do not set one, where nothing is
+// offloaded and blob references are returned as they are.
+var payloads *payloadStore
+
+func newPayloadStore(cfg *dispatcherConfig) (*payloadStore, error) {
+	store, err := newBlobStore(cfg.Blobs)
+	if err != nil {
+		return nil, err
+	}
+	namespace := cfg.Redis.Namespace
+	if cfg.Broker == brokerNATS {
+		namespace = cfg.NATS.Namespace
+	}
+	return &payloadStore{
+		store:     store,
+		namespace: namespace,
+		threshold: cfg.Blobs.ThresholdBytes,
+		ttl:       time.Duration(cfg.Blobs.TTLSeconds) * time.Second,
+	}, nil
+}
+
+// Store the task's parameters as a blob if they exceed the threshold, and return the task as the
+// workers should receive it: with a reference in place of the parameters. Binary parameters are
+// stored as they are, and workers tell them apart by their content type or encoding. The task itself
+// is not changed, since its parameters still identify it for the result cache.
+func (p *payloadStore) offloadParameters(c context.Context, t *taskRequest) (*taskRequest, error) {
+	data := []byte(t.Parameters)
+	if len(t.ParametersData) > 0 {
+		data = t.ParametersData
+	}
+	if p == nil || p.threshold == 0 || len(data) <= p.threshold {
+		return t, nil
+	}
+	ctx, cancel := context.WithTimeout(c, opTimeout())
+	defer cancel()
+	key := p.namespace + "/" + strings.ToLower(rand.Text())
+	if err := p.store.put(ctx, key, data); err != nil {
+		blobOps.WithLabelValues("put", blobError).Inc()
+		return nil, fmt.Errorf("storing parameters of task %s: %w", t.TaskID, err)
+	}
+	blobOps.WithLabelValues("put", blobOK).Inc()
+	blobBytes.Add(float64(len(data)))
+	wire := *t
+	wire.Parameters, wire.ParametersData = "", nil
+	wire.ParametersRef = key
+	return &wire, nil
+}
+
+// Resolve a result published as a blob reference to the blob's contents. Other results are returned
+// as they are.
+func (p *payloadStore) resolveResult(c context.Context, result string) (string, error) {
+	key, ok := strings.CutPrefix(result, blobRefPrefix)
+	if p == nil || !ok {
+		return result, nil
+	}
+	if !validBlobKey(key) {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	ctx, cancel := context.WithTimeout(c, opTimeout())
+	defer cancel()
+	data, err := p.store.get(ctx, key)
+	if err != nil {
+		blobOps.WithLabelValues("get", blobError).Inc()
+		return "", fmt.Errorf("reading result blob %s: %w", key, err)
+	}
+	blobOps.WithLabelValues("get", blobOK).Inc()
+	return string(data), nil
+}
+
+// Delete the namespace's blobs once they are older than the blob TTL, periodically until the context
+// is done. Workers cannot tell when a result was read, so their blobs expire the same way. The
+// parameters of tasks still waiting in a queue are kept until a worker takes the task.
+func (p *payloadStore) runSweeper(c context.Context, b broker) {
+	ticker := time.NewTicker(blobSweepIntervalSeconds * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.Done():
+			return
+		case <-ticker.C:
+		}
+		p.sweep(c, b)
+	}
+}
+
+func (p *payloadStore) sweep(c context.Context, b broker) {
+	n, err := p.sweepExpired(c, b)
+	if n > 0 {
+		blobOps.WithLabelValues("delete", blobOK).Add(float64(n))
+		slog.Info("Deleted expired blobs", "blobs", n)
+	}
+	if err != nil {
+		blobOps.WithLabelValues("delete", blobError).Inc()
+		slog.Error("Unable to delete expired blobs!", "error", err)
+	}
+}
+
+// Delete the expired blobs, except the parameters of queued tasks. The broker is asked on every
+// sweep, even with nothing expired, so it can keep what it tracks up to date. Nothing is deleted if
+// the broker cannot tell which tasks are queued.
+func (p *payloadStore) sweepExpired(c context.Context, b broker) (int, error) {
+	keys, err := p.store.expired(c, p.namespace, time.Now().Add(-p.ttl))
+	queued, qerr := b.queuedParameters(c, keys)
+	if qerr != nil {
+		return 0, errors.Join(err, qerr)
+	}
+	if len(keys) == 0 {
+		return 0, err
+	}
+	keys = slices.DeleteFunc(keys, func(key string) bool { return queued[key] })
+	n, rerr := p.store.remove(c, keys)
+	return n, errors.Join(err, rerr)
+}
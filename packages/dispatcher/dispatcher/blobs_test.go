@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/redis/go-redis/v9"
+)
+
+// Offload parameters above the threshold to a blob store in a temporary directory for the test.
+func setPayloadStore(t *testing.T, threshold int) *payloadStore {
+	t.Helper()
+	payloads = &payloadStore{
+		store:     &fsBlobStore{dir: t.TempDir()},
+		namespace: defaultNamespace,
+		threshold: threshold,
+		ttl:       time.Minute,
+	}
+	t.Cleanup(func() { payloads = nil })
+	return payloads
+}
+
+// Store two blobs, age them, and store a third. Only the aged blob of the namespace must expire at
+// the cutoff.
+func testBlobStore(t *testing.T, s blobStore, cutoff time.Time, age func()) {
+	t.Helper()
+	c := context.Background()
+	put := func(key string) {
+		if err := s.put(c, key, []byte("data of "+key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put("ns/old")
+	put("other/old")
+	age()
+	put("ns/new")
+	if data, err := s.get(c, "ns/new"); err != nil || string(data) != "data of ns/new" {
+		t.Errorf("Expected the stored blob, got %q %v", data, err)
+	}
+	keys, err := s.expired(c, "ns", cutoff)
+	if !slices.Equal(keys, []string{"ns/old"}) || err != nil {
+		t.Errorf("Expected the aged blob to expire, got %v %v", keys, err)
+	}
+	if n, err := s.remove(c, keys); n != 1 || err != nil {
+		t.Errorf("Expected 1 blob removed, got %d %v", n, err)
+	}
+	for key, kept := range map[string]bool{"ns/old": false, "ns/new": true, "other/old": true} {
+		if _, err := s.get(c, key); (err == nil) != kept {
+			t.Errorf("Expected blob %s kept: %v, got error %v", key, kept, err)
+		}
+	}
+	if keys, err := s.expired(c, "missing", cutoff); len(keys) != 0 || err != nil {
+		t.Errorf("Expected nothing to expire, got %v %v", keys, err)
+	}
+}
+
+func TestFilesystemBlobStore(t *testing.T) {
+	dir := t.TempDir()
+	testBlobStore(t, &fsBlobStore{dir: dir}, time.Now().Add(-time.Minute), func() {
+		old := time.Now().Add(-time.Hour)
+		for _, key := range []string{"ns/old", "other/old"} {
+			if err := os.Chtimes(filepath.Join(dir, key), old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+	if tmp, _ := filepath.Glob(filepath.Join(dir, "ns", ".tmp-*")); len(tmp) != 0 {
+		t.Errorf("Expected no temporary files left, got %v", tmp)
+	}
+}
+
+// Test the S3 store against an in-memory S3 server, whose clock the test moves forward
+func TestS3BlobStore(t *testing.T) {
+	start := time.Now()
+	clock := gofakes3.FixedTimeSource(start)
+	backend := s3mem.New(s3mem.WithTimeSource(clock))
+	if err := backend.CreateBucket("blobs"); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(gofakes3.New(backend).Server())
+	defer srv.Close()
+	s, err := newS3BlobStore(s3Config{
+		Endpoint:        strings.TrimPrefix(srv.URL, "http://"),
+		Bucket:          "blobs",
+		Region:          "us-east-1",
+		AccessKeyID:     "key",
+		SecretAccessKey: "secret",
+		Insecure:        true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBlobStore(t, s, start.Add(30*time.Minute), func() { clock.Advance(time.Hour) })
+}
+
+// Test that large parameters reach the worker as a blob reference, and that results the worker
+// offloaded are resolved for the caller and in the task status
+func TestOffloadPayloads(t *testing.T) {
+	r, c := mockRedis(true)
+	defer r.Close()
+	p := setPayloadStore(t, 16)
+	params := `{"text": "` + strings.Repeat("a", 32) + `"}`
+
+	tr := &taskRequest{TaskID: "t1", TaskType: "embed", Label: "label-1", Parameters: params}
+	if err := workerId("work1").sendTask(tr, r, c); err != nil {
+		t.Fatal(err)
+	}
+	if tr.Parameters != params || tr.ParametersRef != "" {
+		t.Errorf("Expected the task itself to keep its parameters, got %+v", tr)
+	}
+	raw, _ := r.LPop(c, r.keys.queue("work1")).Result()
+	var queued taskRequest
+	if err := json.Unmarshal([]byte(raw), &queued); err != nil {
+		t.Fatal(err)
+	}
+	if queued.Parameters != "" || !strings.HasPrefix(queued.ParametersRef, defaultNamespace+"/") || !validBlobKey(queued.ParametersRef) {
+		t.Fatalf("Expected a blob reference in place of the parameters, got %+v", queued)
+	}
+	if data, err := p.store.get(c, queued.ParametersRef); err != nil || string(data) != params {
+		t.Errorf("Expected the parameters in the blob, got %q %v", data, err)
+	}
+
+	// Small parameters stay inline
+	small := &taskRequest{TaskID: "t2", TaskType: "embed", Label: "label-1", Parameters: "{}"}
+	if wire, _ := p.offloadParameters(c, small); wire != small {
+		t.Errorf("Expected small parameters to stay inline, got %+v", wire)
+	}
+
+	// The worker offloads its result, publishes the reference, and records it in the status
+	p.store.put(c, defaultNamespace+"/result-t1", []byte("large result"))
+	r.HSet(c, r.keys.taskStatus("t1"), "status", taskCompleted, "result", blobRefPrefix+defaultNamespace+"/result-t1")
+	go func() {
+		waitSubscribers(t, r, r.keys.results("t1"), 1)
+		r.Publish(c, r.keys.results("t1"), blobRefPrefix+defaultNamespace+"/result-t1")
+	}()
+	if result, err := awaitResult(tr, r, c); result != "large result" || err != nil {
+		t.Errorf("Expected the result from the blob, got %q %v", result, err)
+	}
+	if info, err := getTaskInfo(r, c, "t1"); err != nil || info.Result != "large result" {
+		t.Errorf("Expected the task status to resolve the result, got %+v %v", info, err)
+	}
+
+	for _, ref := range []string{blobRefPrefix + "../etc/passwd", blobRefPrefix + defaultNamespace + "/missing"} {
+		if _, err := p.resolveResult(c, ref); err == nil {
+			t.Errorf("Expected an error resolving %s", ref)
+		}
+	}
+}
+
+// Age every blob of the filesystem store past the blob TTL.
+func ageBlobs(t *testing.T, p *payloadStore) {
+	t.Helper()
+	dir := p.store.(*fsBlobStore).dir
+	old := time.Now().Add(-2 * p.ttl)
+	paths, _ := filepath.Glob(filepath.Join(dir, "*", "*"))
+	for _, path := range paths {
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Queue a task with offloaded parameters, and check that the sweeper keeps its blob past the TTL
+// until a worker takes the task, while expired results are deleted.
+func testSweepQueuedParameters(t *testing.T, b broker, take func()) {
+	t.Helper()
+	c := context.Background()
+	p := setPayloadStore(t, 16)
+	tr := &taskRequest{TaskID: "t1", TaskType: "embed", Parameters: `{"text": "` + strings.Repeat("a", 32) + `"}`}
+	if err := poolQueue("").sendTask(tr, b, c); err != nil {
+		t.Fatal(err)
+	}
+	result := defaultNamespace + "/result-t0"
+	p.store.put(c, result, []byte("large result"))
+	ageBlobs(t, p)
+
+	p.sweep(c, b)
+	keys, _ := p.store.expired(c, defaultNamespace, time.Now())
+	if len(keys) != 1 || keys[0] == result {
+		t.Fatalf("Expected only the parameters of the queued task to stay, got %v", keys)
+	}
+	take()
+	p.sweep(c, b)
+	if keys, _ := p.store.expired(c, defaultNamespace, time.Now()); len(keys) != 0 {
+		t.Errorf("Expected the parameters to be deleted once the task was taken, got %v", keys)
+	}
+}
+
+// Test that a task waiting in a queue longer than the blob TTL keeps its parameters
+func TestSweepQueuedParameters(t *testing.T) {
+	r, c := mockRedis(false)
+	defer r.Close()
+	testSweepQueuedParameters(t, r, func() {
+		r.LPop(c, r.keys.queue(poolQueue("")))
+		r.HSet(c, r.keys.taskStatus("t1"), "status", taskRunning)
+	})
+	if n, _ := r.HLen(c, r.keys.queuedParameters()).Result(); n != 0 {
+		t.Errorf("Expected the parameters to be no longer tracked, got %d", n)
+	}
+
+	m := newMemoryBroker()
+	testSweepQueuedParameters(t, m, func() { m.popTask(c, poolQueue("")) })
+}
+
+// Test that parameters are deleted once the status of their task expired, and that the sweeper keeps
+// the status of a task waiting longer than the status TTL
+func TestSweepExpiredStatuses(t *testing.T) {
+	mr := miniredis.RunT(t)
+	r := newNamespacedClient(redis.NewClient(&redis.Options{Addr: mr.Addr()}), defaultNamespace)
+	defer r.Close()
+	c := context.Background()
+	p := setPayloadStore(t, 16)
+	params := `{"text": "` + strings.Repeat("a", 32) + `"}`
+	for _, id := range []string{"done", "waiting"} {
+		if err := poolQueue("").sendTask(&taskRequest{TaskID: id, TaskType: "embed", Parameters: params}, r, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.HSet(c, r.keys.taskStatus("done"), "status", taskCompleted)
+
+	// The waiting task outlives its status TTL, and the finished task's status expires
+	for range 3 {
+		mr.FastForward(taskStatusTTLSeconds * time.Second / 2)
+		ageBlobs(t, p)
+		p.sweep(c, r)
+	}
+	if status, _ := r.HGet(c, r.keys.taskStatus("waiting"), "status").Result(); status != taskQueued {
+		t.Errorf("Expected the waiting task to stay queued, got %q", status)
+	}
+	tracked, _ := r.HGetAll(c, r.keys.queuedParameters()).Result()
+	keys, _ := p.store.expired(c, defaultNamespace, time.Now())
+	if len(tracked) != 1 || len(keys) != 1 || tracked[keys[0]] != "waiting" {
+		t.Errorf("Expected only the parameters of the waiting task to stay, got %v %v", tracked, keys)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import "context"
+
+// Backend the dispatcher routes and delivers tasks through: the worker registry that routing reads,
+// the queues tasks are sent to, and the results workers send back. The service runs on Redis, through
+// redisClient, or on NATS JetStream, through natsBroker. memoryBroker keeps everything in the process,
+// so routing and delivery can run with in-process workers and without Redis, for local development
+// and tests.
+type broker interface {
+	// Available workers, excluding draining ones, optionally sorted by ID.
+	availableWorkers(c context.Context, sorted bool) (workerIds, error)
+	// Available workers that hold all the given labels, excluding draining ones.
+	availableWorkersLabel(c context.Context, labels ...string) (workerIds, error)
+	runningWorkers(c context.Context) (workerIds, error)
+	// Running workers, busy or not, excluding draining ones.
+	activeWorkers(c context.Context) (workerIds, error)
+	workersWithLabel(c context.Context, label string) (workerIds, error)
+	isAvailable(c context.Context, wid workerId) (bool, error)
+	// Capacity and attributes the given workers published.
+	loadWorkerResources(c context.Context, wids workerIds) ([]workerResources, error)
+	// Labels and resources of the given workers, with the size of every label they hold and of the
+	// requested labels, and which of the requested labels each worker holds.
+	loadCapacities(c context.Context, wids workerIds, requested ...string) ([]workerCapacity, error)
+	// Count a task routed for the labels. Errors are only logged, since demand only guides evictions.
+	recordDemand(c context.Context, labels ...string)
+	// Demand over the configured window, and the number of workers holding each label.
+	loadEvictionStats(c context.Context, labels []string) (evictionStats, error)
+	// Append a serialized task to a worker's queue or a common queue, and record it as queued.
+	enqueue(c context.Context, wid workerId, t *taskRequest, raw []byte) error
+	// Wait for the result a worker sends back for a task, until the context is done.
+	receiveResult(c context.Context, taskID string) (string, error)
+	// Which of the given blobs hold the parameters of tasks still waiting in a queue. The blob sweeper
+	// keeps those past the blob TTL. Called on every sweep, so brokers can refresh what they track.
+	queuedParameters(c context.Context, keys []string) (map[string]bool, error)
+}
+
+var (
+	_ broker = (*redisClient)(nil)
+	_ broker = (*memoryBroker)(nil)
+	_ broker = (*natsBroker)(nil)
+)
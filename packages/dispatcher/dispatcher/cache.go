@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Caches the results of deterministic task types in Redis, so that repeated /run-task requests skip
+// the workers. Only the Redis broker has a cache: on other brokers it is nil, and every lookup misses.
+type resultCache struct {
+	r *redisClient
+}
+
+func newResultCache(b broker) *resultCache {
+	r, ok := b.(*redisClient)
+	if !ok {
+		return nil
+	}
+	return &resultCache{r: r}
+}
+
+// Hash of the fields that determine a task's result: its type, labels, and parameters. The format of
+// binary parameters is only added when set, so that JSON tasks keep their hashes.
+func cacheHash(t *taskRequest) string {
+	fields := []any{t.TaskType, t.labels(), t.Parameters}
+	if t.ParametersType != "" || t.ParametersEncoding != "" {
+		fields = append(fields, t.ParametersType, t.ParametersEncoding, t.ParametersData)
+	}
+	key, err := json.Marshal(fields)
+	if err != nil {
+		panic("Error serializing cache key: " + err.Error())
+	}
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:])
+}
+
+// Outcome of a cache lookup. On a miss, it holds the invalidation generations of the task's labels
+// at the time of the lookup, so that put does not cache a result computed before an invalidation.
+type cacheLookup struct {
+	Result      string
+	Hit         bool
+	generations string
+}
+
+// Keys of the invalidation generations of the task's labels.
+func (rc *resultCache) generationKeys(t *taskRequest) []string {
+	keys := []string{}
+	for _, l := range t.labels() {
+		keys = append(keys, rc.r.keys.cacheGeneration(l))
+	}
+	return keys
+}
+
+// Join generation values read with MGET, with missing ones left empty.
+func joinGenerations(values []any) string {
+	out := make([]string, len(values))
+	for i, v := range values {
+		if s, ok := v.(string); ok {
+			out[i] = s
+		}
+	}
+	return strings.Join(out, ",")
+}
+
+// Get the cached result of a task. Tasks of types without a TTL always miss, and are not counted.
+// Errors are logged and count as misses, so the task still runs.
+func (rc *resultCache) get(c context.Context, t *taskRequest) cacheLookup {
+	if rc == nil || currentConfig().Cache.ttl(t.TaskType) == 0 {
+		return cacheLookup{}
+	}
+	ctx, cancel := context.WithTimeout(c, opTimeout())
+	defer cancel()
+	values, err := rc.r.MGet(ctx, append([]string{rc.r.keys.cachedResult(cacheHash(t))}, rc.generationKeys(t)...)...).Result()
+	if err != nil {
+		observeRedisError("cache_get", err)
+		slog.Error("Unable to read cached result!", "error", err, "task_id", t.TaskID)
+		cacheLookups.WithLabelValues(cacheMiss).Inc()
+		return cacheLookup{}
+	}
+	lookup := cacheLookup{generations: joinGenerations(values[1:])}
+	if result, ok := values[0].(string); ok {
+		lookup.Result, lookup.Hit = result, true
+		cacheLookups.WithLabelValues(cacheHit).Inc()
+	} else {
+		cacheLookups.WithLabelValues(cacheMiss).Inc()
+	}
+	return lookup
+}
+
+var errCacheInvalidated = errors.New("labels invalidated since the lookup")
+
+// Cache the result of a task, if its type has a TTL, and index it under each of its labels. The
+// indexes are sorted by expiry, so entries that expired are dropped from them on the next write.
+// Results above the blob threshold are not cached, since they would be copied back into Redis, and
+// neither are results of tasks whose labels were invalidated since the lookup.
+func (rc *resultCache) put(c context.Context, t *taskRequest, lookup cacheLookup, result string) {
+	ttl := currentConfig().Cache.ttl(t.TaskType)
+	if rc == nil || ttl == 0 {
+		return
+	}
+	if payloads != nil && payloads.threshold > 0 && len(result) > payloads.threshold {
+		slog.Debug("Not caching a result above the blob threshold", "task_id", t.TaskID, "bytes", len(result))
+		return
+	}
+	ctx, cancel := context.WithTimeout(c, opTimeout())
+	defer cancel()
+	key := rc.r.keys.cachedResult(cacheHash(t))
+	now := time.Now()
+	write := func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, key, result, ttl)
+		for _, l := range t.labels() {
+			index := rc.r.keys.cachedLabel(l)
+			pipe.ZRemRangeByScore(ctx, index, "-inf", strconv.FormatInt(now.UnixMilli(), 10))
+			pipe.ZAdd(ctx, index, redis.Z{Score: float64(now.Add(ttl).UnixMilli()), Member: key})
+			// Keep the index as long as its longest-lived entry
+			pipe.ExpireNX(ctx, index, ttl)
+			pipe.ExpireGT(ctx, index, ttl)
+		}
+		return nil
+	}
+	var err error
+	if gens := rc.generationKeys(t); len(gens) == 0 {
+		_, err = rc.r.TxPipelined(ctx, write)
+	} else {
+		// Write only if no invalidation bumped a generation since the lookup
+		err = rc.r.Watch(ctx, func(tx *redis.Tx) error {
+			values, err := tx.MGet(ctx, gens...).Result()
+			if err != nil {
+				return err
+			}
+			if joinGenerations(values) != lookup.generations {
+				return errCacheInvalidated
+			}
+			_, err = tx.TxPipelined(ctx, write)
+			return err
+		}, gens...)
+	}
+	switch {
+	case errors.Is(err, errCacheInvalidated) || errors.Is(err, redis.TxFailedErr):
+		slog.Info("Not caching a result invalidated while the task ran", "task_id", t.TaskID)
+	case err != nil:
+		observeRedisError("cache_put", err)
+		slog.Error("Unable to cache result!", "error", err, "task_id", t.TaskID)
+	}
+}
+
+// Delete the cached results of every task that used a label, such as after the model behind it was
+// updated. Returns the number of results deleted.
+func invalidateCachedLabel(r *redisClient, c context.Context, label string) (int64, error) {
+	ctx, cancel := context.WithTimeout(c, opTimeout())
+	defer cancel()
+	// Bump the generation first, so results of tasks in flight are not cached after the deletion
+	if err := r.Incr(ctx, r.keys.cacheGeneration(label)).Err(); err != nil {
+		observeRedisError("cache_invalidate", err)
+		return 0, err
+	}
+	index := r.keys.cachedLabel(label)
+	keys, err := r.ZRange(ctx, index, 0, -1).Result()
+	if err != nil {
+		observeRedisError("cache_invalidate", err)
+		return 0, err
+	}
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	pipe := r.TxPipeline()
+	deleted := pipe.Del(ctx, keys...)
+	pipe.Del(ctx, index)
+	if _, err := pipe.Exec(ctx); err != nil {
+		observeRedisError("cache_invalidate", err)
+		return 0, err
+	}
+	n := deleted.Val()
+	slog.Info("Invalidated cached results", "label", label, "results", n)
+	return n, nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"dispatcher/dispatcherpb"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/redis/go-redis/v9"
+)
+
+// Enable the result cache with the given TTLs for the test.
+func setCacheConfig(t *testing.T, ttls map[string]int) {
+	t.Helper()
+	cfg := defaultConfig()
+	cfg.Cache = cacheConfig{Enabled: true, TTLSeconds: ttls}
+	setConfig(cfg)
+	t.Cleanup(func() { setConfig(defaultConfig()) })
+}
+
+// Post a task to /run-task and decode the response.
+func postRunTask(t *testing.T, url, body string) runTaskResponse {
+	t.Helper()
+	resp, err := http.Post(url+"/run-task", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected the task to run, got %d", resp.StatusCode)
+	}
+	var out runTaskResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// Test that repeated tasks of cached types are answered from the cache without reaching a worker
+func TestResultCacheRunTask(t *testing.T) {
+	r, c := mockRedis(true)
+	defer r.Close()
+	setCacheConfig(t, map[string]int{"embed": 60})
+	srv := httptest.NewServer(newRouter(r))
+	defer srv.Close()
+	hits, misses := testutil.ToFloat64(cacheLookups.WithLabelValues(cacheHit)), testutil.ToFloat64(cacheLookups.WithLabelValues(cacheMiss))
+
+	publishWhenQueued(r, "t1", "vector")
+	rsp := postRunTask(t, srv.URL, `{"task_id": "t1", "task_type": "embed", "label": "label-1", "parameters_json": "{\"text\": \"hi\"}", "return_result": true}`)
+	if rsp.Message != "vector" || rsp.Cached {
+		t.Errorf("Expected the result from the worker, got %+v", rsp)
+	}
+	rsp = postRunTask(t, srv.URL, `{"task_id": "t2", "task_type": "embed", "label": "label-1", "parameters_json": "{\"text\": \"hi\"}", "return_result": true}`)
+	if rsp.Message != "vector" || !rsp.Cached {
+		t.Errorf("Expected the cached result, got %+v", rsp)
+	}
+	if info, _ := getTaskInfo(r, c, "t2"); info != nil {
+		t.Errorf("Expected the cached task not to be queued, got %+v", info)
+	}
+	if n := testutil.ToFloat64(cacheLookups.WithLabelValues(cacheHit)) - hits; n != 1 {
+		t.Errorf("Expected 1 cache hit, got %v", n)
+	}
+	if n := testutil.ToFloat64(cacheLookups.WithLabelValues(cacheMiss)) - misses; n != 1 {
+		t.Errorf("Expected 1 cache miss, got %v", n)
+	}
+
+	// Other parameters, labels, and task types without a TTL still run
+	for _, tr := range []*taskRequest{
+		{TaskType: "embed", Label: "label-1", Parameters: `{"text": "bye"}`},
+		{TaskType: "embed", Label: "label-2", Parameters: `{"text": "hi"}`},
+		{TaskType: "classify", Label: "label-1", Parameters: `{"text": "hi"}`},
+	} {
+		if newResultCache(r).get(c, tr).Hit {
+			t.Errorf("Expected a cache miss for %+v", tr)
+		}
+	}
+
+	// Disabling the cache keeps the entries, but stops serving them
+	setConfig(defaultConfig())
+	if newResultCache(r).get(c, &taskRequest{TaskType: "embed", Label: "label-1", Parameters: `{"text": "hi"}`}).Hit {
+		t.Error("Expected no cache hits with the cache disabled")
+	}
+}
+
+// Test invalidating the cached results of the tasks that used a label
+func TestResultCacheInvalidate(t *testing.T) {
+	r, c := mockRedis(false)
+	defer r.Close()
+	setCacheConfig(t, map[string]int{"embed": 60})
+	cache := newResultCache(r)
+
+	// An entry that expired is dropped from the index on the next write
+	r.ZAdd(c, r.keys.cachedLabel("model-a"), redis.Z{Score: 1, Member: r.keys.cachedResult("expired")})
+	tasks := []*taskRequest{
+		{TaskType: "embed", Label: "model-a", Parameters: "1"},
+		{TaskType: "embed", Label: "model-a", Parameters: "2"},
+		{TaskType: "embed", Labels: []string{"model-b", "model-a"}, Parameters: "1"},
+		{TaskType: "embed", Label: "model-b", Parameters: "1"},
+	}
+	for _, tr := range tasks {
+		cache.put(c, tr, cache.get(c, tr), "result")
+	}
+	if n, _ := r.ZCard(c, r.keys.cachedLabel("model-a")).Result(); n != 3 {
+		t.Errorf("Expected 3 entries indexed for the label, got %d", n)
+	}
+	if ttl, _ := r.TTL(c, r.keys.cachedLabel("model-a")).Result(); ttl <= 0 {
+		t.Errorf("Expected the index to expire, got %v", ttl)
+	}
+
+	srv := httptest.NewServer(newRouter(r))
+	defer srv.Close()
+	req, _ := http.NewRequest(http.MethodDelete, srv.URL+"/admin/cache/labels/model-a", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var out struct {
+		Invalidated int64 `json:"invalidated"`
+	}
+	json.NewDecoder(resp.Body).Decode(&out)
+	if resp.StatusCode != http.StatusOK || out.Invalidated != 3 {
+		t.Errorf("Expected 3 results invalidated, got %d %+v", resp.StatusCode, out)
+	}
+	for i, tr := range tasks {
+		if ok := cache.get(c, tr).Hit; ok != (i == 3) {
+			t.Errorf("Expected only the results without the label to stay cached, task %d cached: %v", i, ok)
+		}
+	}
+	if n, err := invalidateCachedLabel(r, c, "model-a"); n != 0 || err != nil {
+		t.Errorf("Expected nothing left to invalidate, got %d %v", n, err)
+	}
+}
+
+// Test that a result computed before an invalidation of one of its labels is not cached
+func TestResultCacheInvalidatedInFlight(t *testing.T) {
+	r, c := mockRedis(false)
+	defer r.Close()
+	setCacheConfig(t, map[string]int{"embed": 60})
+	cache := newResultCache(r)
+
+	tr := &taskRequest{TaskType: "embed", Labels: []string{"model-a", "model-b"}, Parameters: "1"}
+	lookup := cache.get(c, tr)
+	if _, err := invalidateCachedLabel(r, c, "model-b"); err != nil {
+		t.Fatal(err)
+	}
+	cache.put(c, tr, lookup, "stale")
+	if cache.get(c, tr).Hit {
+		t.Error("Expected the result of the invalidated task not to be cached")
+	}
+
+	// Tasks looked up after the invalidation are cached again
+	cache.put(c, tr, cache.get(c, tr), "fresh")
+	if lookup := cache.get(c, tr); lookup.Result != "fresh" {
+		t.Errorf("Expected the fresh result to be cached, got %+v", lookup)
+	}
+}
+
+// Test that results above the blob threshold are not copied into the cache
+func TestResultCacheSkipsLargeResults(t *testing.T) {
+	r, c := mockRedis(false)
+	defer r.Close()
+	setCacheConfig(t, map[string]int{"embed": 60})
+	setPayloadStore(t, 8)
+	cache := newResultCache(r)
+
+	large, small := &taskRequest{TaskType: "embed", Parameters: "1"}, &taskRequest{TaskType: "embed", Parameters: "2"}
+	cache.put(c, large, cache.get(c, large), "a result above the threshold")
+	cache.put(c, small, cache.get(c, small), "small")
+	if cache.get(c, large).Hit || !cache.get(c, small).Hit {
+		t.Error("Expected only the result below the threshold to be cached")
+	}
+}
+
+// Test that the gRPC API serves and marks cached results
+func TestGRPCRunTaskCached(t *testing.T) {
+	conn, r, cleanup := grpcTestServer(t)
+	defer cleanup()
+	setCacheConfig(t, map[string]int{"test": 60})
+	client := dispatcherpb.NewDispatcherClient(conn)
+
+	publishWhenQueued(r, "g1", "done")
+	spec := &dispatcherpb.TaskSpec{TaskId: "g1", TaskType: "test", Label: "label-2"}
+	rsp, err := client.RunTask(context.Background(), &dispatcherpb.RunTaskRequest{Task: spec})
+	if err != nil || rsp.Cached {
+		t.Fatalf("Expected the result from the worker, got %v %v", rsp, err)
+	}
+	spec.TaskId = "g2"
+	rsp, err = client.RunTask(context.Background(), &dispatcherpb.RunTaskRequest{Task: spec})
+	if err != nil || !rsp.Cached || rsp.Result != "done" || rsp.Queue != "" {
+		t.Errorf("Expected the cached result, got %v %v", rsp, err)
+	}
+
+	stream, err := client.RunTaskStream(context.Background(), &dispatcherpb.RunTaskRequest{Task: spec})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev, err := stream.Recv()
+	if err != nil || ev.Status != dispatcherpb.TaskStatus_TASK_STATUS_COMPLETED || !ev.Cached || ev.Result != "done" {
+		t.Errorf("Expected a cached completion first, got %v %v", ev, err)
+	}
+}
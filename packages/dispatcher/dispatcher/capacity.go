@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"slices"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Capacity and attributes a worker publishes in its info hash.
+type workerResources struct {
+	// Label limit of the worker. Workers that do not publish one get the configured default.
+	MaxLabels int
+	// Memory budget in bytes for the worker's labels. Zero means the worker only limits the count.
+	MemoryBudget int64
+	Attributes   map[string]string
+}
+
+// Parse a worker info hash. Invalid or missing fields are left at their defaults.
+func parseWorkerResources(wid workerId, info map[string]string) workerResources {
+	res := workerResources{
+		MaxLabels:  currentConfig().Routing.MaxLabelsPerWorker,
+		Attributes: map[string]string{},
+	}
+	if n, err := strconv.Atoi(info[maxLabelsField]); err == nil && n > 0 {
+		res.MaxLabels = n
+	}
+	if n, err := strconv.ParseInt(info[memoryBudgetField], 10, 64); err == nil && n > 0 {
+		res.MemoryBudget = n
+	}
+	if raw := info[attributesField]; raw != "" {
+		if err := json.Unmarshal([]byte(raw), &res.Attributes); err != nil {
+			slog.Warn("Ignoring invalid worker attributes", "worker", wid, "error", err)
+		}
+	}
+	return res
+}
+
+// Read the capacity and attributes the given workers published.
+func (r *redisClient) loadWorkerResources(c context.Context, wids workerIds) ([]workerResources, error) {
+	ctx, cancel := context.WithTimeout(c, opTimeout())
+	defer cancel()
+	pipe := r.Pipeline()
+	infos := make([]*redis.MapStringStringCmd, len(wids))
+	for i, w := range wids {
+		infos[i] = pipe.HGetAll(ctx, r.keys.info(w))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && len(wids) > 0 {
+		observeRedisError("worker_resources", err)
+		return nil, err
+	}
+	out := make([]workerResources, len(wids))
+	for i, w := range wids {
+		out[i] = parseWorkerResources(w, infos[i].Val())
+	}
+	return out, nil
+}
+
+// Labels and resources of a worker, as needed to decide whether new labels fit on it.
+type workerCapacity struct {
+	workerResources
+	ID         workerId
+	Labels     []string // In eviction order
+	Sizes      map[string]int64
+	LabelCount int
+	// Which of the requested labels the worker holds, from the label membership sets
+	Holds []string
+}
+
+// Bytes used by the worker's labels.
+func (w *workerCapacity) memoryUsed() int64 {
+	var used int64
+	for _, l := range w.Labels {
+		used += w.Sizes[l]
+	}
+	return used
+}
+
+// Labels the worker would evict to hold all the given labels, and the bytes they take. Labels in the
+// set are never evicted, since workers refresh the ones they hold before loading the others. Returns
+// false if the set does not fit even after evicting every other label the dispatcher knows of.
+func (w *workerCapacity) evictionFor(labels []string) ([]string, int64, bool) {
+	missing := 0
+	var size int64
+	for _, l := range labels {
+		if !slices.Contains(w.Holds, l) {
+			missing++
+			size += w.Sizes[l]
+		}
+	}
+	count, used := w.LabelCount, w.memoryUsed()
+	full := func() bool {
+		return count+missing > w.MaxLabels || (w.MemoryBudget > 0 && used+size > w.MemoryBudget)
+	}
+	evicted := []string{}
+	var bytes int64
+	for _, l := range w.Labels {
+		if !full() {
+			break
+		}
+		if slices.Contains(labels, l) {
+			continue
+		}
+		evicted = append(evicted, l)
+		bytes += w.Sizes[l]
+		count--
+		used -= w.Sizes[l]
+	}
+	if full() {
+		return nil, 0, false
+	}
+	return evicted, bytes, true
+}
+
+// Read the label sizes registered for the given labels. Labels without a size count as zero bytes.
+func labelSizes(r *redisClient, c context.Context, labels []string) (map[string]int64, error) {
+	out := make(map[string]int64, len(labels))
+	if len(labels) == 0 {
+		return out, nil
+	}
+	ctx, cancel := context.WithTimeout(c, opTimeout())
+	defer cancel()
+	vals, err := r.HMGet(ctx, r.keys.labelSizes(), labels...).Result()
+	if err != nil {
+		observeRedisError("label_sizes", err)
+		return nil, err
+	}
+	for i, v := range vals {
+		if s, ok := v.(string); ok {
+			n, _ := strconv.ParseInt(s, 10, 64)
+			out[labels[i]] = n
+		}
+	}
+	return out, nil
+}
+
+// Set the size of a label in the label registry, or remove it if size is zero.
+func setLabelSize(r *redisClient, c context.Context, label string, size int64) error {
+	ctx, cancel := context.WithTimeout(c, opTimeout())
+	defer cancel()
+	var err error
+	if size > 0 {
+		err = r.HSet(ctx, r.keys.labelSizes(), label, size).Err()
+	} else {
+		err = r.HDel(ctx, r.keys.labelSizes(), label).Err()
+	}
+	if err != nil {
+		observeRedisError("set_label_size", err)
+		slog.Error("Unable to update label size!", "error", err, "label", label)
+	}
+	return err
+}
+
+// Load the labels and resources of the given workers, with the size of every label they hold and of
+// the requested labels, and which of the requested labels each worker holds.
+func (r *redisClient) loadCapacities(c context.Context, wids workerIds, requested ...string) ([]workerCapacity, error) {
+	resources, err := r.loadWorkerResources(c, wids)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(c, opTimeout())
+	defer cancel()
+	pipe := r.Pipeline()
+	labels := make([]*redis.StringSliceCmd, len(wids))
+	counts := make([]*redis.FloatCmd, len(wids))
+	holds := make([][]*redis.BoolCmd, len(wids))
+	for i, w := range wids {
+		labels[i] = pipe.ZRange(ctx, r.keys.workerLabels(w), 0, -1)
+		counts[i] = pipe.ZScore(ctx, r.keys.labelCounts(), string(w))
+		for _, l := range requested {
+			holds[i] = append(holds[i], pipe.SIsMember(ctx, r.keys.label(l), string(w)))
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		observeRedisError("worker_capacity", err)
+		return nil, err
+	}
+
+	all := append([]string{}, requested...)
+	out := make([]workerCapacity, len(wids))
+	for i, w := range wids {
+		out[i] = workerCapacity{
+			workerResources: resources[i],
+			ID:              w,
+			Labels:          labels[i].Val(),
+			LabelCount:      int(counts[i].Val()),
+			Holds:           []string{},
+		}
+		for j, l := range requested {
+			if holds[i][j].Val() {
+				out[i].Holds = append(out[i].Holds, l)
+			}
+		}
+		all = append(all, out[i].Labels...)
+	}
+	sizes, err := labelSizes(r, c, all)
+	if err != nil {
+		return nil, err
+	}
+	for i := range out {
+		out[i].Sizes = sizes
+	}
+	return out, nil
+}
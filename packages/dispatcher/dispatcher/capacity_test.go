@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const gb = int64(1) << 30
+
+// Publish a worker's memory budget and labels, oldest first, with their sizes in GB. The worker
+// allows up to 10 labels.
+func publishWorker(t *testing.T, r *redisClient, c context.Context, wid workerId, budgetGB int64, labels ...string) {
+	t.Helper()
+	pipe := r.TxPipeline()
+	pipe.SAdd(c, r.keys.running(), string(wid))
+	pipe.SAdd(c, r.keys.available(), string(wid))
+	pipe.HSet(c, r.keys.info(wid), memoryBudgetField, budgetGB*gb, maxLabelsField, 10)
+	pipe.ZAdd(c, r.keys.labelCounts(), redis.Z{Score: float64(len(labels)), Member: string(wid)})
+	for i, l := range labels {
+		pipe.SAdd(c, r.keys.label(l), string(wid))
+		pipe.ZAdd(c, r.keys.workerLabels(wid), redis.Z{Score: float64(i), Member: l})
+	}
+	if _, err := pipe.Exec(c); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func setSizes(t *testing.T, r *redisClient, c context.Context, sizesGB map[string]int64) {
+	t.Helper()
+	for l, s := range sizesGB {
+		if err := setLabelSize(r, c, l, s*gb); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Test that workers without a published label list are only chosen while under the label limit
+func TestCapacityLegacyWorkers(t *testing.T) {
+	r, c := mockRedis(true)
+	defer r.Close()
+
+	caps, err := r.loadCapacities(c, workerIds{"u-work1", "u-work2", "work1", "work2"}, "label-9")
+	if err != nil {
+		t.Fatalf("Error loading capacities: %v", err)
+	}
+	var fits []workerId
+	for _, w := range caps {
+		if evicted, _, ok := w.evictionFor([]string{"label-9"}); ok && len(evicted) == 0 {
+			fits = append(fits, w.ID)
+		}
+	}
+	if !slices.Equal(fits, []workerId{"u-work2", "work2"}) {
+		t.Errorf("Expected workers u-work2 and work2 with capacity, got: %v", fits)
+	}
+	if p := choosePlacement(caps[2:3], []string{"label-9"}, evictionStats{}); p != nil {
+		t.Errorf("Expected no placement on a full worker without a label list, got %+v", p)
+	}
+}
+
+// Test that a label goes to the worker that can fit it while evicting the fewest bytes
+func TestSelectWorkerByMemory(t *testing.T) {
+	r, c := mockRedis(false)
+	defer r.Close()
+
+	setSizes(t, r, c, map[string]int64{"small": 1, "medium": 4, "large": 12, "huge": 20, "new": 6})
+	publishWorker(t, r, c, "w-a", 16, "large")           // 4 GB free
+	publishWorker(t, r, c, "w-b", 16, "medium", "small") // 11 GB free
+	publishWorker(t, r, c, "w-c", 24, "huge")            // 4 GB free
+
+	route := func(label string) workerId {
+		t.Helper()
+		wid, err := selectLabeledQueue(&taskRequest{TaskID: "t", Label: label}, r, c)
+		if err != nil {
+			t.Fatalf("Error selecting queue: %v", err)
+		}
+		return wid
+	}
+
+	// Fits on w-b without evicting anything
+	if wid := route("new"); wid != "w-b" {
+		t.Errorf("Expected w-b, which has room for the label, got %s", wid)
+	}
+
+	// A 12 GB label fits nowhere as is: w-b evicts 4 GB (medium), w-a 12 GB and w-c 20 GB
+	setSizes(t, r, c, map[string]int64{"new": 12})
+	caps, err := r.loadCapacities(c, workerIds{"w-a", "w-b", "w-c"}, "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := choosePlacement(caps, []string{"new"}, evictionStats{})
+	if p == nil || p.Worker != "w-b" || !slices.Equal(p.Evicted, []string{"medium"}) || p.EvictedBytes != 4*gb || p.Reason != reasonFewestBytes {
+		t.Errorf("Expected w-b to evict medium, got %+v", p)
+	}
+	if wid := route("new"); wid != "w-b" {
+		t.Errorf("Expected w-b, which evicts the fewest bytes, got %s", wid)
+	}
+
+	// Nothing can hold a 30 GB label
+	setSizes(t, r, c, map[string]int64{"new": 30})
+	if wid := route("new"); wid != "all" {
+		t.Errorf("Expected common queue for a label that fits nowhere, got %s", wid)
+	}
+}
+
+// Test that each worker is checked against the label limit it published, and that workers that do not
+// publish one get the configured default
+func TestPerWorkerLabelLimit(t *testing.T) {
+	r, c := mockRedis(true)
+	defer r.Close()
+
+	// work1 holds 2 labels, the default limit, but publishes a limit of 4; work2 holds 1 but allows 1
+	r.HSet(c, r.keys.info(workerId("work1")), maxLabelsField, 4, attributesField, `{"gpu": "a100"}`)
+	r.HSet(c, r.keys.info(workerId("work2")), maxLabelsField, 1)
+	wid, err := selectLabeledQueue(&taskRequest{TaskID: "t", Label: "label-9"}, r, c)
+	if err != nil || wid != "work1" {
+		t.Errorf("Expected work1, which is under its own limit, got %s %v", wid, err)
+	}
+
+	res, err := r.loadWorkerResources(c, workerIds{"work1", "u-work1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res[0].MaxLabels != 4 || res[0].Attributes["gpu"] != "a100" {
+		t.Errorf("Unexpected published resources: %+v", res[0])
+	}
+	if res[1].MaxLabels != defaultMaxLabelsPerWorker || len(res[1].Attributes) != 0 {
+		t.Errorf("Expected defaults for a worker that publishes nothing, got %+v", res[1])
+	}
+}
+
+func TestLabelSizeAPI(t *testing.T) {
+	r, c := mockRedis(true)
+	defer r.Close()
+	srv := httptest.NewServer(newRouter(r))
+	defer srv.Close()
+
+	send := func(method, body string) int {
+		req, _ := http.NewRequest(method, srv.URL+"/admin/labels/label-1/size", strings.NewReader(body))
+		rsp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rsp.Body.Close()
+		return rsp.StatusCode
+	}
+	if code := send(http.MethodPut, `{"size_bytes": 2048}`); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	var out struct {
+		Labels map[string]labelInfo `json:"labels"`
+	}
+	if getJSON(t, srv.URL+"/labels", &out); out.Labels["label-1"].SizeBytes != 2048 {
+		t.Errorf("Expected label size in labels API, got %+v", out.Labels["label-1"])
+	}
+	var info workerInfo
+	if getJSON(t, srv.URL+"/workers/work1", &info); info.MemoryUsedBytes != 2048 || info.MemoryBudgetBytes != nil {
+		t.Errorf("Expected worker memory use from label sizes, got %+v", info)
+	}
+
+	if code := send(http.MethodPut, `{"size_bytes": -1}`); code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for invalid size, got %d", code)
+	}
+	if code := send(http.MethodDelete, ""); code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", code)
+	}
+	if n, _ := r.HLen(c, r.keys.labelSizes()).Result(); n != 0 {
+		t.Errorf("Expected label size to be removed, got %d sizes", n)
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Details about a single worker, returned by GET /workers/{id}.
+type workerInfo struct {
+	ID           string   `json:"id"`
+	Running      bool     `json:"running"`
+	Available    bool     `json:"available"`
+	Draining     bool     `json:"draining"`
+	Labels       []string `json:"labels"`
+	LabelCount   int      `json:"label_count"`
+	QueueDepth   int64    `json:"queue_depth"`
+	LastActivity *string  `json:"last_activity"`
+	// Label limit and attributes published by the worker, or the configured default limit
+	MaxLabels  int               `json:"max_labels"`
+	Attributes map[string]string `json:"attributes"`
+	// Memory budget published by the worker, and the bytes used by its labels per the label registry
+	MemoryBudgetBytes *int64 `json:"memory_budget_bytes,omitempty"`
+	MemoryUsedBytes   int64  `json:"memory_used_bytes"`
+}
+
+// Workers holding a label and the number of queued tasks that require it, returned by GET /labels.
+type labelInfo struct {
+	Workers      []string `json:"workers"`
+	PendingTasks int      `json:"pending_tasks"`
+	SizeBytes    int64    `json:"size_bytes"`
+}
+
+// Iterate over all keys matching a pattern with SCAN, which does not block Redis like KEYS. On a
+// cluster every master is scanned, since SCAN only covers the node it runs on.
+func scanKeys(r *redisClient, c context.Context, pattern string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(c, opTimeout()*introspectionTimeoutFactor)
+	defer cancel()
+	var mu sync.Mutex
+	out := []string{}
+	scan := func(ctx context.Context, n redis.UniversalClient) error {
+		iter := n.Scan(ctx, 0, pattern, scanBatchSize).Iterator()
+		for iter.Next(ctx) {
+			mu.Lock()
+			out = append(out, iter.Val())
+			mu.Unlock()
+		}
+		return iter.Err()
+	}
+	var err error
+	if cc, ok := r.UniversalClient.(*redis.ClusterClient); ok {
+		err = cc.ForEachMaster(ctx, func(ctx context.Context, m *redis.Client) error { return scan(ctx, m) })
+	} else {
+		err = scan(ctx, r)
+	}
+	if err != nil {
+		observeRedisError("scan", err)
+		slog.Error("Unable to scan keys!", "error", err, "pattern", pattern)
+		return nil, err
+	}
+	slices.Sort(out)
+	return out, nil
+}
+
+// Get the labels held by each worker, by reading all label membership sets.
+func labelsByWorker(r *redisClient, c context.Context) (map[string][]string, map[string][]string, error) {
+	keys, err := scanKeys(r, c, r.keys.label("*"))
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := context.WithTimeout(c, opTimeout())
+	defer cancel()
+	pipe := r.Pipeline()
+	members := make([]*redis.StringSliceCmd, len(keys))
+	for i, k := range keys {
+		members[i] = pipe.SMembers(ctx, k)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && len(keys) > 0 {
+		observeRedisError("label_members", err)
+		return nil, nil, err
+	}
+
+	byWorker := map[string][]string{}
+	byLabel := map[string][]string{}
+	for i, k := range keys {
+		l := r.keys.labelFromKey(k)
+		ws := members[i].Val()
+		slices.Sort(ws)
+		byLabel[l] = ws
+		for _, w := range ws {
+			byWorker[w] = append(byWorker[w], l)
+		}
+	}
+	return byWorker, byLabel, nil
+}
+
+// Get the details for a single worker. Returns nil if the worker is unknown.
+func getWorkerInfo(r *redisClient, c context.Context, wid workerId) (*workerInfo, error) {
+	byWorker, _, err := labelsByWorker(r, c)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(c, opTimeout())
+	defer cancel()
+	pipe := r.Pipeline()
+	running := pipe.SIsMember(ctx, r.keys.running(), string(wid))
+	available := pipe.SIsMember(ctx, r.keys.available(), string(wid))
+	draining := pipe.SIsMember(ctx, r.keys.draining(), string(wid))
+	count := pipe.ZScore(ctx, r.keys.labelCounts(), string(wid))
+	depth := pipe.LLen(ctx, r.keys.queue(wid))
+	activity := pipe.HGet(ctx, r.keys.lastActivity(), string(wid))
+	published := pipe.HGetAll(ctx, r.keys.info(wid))
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		observeRedisError("worker_info", err)
+		return nil, err
+	}
+
+	labels := byWorker[string(wid)]
+	if !running.Val() && len(labels) == 0 && depth.Val() == 0 && activity.Err() == redis.Nil {
+		return nil, nil
+	}
+	info := &workerInfo{
+		ID:         string(wid),
+		Running:    running.Val(),
+		Available:  available.Val(),
+		Draining:   draining.Val(),
+		Labels:     labels,
+		LabelCount: int(count.Val()),
+		QueueDepth: depth.Val(),
+	}
+	if info.Labels == nil {
+		info.Labels = []string{}
+	}
+	if ts, err := strconv.ParseFloat(activity.Val(), 64); err == nil {
+		t := time.Unix(0, int64(ts*float64(time.Second))).UTC().Format(time.RFC3339)
+		info.LastActivity = &t
+	}
+	res := parseWorkerResources(wid, published.Val())
+	info.MaxLabels, info.Attributes = res.MaxLabels, res.Attributes
+	if res.MemoryBudget > 0 {
+		info.MemoryBudgetBytes = &res.MemoryBudget
+	}
+	sizes, err := labelSizes(r, c, labels)
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range labels {
+		info.MemoryUsedBytes += sizes[l]
+	}
+	return info, nil
+}
+
+// Get the details of all running workers, sorted by ID. Queue depths and activity are not included.
+func listWorkers(r *redisClient, c context.Context) ([]workerInfo, error) {
+	byWorker, _, err := labelsByWorker(r, c)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(c, opTimeout())
+	defer cancel()
+	pipe := r.Pipeline()
+	running := pipe.SMembers(ctx, r.keys.running())
+	available := pipe.SMembers(ctx, r.keys.available())
+	draining := pipe.SMembers(ctx, r.keys.draining())
+	if _, err := pipe.Exec(ctx); err != nil {
+		observeRedisError("list_workers", err)
+		return nil, err
+	}
+
+	ids := running.Val()
+	slices.Sort(ids)
+	resources, err := r.loadWorkerResources(c, stringToWidSlice(ids))
+	if err != nil {
+		return nil, err
+	}
+	out := make([]workerInfo, 0, len(ids))
+	for i, id := range ids {
+		labels := byWorker[id]
+		if labels == nil {
+			labels = []string{}
+		}
+		out = append(out, workerInfo{
+			ID:         id,
+			Running:    true,
+			Available:  slices.Contains(available.Val(), id),
+			Draining:   slices.Contains(draining.Val(), id),
+			Labels:     labels,
+			LabelCount: len(labels),
+			MaxLabels:  resources[i].MaxLabels,
+			Attributes: resources[i].Attributes,
+		})
+	}
+	return out, nil
+}
+
+// Get the length of every job list, keyed by queue name.
+func queueLengths(r *redisClient, c context.Context) (map[string]int64, error) {
+	keys, err := scanKeys(r, c, r.keys.queue("*"))
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(c, opTimeout())
+	defer cancel()
+	pipe := r.Pipeline()
+	lens := make([]*redis.IntCmd, len(keys))
+	for i, k := range keys {
+		lens[i] = pipe.LLen(ctx, k)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && len(keys) > 0 {
+		observeRedisError("queue_lengths", err)
+		return nil, err
+	}
+	out := map[string]int64{}
+	for i, k := range keys {
+		out[r.keys.queueFromKey(k)] = lens[i].Val()
+	}
+	return out, nil
+}
+
+// Count the queued tasks for each label, across all job lists.
+func pendingTasksByLabel(r *redisClient, c context.Context) (map[string]int, error) {
+	keys, err := scanKeys(r, c, r.keys.queue("*"))
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(c, opTimeout()*introspectionTimeoutFactor)
+	defer cancel()
+	pipe := r.Pipeline()
+	tasks := make([]*redis.StringSliceCmd, len(keys))
+	for i, k := range keys {
+		tasks[i] = pipe.LRange(ctx, k, 0, -1)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && len(keys) > 0 {
+		observeRedisError("pending_tasks", err)
+		return nil, err
+	}
+
+	out := map[string]int{}
+	for i := range keys {
+		for _, raw := range tasks[i].Val() {
+			var t taskRequest
+			if err := json.Unmarshal([]byte(raw), &t); err != nil {
+				slog.Warn("Skipping malformed queued task", "queue", keys[i], "error", err)
+				continue
+			}
+			for _, l := range t.labels() {
+				out[l]++
+			}
+		}
+	}
+	return out, nil
+}
+
+// Get every label with the workers holding it, its pending task count and its registered size.
+// Labels that only appear in queued tasks or in the size registry are included with no workers.
+func getLabelsInfo(r *redisClient, c context.Context) (map[string]labelInfo, error) {
+	_, byLabel, err := labelsByWorker(r, c)
+	if err != nil {
+		return nil, err
+	}
+	pending, err := pendingTasksByLabel(r, c)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(c, opTimeout())
+	defer cancel()
+	registry, err := r.HGetAll(ctx, r.keys.labelSizes()).Result()
+	if err != nil {
+		observeRedisError("label_sizes", err)
+		return nil, err
+	}
+
+	out := map[string]labelInfo{}
+	for l, ws := range byLabel {
+		out[l] = labelInfo{Workers: ws, PendingTasks: pending[l]}
+	}
+	for l, n := range pending {
+		if _, ok := out[l]; !ok {
+			out[l] = labelInfo{Workers: []string{}, PendingTasks: n}
+		}
+	}
+	for l, raw := range registry {
+		info, ok := out[l]
+		if !ok {
+			info = labelInfo{Workers: []string{}}
+		}
+		info.SizeBytes, _ = strconv.ParseInt(raw, 10, 64)
+		out[l] = info
+	}
+	return out, nil
+}
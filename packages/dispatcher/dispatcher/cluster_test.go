@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+// Start a test server with some queued tasks for the introspection tests: two label-1 tasks on work1,
+// and one label-2 task on the common queue. Returns the server URL and a cleanup function.
+func introspectionServer(t *testing.T) (string, func()) {
+	r, c := mockRedis(true)
+	for _, q := range []struct {
+		wid   workerId
+		label string
+	}{{"work1", "label-1"}, {"work1", "label-1"}, {"all", "label-2"}} {
+		tr := taskRequest{TaskID: "intro", Label: q.label, TaskType: "test", Parameters: "{}"}
+		if err := q.wid.sendTask(&tr, r, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.HSet(c, r.keys.lastActivity(), "work1", "1700000000.5").Err(); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(newRouter(r))
+	return srv.URL, func() {
+		srv.Close()
+		r.Close()
+	}
+}
+
+// GET a URL from the test server and decode the JSON response if successful.
+func getJSON(t *testing.T, url string, out any) int {
+	t.Helper()
+	rsp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(rsp.Body).Decode(out); err != nil {
+			t.Fatalf("Error decoding response: %v", err)
+		}
+	}
+	return rsp.StatusCode
+}
+
+func TestWorkerInfoAPI(t *testing.T) {
+	url, cleanup := introspectionServer(t)
+	defer cleanup()
+
+	var info workerInfo
+	if code := getJSON(t, url+"/workers/work1", &info); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	if !info.Running || !info.Available || info.LabelCount != 2 || info.QueueDepth != 2 {
+		t.Errorf("Unexpected worker info: %+v", info)
+	}
+	if !slices.Equal(info.Labels, []string{"label-1", "label-3"}) {
+		t.Errorf("Expected labels label-1 and label-3, got %v", info.Labels)
+	}
+	if info.LastActivity == nil || *info.LastActivity != "2023-11-14T22:13:20Z" {
+		t.Errorf("Unexpected last activity: %v", info.LastActivity)
+	}
+
+	if code := getJSON(t, url+"/workers/u-work2", &info); code != http.StatusOK || info.Available || info.LastActivity != nil {
+		t.Errorf("Expected unavailable worker without activity, got %d %+v", code, info)
+	}
+	if code := getJSON(t, url+"/workers/unknown", &info); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown worker, got %d", code)
+	}
+}
+
+func TestLabelsAPI(t *testing.T) {
+	url, cleanup := introspectionServer(t)
+	defer cleanup()
+
+	var out struct {
+		Labels map[string]labelInfo `json:"labels"`
+	}
+	if code := getJSON(t, url+"/labels", &out); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	if len(out.Labels) != 3 {
+		t.Errorf("Expected 3 labels, got %v", out.Labels)
+	}
+	l1 := out.Labels["label-1"]
+	if !slices.Equal(l1.Workers, []string{"u-work1", "work1"}) || l1.PendingTasks != 2 {
+		t.Errorf("Unexpected label-1 info: %+v", l1)
+	}
+	if out.Labels["label-2"].PendingTasks != 1 || out.Labels["label-3"].PendingTasks != 0 {
+		t.Errorf("Unexpected pending counts: %+v", out.Labels)
+	}
+}
+
+func TestQueuesAPI(t *testing.T) {
+	url, cleanup := introspectionServer(t)
+	defer cleanup()
+
+	var out struct {
+		Queues map[string]int64 `json:"queues"`
+	}
+	if code := getJSON(t, url+"/queues", &out); code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", code)
+	}
+	if len(out.Queues) != 2 || out.Queues["work1"] != 2 || out.Queues["all"] != 1 {
+		t.Errorf("Unexpected queues: %v", out.Queues)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Outcome of a coalesced task, published to the requests waiting for it when the running request
+// releases the in-flight lock.
+type coalescedOutcome struct {
+	// Bytes, since results may be binary
+	Result []byte `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// The running request stopped waiting, on timeout or because its caller left. The task may still
+	// finish, so the others keep waiting for its result until their own timeout.
+	TimedOut bool `json:"timed_out,omitempty"`
+}
+
+// Release the in-flight lock if it is still held for the task, and publish the outcome in the same
+// step, so that a request that sees the lock is sure to receive the outcome.
+var releaseInflight = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	redis.call("DEL", KEYS[1])
+end
+redis.call("PUBLISH", KEYS[2], ARGV[2])
+return 0
+`)
+
+// Shares one execution among identical concurrent /run-task requests, across dispatcher replicas.
+// The first request takes the in-flight lock for the task's hash and runs it; the others wait for
+// the result on its result channel. Only the Redis broker coalesces: on other brokers the coalescer
+// is nil, and every request runs its task.
+type coalescer struct {
+	r *redisClient
+}
+
+func newCoalescer(b broker) *coalescer {
+	r, ok := b.(*redisClient)
+	if !ok {
+		return nil
+	}
+	return &coalescer{r: r}
+}
+
+// Run a task with run, or wait for an identical task another request is running. Returns whether
+// the result came from another request. Waiting requests keep their own task timeout. Redis errors
+// while coordinating are logged, and the task runs without coalescing.
+func (co *coalescer) do(c context.Context, t *taskRequest, run func() (string, error)) (string, bool, error) {
+	if co == nil || !currentConfig().Cache.Coalesce || t.TaskID == "" {
+		result, err := run()
+		return result, false, err
+	}
+	hash := cacheHash(t)
+	for range coalesceAttempts {
+		leader, err := co.acquire(c, hash, t.TaskID)
+		if err != nil {
+			break
+		}
+		if leader {
+			result, err := run()
+			co.release(c, hash, t.TaskID, result, err)
+			return result, false, err
+		}
+		result, joined, err := co.join(c, hash)
+		if joined {
+			if err == nil {
+				coalescedRuns.Inc()
+			}
+			return result, true, err
+		}
+	}
+	result, err := run()
+	return result, false, err
+}
+
+// Take the in-flight lock for the task. Returns false if another request holds it.
+func (co *coalescer) acquire(c context.Context, hash, taskID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(c, opTimeout())
+	defer cancel()
+	ttl := taskTimeout() + inflightLockMarginSeconds*time.Second
+	ok, err := co.r.SetNX(ctx, co.r.keys.inflight(hash), taskID, ttl).Result()
+	if err != nil {
+		observeRedisError("coalesce", err)
+		slog.Error("Unable to coalesce task!", "error", err, "task_id", taskID)
+	}
+	return ok, err
+}
+
+// Wait for the outcome of the task running under the in-flight lock. Returns false without waiting
+// if the lock was released before the request could subscribe, so that it tries again.
+func (co *coalescer) join(c context.Context, hash string) (string, bool, error) {
+	ctx, cancel := context.WithTimeout(c, taskTimeout())
+	defer cancel()
+	lock := co.r.keys.inflight(hash)
+	leaderID, err := co.r.Get(ctx, lock).Result()
+	if err != nil {
+		observeRedisError("coalesce", err)
+		return "", false, nil
+	}
+	pubsub := co.r.Subscribe(ctx, co.r.keys.inflightDone(hash), co.r.keys.results(leaderID))
+	defer pubsub.Close()
+	// The outcome is published when the lock is released, so it cannot be missed while the lock is
+	// still held after subscribing
+	if _, err := pubsub.Receive(ctx); err != nil {
+		return "", false, nil
+	}
+	if held, err := co.r.Get(ctx, lock).Result(); err != nil || held != leaderID {
+		observeRedisError("coalesce", err)
+		return "", false, nil
+	}
+
+	slog.Info("Waiting for identical task", "leader_task_id", leaderID)
+	for {
+		m, err := pubsub.ReceiveMessage(ctx)
+		if err != nil {
+			return "", true, err
+		}
+		if m.Channel == co.r.keys.results(leaderID) {
+			result, err := payloads.resolveResult(ctx, m.Payload)
+			return result, true, err
+		}
+		var out coalescedOutcome
+		if err := json.Unmarshal([]byte(m.Payload), &out); err != nil {
+			return "", true, err
+		}
+		switch {
+		case out.TimedOut:
+			continue
+		case out.Error != "":
+			return "", true, errors.New(out.Error)
+		default:
+			return string(out.Result), true, nil
+		}
+	}
+}
+
+// Release the in-flight lock and publish the outcome of the task to the waiting requests.
+func (co *coalescer) release(c context.Context, hash, taskID, result string, runErr error) {
+	out := coalescedOutcome{Result: []byte(result)}
+	if isTimeout(runErr) || errors.Is(runErr, context.Canceled) {
+		out = coalescedOutcome{TimedOut: true}
+	} else if runErr != nil {
+		out = coalescedOutcome{Error: runErr.Error()}
+	}
+	payload, err := json.Marshal(out)
+	if err != nil {
+		panic("Error serializing coalesced outcome: " + err.Error())
+	}
+	// The request context may be done after a timeout, and the lock must still be released
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(c), opTimeout())
+	defer cancel()
+	keys := []string{co.r.keys.inflight(hash), co.r.keys.inflightDone(hash)}
+	if err := releaseInflight.Run(ctx, co.r, keys, taskID, payload).Err(); err != nil {
+		observeRedisError("coalesce", err)
+		slog.Error("Unable to release coalesced task!", "error", err, "task_id", taskID)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// Enable coalescing of identical run-task requests for the test.
+func setCoalesceConfig(t *testing.T) {
+	t.Helper()
+	cfg := defaultConfig()
+	cfg.Cache.Coalesce = true
+	setConfig(cfg)
+	t.Cleanup(func() { setConfig(defaultConfig()) })
+}
+
+// Wait until a channel has the given number of subscribers.
+func waitSubscribers(t *testing.T, r *redisClient, channel string, n int64) {
+	t.Helper()
+	for range 200 {
+		if subs, _ := r.PubSubNumSub(context.Background(), channel).Result(); subs[channel] >= n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Expected %d subscribers on %s", n, channel)
+}
+
+// Wait until a request holds the in-flight lock of a task hash.
+func waitInflight(t *testing.T, r *redisClient, hash string) {
+	t.Helper()
+	for range 200 {
+		if n, _ := r.Exists(context.Background(), r.keys.inflight(hash)).Result(); n == 1 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Expected the in-flight lock to be taken")
+}
+
+// Test that identical concurrent requests run the task once and all get its result
+func TestCoalesceRunTask(t *testing.T) {
+	r, c := mockRedis(true)
+	defer r.Close()
+	setCoalesceConfig(t)
+	srv := httptest.NewServer(newRouter(r))
+	defer srv.Close()
+	coalesced := testutil.ToFloat64(coalescedRuns)
+
+	results := make(chan runTaskResponse, 2)
+	post := func(id string) {
+		body := `{"task_id": "` + id + `", "task_type": "embed", "label": "label-1", "parameters_json": "{}", "return_result": true}`
+		resp, err := http.Post(srv.URL+"/run-task", "application/json", strings.NewReader(body))
+		var out runTaskResponse
+		if err == nil {
+			json.NewDecoder(resp.Body).Decode(&out)
+			resp.Body.Close()
+		}
+		results <- out
+	}
+	go post("t1")
+	for info, _ := getTaskInfo(r, c, "t1"); info == nil; info, _ = getTaskInfo(r, c, "t1") {
+		time.Sleep(10 * time.Millisecond)
+	}
+	go post("t2")
+	hash := cacheHash(&taskRequest{TaskType: "embed", Label: "label-1", Parameters: "{}"})
+	waitSubscribers(t, r, r.keys.inflightDone(hash), 1)
+	waitSubscribers(t, r, r.keys.results("t1"), 2)
+	r.Publish(c, r.keys.results("t1"), "shared")
+
+	for range 2 {
+		if out := <-results; out.Message != "shared" {
+			t.Errorf("Expected the shared result, got %+v", out)
+		}
+	}
+	if info, _ := getTaskInfo(r, c, "t2"); info != nil {
+		t.Errorf("Expected the identical task not to be queued, got %+v", info)
+	}
+	if n, _ := r.LLen(c, r.keys.queue("work1")).Result(); n != 1 {
+		t.Errorf("Expected the task to be queued once, got %d", n)
+	}
+	if n := testutil.ToFloat64(coalescedRuns) - coalesced; n != 1 {
+		t.Errorf("Expected 1 coalesced request, got %v", n)
+	}
+	if n, _ := r.Exists(c, r.keys.inflight(hash)).Result(); n != 0 {
+		t.Error("Expected the in-flight lock to be released")
+	}
+}
+
+// Test that waiting requests keep their own timeout when the running request stops waiting, and get
+// the result if the task finishes later
+func TestCoalesceLeaderTimeout(t *testing.T) {
+	r, c := mockRedis(false)
+	defer r.Close()
+	setCoalesceConfig(t)
+	co := newCoalescer(r)
+	task := func(id string) *taskRequest {
+		return &taskRequest{TaskID: id, TaskType: "embed", Label: "a", Parameters: "{}"}
+	}
+
+	giveUp := make(chan struct{})
+	go co.do(c, task("t1"), func() (string, error) {
+		<-giveUp
+		return "", context.DeadlineExceeded
+	})
+	waitInflight(t, r, cacheHash(task("t1")))
+
+	type outcome struct {
+		result string
+		shared bool
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		result, shared, err := co.do(c, task("t2"), func() (string, error) {
+			return "", errors.New("identical task ran twice")
+		})
+		done <- outcome{result, shared, err}
+	}()
+	waitSubscribers(t, r, r.keys.inflightDone(cacheHash(task("t1"))), 1)
+	close(giveUp)
+	for n, _ := r.Exists(c, r.keys.inflight(cacheHash(task("t1")))).Result(); n == 1; n, _ = r.Exists(c, r.keys.inflight(cacheHash(task("t1")))).Result() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	r.Publish(c, r.keys.results("t1"), "late")
+
+	if out := <-done; out.result != "late" || !out.shared || out.err != nil {
+		t.Errorf("Expected the late result, got %+v", out)
+	}
+}
+
+// Test that errors of the running request reach the waiting ones, and that requests run on their
+// own once the lock is released or coalescing is disabled
+func TestCoalesceErrors(t *testing.T) {
+	r, c := mockRedis(false)
+	defer r.Close()
+	setCoalesceConfig(t)
+	co := newCoalescer(r)
+	tr := &taskRequest{TaskID: "t1", TaskType: "embed", Label: "a", Parameters: "{}"}
+	hash := cacheHash(tr)
+
+	fail := make(chan struct{})
+	go co.do(c, tr, func() (string, error) {
+		<-fail
+		return "", errors.New("no workers")
+	})
+	waitInflight(t, r, hash)
+	errs := make(chan error, 1)
+	go func() {
+		_, _, err := co.do(c, &taskRequest{TaskID: "t2", TaskType: "embed", Label: "a", Parameters: "{}"}, func() (string, error) {
+			return "", errors.New("identical task ran twice")
+		})
+		errs <- err
+	}()
+	waitSubscribers(t, r, r.keys.inflightDone(hash), 1)
+	close(fail)
+	if err := <-errs; err == nil || err.Error() != "no workers" {
+		t.Errorf("Expected the error of the running request, got %v", err)
+	}
+
+	runs := 0
+	run := func() (string, error) { runs++; return "ok", nil }
+	for _, cfg := range []*dispatcherConfig{currentConfig(), defaultConfig()} {
+		setConfig(cfg)
+		if result, shared, err := co.do(c, &taskRequest{TaskID: "t3", TaskType: "embed", Label: "a", Parameters: "{}"}, run); result != "ok" || shared || err != nil {
+			t.Errorf("Expected the request to run the task, got %q %v %v", result, shared, err)
+		}
+	}
+	if runs != 2 {
+		t.Errorf("Expected 2 runs, got %d", runs)
+	}
+}
@@ -0,0 +1,577 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Dispatcher configuration. Values are taken, in increasing order of precedence, from the defaults,
+// the YAML config file, environment variables, and CLI flags. See config.example.yaml for the schema.
+type dispatcherConfig struct {
+	Port       string           `yaml:"port"`
+	GRPCPort   string           `yaml:"grpc_port"`
+	Broker     string           `yaml:"broker"`
+	Redis      redisConfig      `yaml:"redis"`
+	NATS       natsConfig       `yaml:"nats"`
+	TLS        serverTLSConfig  `yaml:"tls"`
+	Routing    routingConfig    `yaml:"routing"`
+	Scheduling schedulingConfig `yaml:"scheduling"`
+	Cache      cacheConfig      `yaml:"cache"`
+	Blobs      blobsConfig      `yaml:"blobs"`
+	Timeouts   timeoutsConfig   `yaml:"timeouts"`
+	Health     healthConfig     `yaml:"health"`
+}
+
+// Settings for the connection to Redis. Changes require a restart.
+type redisConfig struct {
+	Host     string         `yaml:"host"`
+	Port     string         `yaml:"port"`
+	DB       int            `yaml:"db"`
+	Username string         `yaml:"username"`
+	Password string         `yaml:"password"`
+	TLS      redisTLSConfig `yaml:"tls"`
+	// Sentinel or cluster node addresses, as host:port. Host and port are only used without them.
+	Addrs []string `yaml:"addrs"`
+	// Name of the master monitored by the Sentinels at Addrs, to follow failovers.
+	MasterName       string `yaml:"master_name"`
+	SentinelPassword string `yaml:"sentinel_password"`
+	// Connect to a Redis Cluster, discovering the nodes from Addrs.
+	Cluster bool `yaml:"cluster"`
+	// Namespace of the keys, shared with the workers of this deployment. Deployments with different
+	// namespaces can share a Redis instance.
+	Namespace string `yaml:"namespace"`
+}
+
+// Settings for the connection to NATS, used when the broker is "nats". Changes require a restart.
+type natsConfig struct {
+	URL string `yaml:"url"`
+	// Namespace of the subjects, stream, and KV buckets, shared with the workers of this deployment.
+	Namespace string `yaml:"namespace"`
+}
+
+// Offloading of large task payloads to a blob store, shared with the workers. Changes require a
+// restart.
+type blobsConfig struct {
+	// Parameters larger than this many bytes are stored as blobs, and only a reference is queued. Zero
+	// disables offloading; results the workers offload are still resolved.
+	ThresholdBytes int `yaml:"threshold_bytes"`
+	// Where blobs are stored: "filesystem" or "s3".
+	Backend string `yaml:"backend"`
+	// Directory of the filesystem backend. The workers must see the same files.
+	Dir string   `yaml:"dir"`
+	S3  s3Config `yaml:"s3"`
+	// Blobs are deleted this long after they are stored, except the parameters of tasks still queued.
+	// At least the task status TTL, so results outlive the statuses recording them.
+	TTLSeconds int `yaml:"ttl_seconds"`
+}
+
+// Settings of an S3-compatible blob store.
+type s3Config struct {
+	// Host and optional port of the S3 API, such as "s3.amazonaws.com" or "minio:9000".
+	Endpoint        string `yaml:"endpoint"`
+	Bucket          string `yaml:"bucket"`
+	Region          string `yaml:"region"`
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+	// Use plain HTTP instead of HTTPS, for local stores.
+	Insecure bool `yaml:"insecure"`
+}
+
+type redisTLSConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	CAFile     string `yaml:"ca_file"`
+	CertFile   string `yaml:"cert_file"`
+	KeyFile    string `yaml:"key_file"`
+	ServerName string `yaml:"server_name"`
+}
+
+// Settings for serving the API over TLS. Changes require a restart, but the certificates themselves
+// are reloaded when they change.
+type serverTLSConfig struct {
+	CertFile   string `yaml:"cert_file"`
+	KeyFile    string `yaml:"key_file"`
+	CAFile     string `yaml:"ca_file"`
+	ClientAuth string `yaml:"client_auth"`
+}
+
+// Routing settings. These are reloaded without a restart.
+type routingConfig struct {
+	RandomDispatch      bool `yaml:"random_dispatch"`
+	MaxLabelsPerWorker  int  `yaml:"max_labels_per_worker"`
+	DemandWindowSeconds int  `yaml:"demand_window_seconds"`
+}
+
+// Tenant-fair scheduling of the common queues. These are reloaded without a restart.
+type schedulingConfig struct {
+	// Queue tasks for the common queues per tenant, and feed them to the common queues by deficit
+	// round-robin, so that a burst from one tenant does not delay the others.
+	Fair bool `yaml:"fair"`
+	// Tasks kept in each common queue for the workers to take. Fairness only applies to the tasks
+	// waiting behind them.
+	CommonQueueDepth int `yaml:"common_queue_depth"`
+	// Share of the common queues of each tenant, relative to the others. Tenants without a weight get
+	// the default one.
+	DefaultTenantWeight float64            `yaml:"default_tenant_weight"`
+	TenantWeights       map[string]float64 `yaml:"tenant_weights"`
+}
+
+// Weight of a tenant for fair scheduling.
+func (s schedulingConfig) weight(tenant string) float64 {
+	if w, ok := s.TenantWeights[tenant]; ok {
+		return w
+	}
+	return s.DefaultTenantWeight
+}
+
+// Result cache of deterministic task types, and coalescing of identical requests. These are reloaded
+// without a restart.
+type cacheConfig struct {
+	// Serve /run-task results from the cache when the same task ran recently.
+	Enabled bool `yaml:"enabled"`
+	// Seconds the results of each task type are kept. Task types without a TTL are never cached.
+	TTLSeconds map[string]int `yaml:"ttl_seconds"`
+	// Run identical concurrent /run-task requests once, across replicas, and share the result.
+	Coalesce bool `yaml:"coalesce"`
+}
+
+// How long the results of a task type are cached, or zero if they are not.
+func (c cacheConfig) ttl(taskType string) time.Duration {
+	if !c.Enabled {
+		return 0
+	}
+	return time.Duration(c.TTLSeconds[taskType]) * time.Second
+}
+
+// Timeouts. These are reloaded without a restart.
+type timeoutsConfig struct {
+	TaskSeconds         int `yaml:"task_seconds"`
+	RedisOpMilliseconds int `yaml:"redis_op_milliseconds"`
+	DrainDelaySeconds   int `yaml:"drain_delay_seconds"`
+}
+
+// Thresholds for the readiness checks. These are reloaded without a restart.
+type healthConfig struct {
+	RedisLatencyDegradedMs int `yaml:"redis_latency_degraded_ms"`
+	BacklogDegraded        int `yaml:"backlog_degraded"`
+	BacklogFailed          int `yaml:"backlog_failed"`
+}
+
+func defaultConfig() *dispatcherConfig {
+	return &dispatcherConfig{
+		Port:     "8080",
+		GRPCPort: "50051",
+		Broker:   brokerRedis,
+		Redis:    redisConfig{Host: "localhost", Port: "6379", Addrs: []string{}, Namespace: defaultNamespace},
+		NATS:     natsConfig{URL: "nats://localhost:4222", Namespace: defaultNamespace},
+		TLS:      serverTLSConfig{ClientAuth: "none"},
+		Routing: routingConfig{
+			MaxLabelsPerWorker:  defaultMaxLabelsPerWorker,
+			DemandWindowSeconds: defaultDemandWindowSeconds,
+		},
+		Scheduling: schedulingConfig{
+			CommonQueueDepth:    defaultCommonQueueDepth,
+			DefaultTenantWeight: 1,
+			TenantWeights:       map[string]float64{},
+		},
+		Cache: cacheConfig{TTLSeconds: map[string]int{}},
+		Blobs: blobsConfig{Backend: blobBackendFilesystem, Dir: defaultBlobDir, TTLSeconds: taskStatusTTLSeconds},
+		Timeouts: timeoutsConfig{
+			TaskSeconds:         defaultTaskTimeoutSeconds,
+			RedisOpMilliseconds: defaultOpTimeoutMilliseconds,
+			DrainDelaySeconds:   defaultDrainDelaySeconds,
+		},
+		Health: healthConfig{
+			RedisLatencyDegradedMs: defaultLatencyDegradedMilliseconds,
+			BacklogDegraded:        defaultBacklogDegraded,
+			BacklogFailed:          defaultBacklogFailed,
+		},
+	}
+}
+
+// Namespaces are used in key patterns and hash tags, so they cannot contain glob characters or braces.
+var namespacePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// Check that the configuration values are usable.
+func (cfg *dispatcherConfig) validate() error {
+	errs := []error{}
+	if _, err := strconv.ParseUint(cfg.Port, 10, 16); err != nil {
+		errs = append(errs, fmt.Errorf("port: invalid port %q", cfg.Port))
+	}
+	if _, err := strconv.ParseUint(cfg.GRPCPort, 10, 16); err != nil {
+		errs = append(errs, fmt.Errorf("grpc_port: invalid port %q", cfg.GRPCPort))
+	}
+	if cfg.Broker != brokerRedis && cfg.Broker != brokerNATS {
+		errs = append(errs, fmt.Errorf("broker: unknown broker %q, use %q or %q", cfg.Broker, brokerRedis, brokerNATS))
+	}
+	if cfg.Broker == brokerNATS && cfg.NATS.URL == "" {
+		errs = append(errs, errors.New("nats.url: must not be empty"))
+	}
+	if !namespacePattern.MatchString(cfg.NATS.Namespace) {
+		errs = append(errs, fmt.Errorf("nats.namespace: invalid namespace %q, use letters, digits, '.', '_' and '-'", cfg.NATS.Namespace))
+	}
+	if cfg.Blobs.ThresholdBytes < 0 {
+		errs = append(errs, errors.New("blobs.threshold_bytes: must not be negative"))
+	}
+	switch cfg.Blobs.Backend {
+	case blobBackendFilesystem:
+		if cfg.Blobs.Dir == "" {
+			errs = append(errs, errors.New("blobs.dir: must not be empty"))
+		}
+	case blobBackendS3:
+		if cfg.Blobs.S3.Endpoint == "" || cfg.Blobs.S3.Bucket == "" {
+			errs = append(errs, errors.New("blobs.s3: endpoint and bucket are required"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("blobs.backend: unknown backend %q, use %q or %q", cfg.Blobs.Backend, blobBackendFilesystem, blobBackendS3))
+	}
+	if cfg.Blobs.TTLSeconds < taskStatusTTLSeconds {
+		errs = append(errs, fmt.Errorf("blobs.ttl_seconds: must be at least the task status TTL of %d seconds", taskStatusTTLSeconds))
+	}
+	if cfg.Redis.Host == "" {
+		errs = append(errs, errors.New("redis.host: must not be empty"))
+	}
+	if _, err := strconv.ParseUint(cfg.Redis.Port, 10, 16); err != nil {
+		errs = append(errs, fmt.Errorf("redis.port: invalid port %q", cfg.Redis.Port))
+	}
+	if cfg.Redis.DB < 0 {
+		errs = append(errs, errors.New("redis.db: must not be negative"))
+	}
+	if !namespacePattern.MatchString(cfg.Redis.Namespace) {
+		errs = append(errs, fmt.Errorf("redis.namespace: invalid namespace %q, use letters, digits, '.', '_' and '-'", cfg.Redis.Namespace))
+	}
+	if cfg.Redis.MasterName != "" && cfg.Redis.Cluster {
+		errs = append(errs, errors.New("redis: master_name and cluster cannot be used together"))
+	}
+	if (cfg.Redis.MasterName != "" || cfg.Redis.Cluster) && len(cfg.Redis.Addrs) == 0 {
+		errs = append(errs, errors.New("redis.addrs: required for Sentinel and cluster connections"))
+	}
+	if cfg.Redis.MasterName == "" && !cfg.Redis.Cluster && len(cfg.Redis.Addrs) > 0 {
+		errs = append(errs, errors.New("redis.addrs: only used with master_name or cluster"))
+	}
+	if cfg.Redis.Cluster && cfg.Redis.DB != 0 {
+		errs = append(errs, errors.New("redis.db: must be 0 on a cluster"))
+	}
+	if (cfg.TLS.CertFile == "") != (cfg.TLS.KeyFile == "") {
+		errs = append(errs, errors.New("tls: cert_file and key_file must be set together"))
+	}
+	if ca, err := parseClientAuth(cfg.TLS.ClientAuth); err != nil {
+		errs = append(errs, fmt.Errorf("tls.client_auth: %w", err))
+	} else if ca != tls.NoClientCert && cfg.TLS.CAFile == "" {
+		errs = append(errs, errors.New("tls.ca_file: required to verify client certificates"))
+	}
+	if cfg.Routing.MaxLabelsPerWorker < 1 {
+		errs = append(errs, errors.New("routing.max_labels_per_worker: must be at least 1"))
+	}
+	if cfg.Routing.DemandWindowSeconds < demandBucketSeconds {
+		errs = append(errs, fmt.Errorf("routing.demand_window_seconds: must be at least %d", demandBucketSeconds))
+	}
+	if cfg.Scheduling.Fair && cfg.Broker != brokerRedis {
+		errs = append(errs, fmt.Errorf("scheduling.fair: needs the %q broker, the %q broker has no tenant queues", brokerRedis, cfg.Broker))
+	}
+	if cfg.Scheduling.CommonQueueDepth < 1 {
+		errs = append(errs, errors.New("scheduling.common_queue_depth: must be at least 1"))
+	}
+	if cfg.Scheduling.DefaultTenantWeight <= 0 {
+		errs = append(errs, errors.New("scheduling.default_tenant_weight: must be positive"))
+	}
+	for tenant, w := range cfg.Scheduling.TenantWeights {
+		if w <= 0 {
+			errs = append(errs, fmt.Errorf("scheduling.tenant_weights: weight of %q must be positive", tenant))
+		}
+	}
+	for taskType, ttl := range cfg.Cache.TTLSeconds {
+		if ttl < 1 {
+			errs = append(errs, fmt.Errorf("cache.ttl_seconds: TTL of %q must be at least 1", taskType))
+		}
+	}
+	if cfg.Timeouts.TaskSeconds < 1 {
+		errs = append(errs, errors.New("timeouts.task_seconds: must be at least 1"))
+	}
+	if cfg.Timeouts.RedisOpMilliseconds < 1 {
+		errs = append(errs, errors.New("timeouts.redis_op_milliseconds: must be at least 1"))
+	}
+	if cfg.Timeouts.DrainDelaySeconds < 0 {
+		errs = append(errs, errors.New("timeouts.drain_delay_seconds: must not be negative"))
+	}
+	if cfg.Health.RedisLatencyDegradedMs < 1 {
+		errs = append(errs, errors.New("health.redis_latency_degraded_ms: must be at least 1"))
+	}
+	if cfg.Health.BacklogDegraded < 1 || cfg.Health.BacklogFailed <= cfg.Health.BacklogDegraded {
+		errs = append(errs, errors.New("health: backlog_failed must be greater than backlog_degraded, which must be at least 1"))
+	}
+	return errors.Join(errs...)
+}
+
+// Read the YAML config file on top of the defaults. Unknown keys are rejected to catch typos.
+func readConfigFile(path string) (*dispatcherConfig, error) {
+	cfg := defaultConfig()
+	if path == "" {
+		return cfg, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Override configuration values with the environment variables that are set.
+func (cfg *dispatcherConfig) applyEnv() error {
+	str := map[string]*string{
+		"PORT":                      &cfg.Port,
+		"GRPC_PORT":                 &cfg.GRPCPort,
+		"BROKER":                    &cfg.Broker,
+		"NATS_URL":                  &cfg.NATS.URL,
+		"NATS_NAMESPACE":            &cfg.NATS.Namespace,
+		"BLOB_BACKEND":              &cfg.Blobs.Backend,
+		"BLOB_DIR":                  &cfg.Blobs.Dir,
+		"BLOB_S3_ENDPOINT":          &cfg.Blobs.S3.Endpoint,
+		"BLOB_S3_BUCKET":            &cfg.Blobs.S3.Bucket,
+		"BLOB_S3_REGION":            &cfg.Blobs.S3.Region,
+		"BLOB_S3_ACCESS_KEY_ID":     &cfg.Blobs.S3.AccessKeyID,
+		"BLOB_S3_SECRET_ACCESS_KEY": &cfg.Blobs.S3.SecretAccessKey,
+		"REDIS_HOST":                &cfg.Redis.Host,
+		"REDIS_PORT":                &cfg.Redis.Port,
+		"REDIS_USERNAME":            &cfg.Redis.Username,
+		"REDIS_PASSWORD":            &cfg.Redis.Password,
+		"REDIS_TLS_CA_FILE":         &cfg.Redis.TLS.CAFile,
+		"REDIS_TLS_CERT_FILE":       &cfg.Redis.TLS.CertFile,
+		"REDIS_TLS_KEY_FILE":        &cfg.Redis.TLS.KeyFile,
+		"REDIS_TLS_SERVER_NAME":     &cfg.Redis.TLS.ServerName,
+		"REDIS_NAMESPACE":           &cfg.Redis.Namespace,
+		"REDIS_MASTER_NAME":         &cfg.Redis.MasterName,
+		"REDIS_SENTINEL_PASSWORD":   &cfg.Redis.SentinelPassword,
+		"TLS_CERT_FILE":             &cfg.TLS.CertFile,
+		"TLS_KEY_FILE":              &cfg.TLS.KeyFile,
+		"TLS_CA_FILE":               &cfg.TLS.CAFile,
+		"TLS_CLIENT_AUTH":           &cfg.TLS.ClientAuth,
+	}
+	for k, p := range str {
+		if v, ok := os.LookupEnv(k); ok && v != "" {
+			*p = v
+		}
+	}
+
+	if v, ok := os.LookupEnv("REDIS_ADDRS"); ok && v != "" {
+		cfg.Redis.Addrs = strings.Split(v, ",")
+	}
+
+	ints := map[string]*int{
+		"REDIS_DB":              &cfg.Redis.DB,
+		"MAX_LABELS_WORKER":     &cfg.Routing.MaxLabelsPerWorker,
+		"DEMAND_WINDOW_SECONDS": &cfg.Routing.DemandWindowSeconds,
+		"COMMON_QUEUE_DEPTH":    &cfg.Scheduling.CommonQueueDepth,
+		"BLOB_THRESHOLD_BYTES":  &cfg.Blobs.ThresholdBytes,
+		"BLOB_TTL_SECONDS":      &cfg.Blobs.TTLSeconds,
+		"TASK_TIMEOUT_SECONDS":  &cfg.Timeouts.TaskSeconds,
+		"REDIS_OP_TIMEOUT_MS":   &cfg.Timeouts.RedisOpMilliseconds,
+		"DRAIN_DELAY_SECONDS":   &cfg.Timeouts.DrainDelaySeconds,
+	}
+	for k, p := range ints {
+		if v, ok := os.LookupEnv(k); ok && v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %w", k, err)
+			}
+			*p = n
+		}
+	}
+
+	bools := map[string]*bool{
+		"RANDOM_DISPATCH":   &cfg.Routing.RandomDispatch,
+		"FAIR_SCHEDULING":   &cfg.Scheduling.Fair,
+		"RESULT_CACHE":      &cfg.Cache.Enabled,
+		"COALESCE_RUN_TASK": &cfg.Cache.Coalesce,
+		"BLOB_S3_INSECURE":  &cfg.Blobs.S3.Insecure,
+		"REDIS_TLS":         &cfg.Redis.TLS.Enabled,
+		"REDIS_CLUSTER":     &cfg.Redis.Cluster,
+	}
+	for k, p := range bools {
+		if v, ok := os.LookupEnv(k); ok && v != "" {
+			*p = v == "true"
+		}
+	}
+	return nil
+}
+
+// CLI flags that override the configuration file and environment variables.
+type configFlags struct {
+	path               string
+	randomDispatch     bool
+	maxLabelsPerWorker int
+	set                map[string]bool
+}
+
+// Register the configuration CLI flags on the given flag set.
+func (cf *configFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&cf.path, "config", os.Getenv("DISPATCHER_CONFIG"), "Path to the YAML configuration file")
+	fs.BoolVar(&cf.randomDispatch, "random-dispatch", false, "Use random dispatching instead of 'smart' dispatching")
+	fs.IntVar(&cf.maxLabelsPerWorker, "max-labels-worker", defaultMaxLabelsPerWorker, "Label limit for workers that do not publish their own")
+}
+
+// Record which flags were given explicitly, so that only those override other sources.
+func (cf *configFlags) parsed(fs *flag.FlagSet) {
+	cf.set = map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { cf.set[f.Name] = true })
+}
+
+func (cf *configFlags) apply(cfg *dispatcherConfig) {
+	if cf.set["random-dispatch"] {
+		cfg.Routing.RandomDispatch = cf.randomDispatch
+	}
+	if cf.set["max-labels-worker"] {
+		cfg.Routing.MaxLabelsPerWorker = cf.maxLabelsPerWorker
+	}
+}
+
+// Build the configuration from all sources and validate it.
+func loadConfig(cf *configFlags) (*dispatcherConfig, error) {
+	cfg, err := readConfigFile(cf.path)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+	cf.apply(cfg)
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return cfg, nil
+}
+
+// The active configuration. Requests read it once per operation, so reloads never affect
+// an operation that is already in progress.
+var activeConfig atomic.Pointer[dispatcherConfig]
+
+func init() {
+	activeConfig.Store(defaultConfig())
+}
+
+func currentConfig() *dispatcherConfig {
+	return activeConfig.Load()
+}
+
+func setConfig(cfg *dispatcherConfig) {
+	activeConfig.Store(cfg)
+}
+
+// Timeout for individual Redis operations.
+func opTimeout() time.Duration {
+	return time.Duration(currentConfig().Timeouts.RedisOpMilliseconds) * time.Millisecond
+}
+
+// Timeout to wait for the result of a synchronous task.
+func taskTimeout() time.Duration {
+	return time.Duration(currentConfig().Timeouts.TaskSeconds) * time.Second
+}
+
+// Reload the configuration from all sources. Only the routing, scheduling and cache settings,
+// timeouts, and health thresholds are applied; changes to other settings are reported and ignored
+// until the next restart.
+func reloadConfig(cf *configFlags) error {
+	next, err := loadConfig(cf)
+	if err != nil {
+		return err
+	}
+	prev := currentConfig()
+	applied := *prev
+	applied.Routing = next.Routing
+	applied.Scheduling = next.Scheduling
+	applied.Cache = next.Cache
+	applied.Timeouts = next.Timeouts
+	applied.Health = next.Health
+
+	restart := *next
+	restart.Routing, restart.Scheduling, restart.Cache = prev.Routing, prev.Scheduling, prev.Cache
+	restart.Timeouts, restart.Health = prev.Timeouts, prev.Health
+	if !reflect.DeepEqual(restart, *prev) {
+		slog.Warn("Configuration changes outside 'routing', 'scheduling', 'cache', 'timeouts', and 'health' require a restart")
+	}
+	setConfig(&applied)
+	slog.Info(
+		"Configuration reloaded",
+		"random_dispatch", applied.Routing.RandomDispatch,
+		"max_labels_per_worker", applied.Routing.MaxLabelsPerWorker,
+		"demand_window_seconds", applied.Routing.DemandWindowSeconds,
+		"fair_scheduling", applied.Scheduling.Fair,
+		"result_cache", applied.Cache.Enabled,
+		"coalesce_run_task", applied.Cache.Coalesce,
+		"task_timeout_seconds", applied.Timeouts.TaskSeconds,
+		"redis_op_timeout_ms", applied.Timeouts.RedisOpMilliseconds,
+	)
+	return nil
+}
+
+// Modification time of a file, or the zero time if it cannot be read.
+func modTime(path string) time.Time {
+	st, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return st.ModTime()
+}
+
+// Call reload on SIGHUP, or when changed reports that the watched files were modified, until the context is done.
+func watchForReload(c context.Context, interval time.Duration, changed func() bool, reload func(reason string)) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.Done():
+			return
+		case <-hup:
+			reload("signal")
+		case <-ticker.C:
+			if changed() {
+				reload("file change")
+			}
+		}
+	}
+}
+
+// Reload the configuration on SIGHUP or when the config file changes.
+func watchConfig(c context.Context, cf *configFlags) {
+	last := modTime(cf.path)
+	changed := func() bool {
+		if cf.path == "" {
+			return false
+		}
+		m := modTime(cf.path)
+		if m.Equal(last) {
+			return false
+		}
+		last = m
+		return true
+	}
+	watchForReload(c, configReloadIntervalSeconds*time.Second, changed, func(reason string) {
+		if err := reloadConfig(cf); err != nil {
+			slog.Error("Unable to reload configuration, keeping the current one", "error", err, "reason", reason)
+		}
+	})
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Write a config file to a temporary directory and return its path.
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(p, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// Parse the given CLI args into config flags.
+func parseConfigFlags(t *testing.T, args ...string) *configFlags {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cf := &configFlags{}
+	cf.register(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	cf.parsed(fs)
+	return cf
+}
+
+// The example config documents the defaults, so loading it must give the default config.
+func TestExampleConfigMatchesDefaults(t *testing.T) {
+	cfg, err := readConfigFile(filepath.Join("..", "config.example.yaml"))
+	if err != nil {
+		t.Fatalf("Error reading example config: %v", err)
+	}
+	if !reflect.DeepEqual(cfg, defaultConfig()) {
+		t.Errorf("Expected example config to match defaults, got %+v", cfg)
+	}
+}
+
+// Test the precedence of file, environment, and flags
+func TestConfigPrecedence(t *testing.T) {
+	p := writeConfigFile(t, `
+port: "9000"
+redis:
+  host: "redis.internal"
+routing:
+  random_dispatch: true
+  max_labels_per_worker: 4
+timeouts:
+  task_seconds: 10
+`)
+	t.Setenv("REDIS_HOST", "redis.env")
+	t.Setenv("TASK_TIMEOUT_SECONDS", "20")
+	cf := parseConfigFlags(t, "--config", p, "--max-labels-worker", "6")
+
+	cfg, err := loadConfig(cf)
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	if cfg.Port != "9000" || !cfg.Routing.RandomDispatch {
+		t.Errorf("Expected values from the config file, got %+v", cfg)
+	}
+	if cfg.Redis.Host != "redis.env" || cfg.Timeouts.TaskSeconds != 20 {
+		t.Errorf("Expected environment to override the file, got %+v", cfg)
+	}
+	if cfg.Routing.MaxLabelsPerWorker != 6 {
+		t.Errorf("Expected flag to override the file, got %d", cfg.Routing.MaxLabelsPerWorker)
+	}
+	if cfg.Redis.Port != "6379" || cfg.Timeouts.RedisOpMilliseconds != defaultOpTimeoutMilliseconds {
+		t.Errorf("Expected defaults for unset values, got %+v", cfg)
+	}
+}
+
+func TestConfigValidation(t *testing.T) {
+	p := writeConfigFile(t, `
+port: "http"
+broker: "kafka"
+routing:
+  max_labels_per_worker: 0
+scheduling:
+  common_queue_depth: 0
+  tenant_weights:
+    acme: -1
+cache:
+  ttl_seconds:
+    embed: 0
+blobs:
+  backend: s3
+  ttl_seconds: 60
+tls:
+  cert_file: "server.crt"
+  key_file: "server.key"
+  client_auth: "require"
+`)
+	_, err := loadConfig(parseConfigFlags(t, "--config", p))
+	if err == nil {
+		t.Fatal("Expected validation error")
+	}
+	for _, exp := range []string{"port", "broker", "max_labels_per_worker", "common_queue_depth", `"acme"`, `"embed"`, "blobs.s3", "blobs.ttl_seconds", "tls.ca_file"} {
+		if !strings.Contains(err.Error(), exp) {
+			t.Errorf("Expected error to mention %s, got: %v", exp, err)
+		}
+	}
+
+	// Fair scheduling only runs on Redis
+	p = writeConfigFile(t, "broker: nats\nnats:\n  url: nats://localhost:4222\nscheduling:\n  fair: true\n")
+	if _, err := loadConfig(parseConfigFlags(t, "--config", p)); err == nil || !strings.Contains(err.Error(), "scheduling.fair") {
+		t.Errorf("Expected fair scheduling to be rejected on NATS, got %v", err)
+	}
+
+	p = writeConfigFile(t, "routing:\n  max_labels: 3\n")
+	if _, err := loadConfig(parseConfigFlags(t, "--config", p)); err == nil {
+		t.Error("Expected error for unknown config key")
+	}
+}
+
+// Test that the Redis settings pick a single node, Sentinel, or cluster client
+func TestRedisModes(t *testing.T) {
+	t.Setenv("REDIS_ADDRS", "sentinel-1:26379,sentinel-2:26379")
+	t.Setenv("REDIS_MASTER_NAME", "tasks")
+	cfg, err := loadConfig(parseConfigFlags(t))
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	if !slices.Equal(cfg.Redis.Addrs, []string{"sentinel-1:26379", "sentinel-2:26379"}) || cfg.Redis.MasterName != "tasks" {
+		t.Errorf("Expected Sentinel settings from the environment, got %+v", cfg.Redis)
+	}
+
+	t.Setenv("REDIS_CLUSTER", "true")
+	t.Setenv("REDIS_DB", "1")
+	_, err = loadConfig(parseConfigFlags(t))
+	for _, exp := range []string{"master_name and cluster", "redis.db"} {
+		if err == nil || !strings.Contains(err.Error(), exp) {
+			t.Errorf("Expected error to mention %s, got: %v", exp, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		cfg  redisConfig
+		kind string
+	}{
+		{redisConfig{Host: "localhost", Port: "6379"}, "*redis.Client"},
+		{redisConfig{Addrs: []string{"localhost:26379"}, MasterName: "tasks"}, "*redis.Client"},
+		{redisConfig{Addrs: []string{"localhost:7000"}, Cluster: true}, "*redis.ClusterClient"},
+	} {
+		r, err := newRedisClient(t.Context(), tc.cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kind := fmt.Sprintf("%T", r.UniversalClient); kind != tc.kind {
+			t.Errorf("Expected %s for %+v, got %s", tc.kind, tc.cfg, kind)
+		}
+		r.Close()
+	}
+}
+
+// Test that a reload applies routing and timeouts only, and keeps the config if the new one is invalid
+func TestConfigReload(t *testing.T) {
+	defer setConfig(defaultConfig())
+	p := writeConfigFile(t, "port: \"8080\"\n")
+	cf := parseConfigFlags(t, "--config", p)
+	cfg, err := loadConfig(cf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setConfig(cfg)
+
+	if err := os.WriteFile(p, []byte("port: \"9090\"\nrouting:\n  random_dispatch: true\ntimeouts:\n  task_seconds: 5\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloadConfig(cf); err != nil {
+		t.Fatalf("Error reloading config: %v", err)
+	}
+	cur := currentConfig()
+	if !cur.Routing.RandomDispatch || taskTimeout().Seconds() != 5 {
+		t.Errorf("Expected routing and timeouts to be reloaded, got %+v", cur)
+	}
+	if cur.Port != "8080" {
+		t.Errorf("Expected port change to be ignored until restart, got %s", cur.Port)
+	}
+
+	if err := os.WriteFile(p, []byte("timeouts:\n  task_seconds: -1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloadConfig(cf); err == nil {
+		t.Error("Expected invalid config to be rejected")
+	}
+	if currentConfig() != cur {
+		t.Error("Expected the previous config to remain active")
+	}
+}
@@ -1,11 +1,100 @@
 package main
 
-const availableWorkersKey = "task-runners:available"
+// Namespace of the Redis keys and NATS subjects when none is configured.
+const defaultNamespace = "task-runners"
 
-const runningWorkerskey = "task-runners:running"
+// Brokers the dispatcher can route tasks through.
+const (
+	brokerRedis = "redis"
+	brokerNATS  = "nats"
+)
 
-const workersLabelCountKey = "task-runners:labels:count"
+// Fields of the worker info hash: the worker's label limit, its memory budget for labels in bytes,
+// and its attributes as a JSON object of strings.
+const (
+	maxLabelsField    = "max_labels"
+	memoryBudgetField = "memory_budget_bytes"
+	attributesField   = "attributes"
+)
 
-const taskTimeoutSeconds = 45
+// Worker attribute naming the pool the worker belongs to. Each pool has its own common queue.
+const poolAttribute = "pool"
 
-const opTimeoutMilliseconds = 250
+// Prefix of the common queue IDs of worker pools, so that they cannot collide with worker IDs.
+const poolQueuePrefix = "pool:"
+
+// Tenant of the tasks that do not name one, for fair scheduling.
+const defaultTenant = "default"
+
+// Tenant label of the tenant metrics for the tenants without a configured weight.
+const otherTenantsLabel = "other"
+
+// Blob store backends for offloaded payloads.
+const (
+	blobBackendFilesystem = "filesystem"
+	blobBackendS3         = "s3"
+)
+
+const defaultBlobDir = "/var/lib/task-runners/blobs"
+
+// Workers opt in to typed and offloaded results by starting them with this envelope: a byte that
+// cannot start valid UTF-8 text, then the envelope version. Results without it are plain text.
+const resultEnvelope = "\xff\x01"
+
+// Results stored as blobs are published as this prefix followed by the blob key.
+const blobRefPrefix = resultEnvelope + "blob:"
+
+// Blobs older than the blob TTL are deleted this often.
+const blobSweepIntervalSeconds = 60
+
+// Task status records expire after this long, matching the worker's default result TTL.
+const taskStatusTTLSeconds = 1800
+
+const defaultTaskTimeoutSeconds = 45
+
+const defaultOpTimeoutMilliseconds = 250
+
+const defaultMaxLabelsPerWorker = 2
+
+// Recent label demand is counted in buckets of this many seconds, over the configured window.
+const demandBucketSeconds = 60
+
+const defaultDemandWindowSeconds = 300
+
+const defaultCommonQueueDepth = 10
+
+const certReloadIntervalSeconds = 30
+
+const configReloadIntervalSeconds = 10
+
+const defaultDrainDelaySeconds = 2
+
+const shutdownMarginSeconds = 5
+
+const scanBatchSize = 100
+
+// The fair scheduler tops up the common queues from the tenant queues at least this often, and right
+// after a task is queued for a tenant.
+const fairFeedIntervalMilliseconds = 50
+
+// Upper bound on the tasks the fair scheduler moves into a common queue in one pass.
+const fairFeedBatch = 100
+
+// The in-flight lock of coalesced requests outlives the task timeout by this much, in case the
+// request that holds it stops without releasing it.
+const inflightLockMarginSeconds = 5
+
+// Attempts to join a coalesced request whose lock is released in between, before running the task
+// without coalescing.
+const coalesceAttempts = 3
+
+// Introspection reads many keys, so it gets a multiple of the Redis operation timeout.
+const introspectionTimeoutFactor = 4
+
+// The health endpoint reports degraded above this Redis round trip latency, and degraded or failed
+// above these numbers of queued tasks.
+const (
+	defaultLatencyDegradedMilliseconds = 50
+	defaultBacklogDegraded             = 100
+	defaultBacklogFailed               = 1000
+)
@@ -0,0 +1,1031 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.8
+// 	protoc        v6.32.1
+// source: dispatcher.proto
+
+package dispatcherpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type TaskStatus int32
+
+const (
+	TaskStatus_TASK_STATUS_UNSPECIFIED TaskStatus = 0
+	TaskStatus_TASK_STATUS_QUEUED      TaskStatus = 1
+	TaskStatus_TASK_STATUS_RUNNING     TaskStatus = 2
+	TaskStatus_TASK_STATUS_COMPLETED   TaskStatus = 3
+	TaskStatus_TASK_STATUS_FAILED      TaskStatus = 4
+)
+
+// Enum value maps for TaskStatus.
+var (
+	TaskStatus_name = map[int32]string{
+		0: "TASK_STATUS_UNSPECIFIED",
+		1: "TASK_STATUS_QUEUED",
+		2: "TASK_STATUS_RUNNING",
+		3: "TASK_STATUS_COMPLETED",
+		4: "TASK_STATUS_FAILED",
+	}
+	TaskStatus_value = map[string]int32{
+		"TASK_STATUS_UNSPECIFIED": 0,
+		"TASK_STATUS_QUEUED":      1,
+		"TASK_STATUS_RUNNING":     2,
+		"TASK_STATUS_COMPLETED":   3,
+		"TASK_STATUS_FAILED":      4,
+	}
+)
+
+func (x TaskStatus) Enum() *TaskStatus {
+	p := new(TaskStatus)
+	*p = x
+	return p
+}
+
+func (x TaskStatus) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (TaskStatus) Descriptor() protoreflect.EnumDescriptor {
+	return file_dispatcher_proto_enumTypes[0].Descriptor()
+}
+
+func (TaskStatus) Type() protoreflect.EnumType {
+	return &file_dispatcher_proto_enumTypes[0]
+}
+
+func (x TaskStatus) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use TaskStatus.Descriptor instead.
+func (TaskStatus) EnumDescriptor() ([]byte, []int) {
+	return file_dispatcher_proto_rawDescGZIP(), []int{0}
+}
+
+// A task to run on a worker.
+type TaskSpec struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	TaskId         string                 `protobuf:"bytes,1,opt,name=task_id,json=taskId,proto3" json:"task_id,omitempty"`
+	TaskType       string                 `protobuf:"bytes,2,opt,name=task_type,json=taskType,proto3" json:"task_type,omitempty"`
+	Label          string                 `protobuf:"bytes,3,opt,name=label,proto3" json:"label,omitempty"`
+	ParametersJson string                 `protobuf:"bytes,4,opt,name=parameters_json,json=parametersJson,proto3" json:"parameters_json,omitempty"`
+	// Labels the task needs together on one worker. The single label, if set, is added to them.
+	Labels []string `protobuf:"bytes,5,rep,name=labels,proto3" json:"labels,omitempty"`
+	// Worker attributes the task requires or prefers.
+	Selector *Selector `protobuf:"bytes,6,opt,name=selector,proto3" json:"selector,omitempty"`
+	// Tenant the task is scheduled for when it waits in a common queue, with fair scheduling.
+	Tenant string `protobuf:"bytes,7,opt,name=tenant,proto3" json:"tenant,omitempty"`
+	// Parameters in another format than JSON, or compressed, instead of parameters_json. The content
+	// type is "application/msgpack" or "application/octet-stream" (JSON by default), and the encoding
+	// is empty or "zstd".
+	ParametersData     []byte `protobuf:"bytes,8,opt,name=parameters_data,json=parametersData,proto3" json:"parameters_data,omitempty"`
+	ParametersType     string `protobuf:"bytes,9,opt,name=parameters_type,json=parametersType,proto3" json:"parameters_type,omitempty"`
+	ParametersEncoding string `protobuf:"bytes,10,opt,name=parameters_encoding,json=parametersEncoding,proto3" json:"parameters_encoding,omitempty"`
+	unknownFields      protoimpl.UnknownFields
+	sizeCache          protoimpl.SizeCache
+}
+
+func (x *TaskSpec) Reset() {
+	*x = TaskSpec{}
+	mi := &file_dispatcher_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TaskSpec) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TaskSpec) ProtoMessage() {}
+
+func (x *TaskSpec) ProtoReflect() protoreflect.Message {
+	mi := &file_dispatcher_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TaskSpec.ProtoReflect.Descriptor instead.
+func (*TaskSpec) Descriptor() ([]byte, []int) {
+	return file_dispatcher_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *TaskSpec) GetTaskId() string {
+	if x != nil {
+		return x.TaskId
+	}
+	return ""
+}
+
+func (x *TaskSpec) GetTaskType() string {
+	if x != nil {
+		return x.TaskType
+	}
+	return ""
+}
+
+func (x *TaskSpec) GetLabel() string {
+	if x != nil {
+		return x.Label
+	}
+	return ""
+}
+
+func (x *TaskSpec) GetParametersJson() string {
+	if x != nil {
+		return x.ParametersJson
+	}
+	return ""
+}
+
+func (x *TaskSpec) GetLabels() []string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *TaskSpec) GetSelector() *Selector {
+	if x != nil {
+		return x.Selector
+	}
+	return nil
+}
+
+func (x *TaskSpec) GetTenant() string {
+	if x != nil {
+		return x.Tenant
+	}
+	return ""
+}
+
+func (x *TaskSpec) GetParametersData() []byte {
+	if x != nil {
+		return x.ParametersData
+	}
+	return nil
+}
+
+func (x *TaskSpec) GetParametersType() string {
+	if x != nil {
+		return x.ParametersType
+	}
+	return ""
+}
+
+func (x *TaskSpec) GetParametersEncoding() string {
+	if x != nil {
+		return x.ParametersEncoding
+	}
+	return ""
+}
+
+// Worker attributes a task is matched against. The "pool" attribute picks the worker pool.
+type Selector struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Required      map[string]string      `protobuf:"bytes,1,rep,name=required,proto3" json:"required,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Preferred     map[string]string      `protobuf:"bytes,2,rep,name=preferred,proto3" json:"preferred,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Selector) Reset() {
+	*x = Selector{}
+	mi := &file_dispatcher_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Selector) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Selector) ProtoMessage() {}
+
+func (x *Selector) ProtoReflect() protoreflect.Message {
+	mi := &file_dispatcher_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Selector.ProtoReflect.Descriptor instead.
+func (*Selector) Descriptor() ([]byte, []int) {
+	return file_dispatcher_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Selector) GetRequired() map[string]string {
+	if x != nil {
+		return x.Required
+	}
+	return nil
+}
+
+func (x *Selector) GetPreferred() map[string]string {
+	if x != nil {
+		return x.Preferred
+	}
+	return nil
+}
+
+type SendTaskRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Task          *TaskSpec              `protobuf:"bytes,1,opt,name=task,proto3" json:"task,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SendTaskRequest) Reset() {
+	*x = SendTaskRequest{}
+	mi := &file_dispatcher_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SendTaskRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SendTaskRequest) ProtoMessage() {}
+
+func (x *SendTaskRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_dispatcher_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SendTaskRequest.ProtoReflect.Descriptor instead.
+func (*SendTaskRequest) Descriptor() ([]byte, []int) {
+	return file_dispatcher_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *SendTaskRequest) GetTask() *TaskSpec {
+	if x != nil {
+		return x.Task
+	}
+	return nil
+}
+
+type SendTaskResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Queue the task was sent to: a worker ID, or "all" for the common queue.
+	Queue         string `protobuf:"bytes,1,opt,name=queue,proto3" json:"queue,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SendTaskResponse) Reset() {
+	*x = SendTaskResponse{}
+	mi := &file_dispatcher_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SendTaskResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SendTaskResponse) ProtoMessage() {}
+
+func (x *SendTaskResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_dispatcher_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SendTaskResponse.ProtoReflect.Descriptor instead.
+func (*SendTaskResponse) Descriptor() ([]byte, []int) {
+	return file_dispatcher_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *SendTaskResponse) GetQueue() string {
+	if x != nil {
+		return x.Queue
+	}
+	return ""
+}
+
+type RunTaskRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Task          *TaskSpec              `protobuf:"bytes,1,opt,name=task,proto3" json:"task,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RunTaskRequest) Reset() {
+	*x = RunTaskRequest{}
+	mi := &file_dispatcher_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RunTaskRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RunTaskRequest) ProtoMessage() {}
+
+func (x *RunTaskRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_dispatcher_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RunTaskRequest.ProtoReflect.Descriptor instead.
+func (*RunTaskRequest) Descriptor() ([]byte, []int) {
+	return file_dispatcher_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *RunTaskRequest) GetTask() *TaskSpec {
+	if x != nil {
+		return x.Task
+	}
+	return nil
+}
+
+type RunTaskResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Queue the task was sent to. Empty for cached results.
+	Queue  string `protobuf:"bytes,1,opt,name=queue,proto3" json:"queue,omitempty"`
+	Result string `protobuf:"bytes,2,opt,name=result,proto3" json:"result,omitempty"`
+	// Whether the result was served from the result cache, without running the task.
+	Cached bool `protobuf:"varint,3,opt,name=cached,proto3" json:"cached,omitempty"`
+	// Results that are not text, such as msgpack or raw bytes, are set here instead of in result.
+	ResultData []byte `protobuf:"bytes,4,opt,name=result_data,json=resultData,proto3" json:"result_data,omitempty"`
+	// Content type of results the worker published with one, such as "application/msgpack".
+	ContentType   string `protobuf:"bytes,5,opt,name=content_type,json=contentType,proto3" json:"content_type,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RunTaskResponse) Reset() {
+	*x = RunTaskResponse{}
+	mi := &file_dispatcher_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RunTaskResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RunTaskResponse) ProtoMessage() {}
+
+func (x *RunTaskResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_dispatcher_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RunTaskResponse.ProtoReflect.Descriptor instead.
+func (*RunTaskResponse) Descriptor() ([]byte, []int) {
+	return file_dispatcher_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *RunTaskResponse) GetQueue() string {
+	if x != nil {
+		return x.Queue
+	}
+	return ""
+}
+
+func (x *RunTaskResponse) GetResult() string {
+	if x != nil {
+		return x.Result
+	}
+	return ""
+}
+
+func (x *RunTaskResponse) GetCached() bool {
+	if x != nil {
+		return x.Cached
+	}
+	return false
+}
+
+func (x *RunTaskResponse) GetResultData() []byte {
+	if x != nil {
+		return x.ResultData
+	}
+	return nil
+}
+
+func (x *RunTaskResponse) GetContentType() string {
+	if x != nil {
+		return x.ContentType
+	}
+	return ""
+}
+
+type TaskEvent struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	Status TaskStatus             `protobuf:"varint,1,opt,name=status,proto3,enum=dispatcher.v1.TaskStatus" json:"status,omitempty"`
+	Queue  string                 `protobuf:"bytes,2,opt,name=queue,proto3" json:"queue,omitempty"`
+	// Set on COMPLETED events.
+	Result string `protobuf:"bytes,3,opt,name=result,proto3" json:"result,omitempty"`
+	// Set on COMPLETED events served from the result cache. The stream has no QUEUED event then.
+	Cached bool `protobuf:"varint,4,opt,name=cached,proto3" json:"cached,omitempty"`
+	// Set on COMPLETED events, like in RunTaskResponse.
+	ResultData    []byte `protobuf:"bytes,5,opt,name=result_data,json=resultData,proto3" json:"result_data,omitempty"`
+	ContentType   string `protobuf:"bytes,6,opt,name=content_type,json=contentType,proto3" json:"content_type,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TaskEvent) Reset() {
+	*x = TaskEvent{}
+	mi := &file_dispatcher_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TaskEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TaskEvent) ProtoMessage() {}
+
+func (x *TaskEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_dispatcher_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TaskEvent.ProtoReflect.Descriptor instead.
+func (*TaskEvent) Descriptor() ([]byte, []int) {
+	return file_dispatcher_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *TaskEvent) GetStatus() TaskStatus {
+	if x != nil {
+		return x.Status
+	}
+	return TaskStatus_TASK_STATUS_UNSPECIFIED
+}
+
+func (x *TaskEvent) GetQueue() string {
+	if x != nil {
+		return x.Queue
+	}
+	return ""
+}
+
+func (x *TaskEvent) GetResult() string {
+	if x != nil {
+		return x.Result
+	}
+	return ""
+}
+
+func (x *TaskEvent) GetCached() bool {
+	if x != nil {
+		return x.Cached
+	}
+	return false
+}
+
+func (x *TaskEvent) GetResultData() []byte {
+	if x != nil {
+		return x.ResultData
+	}
+	return nil
+}
+
+func (x *TaskEvent) GetContentType() string {
+	if x != nil {
+		return x.ContentType
+	}
+	return ""
+}
+
+type GetTaskRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	TaskId        string                 `protobuf:"bytes,1,opt,name=task_id,json=taskId,proto3" json:"task_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetTaskRequest) Reset() {
+	*x = GetTaskRequest{}
+	mi := &file_dispatcher_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetTaskRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetTaskRequest) ProtoMessage() {}
+
+func (x *GetTaskRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_dispatcher_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetTaskRequest.ProtoReflect.Descriptor instead.
+func (*GetTaskRequest) Descriptor() ([]byte, []int) {
+	return file_dispatcher_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *GetTaskRequest) GetTaskId() string {
+	if x != nil {
+		return x.TaskId
+	}
+	return ""
+}
+
+type Task struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	TaskId string                 `protobuf:"bytes,1,opt,name=task_id,json=taskId,proto3" json:"task_id,omitempty"`
+	Status TaskStatus             `protobuf:"varint,2,opt,name=status,proto3,enum=dispatcher.v1.TaskStatus" json:"status,omitempty"`
+	// Queue the task was sent to.
+	Queue string `protobuf:"bytes,3,opt,name=queue,proto3" json:"queue,omitempty"`
+	// Worker that picked up the task, once it is running.
+	WorkerId string `protobuf:"bytes,4,opt,name=worker_id,json=workerId,proto3" json:"worker_id,omitempty"`
+	// Result of a completed task, or the error of a failed one.
+	Result string `protobuf:"bytes,5,opt,name=result,proto3" json:"result,omitempty"`
+	// Set for completed tasks like in RunTaskResponse.
+	ResultData    []byte `protobuf:"bytes,6,opt,name=result_data,json=resultData,proto3" json:"result_data,omitempty"`
+	ContentType   string `protobuf:"bytes,7,opt,name=content_type,json=contentType,proto3" json:"content_type,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Task) Reset() {
+	*x = Task{}
+	mi := &file_dispatcher_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Task) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Task) ProtoMessage() {}
+
+func (x *Task) ProtoReflect() protoreflect.Message {
+	mi := &file_dispatcher_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Task.ProtoReflect.Descriptor instead.
+func (*Task) Descriptor() ([]byte, []int) {
+	return file_dispatcher_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *Task) GetTaskId() string {
+	if x != nil {
+		return x.TaskId
+	}
+	return ""
+}
+
+func (x *Task) GetStatus() TaskStatus {
+	if x != nil {
+		return x.Status
+	}
+	return TaskStatus_TASK_STATUS_UNSPECIFIED
+}
+
+func (x *Task) GetQueue() string {
+	if x != nil {
+		return x.Queue
+	}
+	return ""
+}
+
+func (x *Task) GetWorkerId() string {
+	if x != nil {
+		return x.WorkerId
+	}
+	return ""
+}
+
+func (x *Task) GetResult() string {
+	if x != nil {
+		return x.Result
+	}
+	return ""
+}
+
+func (x *Task) GetResultData() []byte {
+	if x != nil {
+		return x.ResultData
+	}
+	return nil
+}
+
+func (x *Task) GetContentType() string {
+	if x != nil {
+		return x.ContentType
+	}
+	return ""
+}
+
+type ListWorkersRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListWorkersRequest) Reset() {
+	*x = ListWorkersRequest{}
+	mi := &file_dispatcher_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListWorkersRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListWorkersRequest) ProtoMessage() {}
+
+func (x *ListWorkersRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_dispatcher_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListWorkersRequest.ProtoReflect.Descriptor instead.
+func (*ListWorkersRequest) Descriptor() ([]byte, []int) {
+	return file_dispatcher_proto_rawDescGZIP(), []int{9}
+}
+
+type Worker struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	Id        string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Available bool                   `protobuf:"varint,2,opt,name=available,proto3" json:"available,omitempty"`
+	Draining  bool                   `protobuf:"varint,3,opt,name=draining,proto3" json:"draining,omitempty"`
+	Labels    []string               `protobuf:"bytes,4,rep,name=labels,proto3" json:"labels,omitempty"`
+	// Label limit and attributes the worker published at registration.
+	MaxLabels     int32             `protobuf:"varint,5,opt,name=max_labels,json=maxLabels,proto3" json:"max_labels,omitempty"`
+	Attributes    map[string]string `protobuf:"bytes,6,rep,name=attributes,proto3" json:"attributes,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Worker) Reset() {
+	*x = Worker{}
+	mi := &file_dispatcher_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Worker) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Worker) ProtoMessage() {}
+
+func (x *Worker) ProtoReflect() protoreflect.Message {
+	mi := &file_dispatcher_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Worker.ProtoReflect.Descriptor instead.
+func (*Worker) Descriptor() ([]byte, []int) {
+	return file_dispatcher_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *Worker) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Worker) GetAvailable() bool {
+	if x != nil {
+		return x.Available
+	}
+	return false
+}
+
+func (x *Worker) GetDraining() bool {
+	if x != nil {
+		return x.Draining
+	}
+	return false
+}
+
+func (x *Worker) GetLabels() []string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *Worker) GetMaxLabels() int32 {
+	if x != nil {
+		return x.MaxLabels
+	}
+	return 0
+}
+
+func (x *Worker) GetAttributes() map[string]string {
+	if x != nil {
+		return x.Attributes
+	}
+	return nil
+}
+
+type ListWorkersResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Workers       []*Worker              `protobuf:"bytes,1,rep,name=workers,proto3" json:"workers,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListWorkersResponse) Reset() {
+	*x = ListWorkersResponse{}
+	mi := &file_dispatcher_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListWorkersResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListWorkersResponse) ProtoMessage() {}
+
+func (x *ListWorkersResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_dispatcher_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListWorkersResponse.ProtoReflect.Descriptor instead.
+func (*ListWorkersResponse) Descriptor() ([]byte, []int) {
+	return file_dispatcher_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *ListWorkersResponse) GetWorkers() []*Worker {
+	if x != nil {
+		return x.Workers
+	}
+	return nil
+}
+
+var File_dispatcher_proto protoreflect.FileDescriptor
+
+const file_dispatcher_proto_rawDesc = "" +
+	"\n" +
+	"\x10dispatcher.proto\x12\rdispatcher.v1\"\xe7\x02\n" +
+	"\bTaskSpec\x12\x17\n" +
+	"\atask_id\x18\x01 \x01(\tR\x06taskId\x12\x1b\n" +
+	"\ttask_type\x18\x02 \x01(\tR\btaskType\x12\x14\n" +
+	"\x05label\x18\x03 \x01(\tR\x05label\x12'\n" +
+	"\x0fparameters_json\x18\x04 \x01(\tR\x0eparametersJson\x12\x16\n" +
+	"\x06labels\x18\x05 \x03(\tR\x06labels\x123\n" +
+	"\bselector\x18\x06 \x01(\v2\x17.dispatcher.v1.SelectorR\bselector\x12\x16\n" +
+	"\x06tenant\x18\a \x01(\tR\x06tenant\x12'\n" +
+	"\x0fparameters_data\x18\b \x01(\fR\x0eparametersData\x12'\n" +
+	"\x0fparameters_type\x18\t \x01(\tR\x0eparametersType\x12/\n" +
+	"\x13parameters_encoding\x18\n" +
+	" \x01(\tR\x12parametersEncoding\"\x8e\x02\n" +
+	"\bSelector\x12A\n" +
+	"\brequired\x18\x01 \x03(\v2%.dispatcher.v1.Selector.RequiredEntryR\brequired\x12D\n" +
+	"\tpreferred\x18\x02 \x03(\v2&.dispatcher.v1.Selector.PreferredEntryR\tpreferred\x1a;\n" +
+	"\rRequiredEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\x1a<\n" +
+	"\x0ePreferredEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\">\n" +
+	"\x0fSendTaskRequest\x12+\n" +
+	"\x04task\x18\x01 \x01(\v2\x17.dispatcher.v1.TaskSpecR\x04task\"(\n" +
+	"\x10SendTaskResponse\x12\x14\n" +
+	"\x05queue\x18\x01 \x01(\tR\x05queue\"=\n" +
+	"\x0eRunTaskRequest\x12+\n" +
+	"\x04task\x18\x01 \x01(\v2\x17.dispatcher.v1.TaskSpecR\x04task\"\x9b\x01\n" +
+	"\x0fRunTaskResponse\x12\x14\n" +
+	"\x05queue\x18\x01 \x01(\tR\x05queue\x12\x16\n" +
+	"\x06result\x18\x02 \x01(\tR\x06result\x12\x16\n" +
+	"\x06cached\x18\x03 \x01(\bR\x06cached\x12\x1f\n" +
+	"\vresult_data\x18\x04 \x01(\fR\n" +
+	"resultData\x12!\n" +
+	"\fcontent_type\x18\x05 \x01(\tR\vcontentType\"\xc8\x01\n" +
+	"\tTaskEvent\x121\n" +
+	"\x06status\x18\x01 \x01(\x0e2\x19.dispatcher.v1.TaskStatusR\x06status\x12\x14\n" +
+	"\x05queue\x18\x02 \x01(\tR\x05queue\x12\x16\n" +
+	"\x06result\x18\x03 \x01(\tR\x06result\x12\x16\n" +
+	"\x06cached\x18\x04 \x01(\bR\x06cached\x12\x1f\n" +
+	"\vresult_data\x18\x05 \x01(\fR\n" +
+	"resultData\x12!\n" +
+	"\fcontent_type\x18\x06 \x01(\tR\vcontentType\")\n" +
+	"\x0eGetTaskRequest\x12\x17\n" +
+	"\atask_id\x18\x01 \x01(\tR\x06taskId\"\xe1\x01\n" +
+	"\x04Task\x12\x17\n" +
+	"\atask_id\x18\x01 \x01(\tR\x06taskId\x121\n" +
+	"\x06status\x18\x02 \x01(\x0e2\x19.dispatcher.v1.TaskStatusR\x06status\x12\x14\n" +
+	"\x05queue\x18\x03 \x01(\tR\x05queue\x12\x1b\n" +
+	"\tworker_id\x18\x04 \x01(\tR\bworkerId\x12\x16\n" +
+	"\x06result\x18\x05 \x01(\tR\x06result\x12\x1f\n" +
+	"\vresult_data\x18\x06 \x01(\fR\n" +
+	"resultData\x12!\n" +
+	"\fcontent_type\x18\a \x01(\tR\vcontentType\"\x14\n" +
+	"\x12ListWorkersRequest\"\x8f\x02\n" +
+	"\x06Worker\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1c\n" +
+	"\tavailable\x18\x02 \x01(\bR\tavailable\x12\x1a\n" +
+	"\bdraining\x18\x03 \x01(\bR\bdraining\x12\x16\n" +
+	"\x06labels\x18\x04 \x03(\tR\x06labels\x12\x1d\n" +
+	"\n" +
+	"max_labels\x18\x05 \x01(\x05R\tmaxLabels\x12E\n" +
+	"\n" +
+	"attributes\x18\x06 \x03(\v2%.dispatcher.v1.Worker.AttributesEntryR\n" +
+	"attributes\x1a=\n" +
+	"\x0fAttributesEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"F\n" +
+	"\x13ListWorkersResponse\x12/\n" +
+	"\aworkers\x18\x01 \x03(\v2\x15.dispatcher.v1.WorkerR\aworkers*\x8d\x01\n" +
+	"\n" +
+	"TaskStatus\x12\x1b\n" +
+	"\x17TASK_STATUS_UNSPECIFIED\x10\x00\x12\x16\n" +
+	"\x12TASK_STATUS_QUEUED\x10\x01\x12\x17\n" +
+	"\x13TASK_STATUS_RUNNING\x10\x02\x12\x19\n" +
+	"\x15TASK_STATUS_COMPLETED\x10\x03\x12\x16\n" +
+	"\x12TASK_STATUS_FAILED\x10\x042\x84\x03\n" +
+	"\n" +
+	"Dispatcher\x12K\n" +
+	"\bSendTask\x12\x1e.dispatcher.v1.SendTaskRequest\x1a\x1f.dispatcher.v1.SendTaskResponse\x12H\n" +
+	"\aRunTask\x12\x1d.dispatcher.v1.RunTaskRequest\x1a\x1e.dispatcher.v1.RunTaskResponse\x12J\n" +
+	"\rRunTaskStream\x12\x1d.dispatcher.v1.RunTaskRequest\x1a\x18.dispatcher.v1.TaskEvent0\x01\x12=\n" +
+	"\aGetTask\x12\x1d.dispatcher.v1.GetTaskRequest\x1a\x13.dispatcher.v1.Task\x12T\n" +
+	"\vListWorkers\x12!.dispatcher.v1.ListWorkersRequest\x1a\".dispatcher.v1.ListWorkersResponseB&Z$dispatcher/dispatcherpb;dispatcherpbb\x06proto3"
+
+var (
+	file_dispatcher_proto_rawDescOnce sync.Once
+	file_dispatcher_proto_rawDescData []byte
+)
+
+func file_dispatcher_proto_rawDescGZIP() []byte {
+	file_dispatcher_proto_rawDescOnce.Do(func() {
+		file_dispatcher_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_dispatcher_proto_rawDesc), len(file_dispatcher_proto_rawDesc)))
+	})
+	return file_dispatcher_proto_rawDescData
+}
+
+var file_dispatcher_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_dispatcher_proto_msgTypes = make([]protoimpl.MessageInfo, 15)
+var file_dispatcher_proto_goTypes = []any{
+	(TaskStatus)(0),             // 0: dispatcher.v1.TaskStatus
+	(*TaskSpec)(nil),            // 1: dispatcher.v1.TaskSpec
+	(*Selector)(nil),            // 2: dispatcher.v1.Selector
+	(*SendTaskRequest)(nil),     // 3: dispatcher.v1.SendTaskRequest
+	(*SendTaskResponse)(nil),    // 4: dispatcher.v1.SendTaskResponse
+	(*RunTaskRequest)(nil),      // 5: dispatcher.v1.RunTaskRequest
+	(*RunTaskResponse)(nil),     // 6: dispatcher.v1.RunTaskResponse
+	(*TaskEvent)(nil),           // 7: dispatcher.v1.TaskEvent
+	(*GetTaskRequest)(nil),      // 8: dispatcher.v1.GetTaskRequest
+	(*Task)(nil),                // 9: dispatcher.v1.Task
+	(*ListWorkersRequest)(nil),  // 10: dispatcher.v1.ListWorkersRequest
+	(*Worker)(nil),              // 11: dispatcher.v1.Worker
+	(*ListWorkersResponse)(nil), // 12: dispatcher.v1.ListWorkersResponse
+	nil,                         // 13: dispatcher.v1.Selector.RequiredEntry
+	nil,                         // 14: dispatcher.v1.Selector.PreferredEntry
+	nil,                         // 15: dispatcher.v1.Worker.AttributesEntry
+}
+var file_dispatcher_proto_depIdxs = []int32{
+	2,  // 0: dispatcher.v1.TaskSpec.selector:type_name -> dispatcher.v1.Selector
+	13, // 1: dispatcher.v1.Selector.required:type_name -> dispatcher.v1.Selector.RequiredEntry
+	14, // 2: dispatcher.v1.Selector.preferred:type_name -> dispatcher.v1.Selector.PreferredEntry
+	1,  // 3: dispatcher.v1.SendTaskRequest.task:type_name -> dispatcher.v1.TaskSpec
+	1,  // 4: dispatcher.v1.RunTaskRequest.task:type_name -> dispatcher.v1.TaskSpec
+	0,  // 5: dispatcher.v1.TaskEvent.status:type_name -> dispatcher.v1.TaskStatus
+	0,  // 6: dispatcher.v1.Task.status:type_name -> dispatcher.v1.TaskStatus
+	15, // 7: dispatcher.v1.Worker.attributes:type_name -> dispatcher.v1.Worker.AttributesEntry
+	11, // 8: dispatcher.v1.ListWorkersResponse.workers:type_name -> dispatcher.v1.Worker
+	3,  // 9: dispatcher.v1.Dispatcher.SendTask:input_type -> dispatcher.v1.SendTaskRequest
+	5,  // 10: dispatcher.v1.Dispatcher.RunTask:input_type -> dispatcher.v1.RunTaskRequest
+	5,  // 11: dispatcher.v1.Dispatcher.RunTaskStream:input_type -> dispatcher.v1.RunTaskRequest
+	8,  // 12: dispatcher.v1.Dispatcher.GetTask:input_type -> dispatcher.v1.GetTaskRequest
+	10, // 13: dispatcher.v1.Dispatcher.ListWorkers:input_type -> dispatcher.v1.ListWorkersRequest
+	4,  // 14: dispatcher.v1.Dispatcher.SendTask:output_type -> dispatcher.v1.SendTaskResponse
+	6,  // 15: dispatcher.v1.Dispatcher.RunTask:output_type -> dispatcher.v1.RunTaskResponse
+	7,  // 16: dispatcher.v1.Dispatcher.RunTaskStream:output_type -> dispatcher.v1.TaskEvent
+	9,  // 17: dispatcher.v1.Dispatcher.GetTask:output_type -> dispatcher.v1.Task
+	12, // 18: dispatcher.v1.Dispatcher.ListWorkers:output_type -> dispatcher.v1.ListWorkersResponse
+	14, // [14:19] is the sub-list for method output_type
+	9,  // [9:14] is the sub-list for method input_type
+	9,  // [9:9] is the sub-list for extension type_name
+	9,  // [9:9] is the sub-list for extension extendee
+	0,  // [0:9] is the sub-list for field type_name
+}
+
+func init() { file_dispatcher_proto_init() }
+func file_dispatcher_proto_init() {
+	if File_dispatcher_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_dispatcher_proto_rawDesc), len(file_dispatcher_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   15,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_dispatcher_proto_goTypes,
+		DependencyIndexes: file_dispatcher_proto_depIdxs,
+		EnumInfos:         file_dispatcher_proto_enumTypes,
+		MessageInfos:      file_dispatcher_proto_msgTypes,
+	}.Build()
+	File_dispatcher_proto = out.File
+	file_dispatcher_proto_goTypes = nil
+	file_dispatcher_proto_depIdxs = nil
+}
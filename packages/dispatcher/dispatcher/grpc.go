@@ -0,0 +1,365 @@
+package main
+
+//go:generate protoc -I proto --go_out=. --go_opt=module=dispatcher --go-grpc_out=. --go-grpc_opt=module=dispatcher dispatcher.proto
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net"
+	"time"
+
+	"dispatcher/dispatcherpb"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+var taskStatuses = map[string]dispatcherpb.TaskStatus{
+	taskQueued:    dispatcherpb.TaskStatus_TASK_STATUS_QUEUED,
+	taskRunning:   dispatcherpb.TaskStatus_TASK_STATUS_RUNNING,
+	taskCompleted: dispatcherpb.TaskStatus_TASK_STATUS_COMPLETED,
+	taskFailed:    dispatcherpb.TaskStatus_TASK_STATUS_FAILED,
+}
+
+// gRPC implementation of the dispatcher API, on top of the same routing as the HTTP API. Task and
+// worker lookups read the Redis keys, so client, cache, and coalescer are only set on the Redis
+// broker.
+type grpcServer struct {
+	dispatcherpb.UnimplementedDispatcherServer
+	broker    broker
+	client    *redisClient
+	cache     *resultCache
+	coalescer *coalescer
+}
+
+func (s *grpcServer) requireRedis() error {
+	if s.client == nil {
+		return status.Error(codes.Unimplemented, "only available with the Redis broker")
+	}
+	return nil
+}
+
+// Adapts incoming gRPC metadata to a propagation carrier, to continue the caller's trace.
+type metadataCarrier metadata.MD
+
+func (m metadataCarrier) Get(key string) string {
+	if v := metadata.MD(m).Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (m metadataCarrier) Set(key, value string) { metadata.MD(m).Set(key, value) }
+
+func (m metadataCarrier) Keys() []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	return out
+}
+
+// Build a task request from the RPC message. The task ID is required to match results to callers.
+func taskFromSpec(spec *dispatcherpb.TaskSpec, returnResult bool) (*taskRequest, error) {
+	if spec == nil || spec.GetTaskId() == "" || spec.GetTaskType() == "" {
+		return nil, status.Error(codes.InvalidArgument, "task_id and task_type are required")
+	}
+	t := &taskRequest{
+		TaskID:       spec.GetTaskId(),
+		TaskType:     spec.GetTaskType(),
+		Label:        spec.GetLabel(),
+		Labels:       spec.GetLabels(),
+		Parameters:   spec.GetParametersJson(),
+		ReturnResult: returnResult,
+		Tenant:       spec.GetTenant(),
+
+		ParametersData:     spec.GetParametersData(),
+		ParametersType:     spec.GetParametersType(),
+		ParametersEncoding: spec.GetParametersEncoding(),
+	}
+	if sel := spec.GetSelector(); sel != nil {
+		t.Selector = &taskSelector{Required: sel.GetRequired(), Preferred: sel.GetPreferred()}
+	}
+	t.normalizeLabels()
+	if err := t.normalizeParameters(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return t, nil
+}
+
+func startRPCSpan(c context.Context, name string, t *taskRequest) (context.Context, trace.Span) {
+	if md, ok := metadata.FromIncomingContext(c); ok {
+		c = propagator.Extract(c, metadataCarrier(md))
+	}
+	return tracer().Start(
+		c,
+		name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("task.id", t.TaskID),
+			attribute.String("task.type", t.TaskType),
+			attribute.String("task.label", t.Label),
+			attribute.StringSlice("task.labels", t.Labels),
+		),
+	)
+}
+
+// Reject new work while the dispatcher is draining, so clients retry against another replica.
+func checkDraining() error {
+	if draining.Load() {
+		return status.Error(codes.Unavailable, "dispatcher is shutting down")
+	}
+	return nil
+}
+
+// Map errors from routing and waiting for results to gRPC status errors.
+func rpcError(msg string, err error) error {
+	slog.Error(msg, "error", err)
+	if isTimeout(err) || errors.Is(err, context.DeadlineExceeded) {
+		return status.Error(codes.DeadlineExceeded, msg)
+	}
+	if errors.Is(err, errNoEligibleWorker) {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	return status.Error(codes.Internal, msg)
+}
+
+// Select a worker and queue the task on it.
+func (s *grpcServer) enqueue(c context.Context, t *taskRequest) (workerId, error) {
+	wid, err := selectWorkerQueue(t, s.broker, c)
+	if err != nil {
+		return "", rpcError("Error selecting worker", err)
+	}
+	if err := wid.sendTask(t, s.broker, c); err != nil {
+		return "", rpcError("Error sending task to worker", err)
+	}
+	return wid, nil
+}
+
+func (s *grpcServer) SendTask(c context.Context, req *dispatcherpb.SendTaskRequest) (*dispatcherpb.SendTaskResponse, error) {
+	if err := checkDraining(); err != nil {
+		return nil, err
+	}
+	t, err := taskFromSpec(req.GetTask(), false)
+	if err != nil {
+		return nil, err
+	}
+	ctx, span := startRPCSpan(c, "send-task", t)
+	defer span.End()
+
+	wid, err := s.enqueue(ctx, t)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Sent task to worker", "worker_id", wid, "task_id", t.TaskID)
+	return &dispatcherpb.SendTaskResponse{Queue: string(wid)}, nil
+}
+
+func (s *grpcServer) RunTask(c context.Context, req *dispatcherpb.RunTaskRequest) (*dispatcherpb.RunTaskResponse, error) {
+	if err := checkDraining(); err != nil {
+		return nil, err
+	}
+	t, err := taskFromSpec(req.GetTask(), true)
+	if err != nil {
+		return nil, err
+	}
+	ctx, span := startRPCSpan(c, "run-task", t)
+	defer span.End()
+
+	lookup := s.cache.get(ctx, t)
+	if lookup.Hit {
+		span.SetAttributes(attribute.Bool("cache.hit", true))
+		d, err := deliverResult(lookup.Result)
+		if err != nil {
+			return nil, rpcError("Error decoding task result", err)
+		}
+		return &dispatcherpb.RunTaskResponse{Result: d.Text, ResultData: d.Data, ContentType: d.ContentType, Cached: true}, nil
+	}
+	var wid workerId
+	result, shared, err := s.coalescer.do(ctx, t, func() (string, error) {
+		var err error
+		if wid, err = s.enqueue(ctx, t); err != nil {
+			return "", err
+		}
+		return awaitResult(t, s.broker, ctx)
+	})
+	if err != nil {
+		// Routing errors are already mapped to gRPC statuses
+		if _, ok := status.FromError(err); ok {
+			return nil, err
+		}
+		return nil, rpcError("Error when running task", err)
+	}
+	if !shared {
+		s.cache.put(ctx, t, lookup, result)
+	}
+	d, err := deliverResult(result)
+	if err != nil {
+		return nil, rpcError("Error decoding task result", err)
+	}
+	return &dispatcherpb.RunTaskResponse{Queue: string(wid), Result: d.Text, ResultData: d.Data, ContentType: d.ContentType}, nil
+}
+
+func (s *grpcServer) RunTaskStream(req *dispatcherpb.RunTaskRequest, stream grpc.ServerStreamingServer[dispatcherpb.TaskEvent]) error {
+	if err := checkDraining(); err != nil {
+		return err
+	}
+	t, err := taskFromSpec(req.GetTask(), true)
+	if err != nil {
+		return err
+	}
+	ctx, span := startRPCSpan(stream.Context(), "run-task", t)
+	defer span.End()
+
+	// Cached results complete the stream without a QUEUED event
+	lookup := s.cache.get(ctx, t)
+	if lookup.Hit {
+		span.SetAttributes(attribute.Bool("cache.hit", true))
+		d, err := deliverResult(lookup.Result)
+		if err != nil {
+			return rpcError("Error decoding task result", err)
+		}
+		return stream.Send(&dispatcherpb.TaskEvent{
+			Status:      dispatcherpb.TaskStatus_TASK_STATUS_COMPLETED,
+			Result:      d.Text,
+			ResultData:  d.Data,
+			ContentType: d.ContentType,
+			Cached:      true,
+		})
+	}
+	wid, err := s.enqueue(ctx, t)
+	if err != nil {
+		return err
+	}
+	queued := &dispatcherpb.TaskEvent{Status: dispatcherpb.TaskStatus_TASK_STATUS_QUEUED, Queue: string(wid)}
+	if err := stream.Send(queued); err != nil {
+		return err
+	}
+	result, err := awaitResult(t, s.broker, ctx)
+	if err != nil {
+		return rpcError("Error when running task", err)
+	}
+	s.cache.put(ctx, t, lookup, result)
+	d, err := deliverResult(result)
+	if err != nil {
+		return rpcError("Error decoding task result", err)
+	}
+	return stream.Send(&dispatcherpb.TaskEvent{
+		Status:      dispatcherpb.TaskStatus_TASK_STATUS_COMPLETED,
+		Queue:       string(wid),
+		Result:      d.Text,
+		ResultData:  d.Data,
+		ContentType: d.ContentType,
+	})
+}
+
+func (s *grpcServer) GetTask(c context.Context, req *dispatcherpb.GetTaskRequest) (*dispatcherpb.Task, error) {
+	if err := s.requireRedis(); err != nil {
+		return nil, err
+	}
+	if req.GetTaskId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "task_id is required")
+	}
+	info, err := getTaskInfo(s.client, c, req.GetTaskId())
+	if err != nil {
+		return nil, rpcError("Error retrieving task", err)
+	}
+	if info == nil {
+		return nil, status.Error(codes.NotFound, "task not found")
+	}
+	return &dispatcherpb.Task{
+		TaskId:      info.TaskID,
+		Status:      taskStatuses[info.Status],
+		Queue:       info.Queue,
+		WorkerId:    info.WorkerID,
+		Result:      info.Result,
+		ResultData:  info.ResultData,
+		ContentType: info.ContentType,
+	}, nil
+}
+
+func (s *grpcServer) ListWorkers(c context.Context, _ *dispatcherpb.ListWorkersRequest) (*dispatcherpb.ListWorkersResponse, error) {
+	if err := s.requireRedis(); err != nil {
+		return nil, err
+	}
+	ws, err := listWorkers(s.client, c)
+	if err != nil {
+		return nil, rpcError("Error retrieving running workers", err)
+	}
+	out := &dispatcherpb.ListWorkersResponse{Workers: make([]*dispatcherpb.Worker, len(ws))}
+	for i, w := range ws {
+		out.Workers[i] = &dispatcherpb.Worker{
+			Id:         w.ID,
+			Available:  w.Available,
+			Draining:   w.Draining,
+			Labels:     w.Labels,
+			MaxLabels:  int32(w.MaxLabels),
+			Attributes: w.Attributes,
+		}
+	}
+	return out, nil
+}
+
+// Create the gRPC server with the dispatcher, health, and reflection services. It uses the same TLS
+// configuration as the HTTP server, if any.
+func newGRPCServer(b broker, tlsConfig *tls.Config) (*grpc.Server, *health.Server) {
+	opts := []grpc.ServerOption{}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	gs := grpc.NewServer(opts...)
+	client, _ := b.(*redisClient)
+	dispatcherpb.RegisterDispatcherServer(gs, &grpcServer{
+		broker:    b,
+		client:    client,
+		cache:     newResultCache(b),
+		coalescer: newCoalescer(b),
+	})
+
+	hs := health.NewServer()
+	hs.SetServingStatus(dispatcherpb.Dispatcher_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(gs, hs)
+	reflection.Register(gs)
+	return gs, hs
+}
+
+// Serve gRPC on the listener until the context is cancelled, then drain like the HTTP server: report
+// NOT_SERVING on the health service, wait for the drain delay, and stop gracefully, letting in-flight
+// calls finish. Calls still running after the task timeout are cancelled.
+func serveGRPCUntilDone(c context.Context, gs *grpc.Server, hs *health.Server, ln net.Listener) error {
+	errs := make(chan error, 1)
+	go func() { errs <- gs.Serve(ln) }()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-c.Done():
+	}
+
+	draining.Store(true)
+	hs.Shutdown()
+	time.Sleep(time.Duration(currentConfig().Timeouts.DrainDelaySeconds) * time.Second)
+
+	stopped := make(chan struct{})
+	go func() {
+		gs.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(taskTimeout() + shutdownMarginSeconds*time.Second):
+		slog.Error("In-flight gRPC calls did not finish before shutdown")
+		gs.Stop()
+	}
+	return <-errs
+}
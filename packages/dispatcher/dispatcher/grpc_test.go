@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"dispatcher/dispatcherpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// Start an in-memory gRPC server on mock Redis data. Returns the client connection, the Redis client,
+// and a cleanup function.
+func grpcTestServer(t *testing.T) (*grpc.ClientConn, *redisClient, func()) {
+	r, _ := mockRedis(true)
+	ln := bufconn.Listen(1 << 20)
+	gs, _ := newGRPCServer(r, nil)
+	go gs.Serve(ln)
+
+	conn, err := grpc.NewClient(
+		"passthrough:///bufnet",
+		grpc.WithContextDialer(func(c context.Context, _ string) (net.Conn, error) { return ln.DialContext(c) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, r, func() {
+		conn.Close()
+		gs.Stop()
+		r.Close()
+	}
+}
+
+// Publish a result once the task is queued, like a worker would.
+func publishWhenQueued(r *redisClient, id, result string) {
+	go func() {
+		c := context.Background()
+		for range 100 {
+			if info, _ := getTaskInfo(r, c, id); info != nil {
+				// Give the dispatcher time to subscribe to the result channel
+				time.Sleep(50 * time.Millisecond)
+				r.Publish(c, r.keys.results(id), result)
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+}
+
+func TestGRPCSendAndGetTask(t *testing.T) {
+	conn, _, cleanup := grpcTestServer(t)
+	defer cleanup()
+	client := dispatcherpb.NewDispatcherClient(conn)
+	c := context.Background()
+
+	rsp, err := client.SendTask(c, &dispatcherpb.SendTaskRequest{
+		Task: &dispatcherpb.TaskSpec{TaskId: "g1", TaskType: "test", Label: "label-1"},
+	})
+	if err != nil || rsp.Queue != "work1" {
+		t.Fatalf("Expected task sent to work1, got %v %v", rsp, err)
+	}
+	task, err := client.GetTask(c, &dispatcherpb.GetTaskRequest{TaskId: "g1"})
+	if err != nil || task.Status != dispatcherpb.TaskStatus_TASK_STATUS_QUEUED || task.Queue != "work1" {
+		t.Errorf("Expected queued task on work1, got %v %v", task, err)
+	}
+
+	if _, err := client.GetTask(c, &dispatcherpb.GetTaskRequest{TaskId: "unknown"}); status.Code(err) != codes.NotFound {
+		t.Errorf("Expected NotFound for unknown task, got %v", err)
+	}
+	if _, err := client.SendTask(c, &dispatcherpb.SendTaskRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument for missing task, got %v", err)
+	}
+}
+
+func TestGRPCRunTask(t *testing.T) {
+	conn, r, cleanup := grpcTestServer(t)
+	defer cleanup()
+	client := dispatcherpb.NewDispatcherClient(conn)
+
+	publishWhenQueued(r, "g2", "done")
+	rsp, err := client.RunTask(context.Background(), &dispatcherpb.RunTaskRequest{
+		Task: &dispatcherpb.TaskSpec{TaskId: "g2", TaskType: "test", Label: "label-2"},
+	})
+	if err != nil || rsp.Result != "done" || rsp.Queue != "work2" {
+		t.Errorf("Expected result from work2, got %v %v", rsp, err)
+	}
+}
+
+func TestGRPCRunTaskStream(t *testing.T) {
+	conn, r, cleanup := grpcTestServer(t)
+	defer cleanup()
+	client := dispatcherpb.NewDispatcherClient(conn)
+
+	publishWhenQueued(r, "g3", "streamed")
+	stream, err := client.RunTaskStream(context.Background(), &dispatcherpb.RunTaskRequest{
+		Task: &dispatcherpb.TaskSpec{TaskId: "g3", TaskType: "test", Label: "label-1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := []*dispatcherpb.TaskEvent{}
+	for {
+		ev, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, ev)
+	}
+	if len(events) != 2 ||
+		events[0].Status != dispatcherpb.TaskStatus_TASK_STATUS_QUEUED ||
+		events[1].Status != dispatcherpb.TaskStatus_TASK_STATUS_COMPLETED ||
+		events[1].Result != "streamed" {
+		t.Errorf("Unexpected events: %v", events)
+	}
+}
+
+func TestGRPCListWorkers(t *testing.T) {
+	conn, r, cleanup := grpcTestServer(t)
+	defer cleanup()
+	client := dispatcherpb.NewDispatcherClient(conn)
+	r.SAdd(context.Background(), r.keys.draining(), "work2")
+	r.HSet(context.Background(), r.keys.info(workerId("work2")), maxLabelsField, 3, attributesField, `{"zone": "b"}`)
+
+	rsp, err := client.ListWorkers(context.Background(), &dispatcherpb.ListWorkersRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rsp.Workers) != 4 {
+		t.Fatalf("Expected 4 workers, got %v", rsp.Workers)
+	}
+	for _, w := range rsp.Workers {
+		if w.Id == "work2" && (!w.Available || !w.Draining || len(w.Labels) != 1 || w.MaxLabels != 3 || w.Attributes["zone"] != "b") {
+			t.Errorf("Unexpected worker: %v", w)
+		}
+	}
+}
+
+// Test that the health service is served, and that new tasks are rejected while draining
+func TestGRPCHealthAndDraining(t *testing.T) {
+	conn, _, cleanup := grpcTestServer(t)
+	defer cleanup()
+	c := context.Background()
+
+	rsp, err := healthpb.NewHealthClient(conn).Check(c, &healthpb.HealthCheckRequest{Service: "dispatcher.v1.Dispatcher"})
+	if err != nil || rsp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("Expected SERVING, got %v %v", rsp, err)
+	}
+
+	draining.Store(true)
+	defer draining.Store(false)
+	_, err = dispatcherpb.NewDispatcherClient(conn).SendTask(c, &dispatcherpb.SendTaskRequest{
+		Task: &dispatcherpb.TaskSpec{TaskId: "g4", TaskType: "test"},
+	})
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("Expected Unavailable while draining, got %v", err)
+	}
+}
+
+func TestTaskInfoAPI(t *testing.T) {
+	r, c := mockRedis(true)
+	defer r.Close()
+	if err := workerId("work1").sendTask(&taskRequest{TaskID: "h1", Label: "label-1"}, r, c); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(newRouter(r))
+	defer srv.Close()
+
+	var info taskInfo
+	if code := getJSON(t, srv.URL+"/tasks/h1", &info); code != http.StatusOK || info.Status != taskQueued || info.Queue != "work1" {
+		t.Errorf("Expected queued task on work1, got %d %+v", code, info)
+	}
+	if code := getJSON(t, srv.URL+"/tasks/unknown", &info); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown task, got %d", code)
+	}
+}
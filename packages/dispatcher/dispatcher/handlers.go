@@ -1,17 +1,31 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"log/slog"
 	"net/http"
 
-	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type SimpleResponse struct {
 	Message string `json:"message"`
 }
 
+// Response of /run-task, with the task result as the message. Results that are not text are in
+// data instead, with their content type. Cached marks results served from the result cache without
+// running the task.
+type runTaskResponse struct {
+	Message     string `json:"message"`
+	Data        []byte `json:"data,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Cached      bool   `json:"cached,omitempty"`
+}
+
 func getResponseJSON(m string) []byte {
 	b, err := json.Marshal(&SimpleResponse{Message: m})
 	if err != nil {
@@ -20,16 +34,99 @@ func getResponseJSON(m string) []byte {
 	return b
 }
 
+// Serialize the value as JSON and write it with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	out, err := json.Marshal(v)
+	if err != nil {
+		panic("Error serializing response: " + err.Error())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if _, err := w.Write(out); err != nil {
+		slog.Error("Error writing response", "error", err)
+	}
+}
+
 // Parse a task request from the HTTP request body.
 func taskFromRequest(r *http.Request) (*taskRequest, error) {
 	var t taskRequest
-	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+	if err := decodeTaskBody(r, &t); err != nil {
 		slog.Error("Error decoding request body", "error", err)
 		return nil, err
 	}
+	t.normalizeLabels()
+	if err := t.normalizeParameters(); err != nil {
+		slog.Error("Invalid task parameters", "error", err)
+		return nil, err
+	}
 	return &t, nil
 }
 
+// Report a routing failure: 503 if no running worker matches the task's selector, so that clients
+// retry once one registers, and 500 otherwise.
+func writeSelectError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errNoEligibleWorker) {
+		http.Error(w, "No running worker matches the task's selector", http.StatusServiceUnavailable)
+		return
+	}
+	http.Error(w, "Error selecting worker", http.StatusInternalServerError)
+	slog.Error("Error selecting worker", "error", err)
+}
+
+// Reject a request whose body could not be decoded: 413 if it was too large once decompressed, 400
+// otherwise.
+func writeBodyError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	http.Error(w, "Invalid request body", http.StatusBadRequest)
+}
+
+// Write the result of /run-task in the format the client accepts: a JSON or msgpack response, or the
+// result itself.
+func writeRunTaskResult(w http.ResponseWriter, r *http.Request, result string, cached bool) {
+	format := negotiateFormat(r.Header.Get("Accept"))
+	var err error
+	if format == contentBinary {
+		var p resultPayload
+		if p, err = parseResult(result); err == nil {
+			err = writeRawResult(w, r, p, cached)
+		}
+	} else {
+		var d deliveredResult
+		if d, err = deliverResult(result); err == nil {
+			rsp := runTaskResponse{Message: d.Text, Data: d.Data, ContentType: d.ContentType, Cached: cached}
+			if format == contentMsgpack {
+				writeMsgpack(w, http.StatusOK, rsp)
+			} else {
+				writeJSON(w, http.StatusOK, rsp)
+			}
+		}
+	}
+	if err != nil {
+		http.Error(w, "Error decoding task result", http.StatusInternalServerError)
+		slog.Error("Error decoding task result", "error", err)
+	}
+}
+
+// Start a server span for a task request, continuing the caller's trace if the request has a traceparent header.
+func startRequestSpan(r *http.Request, name string, t *taskRequest) (context.Context, trace.Span) {
+	ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	return tracer().Start(
+		ctx,
+		name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("task.id", t.TaskID),
+			attribute.String("task.type", t.TaskType),
+			attribute.String("task.label", t.Label),
+			attribute.StringSlice("task.labels", t.Labels),
+		),
+	)
+}
+
 // API method to check the health of the service
 func healthCheckAPI(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -38,8 +135,16 @@ func healthCheckAPI(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Respond with a simple message
-	w.WriteHeader(http.StatusOK)
 	w.Header().Set("Content-Type", "application/json")
+	if draining.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, err := w.Write(getResponseJSON("draining"))
+		if err != nil {
+			slog.Error("Error writing response", "error", err)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusOK)
 	_, err := w.Write(getResponseJSON("OK"))
 	if err != nil {
 		slog.Error("Error writing response", "error", err)
@@ -47,14 +152,14 @@ func healthCheckAPI(w http.ResponseWriter, r *http.Request) {
 }
 
 // API method to get the list of running workers
-func runningWorkersAPI(w http.ResponseWriter, r *http.Request, rd *redis.Client) {
+func runningWorkersAPI(w http.ResponseWriter, r *http.Request, rd *redisClient) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	out := map[string][]string{}
-	ws, err := getRunningWorkerIds(rd, r.Context())
+	ws, err := rd.runningWorkers(r.Context())
 	if err != nil {
 		http.Error(w, "Error retrieving running workers", http.StatusInternalServerError)
 		slog.Error("Error retrieving running workers", "error", err)
@@ -74,27 +179,166 @@ func runningWorkersAPI(w http.ResponseWriter, r *http.Request, rd *redis.Client)
 	}
 }
 
+// API method to get the details of a single worker
+func workerInfoAPI(w http.ResponseWriter, r *http.Request, rd *redisClient) {
+	info, err := getWorkerInfo(rd, r.Context(), workerId(r.PathValue("id")))
+	if err != nil {
+		http.Error(w, "Error retrieving worker", http.StatusInternalServerError)
+		slog.Error("Error retrieving worker", "error", err)
+		return
+	}
+	if info == nil {
+		http.Error(w, "Worker not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+// API method to map every label to the workers holding it and its pending task count
+func labelsAPI(w http.ResponseWriter, r *http.Request, rd *redisClient) {
+	labels, err := getLabelsInfo(rd, r.Context())
+	if err != nil {
+		http.Error(w, "Error retrieving labels", http.StatusInternalServerError)
+		slog.Error("Error retrieving labels", "error", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"labels": labels})
+}
+
+// API method to list all job queues with their lengths
+func queuesAPI(w http.ResponseWriter, r *http.Request, rd *redisClient) {
+	queues, err := queueLengths(rd, r.Context())
+	if err != nil {
+		http.Error(w, "Error retrieving queues", http.StatusInternalServerError)
+		slog.Error("Error retrieving queues", "error", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"queues": queues})
+}
+
+// API method to get the last known status of a task
+func taskInfoAPI(w http.ResponseWriter, r *http.Request, rd *redisClient) {
+	info, err := getTaskInfo(rd, r.Context(), r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Error retrieving task", http.StatusInternalServerError)
+		slog.Error("Error retrieving task", "error", err)
+		return
+	}
+	if info == nil {
+		http.Error(w, "Task not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+// Write the response for an admin operation error.
+func adminError(w http.ResponseWriter, err error, msg string) {
+	switch {
+	case errors.Is(err, errUnknownWorker), errors.Is(err, errLabelNotHeld):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, errWorkerNotDraining):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, msg, http.StatusInternalServerError)
+		slog.Error(msg, "error", err)
+	}
+}
+
+// API method to get the drain state of a worker
+func drainStatusAPI(w http.ResponseWriter, r *http.Request, rd *redisClient) {
+	status, err := getDrainStatus(rd, r.Context(), workerId(r.PathValue("id")))
+	if err != nil {
+		adminError(w, err, "Error retrieving drain status")
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// API method to start (POST) or stop (DELETE) draining a worker
+func drainWorkerAPI(w http.ResponseWriter, r *http.Request, rd *redisClient) {
+	status, err := setDraining(rd, r.Context(), workerId(r.PathValue("id")), r.Method == http.MethodPost)
+	if err != nil {
+		adminError(w, err, "Error updating drain state")
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// API method to force a worker to evict a label
+func evictLabelAPI(w http.ResponseWriter, r *http.Request, rd *redisClient) {
+	if err := evictLabel(rd, r.Context(), workerId(r.PathValue("id")), r.PathValue("label")); err != nil {
+		adminError(w, err, "Error evicting label")
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]any{"status": "eviction requested"})
+}
+
+// API method to route the tasks queued for a draining worker to other workers
+func redistributeAPI(w http.ResponseWriter, r *http.Request, rd *redisClient) {
+	moved, err := redistributeQueue(rd, r.Context(), workerId(r.PathValue("id")))
+	if err != nil {
+		adminError(w, err, "Error redistributing tasks")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"moved": moved})
+}
+
+// Body of a label size update.
+type labelSizeRequest struct {
+	SizeBytes int64 `json:"size_bytes"`
+}
+
+// API method to register the memory a label needs (PUT) or remove it from the registry (DELETE)
+func labelSizeAPI(w http.ResponseWriter, r *http.Request, rd *redisClient) {
+	label := r.PathValue("label")
+	var req labelSizeRequest
+	if r.Method == http.MethodPut {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.SizeBytes <= 0 {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	if err := setLabelSize(rd, r.Context(), label, req.SizeBytes); err != nil {
+		http.Error(w, "Error updating label size", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"label": label, "size_bytes": req.SizeBytes})
+}
+
+// API method to delete the cached results of the tasks that used a label
+func invalidateCacheAPI(w http.ResponseWriter, r *http.Request, rd *redisClient) {
+	label := r.PathValue("label")
+	n, err := invalidateCachedLabel(rd, r.Context(), label)
+	if err != nil {
+		http.Error(w, "Error invalidating cached results", http.StatusInternalServerError)
+		slog.Error("Error invalidating cached results", "error", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"label": label, "invalidated": n})
+}
+
 // API method to dispatch a task to a worker
-func dispatchTaskAPI(w http.ResponseWriter, r *http.Request, rd *redis.Client) {
+func dispatchTaskAPI(w http.ResponseWriter, r *http.Request, b broker) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 	t, err := taskFromRequest(r)
 	if err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeBodyError(w, err)
 		return
 	}
+	ctx, span := startRequestSpan(r, "send-task", t)
+	defer span.End()
 
-	wid, selectErr := selectWorkerQueue(t, rd, r.Context())
+	wid, selectErr := selectWorkerQueue(t, b, ctx)
 
 	if selectErr != nil {
-		http.Error(w, "Error selecting worker", http.StatusInternalServerError)
-		slog.Error("Error selecting worker", "error", selectErr)
+		writeSelectError(w, selectErr)
 		return
 	}
 
-	if sendErr := wid.sendTask(t, rd, r.Context()); sendErr != nil {
+	if sendErr := wid.sendTask(t, b, ctx); sendErr != nil {
 		http.Error(w, "Error sending task to worker", http.StatusInternalServerError)
 		slog.Error("Error sending task to worker", "error", sendErr)
 		return
@@ -109,14 +353,14 @@ func dispatchTaskAPI(w http.ResponseWriter, r *http.Request, rd *redis.Client) {
 	slog.Info("Sent task to worker", "worker_id", wid, "task_id", t.TaskID)
 }
 
-func runTaskAPI(w http.ResponseWriter, r *http.Request, rd *redis.Client) {
+func runTaskAPI(w http.ResponseWriter, r *http.Request, b broker) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 	t, err := taskFromRequest(r)
 	if err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeBodyError(w, err)
 		return
 	}
 
@@ -128,24 +372,39 @@ func runTaskAPI(w http.ResponseWriter, r *http.Request, rd *redis.Client) {
 		)
 		return
 	}
+	ctx, span := startRequestSpan(r, "run-task", t)
+	defer span.End()
 
-	wid, selectErr := selectWorkerQueue(t, rd, r.Context())
+	cache := newResultCache(b)
+	lookup := cache.get(ctx, t)
+	if lookup.Hit {
+		span.SetAttributes(attribute.Bool("cache.hit", true))
+		writeRunTaskResult(w, r, lookup.Result, true)
+		return
+	}
 
+	var selectErr error
+	result, shared, err := newCoalescer(b).do(ctx, t, func() (string, error) {
+		wid, err := selectWorkerQueue(t, b, ctx)
+		if err != nil {
+			selectErr = err
+			return "", err
+		}
+		return wid.runTask(t, b, ctx)
+	})
 	if selectErr != nil {
-		http.Error(w, "Error selecting worker", http.StatusInternalServerError)
-		slog.Error("Error selecting worker", "error", selectErr)
+		writeSelectError(w, selectErr)
 		return
 	}
-
-	result, err := wid.runTask(t, rd, r.Context())
 	if err != nil {
 		http.Error(w, "Error when running task", http.StatusInternalServerError)
 		slog.Error("Error when running task", "error", err)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	_, writeErr := w.Write(getResponseJSON(result))
-	if writeErr != nil {
-		slog.Error("Error writing response", "error", writeErr)
+	if shared {
+		span.SetAttributes(attribute.Bool("coalesced", true))
+	} else {
+		cache.put(ctx, t, lookup, result)
 	}
+	writeRunTaskResult(w, r, result, false)
 }
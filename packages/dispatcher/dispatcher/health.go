@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Health states, from best to worst. A degraded dispatcher still serves traffic.
+type healthStatus string
+
+const (
+	statusOK       healthStatus = "ok"
+	statusDegraded healthStatus = "degraded"
+	statusFailed   healthStatus = "failed"
+)
+
+func (s healthStatus) rank() int {
+	switch s {
+	case statusOK:
+		return 0
+	case statusDegraded:
+		return 1
+	}
+	return 2
+}
+
+// Health of a single component in the readiness report.
+type componentHealth struct {
+	Status  healthStatus   `json:"status"`
+	Message string         `json:"message,omitempty"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+// Readiness report returned by /readyz.
+type readinessReport struct {
+	Status     healthStatus               `json:"status"`
+	Components map[string]componentHealth `json:"components"`
+}
+
+// State of the long-running background goroutines, such as the config and certificate watchers.
+type backgroundRegistry struct {
+	mu    sync.Mutex
+	tasks map[string]string
+}
+
+var background = &backgroundRegistry{tasks: map[string]string{}}
+
+const (
+	bgRunning = "running"
+	bgStopped = "stopped"
+	bgFailed  = "failed"
+)
+
+// Run fn in a goroutine, tracking its state. A goroutine that returns before the context is done,
+// or panics, is reported as failed.
+func (b *backgroundRegistry) run(c context.Context, name string, fn func(context.Context)) {
+	b.set(name, bgRunning)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				slog.Error("Background task panicked", "task", name, "panic", p)
+				b.set(name, bgFailed)
+				return
+			}
+			if c.Err() != nil {
+				b.set(name, bgStopped)
+			} else {
+				slog.Error("Background task exited unexpectedly", "task", name)
+				b.set(name, bgFailed)
+			}
+		}()
+		fn(c)
+	}()
+}
+
+func (b *backgroundRegistry) set(name, state string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tasks[name] = state
+}
+
+func (b *backgroundRegistry) snapshot() map[string]string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := make(map[string]string, len(b.tasks))
+	for k, v := range b.tasks {
+		out[k] = v
+	}
+	return out
+}
+
+// Check the Redis round-trip latency with a PING.
+func checkRedis(rd *redisClient, c context.Context, cfg healthConfig) componentHealth {
+	ctx, cancel := context.WithTimeout(c, opTimeout())
+	defer cancel()
+	start := time.Now()
+	err := rd.Ping(ctx).Err()
+	latency := time.Since(start)
+	details := map[string]any{"latency_ms": float64(latency.Microseconds()) / 1000}
+	if err != nil {
+		observeRedisError("ping", err)
+		return componentHealth{Status: statusFailed, Message: err.Error(), Details: details}
+	}
+	if latency > time.Duration(cfg.RedisLatencyDegradedMs)*time.Millisecond {
+		return componentHealth{Status: statusDegraded, Message: "high Redis latency", Details: details}
+	}
+	return componentHealth{Status: statusOK, Details: details}
+}
+
+// Check the NATS connection and its round-trip latency.
+func checkNATS(nb *natsBroker, cfg healthConfig) componentHealth {
+	details := map[string]any{"connection": nb.nc.Status().String()}
+	if !nb.nc.IsConnected() {
+		return componentHealth{Status: statusFailed, Message: "not connected", Details: details}
+	}
+	latency, err := nb.nc.RTT()
+	details["latency_ms"] = float64(latency.Microseconds()) / 1000
+	if err != nil {
+		return componentHealth{Status: statusFailed, Message: err.Error(), Details: details}
+	}
+	if latency > time.Duration(cfg.RedisLatencyDegradedMs)*time.Millisecond {
+		return componentHealth{Status: statusDegraded, Message: "high NATS latency", Details: details}
+	}
+	return componentHealth{Status: statusOK, Details: details}
+}
+
+// Check that there are registered workers, and whether any of them is available.
+func checkWorkers(b broker, c context.Context) componentHealth {
+	running, err := b.runningWorkers(c)
+	if err != nil {
+		return componentHealth{Status: statusFailed, Message: err.Error()}
+	}
+	av, err := b.availableWorkers(c, false)
+	if err != nil {
+		return componentHealth{Status: statusFailed, Message: err.Error()}
+	}
+	details := map[string]any{"registered": len(running), "available": len(av)}
+	switch {
+	case len(running) == 0:
+		return componentHealth{Status: statusFailed, Message: "no registered workers", Details: details}
+	case len(av) == 0:
+		return componentHealth{Status: statusDegraded, Message: "no available workers", Details: details}
+	}
+	return componentHealth{Status: statusOK, Details: details}
+}
+
+// Check the number of tasks waiting in the common queues, including their tenant queues, reporting the
+// longest one.
+func checkBacklog(rd *redisClient, c context.Context, cfg healthConfig) componentHealth {
+	running, err := rd.runningWorkers(c)
+	if err != nil {
+		return componentHealth{Status: statusFailed, Message: err.Error()}
+	}
+	queues, err := commonQueues(rd, c, running)
+	if err != nil {
+		observeRedisError("backlog", err)
+		return componentHealth{Status: statusFailed, Message: err.Error()}
+	}
+	ctx, cancel := context.WithTimeout(c, opTimeout())
+	defer cancel()
+	pipe := rd.Pipeline()
+	lens := make([]*redis.IntCmd, len(queues))
+	for i, q := range queues {
+		lens[i] = pipe.LLen(ctx, rd.keys.queue(q))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		observeRedisError("backlog", err)
+		return componentHealth{Status: statusFailed, Message: err.Error()}
+	}
+	backlogs, err := tenantBacklogs(rd, c, queues)
+	if err != nil {
+		observeRedisError("backlog", err)
+		return componentHealth{Status: statusFailed, Message: err.Error()}
+	}
+	var n int64
+	longest := queues[0]
+	for i, q := range queues {
+		total := lens[i].Val()
+		for _, waiting := range backlogs[q] {
+			total += waiting
+		}
+		if total > n {
+			n, longest = total, q
+		}
+	}
+	details := map[string]any{"length": n, "queue": string(longest)}
+	switch {
+	case n >= int64(cfg.BacklogFailed):
+		return componentHealth{Status: statusFailed, Message: "common queue backlog too large", Details: details}
+	case n >= int64(cfg.BacklogDegraded):
+		return componentHealth{Status: statusDegraded, Message: "common queue backlog growing", Details: details}
+	}
+	return componentHealth{Status: statusOK, Details: details}
+}
+
+// Report the state of the background goroutines. Failed goroutines degrade the service, since
+// routing keeps working without them.
+func checkBackground() componentHealth {
+	tasks := background.snapshot()
+	details := make(map[string]any, len(tasks))
+	failed := []string{}
+	for name, state := range tasks {
+		details[name] = state
+		if state != bgRunning {
+			failed = append(failed, name)
+		}
+	}
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return componentHealth{Status: statusDegraded, Message: fmt.Sprintf("not running: %v", failed), Details: details}
+	}
+	return componentHealth{Status: statusOK, Details: details}
+}
+
+// Build the readiness report. The overall status is the worst status of all components. The backlog
+// is only checked on Redis.
+func readiness(b broker, c context.Context) readinessReport {
+	cfg := currentConfig().Health
+	rep := readinessReport{Status: statusOK, Components: map[string]componentHealth{}}
+	switch b := b.(type) {
+	case *redisClient:
+		rep.Components["redis"] = checkRedis(b, c, cfg)
+		rep.Components["backlog"] = checkBacklog(b, c, cfg)
+	case *natsBroker:
+		rep.Components["nats"] = checkNATS(b, cfg)
+	}
+	rep.Components["workers"] = checkWorkers(b, c)
+	rep.Components["background"] = checkBackground()
+	if draining.Load() {
+		rep.Components["dispatcher"] = componentHealth{Status: statusFailed, Message: "draining"}
+	} else {
+		rep.Components["dispatcher"] = componentHealth{Status: statusOK}
+	}
+
+	for _, ch := range rep.Components {
+		if ch.Status.rank() > rep.Status.rank() {
+			rep.Status = ch.Status
+		}
+	}
+	return rep
+}
+
+// API method for liveness checks. It only confirms that the process is serving requests.
+func livenessAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(getResponseJSON("OK")); err != nil {
+		slog.Error("Error writing response", "error", err)
+	}
+}
+
+// API method for readiness checks. Returns a JSON report per component, with status 503 if any
+// component failed, and 200 if all are ok or degraded.
+func readinessAPI(w http.ResponseWriter, r *http.Request, b broker) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rep := readiness(b, r.Context())
+	out, err := json.Marshal(rep)
+	if err != nil {
+		panic("Error serializing response: " + err.Error())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if rep.Status == statusFailed {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	if _, err := w.Write(out); err != nil {
+		slog.Error("Error writing response", "error", err)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Call the readiness API and decode the report.
+func getReadiness(t *testing.T, h http.HandlerFunc) (int, readinessReport) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	var rep readinessReport
+	if err := json.Unmarshal(w.Body.Bytes(), &rep); err != nil {
+		t.Fatalf("Error decoding readiness report: %v", err)
+	}
+	return w.Code, rep
+}
+
+func TestReadinessOK(t *testing.T) {
+	r, _ := mockRedis(true)
+	defer r.Close()
+
+	code, rep := getReadiness(t, func(w http.ResponseWriter, req *http.Request) { readinessAPI(w, req, r) })
+	if code != http.StatusOK || rep.Status != statusOK {
+		t.Errorf("Expected ready status, got %d %+v", code, rep)
+	}
+	for _, name := range []string{"redis", "workers", "backlog", "background", "dispatcher"} {
+		if _, ok := rep.Components[name]; !ok {
+			t.Errorf("Expected component %s in report", name)
+		}
+	}
+}
+
+// Test that a missing worker pool fails readiness, and a large backlog degrades it
+func TestReadinessWorkersAndBacklog(t *testing.T) {
+	r, c := mockRedis(false)
+	defer r.Close()
+
+	code, rep := getReadiness(t, func(w http.ResponseWriter, req *http.Request) { readinessAPI(w, req, r) })
+	if code != http.StatusServiceUnavailable || rep.Components["workers"].Status != statusFailed {
+		t.Errorf("Expected readiness to fail without workers, got %d %+v", code, rep)
+	}
+
+	setupTestData(r, c)
+	for range currentConfig().Health.BacklogDegraded {
+		r.RPush(c, r.keys.queue(workerId("all")), "{}")
+	}
+	code, rep = getReadiness(t, func(w http.ResponseWriter, req *http.Request) { readinessAPI(w, req, r) })
+	if code != http.StatusOK || rep.Status != statusDegraded || rep.Components["backlog"].Status != statusDegraded {
+		t.Errorf("Expected degraded backlog, got %d %+v", code, rep)
+	}
+}
+
+// Test that readiness fails when Redis cannot be reached
+func TestReadinessRedisDown(t *testing.T) {
+	r, _ := mockRedis(true)
+	r.Close()
+
+	code, rep := getReadiness(t, func(w http.ResponseWriter, req *http.Request) { readinessAPI(w, req, r) })
+	if code != http.StatusServiceUnavailable || rep.Components["redis"].Status != statusFailed {
+		t.Errorf("Expected readiness to fail without Redis, got %d %+v", code, rep)
+	}
+}
+
+// Test the tracking of background goroutines
+func TestBackgroundRegistry(t *testing.T) {
+	b := &backgroundRegistry{tasks: map[string]string{}}
+	c, cancel := context.WithCancel(context.Background())
+	b.run(c, "watcher", func(c context.Context) { <-c.Done() })
+	b.run(c, "crashes", func(c context.Context) { panic("boom") })
+	b.run(c, "exits", func(c context.Context) {})
+	time.Sleep(50 * time.Millisecond)
+
+	got := b.snapshot()
+	if got["watcher"] != bgRunning || got["crashes"] != bgFailed || got["exits"] != bgFailed {
+		t.Errorf("Unexpected background states: %v", got)
+	}
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	if b.snapshot()["watcher"] != bgStopped {
+		t.Errorf("Expected watcher to be stopped after cancel, got %v", b.snapshot())
+	}
+}
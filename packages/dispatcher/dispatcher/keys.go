@@ -0,0 +1,167 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Builds the Redis keys and channels of one deployment. Every name starts with the namespace as a
+// Redis Cluster hash tag, so all keys of a deployment share one slot and multi-key commands such as
+// SINTER, transactions, and BLPOP over several queues keep working on a cluster. Deployments with
+// different namespaces can share a Redis instance without seeing each other's workers or tasks.
+type keyspace struct {
+	prefix string
+}
+
+func newKeyspace(namespace string) keyspace {
+	return keyspace{prefix: "{" + namespace + "}:"}
+}
+
+func (k keyspace) available() string {
+	return k.prefix + "available"
+}
+
+func (k keyspace) running() string {
+	return k.prefix + "running"
+}
+
+func (k keyspace) draining() string {
+	return k.prefix + "draining"
+}
+
+func (k keyspace) lastActivity() string {
+	return k.prefix + "last-activity"
+}
+
+// Sorted set of the number of labels each worker holds.
+func (k keyspace) labelCounts() string {
+	return k.prefix + "labels:count"
+}
+
+// Hash of label name to the memory, in bytes, a worker needs to hold the label.
+func (k keyspace) labelSizes() string {
+	return k.prefix + "labels:sizes"
+}
+
+// Membership set of the workers holding a label.
+func (k keyspace) label(l string) string {
+	return k.prefix + "labels:" + l + ":workers"
+}
+
+// Extract the label from a label membership key.
+func (k keyspace) labelFromKey(key string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(key, k.prefix+"labels:"), ":workers")
+}
+
+// Hash counting the tasks routed for each label in the demand bucket of the given time.
+func (k keyspace) demand(t time.Time) string {
+	return k.prefix + "labels:demand:" + strconv.FormatInt(t.Unix()/demandBucketSeconds, 10)
+}
+
+// Job list of a worker, or of a common queue.
+func (k keyspace) queue(wid workerId) string {
+	return k.prefix + string(wid) + ":jobs"
+}
+
+// Extract the queue name (worker ID, "all", or "pool:<name>") from a job list key.
+func (k keyspace) queueFromKey(key string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(key, k.prefix), ":jobs")
+}
+
+// Set of the pool common queues that were sent tasks, so their backlog is reported even while none
+// of the pool's workers run.
+func (k keyspace) pools() string {
+	return k.prefix + "pools"
+}
+
+// Set of the common queues with tenant queues, which the fair scheduler feeds.
+func (k keyspace) fairQueues() string {
+	return k.prefix + "tenants"
+}
+
+// Set of the tenants with tasks waiting for a common queue.
+func (k keyspace) tenants(queue workerId) string {
+	return k.prefix + "tenants:" + string(queue)
+}
+
+// Job list of a tenant's tasks waiting for a common queue. It ends like a queue key, so the waiting
+// tasks show up in the queue and label introspection as "tenants:<queue>:<tenant>".
+func (k keyspace) tenantQueue(queue workerId, tenant string) string {
+	return k.queue(workerId("tenants:" + string(queue) + ":" + tenant))
+}
+
+// Hash of the time, in Unix milliseconds, each task waiting in a tenant queue was queued.
+func (k keyspace) tenantEnqueued() string {
+	return k.prefix + "tenants:enqueued"
+}
+
+// Cached result of a task, by the hash of its type, labels, and parameters.
+func (k keyspace) cachedResult(hash string) string {
+	return k.prefix + "cache:" + hash
+}
+
+// Set of the cached result keys of the tasks that used a label, to invalidate them together.
+func (k keyspace) cachedLabel(label string) string {
+	return k.prefix + "cache:labels:" + label
+}
+
+// Hash of the blobs holding the parameters of queued tasks, to the ID of their task. The blob sweeper
+// keeps them until the task leaves the queue.
+func (k keyspace) queuedParameters() string {
+	return k.prefix + "blobs:queued"
+}
+
+// Number of times the cached results of a label were invalidated. Results of tasks that ran across an
+// invalidation are not cached.
+func (k keyspace) cacheGeneration(label string) string {
+	return k.prefix + "cache:generation:" + label
+}
+
+// Lock held by the request running a task for identical concurrent /run-task requests, with the
+// hash of the task. It holds the ID of the task that runs.
+func (k keyspace) inflight(hash string) string {
+	return k.prefix + "inflight:" + hash
+}
+
+// Channel the running request publishes the outcome on when it releases the in-flight lock.
+func (k keyspace) inflightDone(hash string) string {
+	return k.prefix + "inflight:" + hash + ":done"
+}
+
+// Queue the worker reads admin commands from, ahead of its jobs.
+func (k keyspace) control(wid workerId) string {
+	return k.prefix + string(wid) + ":control"
+}
+
+// Hash a worker publishes at registration with its capacity and attributes.
+func (k keyspace) info(wid workerId) string {
+	return k.prefix + string(wid) + ":info"
+}
+
+// Sorted set of the labels a worker holds, scored by the time they were loaded, so the least recently
+// loaded label, which the worker evicts first, comes first.
+func (k keyspace) workerLabels(wid workerId) string {
+	return k.prefix + string(wid) + ":labels"
+}
+
+func (k keyspace) taskStatus(id string) string {
+	return k.prefix + "tasks:" + id
+}
+
+// Channel the worker publishes the result of a task on.
+func (k keyspace) results(id string) string {
+	return k.prefix + "results:" + id
+}
+
+// Redis client of one deployment, with the key builder of its namespace.
+type redisClient struct {
+	redis.UniversalClient
+	keys keyspace
+}
+
+func newNamespacedClient(r redis.UniversalClient, namespace string) *redisClient {
+	return &redisClient{UniversalClient: r, keys: newKeyspace(namespace)}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// Test that two deployments sharing one Redis only route to their own workers and queues
+func TestNamespaceIsolation(t *testing.T) {
+	mr := miniredis.RunT(t)
+	staging := newNamespacedClient(redis.NewClient(&redis.Options{Addr: mr.Addr()}), "staging")
+	defer staging.Close()
+	prod := newNamespacedClient(redis.NewClient(&redis.Options{Addr: mr.Addr()}), "prod")
+	defer prod.Close()
+	c := t.Context()
+
+	setupTestData(staging, c)
+	publishWorker(t, prod, c, "p-work1", 16, "label-1")
+	publishWorker(t, prod, c, "p-work2", 16)
+
+	for _, tc := range []struct {
+		r      *redisClient
+		label  string
+		expect workerId
+	}{
+		{staging, "label-1", "work1"},
+		{staging, "label-2", "work2"},
+		{prod, "label-1", "p-work1"},
+		{prod, "label-2", "p-work2"},
+	} {
+		wid, err := selectWorkerQueue(&taskRequest{TaskID: "t", Label: tc.label}, tc.r, c)
+		if err != nil || wid != tc.expect {
+			t.Errorf("Expected %s for %s in %s, got %s %v", tc.expect, tc.label, tc.r.keys.prefix, wid, err)
+		}
+	}
+
+	if av, _ := prod.availableWorkers(c, true); !slices.Equal(av, workerIds{"p-work1", "p-work2"}) {
+		t.Errorf("Expected only the prod workers, got %v", av)
+	}
+	if labels, _ := getLabelsInfo(staging, c); len(labels) != 3 {
+		t.Errorf("Expected only the staging labels, got %v", labels)
+	}
+
+	// Tasks nobody can take wait in the common queue of their own namespace
+	prod.SRem(c, prod.keys.available(), "p-work1", "p-work2")
+	wid, err := selectWorkerQueue(&taskRequest{TaskID: "t", Label: "label-1"}, prod, c)
+	if err != nil || wid != "all" {
+		t.Fatalf("Expected the common queue, got %s %v", wid, err)
+	}
+	if err := wid.sendTask(&taskRequest{TaskID: "t", Label: "label-1"}, prod, c); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := staging.LLen(c, staging.keys.queue("all")).Result(); n != 0 {
+		t.Errorf("Expected the staging common queue to be empty, got %d tasks", n)
+	}
+	if n, _ := prod.LLen(c, prod.keys.queue("all")).Result(); n != 1 {
+		t.Errorf("Expected the task in the prod common queue, got %d tasks", n)
+	}
+}
+
+func TestNamespaceValidation(t *testing.T) {
+	for ns, ok := range map[string]bool{
+		"task-runners": true,
+		"prod.eu_1":    true,
+		"":             false,
+		"a{b}":         false,
+		"tenant:*":     false,
+	} {
+		cfg := defaultConfig()
+		cfg.Redis.Namespace = ns
+		if err := cfg.validate(); (err == nil) != ok {
+			t.Errorf("Expected namespace %q to be valid: %v, got %v", ns, ok, err)
+		}
+	}
+}
@@ -4,55 +4,276 @@ The dispatcher service is responsible for receiving tasks and dispatching them t
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/redis/go-redis/v9"
 )
 
-// flags
-var randomDispatch bool
-var maxLabelsPerWorker int
+// Create the Redis client: a single node client, a Sentinel-backed client that follows failovers when
+// a master name is set, or a cluster client. When TLS is enabled connections are encrypted, and the
+// optional client certificate and CA bundle are reloaded on SIGHUP or file change.
+func newRedisClient(c context.Context, cfg redisConfig) (*redisClient, error) {
+	opts := &redis.UniversalOptions{
+		Addrs:            cfg.Addrs,
+		MasterName:       cfg.MasterName,
+		SentinelPassword: cfg.SentinelPassword,
+		IsClusterMode:    cfg.Cluster,
+		Username:         cfg.Username,
+		Password:         cfg.Password,
+		DB:               cfg.DB,
+	}
+	if len(opts.Addrs) == 0 {
+		opts.Addrs = []string{fmt.Sprintf("%s:%s", cfg.Host, cfg.Port)}
+	}
 
-// Setup CLI flags
-func flagsSetup() {
-	df := os.Getenv("RANDOM_DISPATCH") == "true"
-	flag.BoolVar(&randomDispatch, "random-dispatch", df, "Use random dispatching instead of 'smart' dispatching")
-	flag.IntVar(&maxLabelsPerWorker, "max-labels-worker", 2, "Maximum number of labels a worker can have")
+	if cfg.TLS.Enabled {
+		store, err := newCertStore(tlsFiles{
+			CertFile: cfg.TLS.CertFile,
+			KeyFile:  cfg.TLS.KeyFile,
+			CAFile:   cfg.TLS.CAFile,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("redis TLS setup: %w", err)
+		}
+		background.run(c, "redis-cert-watcher", func(c context.Context) {
+			store.watch(c, certReloadIntervalSeconds*time.Second)
+		})
+		opts.Dialer = tlsDialer(store, cfg.TLS.ServerName)
+	}
+	return newNamespacedClient(redis.NewUniversalClient(opts), cfg.Namespace), nil
 }
 
-func main() {
-	// flags init
-	flagsSetup()
-	flag.Parse()
-	if randomDispatch {
-		slog.Warn("Using Random Dispatch Method!")
+// Build the TLS configuration shared by the HTTP and gRPC servers, or nil if TLS is disabled.
+func newServerTLS(c context.Context, cfg serverTLSConfig) (*tls.Config, error) {
+	if cfg.CertFile == "" {
+		return nil, nil
 	}
 
-	client := redis.NewClient(&redis.Options{
-		Addr: fmt.Sprintf("%s:%s", os.Getenv("REDIS_HOST"), os.Getenv("REDIS_PORT")),
+	clientAuth, err := parseClientAuth(cfg.ClientAuth)
+	if err != nil {
+		return nil, err
+	}
+	store, err := newCertStore(tlsFiles{CertFile: cfg.CertFile, KeyFile: cfg.KeyFile, CAFile: cfg.CAFile})
+	if err != nil {
+		return nil, fmt.Errorf("server TLS setup: %w", err)
+	}
+	background.run(c, "server-cert-watcher", func(c context.Context) {
+		store.watch(c, certReloadIntervalSeconds*time.Second)
 	})
 
-	http.HandleFunc("/health", healthCheckAPI)
-	http.HandleFunc(
+	slog.Info("TLS enabled", "client_auth", clientAuth.String())
+	return store.serverConfig(clientAuth), nil
+}
+
+// Register the API routes on a new mux. The introspection and admin endpoints read the Redis keys
+// directly, so they are only served on the Redis broker.
+func newRouter(b broker) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", healthCheckAPI)
+	mux.HandleFunc("/livez", livenessAPI)
+	mux.HandleFunc(
+		"/readyz",
+		func(w http.ResponseWriter, r *http.Request) {
+			readinessAPI(w, r, b)
+		})
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc(
+		"/send-task",
+		rejectWhenDraining(func(w http.ResponseWriter, r *http.Request) {
+			dispatchTaskAPI(w, r, b)
+		}))
+	mux.HandleFunc(
+		"/run-task",
+		rejectWhenDraining(func(w http.ResponseWriter, r *http.Request) {
+			runTaskAPI(w, r, b)
+		}))
+
+	client, ok := b.(*redisClient)
+	if !ok {
+		return mux
+	}
+	mux.HandleFunc(
 		"/workers",
 		func(w http.ResponseWriter, r *http.Request) {
 			runningWorkersAPI(w, r, client)
 		})
-	http.HandleFunc(
-		"/send-task",
+	mux.HandleFunc(
+		"GET /workers/{id}",
 		func(w http.ResponseWriter, r *http.Request) {
-			dispatchTaskAPI(w, r, client)
+			workerInfoAPI(w, r, client)
 		})
-	http.HandleFunc(
-		"/run-task",
+	mux.HandleFunc(
+		"GET /labels",
+		func(w http.ResponseWriter, r *http.Request) {
+			labelsAPI(w, r, client)
+		})
+	mux.HandleFunc(
+		"GET /queues",
+		func(w http.ResponseWriter, r *http.Request) {
+			queuesAPI(w, r, client)
+		})
+	mux.HandleFunc(
+		"GET /tasks/{id}",
+		func(w http.ResponseWriter, r *http.Request) {
+			taskInfoAPI(w, r, client)
+		})
+	mux.HandleFunc(
+		"GET /admin/workers/{id}/drain",
+		func(w http.ResponseWriter, r *http.Request) {
+			drainStatusAPI(w, r, client)
+		})
+	mux.HandleFunc(
+		"POST /admin/workers/{id}/drain",
+		func(w http.ResponseWriter, r *http.Request) {
+			drainWorkerAPI(w, r, client)
+		})
+	mux.HandleFunc(
+		"DELETE /admin/workers/{id}/drain",
+		func(w http.ResponseWriter, r *http.Request) {
+			drainWorkerAPI(w, r, client)
+		})
+	mux.HandleFunc(
+		"POST /admin/workers/{id}/labels/{label}/evict",
+		func(w http.ResponseWriter, r *http.Request) {
+			evictLabelAPI(w, r, client)
+		})
+	mux.HandleFunc(
+		"PUT /admin/labels/{label}/size",
+		func(w http.ResponseWriter, r *http.Request) {
+			labelSizeAPI(w, r, client)
+		})
+	mux.HandleFunc(
+		"DELETE /admin/labels/{label}/size",
+		func(w http.ResponseWriter, r *http.Request) {
+			labelSizeAPI(w, r, client)
+		})
+	mux.HandleFunc(
+		"DELETE /admin/cache/labels/{label}",
+		func(w http.ResponseWriter, r *http.Request) {
+			invalidateCacheAPI(w, r, client)
+		})
+	mux.HandleFunc(
+		"POST /admin/workers/{id}/redistribute",
 		func(w http.ResponseWriter, r *http.Request) {
-			runTaskAPI(w, r, client)
+			redistributeAPI(w, r, client)
 		})
-	slog.Info("Starting dispatcher service...")
-	log.Fatal(http.ListenAndServe(fmt.Sprintf("0.0.0.0:%s", os.Getenv("PORT")), nil))
+	return mux
+}
+
+func main() {
+	if err := run(); err != nil {
+		slog.Error("Dispatcher stopped with error", "error", err)
+		os.Exit(1)
+	}
+}
+
+// Run the dispatcher until SIGTERM or SIGINT, then drain and release resources.
+func run() error {
+	// flags and config init
+	cf := &configFlags{}
+	cf.register(flag.CommandLine)
+	migrate := flag.Bool("migrate-keys", false, "Rename Redis keys from the legacy layout to the hash-tagged one, then exit")
+	flag.Parse()
+	cf.parsed(flag.CommandLine)
+	cfg, err := loadConfig(cf)
+	if err != nil {
+		return err
+	}
+	setConfig(cfg)
+	if cfg.Routing.RandomDispatch {
+		slog.Warn("Using Random Dispatch Method!")
+	}
+
+	c, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	background.run(c, "config-watcher", func(c context.Context) { watchConfig(c, cf) })
+	shutdownTracing, err := setupTracing(c)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// Flush pending spans; the signal context is already done at this point
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownMarginSeconds*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Error("Error flushing traces", "error", err)
+		}
+	}()
+
+	var b broker
+	switch cfg.Broker {
+	case brokerNATS:
+		if *migrate {
+			return errors.New("--migrate-keys only applies to the Redis broker")
+		}
+		nc, err := nats.Connect(cfg.NATS.URL, nats.Name("dispatcher"), nats.MaxReconnects(-1))
+		if err != nil {
+			return fmt.Errorf("connecting to NATS: %w", err)
+		}
+		defer nc.Close()
+		if b, err = newNATSBroker(c, nc, cfg.NATS.Namespace); err != nil {
+			return fmt.Errorf("setting up NATS JetStream: %w", err)
+		}
+		slog.Info("Routing tasks through NATS JetStream", "url", cfg.NATS.URL, "namespace", cfg.NATS.Namespace)
+	default:
+		client, err := newRedisClient(c, cfg.Redis)
+		if err != nil {
+			return err
+		}
+		defer client.Close()
+		if *migrate {
+			_, _, err := migrateKeys(client, c)
+			return err
+		}
+		prometheus.MustRegister(newClusterCollector(client))
+		background.run(c, "fair-scheduler", newFairScheduler(client).run)
+		b = client
+	}
+
+	if payloads, err = newPayloadStore(cfg); err != nil {
+		return fmt.Errorf("setting up the blob store: %w", err)
+	}
+	background.run(c, "blob-sweeper", func(c context.Context) { payloads.runSweeper(c, b) })
+	if cfg.Blobs.ThresholdBytes > 0 {
+		slog.Info("Offloading large task parameters", "backend", cfg.Blobs.Backend, "threshold_bytes", cfg.Blobs.ThresholdBytes)
+	}
+
+	tlsConfig, err := newServerTLS(c, cfg.TLS)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Addr: fmt.Sprintf("0.0.0.0:%s", cfg.Port), Handler: newRouter(b), TLSConfig: tlsConfig}
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+	gs, hs := newGRPCServer(b, tlsConfig)
+	grpcAddr := fmt.Sprintf("0.0.0.0:%s", cfg.GRPCPort)
+	grpcLn, err := net.Listen("tcp", grpcAddr)
+	if err != nil {
+		ln.Close()
+		return err
+	}
+
+	slog.Info("Starting dispatcher service...", "address", srv.Addr, "grpc_address", grpcAddr)
+	grpcErrs := make(chan error, 1)
+	go func() { grpcErrs <- serveGRPCUntilDone(c, gs, hs, grpcLn) }()
+	err = serveUntilDone(c, srv, ln)
+	// Stop the gRPC server too if the HTTP server failed on its own
+	stop()
+	return errors.Join(err, <-grpcErrs)
 }
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Broker that keeps the worker registry, the queues, and the results in the process. Workers run in
+// the same process and use the worker side methods, such as registerWorker, addLabel, popTask, and
+// publishResult, in place of the Redis protocol. It follows the same rules as Redis for routing, so
+// tests of the routing decisions need no Redis server.
+type memoryBroker struct {
+	mu        sync.Mutex
+	running   map[workerId]bool
+	available map[workerId]bool
+	draining  map[workerId]bool
+	info      map[workerId]workerResources
+	// Labels of each worker in eviction order, least recently loaded first
+	labels  map[workerId][]string
+	holders map[string]map[workerId]bool
+	sizes   map[string]int64
+	// Tasks routed for each label, by demand bucket
+	demand  map[int64]map[string]int64
+	queues  map[workerId][]string
+	results map[string]*memoryResult
+	// Closed and replaced whenever a task is queued, to wake up the workers waiting for one
+	queued chan struct{}
+}
+
+func newMemoryBroker() *memoryBroker {
+	return &memoryBroker{
+		running:   map[workerId]bool{},
+		available: map[workerId]bool{},
+		draining:  map[workerId]bool{},
+		info:      map[workerId]workerResources{},
+		labels:    map[workerId][]string{},
+		holders:   map[string]map[workerId]bool{},
+		sizes:     map[string]int64{},
+		demand:    map[int64]map[string]int64{},
+		queues:    map[workerId][]string{},
+		results:   map[string]*memoryResult{},
+		queued:    make(chan struct{}),
+	}
+}
+
+// Register a running and available worker with its capacity and attributes.
+func (m *memoryBroker) registerWorker(wid workerId, res workerResources) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if res.Attributes == nil {
+		res.Attributes = map[string]string{}
+	}
+	m.running[wid] = true
+	m.available[wid] = true
+	m.info[wid] = res
+}
+
+// Remove a worker and release its labels.
+func (m *memoryBroker) deregisterWorker(wid workerId) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, l := range m.labels[wid] {
+		delete(m.holders[l], wid)
+	}
+	delete(m.running, wid)
+	delete(m.available, wid)
+	delete(m.draining, wid)
+	delete(m.info, wid)
+	delete(m.labels, wid)
+}
+
+func (m *memoryBroker) setAvailable(wid workerId, on bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.available[wid] = on
+}
+
+func (m *memoryBroker) setDraining(wid workerId, on bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.draining[wid] = on
+}
+
+// Register a label for a worker, or mark it as the most recently loaded if the worker holds it.
+func (m *memoryBroker) addLabel(wid workerId, label string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.labels[wid] = append(slices.DeleteFunc(m.labels[wid], func(l string) bool { return l == label }), label)
+	if m.holders[label] == nil {
+		m.holders[label] = map[workerId]bool{}
+	}
+	m.holders[label][wid] = true
+}
+
+func (m *memoryBroker) removeLabel(wid workerId, label string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.labels[wid] = slices.DeleteFunc(m.labels[wid], func(l string) bool { return l == label })
+	delete(m.holders[label], wid)
+}
+
+// Set the size of a label in the label registry, or remove it if size is zero.
+func (m *memoryBroker) setLabelSize(label string, size int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if size > 0 {
+		m.sizes[label] = size
+	} else {
+		delete(m.sizes, label)
+	}
+}
+
+// Wait for the next task in the given queues, taking them in order like BLPOP. Returns the queue the
+// task was taken from, or the context error once it is done.
+func (m *memoryBroker) popTask(c context.Context, queues ...workerId) (workerId, *taskRequest, error) {
+	q, raw, err := m.popRaw(c, queues...)
+	if err != nil {
+		return q, nil, err
+	}
+	var t taskRequest
+	if err := json.Unmarshal([]byte(raw), &t); err != nil {
+		return q, nil, err
+	}
+	return q, &t, nil
+}
+
+// Wait for the next serialized task in the given queues, like popTask.
+func (m *memoryBroker) popRaw(c context.Context, queues ...workerId) (workerId, string, error) {
+	for {
+		m.mu.Lock()
+		for _, q := range queues {
+			if len(m.queues[q]) == 0 {
+				continue
+			}
+			raw := m.queues[q][0]
+			m.queues[q] = m.queues[q][1:]
+			m.mu.Unlock()
+			return q, raw, nil
+		}
+		queued := m.queued
+		m.mu.Unlock()
+
+		select {
+		case <-queued:
+		case <-c.Done():
+			return "", "", c.Err()
+		}
+	}
+}
+
+// Channel the result of a task is sent on, created by the first of the sender and the receiver.
+type memoryResult struct {
+	ch      chan string
+	created time.Time
+	// Whether a receiver is waiting, which then deletes the channel when it is done
+	waiting bool
+}
+
+// Send the result of a task back to the dispatcher. Results are kept until they are received, so a
+// result sent before the dispatcher starts waiting is not lost, but only for the task timeout: a
+// result that arrives after the dispatcher gave up is dropped. Only the first result of a task is
+// kept.
+func (m *memoryBroker) publishResult(taskID, result string) {
+	select {
+	case m.result(taskID, false) <- result:
+	default:
+	}
+}
+
+func (m *memoryBroker) result(taskID string, waiting bool) chan string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.results[taskID]
+	if !ok {
+		now := time.Now()
+		ttl := time.Duration(currentConfig().Timeouts.TaskSeconds) * time.Second
+		for id, old := range m.results {
+			if !old.waiting && now.Sub(old.created) > ttl {
+				delete(m.results, id)
+			}
+		}
+		r = &memoryResult{ch: make(chan string, 1), created: now}
+		m.results[taskID] = r
+	}
+	r.waiting = r.waiting || waiting
+	return r.ch
+}
+
+func (m *memoryBroker) availableWorkers(_ context.Context, sorted bool) (workerIds, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := workerIds{}
+	for w, ok := range m.available {
+		if ok && !m.draining[w] {
+			out = append(out, w)
+		}
+	}
+	if sorted {
+		slices.Sort(out)
+	}
+	return out, nil
+}
+
+func (m *memoryBroker) availableWorkersLabel(c context.Context, labels ...string) (workerIds, error) {
+	av, _ := m.availableWorkers(c, false)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.DeleteFunc(av, func(w workerId) bool {
+		return slices.ContainsFunc(labels, func(l string) bool { return !m.holders[l][w] })
+	}), nil
+}
+
+func (m *memoryBroker) runningWorkers(_ context.Context) (workerIds, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := workerIds{}
+	for w := range m.running {
+		out = append(out, w)
+	}
+	return out, nil
+}
+
+func (m *memoryBroker) activeWorkers(_ context.Context) (workerIds, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := workerIds{}
+	for w := range m.running {
+		if !m.draining[w] {
+			out = append(out, w)
+		}
+	}
+	return out, nil
+}
+
+func (m *memoryBroker) workersWithLabel(_ context.Context, label string) (workerIds, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := workerIds{}
+	for w := range m.holders[label] {
+		out = append(out, w)
+	}
+	return out, nil
+}
+
+func (m *memoryBroker) isAvailable(_ context.Context, wid workerId) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.available[wid], nil
+}
+
+// Published resources of a worker, with the configured defaults for workers that did not publish any.
+func (m *memoryBroker) resources(wid workerId) workerResources {
+	res, ok := m.info[wid]
+	if !ok {
+		return parseWorkerResources(wid, nil)
+	}
+	if res.MaxLabels <= 0 {
+		res.MaxLabels = currentConfig().Routing.MaxLabelsPerWorker
+	}
+	return res
+}
+
+func (m *memoryBroker) loadWorkerResources(_ context.Context, wids workerIds) ([]workerResources, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]workerResources, len(wids))
+	for i, w := range wids {
+		out[i] = m.resources(w)
+	}
+	return out, nil
+}
+
+func (m *memoryBroker) loadCapacities(_ context.Context, wids workerIds, requested ...string) ([]workerCapacity, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sizes := map[string]int64{}
+	out := make([]workerCapacity, len(wids))
+	for i, w := range wids {
+		out[i] = workerCapacity{
+			workerResources: m.resources(w),
+			ID:              w,
+			Labels:          slices.Clone(m.labels[w]),
+			LabelCount:      len(m.labels[w]),
+			Holds:           []string{},
+			Sizes:           sizes,
+		}
+		for _, l := range requested {
+			if m.holders[l][w] {
+				out[i].Holds = append(out[i].Holds, l)
+			}
+		}
+		for _, l := range out[i].Labels {
+			sizes[l] = m.sizes[l]
+		}
+	}
+	for _, l := range requested {
+		sizes[l] = m.sizes[l]
+	}
+	return out, nil
+}
+
+func (m *memoryBroker) recordDemand(_ context.Context, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	bucket := time.Now().Unix() / demandBucketSeconds
+	oldest := bucket - int64(currentConfig().Routing.DemandWindowSeconds/demandBucketSeconds)
+	for b := range m.demand {
+		if b <= oldest {
+			delete(m.demand, b)
+		}
+	}
+	if len(labels) == 0 {
+		return
+	}
+	if m.demand[bucket] == nil {
+		m.demand[bucket] = map[string]int64{}
+	}
+	for _, l := range labels {
+		m.demand[bucket][l]++
+	}
+}
+
+func (m *memoryBroker) loadEvictionStats(_ context.Context, labels []string) (evictionStats, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := evictionStats{Demand: map[string]int64{}, Holders: map[string]int64{}}
+	bucket := time.Now().Unix() / demandBucketSeconds
+	buckets := int64(currentConfig().Routing.DemandWindowSeconds / demandBucketSeconds)
+	for _, l := range labels {
+		for b := bucket - buckets + 1; b <= bucket; b++ {
+			stats.Demand[l] += m.demand[b][l]
+		}
+		stats.Holders[l] = int64(len(m.holders[l]))
+	}
+	return stats, nil
+}
+
+func (m *memoryBroker) enqueue(_ context.Context, wid workerId, _ *taskRequest, raw []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.queues[wid] = append(m.queues[wid], string(raw))
+	close(m.queued)
+	m.queued = make(chan struct{})
+	return nil
+}
+
+// Which of the given blobs hold the parameters of tasks still in a queue.
+func (m *memoryBroker) queuedParameters(_ context.Context, keys []string) (map[string]bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	refs := map[string]bool{}
+	for _, queue := range m.queues {
+		for _, raw := range queue {
+			var t taskRequest
+			if err := json.Unmarshal([]byte(raw), &t); err == nil && t.ParametersRef != "" {
+				refs[t.ParametersRef] = true
+			}
+		}
+	}
+	queued := map[string]bool{}
+	for _, key := range keys {
+		if refs[key] {
+			queued[key] = true
+		}
+	}
+	return queued, nil
+}
+
+func (m *memoryBroker) receiveResult(c context.Context, taskID string) (string, error) {
+	ch := m.result(taskID, true)
+	defer func() {
+		m.mu.Lock()
+		delete(m.results, taskID)
+		m.mu.Unlock()
+	}()
+	select {
+	case result := <-ch:
+		return result, nil
+	case <-c.Done():
+		return "", c.Err()
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"taskrunner"
+)
+
+// Worker side of the memory broker as a taskrunner.Broker, so Go runners in a test's process take
+// their tasks from it:
+//
+//	runner := taskrunner.NewWithBroker(m.runnerBroker(), taskrunner.Options{})
+//
+// The memory broker has no control queues or task statuses, so admin commands never arrive and
+// statuses are not kept.
+type memoryRunnerBroker struct {
+	m *memoryBroker
+}
+
+var _ taskrunner.Broker = memoryRunnerBroker{}
+
+func (m *memoryBroker) runnerBroker() taskrunner.Broker {
+	return memoryRunnerBroker{m: m}
+}
+
+func (b memoryRunnerBroker) Register(_ context.Context, id string, info taskrunner.WorkerInfo) error {
+	b.m.registerWorker(workerId(id), workerResources{
+		MaxLabels:    info.MaxLabels,
+		MemoryBudget: info.MemoryBudgetBytes,
+		Attributes:   info.Attributes,
+	})
+	return nil
+}
+
+func (b memoryRunnerBroker) Deregister(_ context.Context, id string) error {
+	b.m.deregisterWorker(workerId(id))
+	return nil
+}
+
+func (b memoryRunnerBroker) SetAvailable(_ context.Context, id string, available bool) error {
+	b.m.setAvailable(workerId(id), available)
+	return nil
+}
+
+func (b memoryRunnerBroker) Draining(_ context.Context, id string) (bool, error) {
+	b.m.mu.Lock()
+	defer b.m.mu.Unlock()
+	return b.m.draining[workerId(id)], nil
+}
+
+func (b memoryRunnerBroker) Next(ctx context.Context, id, pool string, common bool, timeout time.Duration) (*taskrunner.Message, error) {
+	queues := workerIds{workerId(id)}
+	if common {
+		queues = append(queues, poolQueue(pool))
+	}
+	c, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	q, raw, err := b.m.popRaw(c, queues...)
+	if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &taskrunner.Message{Queue: string(q), Body: raw}, nil
+}
+
+func (b memoryRunnerBroker) LabelSize(_ context.Context, label string) (int64, error) {
+	b.m.mu.Lock()
+	defer b.m.mu.Unlock()
+	return b.m.sizes[label], nil
+}
+
+func (b memoryRunnerBroker) AddLabel(_ context.Context, id, label string, _ bool) error {
+	b.m.addLabel(workerId(id), label)
+	return nil
+}
+
+func (b memoryRunnerBroker) RemoveLabel(_ context.Context, id, label string, _ bool) error {
+	b.m.removeLabel(workerId(id), label)
+	return nil
+}
+
+func (b memoryRunnerBroker) RecordStatus(context.Context, string, string, string, *string, time.Duration) error {
+	return nil
+}
+
+func (b memoryRunnerBroker) PublishResult(_ context.Context, taskID, result string) error {
+	b.m.publishResult(taskID, result)
+	return nil
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"taskrunner"
+)
+
+// A worker registered the same way on every broker, with its labels oldest first.
+type testWorker struct {
+	id        workerId
+	budgetGB  int64
+	maxLabels int64
+	attrs     string
+	labels    []string
+}
+
+var parityWorkers = []testWorker{
+	{"w-a", 16, 10, "", []string{"a"}},
+	{"w-bc", 16, 10, "", []string{"b", "c"}},
+	{"w-full", 0, 1, "", []string{"d"}},
+	{"w-mem", 64, 10, `{"pool": "highmem"}`, []string{"a"}},
+}
+
+var paritySizes = map[string]int64{"a": 4, "b": 4, "c": 4, "new": 10, "big": 100}
+
+// Worker side of a broker under test, to set up the same cluster as on Redis.
+type parityBackend struct {
+	broker
+	register     func(w testWorker, res workerResources)
+	setLabelSize func(label string, size int64)
+	setDraining  func(wid workerId)
+	setBusy      func(wid workerId)
+}
+
+// Check that routing makes the same decisions on a broker as on Redis, with the same workers, label
+// sizes, and demand.
+func checkRoutingParity(t *testing.T, b parityBackend) {
+	t.Helper()
+	r, c := mockRedis(false)
+	defer r.Close()
+	for _, w := range parityWorkers {
+		publishWorker(t, r, c, w.id, w.budgetGB, w.labels...)
+		r.HSet(c, r.keys.info(w.id), maxLabelsField, w.maxLabels, attributesField, w.attrs)
+
+		res := workerResources{MaxLabels: int(w.maxLabels), MemoryBudget: w.budgetGB * gb}
+		if w.attrs != "" {
+			json.Unmarshal([]byte(w.attrs), &res.Attributes)
+		}
+		b.register(w, res)
+	}
+	setSizes(t, r, c, paritySizes)
+	for l, s := range paritySizes {
+		b.setLabelSize(l, s*gb)
+	}
+	for range 3 {
+		r.recordDemand(c, "c")
+		b.recordDemand(c, "c")
+	}
+
+	highmem := &taskSelector{Required: map[string]string{"pool": "highmem"}}
+	tasks := []*taskRequest{
+		{TaskID: "hit", Label: "a"},
+		{TaskID: "multi-hit", Labels: []string{"b", "c"}},
+		{TaskID: "partial", Labels: []string{"a", "b"}},
+		{TaskID: "place", Label: "new"},
+		{TaskID: "too-big", Label: "big"},
+		{TaskID: "pool", Label: "new", Selector: highmem},
+		{TaskID: "evict", Label: "e", Selector: &taskSelector{Preferred: map[string]string{"gpu": "a100"}}},
+	}
+	route := func(b broker) []workerId {
+		t.Helper()
+		out := []workerId{}
+		for _, task := range tasks {
+			wid, err := selectWorkerQueue(task, b, c)
+			if err != nil {
+				t.Fatalf("Error routing %s: %v", task.TaskID, err)
+			}
+			out = append(out, wid)
+		}
+		return out
+	}
+	compare := func() {
+		t.Helper()
+		onRedis, onBroker := route(r), route(b)
+		for i, task := range tasks {
+			if onRedis[i] != onBroker[i] {
+				t.Errorf("Expected %s to be routed to %s like on Redis, got %s", task.TaskID, onRedis[i], onBroker[i])
+			}
+		}
+	}
+	compare()
+
+	// Draining and busy workers are skipped the same way
+	r.SAdd(c, r.keys.draining(), "w-a")
+	b.setDraining("w-a")
+	r.SRem(c, r.keys.available(), "w-bc")
+	b.setBusy("w-bc")
+	compare()
+}
+
+// Test that routing makes the same decisions on the in-memory broker as on Redis
+func TestMemoryBrokerParity(t *testing.T) {
+	m := newMemoryBroker()
+	checkRoutingParity(t, parityBackend{
+		broker: m,
+		register: func(w testWorker, res workerResources) {
+			m.registerWorker(w.id, res)
+			for _, l := range w.labels {
+				m.addLabel(w.id, l)
+			}
+		},
+		setLabelSize: m.setLabelSize,
+		setDraining:  func(wid workerId) { m.setDraining(wid, true) },
+		setBusy:      func(wid workerId) { m.setAvailable(wid, false) },
+	})
+}
+
+// Test running a task end to end with an in-process worker, without Redis
+func TestMemoryBrokerRunTask(t *testing.T) {
+	m := newMemoryBroker()
+	c, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m.registerWorker("w1", workerResources{MaxLabels: 2})
+
+	// The worker loads the labels of its tasks and echoes their parameters
+	go func() {
+		for {
+			q, task, err := m.popTask(c, "w1", poolQueue(""))
+			if err != nil {
+				return
+			}
+			m.setAvailable("w1", false)
+			for _, l := range task.labels() {
+				m.addLabel("w1", l)
+			}
+			if task.ReturnResult {
+				m.publishResult(task.TaskID, string(q)+":"+task.Parameters)
+			}
+			m.setAvailable("w1", true)
+		}
+	}()
+
+	task := &taskRequest{TaskID: "t1", Label: "model-a", Parameters: "{}", ReturnResult: true}
+	wid, err := selectWorkerQueue(task, m, c)
+	if err != nil || wid != "w1" {
+		t.Fatalf("Expected w1, got %s %v", wid, err)
+	}
+	result, err := wid.runTask(task, m, c)
+	if err != nil || result != "w1:{}" {
+		t.Errorf("Expected the echoed result, got %q %v", result, err)
+	}
+	if holders, _ := m.workersWithLabel(c, "model-a"); len(holders) != 1 {
+		t.Errorf("Expected the worker to hold the label, got %v", holders)
+	}
+
+	// Results sent before the dispatcher waits for them are kept
+	m.publishResult("early", "done")
+	if result, err := awaitResult(&taskRequest{TaskID: "early"}, m, c); err != nil || result != "done" {
+		t.Errorf("Expected the early result, got %q %v", result, err)
+	}
+
+	cfg := defaultConfig()
+	cfg.Timeouts.TaskSeconds = 1
+	setConfig(cfg)
+	defer setConfig(defaultConfig())
+	start := time.Now()
+	if _, err := awaitResult(&taskRequest{TaskID: "lost"}, m, c); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected a timeout, got %v", err)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Error("Expected the task timeout to apply")
+	}
+
+	// A result arriving after the dispatcher gave up is dropped once the task timeout passed
+	m.publishResult("lost", "late")
+	m.results["lost"].created = time.Now().Add(-2 * time.Second)
+	m.publishResult("other", "done")
+	if _, ok := m.results["lost"]; ok || len(m.results) != 1 {
+		t.Errorf("Expected the late result to be dropped, got %v", m.results)
+	}
+}
+
+// Wait for the memory broker to have the given number of available workers.
+func waitAvailable(t *testing.T, m *memoryBroker, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if av, _ := m.availableWorkers(context.Background(), false); len(av) == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d available workers", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Test the dispatcher and Go runners in one process: tasks sent to the HTTP API are routed by label
+// and run by the runners through the memory broker
+func TestMemoryBrokerRunners(t *testing.T) {
+	m := newMemoryBroker()
+	srv := httptest.NewServer(newRouter(m))
+	defer srv.Close()
+	c, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+
+	ids := []string{}
+	for range 2 {
+		rn := taskrunner.NewWithBroker(m.runnerBroker(), taskrunner.Options{PollTimeout: 100 * time.Millisecond})
+		rn.Handle("echo", func(ctx context.Context, labels *taskrunner.LabelSet, task *taskrunner.Task) (string, error) {
+			if err := labels.AddAll(ctx, task.AllLabels(), nil); err != nil {
+				return "", err
+			}
+			return rn.ID() + ":" + task.Parameters, nil
+		})
+		ids = append(ids, rn.ID())
+		wg.Go(func() { rn.Run(c) })
+	}
+	waitAvailable(t, m, 2)
+
+	run := func(id string) string {
+		t.Helper()
+		body := `{"task_id": "` + id + `", "task_type": "echo", "label": "model-a", "parameters_json": "{}", "return_result": true}`
+		resp, err := http.Post(srv.URL+"/run-task", contentJSON, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var out runTaskResponse
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected the task to run, got %d %v", resp.StatusCode, err)
+		}
+		return out.Message
+	}
+	first := run("t1")
+	owner, params, _ := strings.Cut(first, ":")
+	if !slices.Contains(ids, owner) || params != "{}" {
+		t.Fatalf("Expected a runner to echo the parameters, got %q", first)
+	}
+	if holders, _ := m.workersWithLabel(c, "model-a"); !slices.Equal(holders, workerIds{workerId(owner)}) {
+		t.Errorf("Expected the runner to hold the label, got %v", holders)
+	}
+	waitAvailable(t, m, 2)
+	if second := run("t2"); second != first {
+		t.Errorf("Expected the task to go to the runner holding the label, got %q", second)
+	}
+
+	cancel()
+	wg.Wait()
+	if running, _ := m.runningWorkers(c); len(running) != 0 {
+		t.Errorf("Expected the runners to deregister, got %v", running)
+	}
+}
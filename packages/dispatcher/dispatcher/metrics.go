@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+)
+
+// Possible outcomes of routing a task, used as the "outcome" label of the dispatch counter.
+const (
+	outcomeLabelHit       = "label_hit"
+	outcomeCapacityWorker = "capacity_worker"
+	outcomeEvictionWorker = "eviction_worker"
+	outcomePartialHit     = "partial_hit"
+	outcomeCommonQueue    = "common_queue"
+	outcomeBusyWorker     = "busy_worker"
+	outcomeRandomDispatch = "random"
+)
+
+// Possible statuses of a synchronous task run.
+const (
+	runStatusOK      = "ok"
+	runStatusTimeout = "timeout"
+	runStatusError   = "error"
+)
+
+// Possible results of a result cache lookup.
+const (
+	cacheHit  = "hit"
+	cacheMiss = "miss"
+)
+
+// Possible results of a blob store operation.
+const (
+	blobOK    = "ok"
+	blobError = "error"
+)
+
+const metricsNamespace = "dispatcher"
+
+// Upper bound on the number of worker queues reported per scrape.
+const queueDepthScrapeWorkers = 500
+
+var dispatchCount = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "dispatch_total",
+		Help:      "Number of tasks routed, by routing outcome.",
+	},
+	[]string{"outcome"},
+)
+
+var placementCount = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "placements_total",
+		Help:      "Number of label misses placed on a worker, by the reason the worker was chosen.",
+	},
+	[]string{"reason"},
+)
+
+var routingLatency = promauto.NewHistogram(
+	prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "routing_duration_seconds",
+		Help:      "Time taken to select a queue for a task.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5},
+	},
+)
+
+var redisErrors = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "redis_errors_total",
+		Help:      "Number of failed Redis operations, by operation and kind (error or timeout).",
+	},
+	[]string{"operation", "kind"},
+)
+
+var runTaskDuration = promauto.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "run_task_duration_seconds",
+		Help:      "Time from enqueueing a synchronous task until its result arrives, by status.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 10),
+	},
+	[]string{"status"},
+)
+
+var runTaskTimeouts = promauto.NewCounter(
+	prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "run_task_timeouts_total",
+		Help:      "Number of synchronous tasks whose result did not arrive in time.",
+	},
+)
+
+var coalescedRuns = promauto.NewCounter(
+	prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "run_task_coalesced_total",
+		Help:      "Number of synchronous tasks answered with the result of an identical task another request ran.",
+	},
+)
+
+var cacheLookups = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "result_cache_lookups_total",
+		Help:      "Number of result cache lookups for cached task types, by result (hit or miss).",
+	},
+	[]string{"result"},
+)
+
+var blobOps = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "blob_operations_total",
+		Help:      "Number of blob store operations for offloaded payloads, by operation (put, get, or delete) and result (ok or error).",
+	},
+	[]string{"operation", "result"},
+)
+
+var blobBytes = promauto.NewCounter(
+	prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "blob_offloaded_bytes_total",
+		Help:      "Number of bytes of task parameters offloaded to the blob store.",
+	},
+)
+
+var tenantQueueWait = promauto.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "tenant_queue_wait_seconds",
+		Help:      "Time tasks waited in their tenant queue before the fair scheduler moved them to a common queue, by tenant.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 14),
+	},
+	[]string{"tenant"},
+)
+
+// Tenant reported in the tenant metrics. Tenants come from the task requests, so only the default
+// tenant and the tenants with a configured weight get their own series, and the rest are reported
+// as "other".
+func tenantMetricLabel(tenant string) string {
+	if _, ok := currentConfig().Scheduling.TenantWeights[tenant]; ok || tenant == defaultTenant {
+		return tenant
+	}
+	return otherTenantsLabel
+}
+
+// Check whether an error was caused by a timeout.
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &ne) && ne.Timeout())
+}
+
+// Record a failed Redis operation. Missing keys are not counted as errors.
+func observeRedisError(op string, err error) {
+	if err == nil || errors.Is(err, redis.Nil) {
+		return
+	}
+	kind := "error"
+	if isTimeout(err) {
+		kind = "timeout"
+	}
+	redisErrors.WithLabelValues(op, kind).Inc()
+}
+
+// Record the duration and status of a synchronous task run.
+func observeRunTask(start time.Time, err error) {
+	status := runStatusOK
+	if err != nil {
+		status = runStatusError
+		if isTimeout(err) {
+			status = runStatusTimeout
+			runTaskTimeouts.Inc()
+		}
+	}
+	runTaskDuration.WithLabelValues(status).Observe(time.Since(start).Seconds())
+}
+
+// Prometheus collector that reads queue depths and worker availability from Redis at scrape time.
+type clusterCollector struct {
+	rd               *redisClient
+	queueDepth       *prometheus.Desc
+	tenantQueueDepth *prometheus.Desc
+	availableCount   *prometheus.Desc
+}
+
+func newClusterCollector(rd *redisClient) *clusterCollector {
+	return &clusterCollector{
+		rd: rd,
+		queueDepth: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "", "queue_depth"),
+			"Number of tasks waiting in each worker queue. Common queues are reported as 'all' and 'pool:<name>'.",
+			[]string{"queue"},
+			nil,
+		),
+		tenantQueueDepth: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "", "tenant_queue_depth"),
+			"Number of tasks waiting in tenant queues for each common queue, by tenant.",
+			[]string{"queue", "tenant"},
+			nil,
+		),
+		availableCount: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "", "available_workers"),
+			"Number of workers currently available to take tasks.",
+			nil,
+			nil,
+		),
+	}
+}
+
+func (cc *clusterCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cc.queueDepth
+	ch <- cc.tenantQueueDepth
+	ch <- cc.availableCount
+}
+
+func (cc *clusterCollector) Collect(ch chan<- prometheus.Metric) {
+	c := context.Background()
+	av, err := cc.rd.availableWorkers(c, false)
+	if err == nil {
+		ch <- prometheus.MustNewConstMetric(cc.availableCount, prometheus.GaugeValue, float64(len(av)))
+	}
+
+	running, err := cc.rd.runningWorkers(c)
+	if err != nil {
+		return
+	}
+	if len(running) > queueDepthScrapeWorkers {
+		slog.Warn("Too many workers to report queue depths", "workers", len(running))
+		running = running[:queueDepthScrapeWorkers]
+	}
+	queues, err := commonQueues(cc.rd, c, running)
+	if err != nil {
+		slog.Error("Unable to get worker pools!", "error", err)
+		return
+	}
+	common := len(queues)
+	queues = append(queues, running...)
+
+	ctx, cancel := context.WithTimeout(c, opTimeout())
+	defer cancel()
+	pipe := cc.rd.Pipeline()
+	lens := make([]*redis.IntCmd, len(queues))
+	for i, q := range queues {
+		lens[i] = pipe.LLen(ctx, cc.rd.keys.queue(q))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		observeRedisError("queue_depth", err)
+		slog.Error("Unable to get queue depths!", "error", err)
+		return
+	}
+	for i, q := range queues {
+		ch <- prometheus.MustNewConstMetric(cc.queueDepth, prometheus.GaugeValue, float64(lens[i].Val()), string(q))
+	}
+
+	backlogs, err := tenantBacklogs(cc.rd, c, queues[:common])
+	if err != nil {
+		observeRedisError("queue_depth", err)
+		slog.Error("Unable to get tenant queue depths!", "error", err)
+		return
+	}
+	for q, tenants := range backlogs {
+		byLabel := map[string]int64{}
+		for t, n := range tenants {
+			byLabel[tenantMetricLabel(t)] += n
+		}
+		for t, n := range byLabel {
+			ch <- prometheus.MustNewConstMetric(cc.tenantQueueDepth, prometheus.GaugeValue, float64(n), string(q), t)
+		}
+	}
+}
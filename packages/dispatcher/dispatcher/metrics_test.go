@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// Test that routing increments the dispatch counter for the matching outcome
+func TestDispatchOutcomeMetrics(t *testing.T) {
+	r, c := mockRedis(true)
+	defer r.Close()
+
+	cases := map[string]string{
+		"label-1": outcomeLabelHit,
+		"label-9": outcomeCapacityWorker,
+	}
+	for label, outcome := range cases {
+		before := testutil.ToFloat64(dispatchCount.WithLabelValues(outcome))
+		tr := taskRequest{TaskID: "metrics-task", Label: label, TaskType: "test-task"}
+		if _, err := selectWorkerQueue(&tr, r, c); err != nil {
+			t.Fatalf("Error selecting worker queue: %v", err)
+		}
+		after := testutil.ToFloat64(dispatchCount.WithLabelValues(outcome))
+		if after-before != 1 {
+			t.Errorf("Expected %s counter to increase by 1, got %f", outcome, after-before)
+		}
+	}
+
+	// No workers at all: falls back to the common queue
+	empty, c := mockRedis(false)
+	defer empty.Close()
+	before := testutil.ToFloat64(dispatchCount.WithLabelValues(outcomeCommonQueue))
+	tr := taskRequest{TaskID: "metrics-task", Label: "label-1", TaskType: "test-task"}
+	if _, err := selectWorkerQueue(&tr, empty, c); err != nil {
+		t.Fatalf("Error selecting worker queue: %v", err)
+	}
+	if testutil.ToFloat64(dispatchCount.WithLabelValues(outcomeCommonQueue))-before != 1 {
+		t.Error("Expected common queue counter to increase by 1")
+	}
+}
+
+// Test that timeouts and other errors are told apart
+func TestObserveRedisError(t *testing.T) {
+	timeouts := redisErrors.WithLabelValues("test_op", "timeout")
+	errs := redisErrors.WithLabelValues("test_op", "error")
+	timeoutsBefore, errsBefore := testutil.ToFloat64(timeouts), testutil.ToFloat64(errs)
+	observeRedisError("test_op", context.DeadlineExceeded)
+	observeRedisError("test_op", errors.New("connection refused"))
+	observeRedisError("test_op", nil)
+
+	if v := testutil.ToFloat64(timeouts) - timeoutsBefore; v != 1 {
+		t.Errorf("Expected 1 timeout, got %f", v)
+	}
+	if v := testutil.ToFloat64(errs) - errsBefore; v != 1 {
+		t.Errorf("Expected 1 error, got %f", v)
+	}
+}
+
+// Test the queue depth and available worker gauges read from Redis
+func TestClusterCollector(t *testing.T) {
+	r, c := mockRedis(true)
+	defer r.Close()
+
+	tr := taskRequest{TaskID: "queued", Label: "label-1", TaskType: "test-task"}
+	for range 3 {
+		if err := workerId("work1").sendTask(&tr, r, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(newClusterCollector(r))
+	exp := `
+# HELP dispatcher_available_workers Number of workers currently available to take tasks.
+# TYPE dispatcher_available_workers gauge
+dispatcher_available_workers 2
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(exp), "dispatcher_available_workers"); err != nil {
+		t.Error(err)
+	}
+
+	exp = `
+# HELP dispatcher_queue_depth Number of tasks waiting in each worker queue. Common queues are reported as 'all' and 'pool:<name>'.
+# TYPE dispatcher_queue_depth gauge
+dispatcher_queue_depth{queue="all"} 0
+dispatcher_queue_depth{queue="u-work1"} 0
+dispatcher_queue_depth{queue="u-work2"} 0
+dispatcher_queue_depth{queue="work1"} 3
+dispatcher_queue_depth{queue="work2"} 0
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(exp), "dispatcher_queue_depth"); err != nil {
+		t.Error(err)
+	}
+}
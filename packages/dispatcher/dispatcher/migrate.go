@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+)
+
+// Prefix of the keys before they were namespaced and hash-tagged for Redis Cluster.
+const legacyKeyPrefix = "task-runners:"
+
+// Rename the keys of the legacy layout into the client's namespace, keeping their TTLs. Keys that
+// already exist in the namespace are left alone, along with their legacy copy. Returns the number of
+// keys renamed and skipped. Renaming moves keys between slots, so it has to run before moving to a
+// cluster.
+func migrateKeys(r *redisClient, c context.Context) (renamed int, skipped int, err error) {
+	keys, err := scanKeys(r, c, legacyKeyPrefix+"*")
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, k := range keys {
+		ctx, cancel := context.WithTimeout(c, opTimeout())
+		ok, err := r.RenameNX(ctx, k, r.keys.prefix+strings.TrimPrefix(k, legacyKeyPrefix)).Result()
+		cancel()
+		if err != nil {
+			observeRedisError("migrate_keys", err)
+			slog.Error("Unable to migrate key!", "error", err, "key", k)
+			return renamed, skipped, err
+		}
+		if ok {
+			renamed++
+		} else {
+			skipped++
+			slog.Warn("Key exists in the namespace, leaving the legacy key", "key", k)
+		}
+	}
+	slog.Info("Migrated keys to the namespaced layout", "namespace", r.keys.prefix, "renamed", renamed, "skipped", skipped)
+	return renamed, skipped, nil
+}
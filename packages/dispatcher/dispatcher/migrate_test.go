@@ -0,0 +1,105 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis Cluster slot of a key, honoring hash tags.
+func keySlot(key string) int {
+	if i := strings.IndexByte(key, '{'); i >= 0 {
+		if j := strings.IndexByte(key[i+1:], '}'); j > 0 {
+			key = key[i+1 : i+1+j]
+		}
+	}
+	crc := uint16(0)
+	for _, b := range []byte(key) {
+		crc ^= uint16(b) << 8
+		for range 8 {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return int(crc) % 16384
+}
+
+// Test that every key the dispatcher uses hashes to the same cluster slot
+func TestKeysShareSlot(t *testing.T) {
+	k := newKeyspace(defaultNamespace)
+	wid := workerId("w1")
+	keys := []string{
+		k.available(), k.running(), k.draining(), k.labelCounts(), k.lastActivity(), k.labelSizes(),
+		k.label("model-a"), k.demand(time.Now()), k.queue(wid), k.control(wid), k.info(wid),
+		k.workerLabels(wid), k.queue(poolQueue("")), k.queue(poolQueue("highmem")), k.taskStatus("t1"),
+		k.results("t1"),
+	}
+	slot := keySlot(keys[0])
+	for _, k := range keys {
+		if s := keySlot(k); s != slot {
+			t.Errorf("Expected %s in slot %d, got %d", k, slot, s)
+		}
+	}
+	if keySlot("task-runners:available") == keySlot("task-runners:labels:model-a:workers") {
+		t.Error("Expected the legacy keys to hash to different slots")
+	}
+}
+
+// Test routing and introspection through a cluster client, on a single node cluster
+func TestClusterClient(t *testing.T) {
+	mr := miniredis.RunT(t)
+	r := newNamespacedClient(redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{mr.Addr()}}), defaultNamespace)
+	defer r.Close()
+	c := t.Context()
+	setupTestData(r, c)
+
+	wid, err := selectLabeledQueue(&taskRequest{TaskID: "t", Label: "label-1"}, r, c)
+	if err != nil || wid != "work1" {
+		t.Errorf("Expected work1, got %s %v", wid, err)
+	}
+	labels, err := getLabelsInfo(r, c)
+	if err != nil || len(labels) != 3 {
+		t.Errorf("Expected labels scanned from the cluster, got %v %v", labels, err)
+	}
+}
+
+func TestMigrateKeys(t *testing.T) {
+	mr := miniredis.RunT(t)
+	r := newNamespacedClient(redis.NewClient(&redis.Options{Addr: mr.Addr()}), defaultNamespace)
+	defer r.Close()
+	c := t.Context()
+
+	r.RPush(c, "task-runners:all:jobs", `{"task_id": "t1"}`)
+	r.HSet(c, "task-runners:labels:sizes", "model-a", 1024)
+	r.HSet(c, "task-runners:tasks:t1", "status", "queued")
+	r.Expire(c, "task-runners:tasks:t1", time.Minute)
+	r.SAdd(c, "task-runners:available", "old")
+	r.SAdd(c, r.keys.available(), "new")
+
+	renamed, skipped, err := migrateKeys(r, c)
+	if err != nil || renamed != 3 || skipped != 1 {
+		t.Fatalf("Expected 3 keys renamed and 1 skipped, got %d %d %v", renamed, skipped, err)
+	}
+	if n, _ := r.LLen(c, r.keys.queue(poolQueue(""))).Result(); n != 1 {
+		t.Errorf("Expected the queued task in the new common queue, got %d", n)
+	}
+	if sizes, _ := labelSizes(r, c, []string{"model-a"}); sizes["model-a"] != 1024 {
+		t.Errorf("Expected the label size to be migrated, got %v", sizes)
+	}
+	if ttl := mr.TTL(r.keys.taskStatus("t1")); ttl != time.Minute {
+		t.Errorf("Expected the task status TTL to be kept, got %v", ttl)
+	}
+	if av, _ := r.SMembers(c, r.keys.available()).Result(); !slices.Equal(av, []string{"new"}) {
+		t.Errorf("Expected existing keys in the new layout to be kept, got %v", av)
+	}
+	if keys, _ := scanKeys(r, c, legacyKeyPrefix+"*"); !slices.Equal(keys, []string{"task-runners:available"}) {
+		t.Errorf("Expected only the conflicting legacy key to remain, got %v", keys)
+	}
+}
@@ -0,0 +1,715 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Broker on NATS JetStream, for environments that run NATS rather than Redis. Tasks are published
+// on a subject per queue, "<namespace>.tasks.<queue>", captured by a work-queue stream, so each
+// task is delivered once and waits in the stream until a worker takes it, like in a Redis list.
+// Every worker and common queue has its own durable consumer, and the workers of a pool share the
+// consumer of its common queue. The worker registry lives in KV buckets: one entry per worker with
+// its state, resources, and labels in eviction order, one key per label holder for label
+// membership, plus the label sizes and the demand buckets. A bucket maps the parameter blobs of
+// queued tasks to their stream sequence, so the blob sweeper keeps them until a worker takes the
+// task. The dispatcher keeps a copy of the workers bucket, updated by a watcher, so routing reads
+// no keys per worker. Results are published on "<namespace>.results.<task>" like on the Redis
+// result channels, so only a dispatcher already waiting receives them.
+//
+// Worker IDs, queues, labels, and task IDs are base64url-encoded in subjects and keys, since NATS
+// reserves '.', '*', '>' and whitespace. The namespace is used as is, except that dots become
+// underscores in stream and bucket names.
+type natsBroker struct {
+	nc      *nats.Conn
+	js      jetstream.JetStream
+	prefix  string
+	stream  string
+	workers jetstream.KeyValue
+	labels  jetstream.KeyValue
+	sizes   jetstream.KeyValue
+	demand  jetstream.KeyValue
+	params  jetstream.KeyValue
+
+	mu        sync.Mutex
+	consumers map[workerId]jetstream.Consumer
+
+	// Copy of the workers bucket, by worker ID. Deleted workers stay as entries without a worker, so
+	// that older updates arriving late cannot bring them back.
+	regMu sync.RWMutex
+	reg   map[workerId]cachedWorker
+}
+
+// A worker entry as of a revision of the workers bucket. The worker is nil once deleted.
+type cachedWorker struct {
+	rev    uint64
+	worker *natsWorker
+}
+
+// State of a worker in the workers bucket.
+type natsWorker struct {
+	Available bool              `json:"available"`
+	Draining  bool              `json:"draining"`
+	MaxLabels int               `json:"max_labels,omitempty"`
+	Budget    int64             `json:"memory_budget_bytes,omitempty"`
+	Attrs     map[string]string `json:"attributes,omitempty"`
+	// Labels held by the worker, least recently loaded first
+	Labels []string `json:"labels"`
+}
+
+// Tasks are taken by polling the consumers of the queues in order, like BLPOP over several lists.
+const natsPollInterval = 50 * time.Millisecond
+
+// Connect the broker, creating the stream and the KV buckets of the namespace if they are missing.
+func newNATSBroker(c context.Context, nc *nats.Conn, namespace string) (*natsBroker, error) {
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.ReplaceAll(namespace, ".", "_")
+	b := &natsBroker{
+		nc:        nc,
+		js:        js,
+		prefix:    name + ".",
+		stream:    name + "_tasks",
+		consumers: map[workerId]jetstream.Consumer{},
+		reg:       map[workerId]cachedWorker{},
+	}
+	_, err = js.CreateOrUpdateStream(c, jetstream.StreamConfig{
+		Name:      b.stream,
+		Subjects:  []string{b.prefix + "tasks.>"},
+		Retention: jetstream.WorkQueuePolicy,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	window := time.Duration(currentConfig().Routing.DemandWindowSeconds+demandBucketSeconds) * time.Second
+	for _, kv := range []struct {
+		bucket *jetstream.KeyValue
+		name   string
+		ttl    time.Duration
+	}{
+		{&b.workers, "workers", 0},
+		{&b.labels, "labels", 0},
+		{&b.sizes, "label-sizes", 0},
+		{&b.demand, "demand", window},
+		{&b.params, "queued-parameters", 0},
+	} {
+		*kv.bucket, err = js.CreateOrUpdateKeyValue(c, jetstream.KeyValueConfig{Bucket: name + "_" + kv.name, TTL: kv.ttl})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := b.watchWorkers(c); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Load the workers bucket, then keep the copy of it up to date until the context is done.
+func (b *natsBroker) watchWorkers(c context.Context) error {
+	watcher, err := b.workers.WatchAll(c)
+	if err != nil {
+		return err
+	}
+	// The watcher sends the current entries, then nil, then the updates
+	for entry := range watcher.Updates() {
+		if entry == nil {
+			break
+		}
+		b.applyWorkerEntry(entry)
+	}
+	go func() {
+		defer watcher.Stop()
+		for entry := range watcher.Updates() {
+			if entry != nil {
+				b.applyWorkerEntry(entry)
+			}
+		}
+	}()
+	return nil
+}
+
+func (b *natsBroker) applyWorkerEntry(entry jetstream.KeyValueEntry) {
+	wid := workerId(natsFromToken(entry.Key()))
+	if entry.Operation() != jetstream.KeyValuePut {
+		b.cacheWorker(wid, entry.Revision(), nil)
+		return
+	}
+	var w natsWorker
+	if err := json.Unmarshal(entry.Value(), &w); err != nil {
+		slog.Error("Unable to read worker!", "error", err, "worker", wid)
+		return
+	}
+	b.cacheWorker(wid, entry.Revision(), &w)
+}
+
+// Store a worker entry in the copy of the registry, unless a later revision is already there.
+func (b *natsBroker) cacheWorker(wid workerId, rev uint64, w *natsWorker) {
+	b.regMu.Lock()
+	defer b.regMu.Unlock()
+	if cached, ok := b.reg[wid]; ok && cached.rev >= rev {
+		return
+	}
+	b.reg[wid] = cachedWorker{rev: rev, worker: w}
+}
+
+// Drop a deleted worker from the copy of the registry, before the watcher reports the deletion.
+func (b *natsBroker) uncacheWorker(wid workerId) {
+	b.regMu.Lock()
+	defer b.regMu.Unlock()
+	cached := b.reg[wid]
+	b.reg[wid] = cachedWorker{rev: cached.rev, worker: nil}
+}
+
+// Encode a name as a single subject token or key segment.
+func natsToken(s string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+func natsFromToken(tok string) string {
+	s, err := base64.RawURLEncoding.DecodeString(tok)
+	if err != nil {
+		return tok
+	}
+	return string(s)
+}
+
+func (b *natsBroker) taskSubject(wid workerId) string {
+	return b.prefix + "tasks." + natsToken(string(wid))
+}
+
+func (b *natsBroker) resultSubject(taskID string) string {
+	return b.prefix + "results." + natsToken(taskID)
+}
+
+// Key of a label holder in the labels bucket.
+func holderKey(label string, wid workerId) string {
+	return natsToken(label) + "." + natsToken(string(wid))
+}
+
+func demandKey(label string, bucket int64) string {
+	return natsToken(label) + "." + strconv.FormatInt(bucket, 10)
+}
+
+// Keys of a bucket that match the filter, or all keys without one.
+func listKeys(c context.Context, kv jetstream.KeyValue, filter string) ([]string, error) {
+	if filter == "" {
+		filter = ">"
+	}
+	lister, err := kv.ListKeysFiltered(c, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer lister.Stop()
+	keys := []string{}
+	for k := range lister.Keys() {
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// Read the values of the given keys with one filtered watch. Keys that do not exist are left out.
+func getKeys(c context.Context, kv jetstream.KeyValue, keys []string) (map[string][]byte, error) {
+	values := map[string][]byte{}
+	if len(keys) == 0 {
+		return values, nil
+	}
+	watcher, err := kv.WatchFiltered(c, keys, jetstream.IgnoreDeletes())
+	if err != nil {
+		return nil, err
+	}
+	defer watcher.Stop()
+	for {
+		select {
+		case entry := <-watcher.Updates():
+			// The watcher sends nil once the current values were delivered
+			if entry == nil {
+				return values, nil
+			}
+			values[entry.Key()] = entry.Value()
+		case <-c.Done():
+			return nil, c.Err()
+		}
+	}
+}
+
+// Update a key with compare-and-set, retrying when another writer changed it first. The update
+// function receives nil when the key does not exist. Returns the revision written.
+func casUpdate(c context.Context, kv jetstream.KeyValue, key string, update func([]byte) ([]byte, error)) (uint64, error) {
+	for {
+		var current []byte
+		var rev uint64
+		entry, err := kv.Get(c, key)
+		switch {
+		case err == nil:
+			current, rev = entry.Value(), entry.Revision()
+		case !errors.Is(err, jetstream.ErrKeyNotFound):
+			return 0, err
+		}
+		next, err := update(current)
+		if err != nil {
+			return 0, err
+		}
+		if rev == 0 {
+			rev, err = kv.Create(c, key, next)
+		} else {
+			rev, err = kv.Update(c, key, next, rev)
+		}
+		if err == nil || !errors.Is(err, jetstream.ErrKeyExists) && !isWrongSequence(err) {
+			return rev, err
+		}
+	}
+}
+
+// Whether an update failed because the key changed since it was read.
+func isWrongSequence(err error) bool {
+	var apiErr *jetstream.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode == jetstream.JSErrCodeStreamWrongLastSequence
+}
+
+// Worker entry from the copy of the registry. Returns nil if the worker is not registered. Entries
+// are shared, so callers must not change them.
+func (b *natsBroker) worker(wid workerId) *natsWorker {
+	b.regMu.RLock()
+	defer b.regMu.RUnlock()
+	return b.reg[wid].worker
+}
+
+// Change a worker entry, creating it if the worker is not registered.
+func (b *natsBroker) updateWorker(c context.Context, wid workerId, update func(*natsWorker)) error {
+	ctx, cancel := context.WithTimeout(c, opTimeout())
+	defer cancel()
+	var w natsWorker
+	rev, err := casUpdate(ctx, b.workers, natsToken(string(wid)), func(raw []byte) ([]byte, error) {
+		w = natsWorker{Labels: []string{}}
+		if raw != nil {
+			if err := json.Unmarshal(raw, &w); err != nil {
+				return nil, err
+			}
+		}
+		update(&w)
+		return json.Marshal(&w)
+	})
+	if err != nil {
+		return err
+	}
+	b.cacheWorker(wid, rev, &w)
+	return nil
+}
+
+// All registered workers and their entries, from the copy of the registry.
+func (b *natsBroker) registry() map[workerId]*natsWorker {
+	b.regMu.RLock()
+	defer b.regMu.RUnlock()
+	out := make(map[workerId]*natsWorker, len(b.reg))
+	for wid, cached := range b.reg {
+		if cached.worker != nil {
+			out[wid] = cached.worker
+		}
+	}
+	return out
+}
+
+// Register a running and available worker with its capacity and attributes.
+func (b *natsBroker) registerWorker(c context.Context, wid workerId, res workerResources) error {
+	return b.updateWorker(c, wid, func(w *natsWorker) {
+		w.Available = true
+		w.MaxLabels, w.Budget, w.Attrs = res.MaxLabels, res.MemoryBudget, res.Attributes
+	})
+}
+
+// Remove a worker and release its labels. Tasks left in its queue stay in the stream.
+func (b *natsBroker) deregisterWorker(c context.Context, wid workerId) error {
+	ctx, cancel := context.WithTimeout(c, opTimeout())
+	defer cancel()
+	w := b.worker(wid)
+	if w == nil {
+		return nil
+	}
+	for _, l := range w.Labels {
+		if err := b.labels.Delete(ctx, holderKey(l, wid)); err != nil {
+			return err
+		}
+	}
+	if err := b.workers.Delete(ctx, natsToken(string(wid))); err != nil {
+		return err
+	}
+	b.uncacheWorker(wid)
+	return nil
+}
+
+func (b *natsBroker) setAvailable(c context.Context, wid workerId, on bool) error {
+	return b.updateWorker(c, wid, func(w *natsWorker) { w.Available = on })
+}
+
+func (b *natsBroker) setDraining(c context.Context, wid workerId, on bool) error {
+	return b.updateWorker(c, wid, func(w *natsWorker) { w.Draining = on })
+}
+
+// Register a label for a worker, or mark it as the most recently loaded if the worker holds it.
+func (b *natsBroker) addLabel(c context.Context, wid workerId, label string) error {
+	err := b.updateWorker(c, wid, func(w *natsWorker) {
+		w.Labels = append(slices.DeleteFunc(w.Labels, func(l string) bool { return l == label }), label)
+	})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(c, opTimeout())
+	defer cancel()
+	_, err = b.labels.Put(ctx, holderKey(label, wid), nil)
+	return err
+}
+
+func (b *natsBroker) removeLabel(c context.Context, wid workerId, label string) error {
+	err := b.updateWorker(c, wid, func(w *natsWorker) {
+		w.Labels = slices.DeleteFunc(w.Labels, func(l string) bool { return l == label })
+	})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(c, opTimeout())
+	defer cancel()
+	return b.labels.Delete(ctx, holderKey(label, wid))
+}
+
+// Set the size of a label in the label registry, or remove it if size is zero.
+func (b *natsBroker) setLabelSize(c context.Context, label string, size int64) error {
+	ctx, cancel := context.WithTimeout(c, opTimeout())
+	defer cancel()
+	if size <= 0 {
+		return b.sizes.Delete(ctx, natsToken(label))
+	}
+	_, err := b.sizes.Put(ctx, natsToken(label), []byte(strconv.FormatInt(size, 10)))
+	return err
+}
+
+// Durable consumer of a queue, shared by every worker that reads it.
+func (b *natsBroker) consumer(c context.Context, queue workerId) (jetstream.Consumer, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if cons, ok := b.consumers[queue]; ok {
+		return cons, nil
+	}
+	cons, err := b.js.CreateOrUpdateConsumer(c, b.stream, jetstream.ConsumerConfig{
+		Durable:       "queue-" + natsToken(string(queue)),
+		FilterSubject: b.taskSubject(queue),
+		AckPolicy:     jetstream.AckExplicitPolicy,
+	})
+	if err != nil {
+		return nil, err
+	}
+	b.consumers[queue] = cons
+	return cons, nil
+}
+
+// Wait for the next task in the given queues, taking them in order like BLPOP. Returns the queue the
+// task was taken from, or the context error once it is done.
+func (b *natsBroker) popTask(c context.Context, queues ...workerId) (workerId, *taskRequest, error) {
+	for {
+		for _, q := range queues {
+			cons, err := b.consumer(c, q)
+			if err != nil {
+				return q, nil, err
+			}
+			batch, err := cons.FetchNoWait(1)
+			if err != nil {
+				return q, nil, err
+			}
+			for msg := range batch.Messages() {
+				if err := msg.Ack(); err != nil {
+					return q, nil, err
+				}
+				var t taskRequest
+				if err := json.Unmarshal(msg.Data(), &t); err != nil {
+					return q, nil, err
+				}
+				return q, &t, nil
+			}
+			if err := batch.Error(); err != nil && !errors.Is(err, nats.ErrTimeout) {
+				return q, nil, err
+			}
+		}
+
+		select {
+		case <-time.After(natsPollInterval):
+		case <-c.Done():
+			return "", nil, c.Err()
+		}
+	}
+}
+
+// Send the result of a task back to the dispatcher waiting for it.
+func (b *natsBroker) publishResult(taskID, result string) error {
+	return b.nc.Publish(b.resultSubject(taskID), []byte(result))
+}
+
+func (b *natsBroker) availableWorkers(_ context.Context, sorted bool) (workerIds, error) {
+	out := workerIds{}
+	for wid, w := range b.registry() {
+		if w.Available && !w.Draining {
+			out = append(out, wid)
+		}
+	}
+	if sorted {
+		slices.Sort(out)
+	}
+	return out, nil
+}
+
+func (b *natsBroker) availableWorkersLabel(c context.Context, labels ...string) (workerIds, error) {
+	av, err := b.availableWorkers(c, false)
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range labels {
+		holders, err := b.workersWithLabel(c, l)
+		if err != nil {
+			return nil, err
+		}
+		av = slices.DeleteFunc(av, func(w workerId) bool { return !slices.Contains(holders, w) })
+	}
+	return av, nil
+}
+
+func (b *natsBroker) runningWorkers(_ context.Context) (workerIds, error) {
+	out := workerIds{}
+	for wid := range b.registry() {
+		out = append(out, wid)
+	}
+	return out, nil
+}
+
+func (b *natsBroker) activeWorkers(_ context.Context) (workerIds, error) {
+	out := workerIds{}
+	for wid, w := range b.registry() {
+		if !w.Draining {
+			out = append(out, wid)
+		}
+	}
+	return out, nil
+}
+
+func (b *natsBroker) workersWithLabel(c context.Context, label string) (workerIds, error) {
+	ctx, cancel := context.WithTimeout(c, opTimeout())
+	defer cancel()
+	keys, err := listKeys(ctx, b.labels, natsToken(label)+".*")
+	if err != nil {
+		slog.Error("Unable to list label holders!", "error", err, "label", label)
+		return nil, err
+	}
+	out := make(workerIds, len(keys))
+	for i, k := range keys {
+		_, wid, _ := strings.Cut(k, ".")
+		out[i] = workerId(natsFromToken(wid))
+	}
+	return out, nil
+}
+
+func (b *natsBroker) isAvailable(_ context.Context, wid workerId) (bool, error) {
+	w := b.worker(wid)
+	return w != nil && w.Available, nil
+}
+
+// Resources of a worker entry, with the configured defaults for workers that did not publish any.
+func (w *natsWorker) resources() workerResources {
+	res := workerResources{
+		MaxLabels:    currentConfig().Routing.MaxLabelsPerWorker,
+		MemoryBudget: w.Budget,
+		Attributes:   map[string]string{},
+	}
+	if w.MaxLabels > 0 {
+		res.MaxLabels = w.MaxLabels
+	}
+	for k, v := range w.Attrs {
+		res.Attributes[k] = v
+	}
+	return res
+}
+
+func (b *natsBroker) loadWorkerResources(_ context.Context, wids workerIds) ([]workerResources, error) {
+	out := make([]workerResources, len(wids))
+	for i, wid := range wids {
+		w := b.worker(wid)
+		if w == nil {
+			w = &natsWorker{}
+		}
+		out[i] = w.resources()
+	}
+	return out, nil
+}
+
+func (b *natsBroker) loadCapacities(c context.Context, wids workerIds, requested ...string) ([]workerCapacity, error) {
+	sizes := map[string]int64{}
+	out := make([]workerCapacity, len(wids))
+	for i, wid := range wids {
+		w := b.worker(wid)
+		if w == nil {
+			w = &natsWorker{Labels: []string{}}
+		}
+		out[i] = workerCapacity{
+			workerResources: w.resources(),
+			ID:              wid,
+			Labels:          slices.Clone(w.Labels),
+			LabelCount:      len(w.Labels),
+			Holds:           []string{},
+			Sizes:           sizes,
+		}
+		for _, l := range requested {
+			if slices.Contains(w.Labels, l) {
+				out[i].Holds = append(out[i].Holds, l)
+			}
+		}
+		for _, l := range w.Labels {
+			sizes[l] = 0
+		}
+	}
+	for _, l := range requested {
+		sizes[l] = 0
+	}
+
+	ctx, cancel := context.WithTimeout(c, opTimeout())
+	defer cancel()
+	keys := make([]string, 0, len(sizes))
+	for l := range sizes {
+		keys = append(keys, natsToken(l))
+	}
+	values, err := getKeys(ctx, b.sizes, keys)
+	if err != nil {
+		return nil, err
+	}
+	for l := range sizes {
+		sizes[l], _ = strconv.ParseInt(string(values[natsToken(l)]), 10, 64)
+	}
+	return out, nil
+}
+
+func (b *natsBroker) recordDemand(c context.Context, labels ...string) {
+	ctx, cancel := context.WithTimeout(c, opTimeout())
+	defer cancel()
+	bucket := time.Now().Unix() / demandBucketSeconds
+	for _, l := range labels {
+		_, err := casUpdate(ctx, b.demand, demandKey(l, bucket), func(raw []byte) ([]byte, error) {
+			n, _ := strconv.ParseInt(string(raw), 10, 64)
+			return []byte(strconv.FormatInt(n+1, 10)), nil
+		})
+		if err != nil {
+			slog.Warn("Unable to record label demand", "error", err, "labels", labels)
+			return
+		}
+	}
+}
+
+func (b *natsBroker) loadEvictionStats(c context.Context, labels []string) (evictionStats, error) {
+	ctx, cancel := context.WithTimeout(c, opTimeout())
+	defer cancel()
+	stats := evictionStats{Demand: map[string]int64{}, Holders: map[string]int64{}}
+	bucket := time.Now().Unix() / demandBucketSeconds
+	buckets := int64(currentConfig().Routing.DemandWindowSeconds / demandBucketSeconds)
+	for _, l := range labels {
+		for i := bucket - buckets + 1; i <= bucket; i++ {
+			entry, err := b.demand.Get(ctx, demandKey(l, i))
+			switch {
+			case err == nil:
+				n, _ := strconv.ParseInt(string(entry.Value()), 10, 64)
+				stats.Demand[l] += n
+			case !errors.Is(err, jetstream.ErrKeyNotFound):
+				return stats, err
+			}
+		}
+		holders, err := b.workersWithLabel(ctx, l)
+		if err != nil {
+			return stats, err
+		}
+		stats.Holders[l] = int64(len(holders))
+	}
+	return stats, nil
+}
+
+// Publish a serialized task on its queue's subject. Task statuses are only recorded on Redis.
+func (b *natsBroker) enqueue(c context.Context, wid workerId, t *taskRequest, raw []byte) error {
+	ctx, cancel := context.WithTimeout(c, opTimeout())
+	defer cancel()
+	ack, err := b.js.Publish(ctx, b.taskSubject(wid), raw)
+	if err != nil {
+		slog.Error("Unable to send task!", "error", err, "task_id", t.TaskID)
+		return err
+	}
+	if t.ParametersRef != "" {
+		// The task is already queued, so a failure only lets the sweeper delete its parameters early
+		if _, err := b.params.Put(ctx, natsToken(t.ParametersRef), []byte(strconv.FormatUint(ack.Sequence, 10))); err != nil {
+			slog.Warn("Unable to track the parameters of the task", "error", err, "task_id", t.TaskID)
+		}
+	}
+	return nil
+}
+
+// Which of the given blobs hold the parameters of tasks still in the stream. Tasks leave the stream
+// once a worker acknowledges them, and their blobs are then no longer tracked.
+func (b *natsBroker) queuedParameters(c context.Context, keys []string) (map[string]bool, error) {
+	ctx, cancel := context.WithTimeout(c, opTimeout())
+	defer cancel()
+	lister, err := b.params.ListKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tracked := map[string]bool{}
+	for tok := range lister.Keys() {
+		tracked[natsFromToken(tok)] = true
+	}
+	stream, err := b.js.Stream(ctx, b.stream)
+	if err != nil {
+		return nil, err
+	}
+	queued := map[string]bool{}
+	for _, key := range keys {
+		if !tracked[key] {
+			continue
+		}
+		entry, err := b.params.Get(ctx, natsToken(key))
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		seq, _ := strconv.ParseUint(string(entry.Value()), 10, 64)
+		_, err = stream.GetMsg(ctx, seq)
+		if err == nil {
+			queued[key] = true
+			continue
+		}
+		if !errors.Is(err, jetstream.ErrMsgNotFound) {
+			return nil, err
+		}
+		if err := b.params.Delete(ctx, natsToken(key)); err != nil {
+			return nil, err
+		}
+	}
+	return queued, nil
+}
+
+func (b *natsBroker) receiveResult(c context.Context, taskID string) (string, error) {
+	sub, err := b.nc.SubscribeSync(b.resultSubject(taskID))
+	if err != nil {
+		return "", err
+	}
+	defer sub.Unsubscribe()
+	m, err := sub.NextMsgWithContext(c)
+	if err != nil {
+		return "", err
+	}
+	return string(m.Data), nil
+}
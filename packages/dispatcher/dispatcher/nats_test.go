@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"taskrunner"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+// Start an embedded NATS server with JetStream, and connect a broker to it in the given namespace.
+func mockNATS(t *testing.T, namespace string) (*natsBroker, *server.Server) {
+	t.Helper()
+	srv, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, JetStream: true, StoreDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Start()
+	t.Cleanup(srv.Shutdown)
+	if !srv.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server did not start")
+	}
+	return connectNATS(t, srv, namespace), srv
+}
+
+func connectNATS(t *testing.T, srv *server.Server, namespace string) *natsBroker {
+	t.Helper()
+	nc, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+	b, err := newNATSBroker(t.Context(), nc, namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// Fail the test on errors from the worker side of the broker.
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Test that routing makes the same decisions on NATS as on Redis
+func TestNATSBrokerParity(t *testing.T) {
+	b, _ := mockNATS(t, defaultNamespace)
+	c := t.Context()
+	checkRoutingParity(t, parityBackend{
+		broker: b,
+		register: func(w testWorker, res workerResources) {
+			must(t, b.registerWorker(c, w.id, res))
+			for _, l := range w.labels {
+				must(t, b.addLabel(c, w.id, l))
+			}
+		},
+		setLabelSize: func(label string, size int64) { must(t, b.setLabelSize(c, label, size)) },
+		setDraining:  func(wid workerId) { must(t, b.setDraining(c, wid, true)) },
+		setBusy:      func(wid workerId) { must(t, b.setAvailable(c, wid, false)) },
+	})
+}
+
+// Test the worker registry: label order, membership, and deregistration
+func TestNATSBrokerRegistry(t *testing.T) {
+	b, _ := mockNATS(t, defaultNamespace)
+	c := t.Context()
+	// IDs and labels with characters NATS reserves in subjects and keys
+	must(t, b.registerWorker(c, "host.1", workerResources{MaxLabels: 3}))
+	must(t, b.registerWorker(c, "host 2", workerResources{}))
+	for _, l := range []string{"org/model:v1", "b", "org/model:v1"} {
+		must(t, b.addLabel(c, "host.1", l))
+	}
+	must(t, b.addLabel(c, "host 2", "b"))
+	must(t, b.setLabelSize(c, "org/model:v1", 4*gb))
+
+	caps, err := b.loadCapacities(c, workerIds{"host.1", "host 2"}, "org/model:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(caps[0].Labels, []string{"b", "org/model:v1"}) || caps[0].MaxLabels != 3 {
+		t.Errorf("Expected the reloaded label last and the published limit, got %+v", caps[0])
+	}
+	if !slices.Equal(caps[0].Holds, []string{"org/model:v1"}) {
+		t.Errorf("Expected host.1 to hold the requested label, got %v", caps[0].Holds)
+	}
+	if sizes := caps[0].Sizes; len(sizes) != 2 || sizes["org/model:v1"] != 4*gb || sizes["b"] != 0 {
+		t.Errorf("Expected the published size and no size for b, got %v", sizes)
+	}
+	if caps[1].MaxLabels != defaultMaxLabelsPerWorker || len(caps[1].Holds) != 0 {
+		t.Errorf("Expected the default limit and no requested label, got %+v", caps[1])
+	}
+	if av, _ := b.availableWorkersLabel(c, "b", "org/model:v1"); !slices.Equal(av, workerIds{"host.1"}) {
+		t.Errorf("Expected only host.1 to hold both labels, got %v", av)
+	}
+
+	must(t, b.removeLabel(c, "host.1", "b"))
+	if holders, _ := b.workersWithLabel(c, "b"); !slices.Equal(holders, workerIds{"host 2"}) {
+		t.Errorf("Expected host 2 to be the only holder, got %v", holders)
+	}
+	must(t, b.deregisterWorker(c, "host 2"))
+	if holders, _ := b.workersWithLabel(c, "b"); len(holders) != 0 {
+		t.Errorf("Expected the labels to be released, got %v", holders)
+	}
+	if running, _ := b.runningWorkers(c); !slices.Equal(running, workerIds{"host.1"}) {
+		t.Errorf("Expected host.1 to be the only worker, got %v", running)
+	}
+}
+
+// Test running a task end to end with an in-process worker on NATS
+func TestNATSBrokerRunTask(t *testing.T) {
+	b, srv := mockNATS(t, defaultNamespace)
+	c, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	must(t, b.registerWorker(c, "w1", workerResources{MaxLabels: 2}))
+	subs := srv.NumSubscriptions()
+
+	// The worker loads the labels of its tasks and echoes their parameters, once the dispatcher waits
+	go func() {
+		for {
+			q, task, err := b.popTask(c, "w1", poolQueue(""))
+			if err != nil {
+				return
+			}
+			for _, l := range task.labels() {
+				b.addLabel(c, "w1", l)
+			}
+			for srv.NumSubscriptions() <= subs {
+				time.Sleep(10 * time.Millisecond)
+			}
+			b.publishResult(task.TaskID, string(q)+":"+task.Parameters)
+		}
+	}()
+
+	task := &taskRequest{TaskID: "t1", Label: "model-a", Parameters: "{}", ReturnResult: true}
+	wid, err := selectWorkerQueue(task, b, c)
+	if err != nil || wid != "w1" {
+		t.Fatalf("Expected w1, got %s %v", wid, err)
+	}
+	result, err := wid.runTask(task, b, c)
+	if err != nil || result != "w1:{}" {
+		t.Errorf("Expected the echoed result, got %q %v", result, err)
+	}
+	if holders, _ := b.workersWithLabel(c, "model-a"); !slices.Equal(holders, workerIds{"w1"}) {
+		t.Errorf("Expected the worker to hold the label, got %v", holders)
+	}
+
+	// Tasks nobody can take wait in the common queue until a worker reads it
+	must(t, b.setAvailable(c, "w1", false))
+	task = &taskRequest{TaskID: "t2", Parameters: "{}", ReturnResult: true}
+	if wid, _ := selectWorkerQueue(task, b, c); wid != poolQueue("") {
+		t.Fatalf("Expected the common queue, got %s", wid)
+	}
+	if result, err := poolQueue("").runTask(task, b, c); err != nil || result != "all:{}" {
+		t.Errorf("Expected the result from the common queue, got %q %v", result, err)
+	}
+
+	cfg := defaultConfig()
+	cfg.Timeouts.TaskSeconds = 1
+	setConfig(cfg)
+	defer setConfig(defaultConfig())
+	if _, err := awaitResult(&taskRequest{TaskID: "lost"}, b, c); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected a timeout, got %v", err)
+	}
+}
+
+// Test that deployments in different namespaces share a NATS server without seeing each other
+func TestNATSBrokerNamespaces(t *testing.T) {
+	staging, srv := mockNATS(t, "staging")
+	prod := connectNATS(t, srv, "prod.eu")
+	c := t.Context()
+	must(t, staging.registerWorker(c, "s1", workerResources{}))
+	must(t, prod.registerWorker(c, "p1", workerResources{}))
+
+	if av, _ := prod.availableWorkers(c, true); !slices.Equal(av, workerIds{"p1"}) {
+		t.Errorf("Expected only the prod worker, got %v", av)
+	}
+	must(t, poolQueue("").sendTask(&taskRequest{TaskID: "t"}, prod, c))
+	ctx, cancel := context.WithTimeout(c, 200*time.Millisecond)
+	defer cancel()
+	if _, _, err := staging.popTask(ctx, poolQueue("")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected no task in the staging common queue, got %v", err)
+	}
+	if _, task, err := prod.popTask(c, poolQueue("")); err != nil || task.TaskID != "t" {
+		t.Errorf("Expected the task in the prod common queue, got %v %v", task, err)
+	}
+}
+
+// Test the HTTP API on the NATS broker: tasks are routed, readiness checks NATS, and the Redis-only
+// endpoints are not served
+func TestNATSRouter(t *testing.T) {
+	b, _ := mockNATS(t, defaultNamespace)
+	c := t.Context()
+	must(t, b.registerWorker(c, "w1", workerResources{}))
+	srv := httptest.NewServer(newRouter(b))
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/send-task", "application/json", strings.NewReader(`{"task_id": "t1", "task_type": "sleep", "label": "a"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("Expected the task to be sent, got %d", resp.StatusCode)
+	}
+	if q, task, err := b.popTask(c, "w1"); err != nil || task.TaskID != "t1" {
+		t.Errorf("Expected the task on the worker queue, got %s %v %v", q, task, err)
+	}
+
+	code, rep := getReadiness(t, func(w http.ResponseWriter, req *http.Request) { readinessAPI(w, req, b) })
+	if code != http.StatusOK || rep.Components["nats"].Status != statusOK || rep.Components["workers"].Status != statusOK {
+		t.Errorf("Expected NATS and workers to be ready, got %d %+v", code, rep)
+	}
+	if _, ok := rep.Components["redis"]; ok {
+		t.Error("Expected no Redis component")
+	}
+
+	resp, err = http.Get(srv.URL + "/workers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected the worker list to need Redis, got %d", resp.StatusCode)
+	}
+}
+
+// Test the dispatcher and a Go runner on NATS: the runner's registration reaches the dispatcher's
+// copy of the registry, and tasks sent to the HTTP API run on it
+func TestNATSRunners(t *testing.T) {
+	b, srv := mockNATS(t, defaultNamespace)
+	nc, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	c, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	rb, err := taskrunner.NewNATSBroker(c, nc, defaultNamespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rn := taskrunner.NewWithBroker(rb, taskrunner.Options{PollTimeout: 100 * time.Millisecond})
+	rn.Handle("echo", func(ctx context.Context, labels *taskrunner.LabelSet, task *taskrunner.Task) (string, error) {
+		if err := labels.AddAll(ctx, task.AllLabels(), nil); err != nil {
+			return "", err
+		}
+		return "echo:" + task.Parameters, nil
+	})
+	done := make(chan error, 1)
+	go func() { done <- rn.Run(c) }()
+
+	wid := workerId(rn.ID())
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if ok, _ := b.isAvailable(c, wid); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the runner to be available")
+		}
+	}
+	api := httptest.NewServer(newRouter(b))
+	defer api.Close()
+	body := `{"task_id": "t1", "task_type": "echo", "label": "model-a", "parameters_json": "{}", "return_result": true}`
+	resp, err := http.Post(api.URL+"/run-task", contentJSON, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out runTaskResponse
+	json.NewDecoder(resp.Body).Decode(&out)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || out.Message != "echo:{}" {
+		t.Errorf("Expected the runner's result, got %d %+v", resp.StatusCode, out)
+	}
+	if holders, _ := b.workersWithLabel(c, "model-a"); !slices.Equal(holders, workerIds{wid}) {
+		t.Errorf("Expected the runner to hold the label, got %v", holders)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if running, _ := b.runningWorkers(t.Context()); len(running) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the runner to leave the registry")
+		}
+	}
+}
+
+// Test that the parameters of tasks in the stream are kept past the blob TTL
+func TestNATSSweepQueuedParameters(t *testing.T) {
+	b, _ := mockNATS(t, defaultNamespace)
+	testSweepQueuedParameters(t, b, func() { b.popTask(t.Context(), poolQueue("")) })
+}
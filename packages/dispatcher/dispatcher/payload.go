@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Content types of task parameters and results, and the compression they may use.
+const (
+	contentJSON    = "application/json"
+	contentMsgpack = "application/msgpack"
+	contentBinary  = "application/octet-stream"
+	contentText    = "text/plain; charset=utf-8"
+	encodingZstd   = "zstd"
+)
+
+// Results published with a content type start with this prefix, followed by
+// "<content type>[;<encoding>]\n" and the result's bytes. Other results are plain text.
+const resultPayloadPrefix = resultEnvelope + "payload:"
+
+var errInvalidPayload = errors.New("invalid payload")
+
+// Upper bound on the size of compressed payloads and request bodies once decompressed. A variable so
+// that tests can lower it.
+var maxDecompressedBytes int64 = 1 << 30
+
+// Shared decoder for compressed payloads held in memory.
+var zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0), zstd.WithDecoderMaxMemory(uint64(maxDecompressedBytes)))
+
+func decompress(encoding string, data []byte) ([]byte, error) {
+	if encoding == "" {
+		return data, nil
+	}
+	out, err := zstdDecoder.DecodeAll(data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidPayload, err)
+	}
+	return out, nil
+}
+
+func checkPayloadType(contentType, encoding string) error {
+	if !slices.Contains([]string{"", contentJSON, contentMsgpack, contentBinary}, contentType) {
+		return fmt.Errorf("%w: unsupported content type %q", errInvalidPayload, contentType)
+	}
+	if encoding != "" && encoding != encodingZstd {
+		return fmt.Errorf("%w: unsupported encoding %q", errInvalidPayload, encoding)
+	}
+	return nil
+}
+
+// Check the content type and encoding of the parameters, and move uncompressed JSON parameters to
+// the JSON field, where workers without binary support still read them.
+func (t *taskRequest) normalizeParameters() error {
+	if err := checkPayloadType(t.ParametersType, t.ParametersEncoding); err != nil {
+		return err
+	}
+	if t.ParametersType == contentJSON {
+		t.ParametersType = ""
+	}
+	if t.Parameters != "" && len(t.ParametersData) > 0 {
+		return fmt.Errorf("%w: parameters_json and parameters_data are exclusive", errInvalidPayload)
+	}
+	if t.ParametersType == "" && t.ParametersEncoding == "" && len(t.ParametersData) > 0 {
+		if !utf8.Valid(t.ParametersData) {
+			return fmt.Errorf("%w: JSON parameters must be UTF-8", errInvalidPayload)
+		}
+		t.Parameters, t.ParametersData = string(t.ParametersData), nil
+	}
+	if (t.ParametersType != "" || t.ParametersEncoding != "") && t.Parameters != "" {
+		return fmt.Errorf("%w: parameters_json must be empty when parameters_type or parameters_encoding is set", errInvalidPayload)
+	}
+	return nil
+}
+
+// A task result with its content type and compression. Plain text results have no content type.
+type resultPayload struct {
+	ContentType string
+	Encoding    string
+	Data        []byte
+}
+
+// Parse a result as the workers publish it.
+func parseResult(wire string) (resultPayload, error) {
+	rest, ok := strings.CutPrefix(wire, resultPayloadPrefix)
+	if !ok {
+		return resultPayload{Data: []byte(wire)}, nil
+	}
+	header, data, ok := strings.Cut(rest, "\n")
+	if !ok {
+		return resultPayload{}, fmt.Errorf("%w: result without a header", errInvalidPayload)
+	}
+	contentType, encoding, _ := strings.Cut(header, ";")
+	if err := checkPayloadType(contentType, encoding); err != nil || contentType == "" {
+		return resultPayload{}, fmt.Errorf("%w: invalid result header %q", errInvalidPayload, header)
+	}
+	return resultPayload{ContentType: contentType, Encoding: encoding, Data: []byte(data)}, nil
+}
+
+// A result as returned to callers: text results in Text, others in Data with their content type.
+// Compressed results are decompressed.
+type deliveredResult struct {
+	Text        string
+	Data        []byte
+	ContentType string
+}
+
+func deliverResult(wire string) (deliveredResult, error) {
+	p, err := parseResult(wire)
+	if err != nil {
+		return deliveredResult{}, err
+	}
+	if p.ContentType == "" {
+		return deliveredResult{Text: wire}, nil
+	}
+	data, err := decompress(p.Encoding, p.Data)
+	if err != nil {
+		return deliveredResult{}, err
+	}
+	if p.ContentType == contentJSON && utf8.Valid(data) {
+		return deliveredResult{Text: string(data), ContentType: p.ContentType}, nil
+	}
+	return deliveredResult{Data: data, ContentType: p.ContentType}, nil
+}
+
+// Read a task request body, as JSON or, with a msgpack Content-Type, as msgpack with the same field
+// names. Bodies may be compressed with zstd, with a Content-Encoding header. Compressed bodies larger
+// than maxDecompressedBytes once decompressed fail with an *http.MaxBytesError.
+func decodeTaskBody(r *http.Request, t *taskRequest) error {
+	body := io.Reader(r.Body)
+	switch r.Header.Get("Content-Encoding") {
+	case "", "identity":
+	case encodingZstd:
+		zr, err := zstd.NewReader(r.Body, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(uint64(maxDecompressedBytes)))
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		body = http.MaxBytesReader(nil, zr.IOReadCloser(), maxDecompressedBytes)
+	default:
+		return fmt.Errorf("%w: unsupported content encoding %q", errInvalidPayload, r.Header.Get("Content-Encoding"))
+	}
+	var err error
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == contentMsgpack {
+		dec := msgpack.NewDecoder(body)
+		dec.SetCustomStructTag("json")
+		err = dec.Decode(t)
+	} else {
+		err = json.NewDecoder(body).Decode(t)
+	}
+	// Frames declaring a larger size are rejected by the decoder before the body reaches the limit
+	if errors.Is(err, zstd.ErrDecoderSizeExceeded) {
+		return &http.MaxBytesError{Limit: maxDecompressedBytes}
+	}
+	return err
+}
+
+// Pick the response format of a result from the Accept header: msgpack, raw bytes, or JSON, which is
+// also the default when the header names none of them.
+func negotiateFormat(accept string) string {
+	best, bestQ := contentJSON, 0.0
+	for part := range strings.SplitSeq(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || !slices.Contains([]string{contentJSON, contentMsgpack, contentBinary}, mediaType) {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > bestQ {
+			best, bestQ = mediaType, q
+		}
+	}
+	return best
+}
+
+// Whether the Accept-Encoding header allows the encoding.
+func acceptsEncoding(header, encoding string) bool {
+	for part := range strings.SplitSeq(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.EqualFold(strings.TrimSpace(name), encoding) {
+			q, err := strconv.ParseFloat(strings.TrimPrefix(strings.TrimSpace(params), "q="), 64)
+			return err != nil || q > 0
+		}
+	}
+	return false
+}
+
+// Serialize the value as msgpack, with its JSON field names, and write it with the given status code.
+func writeMsgpack(w http.ResponseWriter, status int, v any) {
+	var out bytes.Buffer
+	enc := msgpack.NewEncoder(&out)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(v); err != nil {
+		panic("Error serializing response: " + err.Error())
+	}
+	w.Header().Set("Content-Type", contentMsgpack)
+	w.WriteHeader(status)
+	if _, err := w.Write(out.Bytes()); err != nil {
+		slog.Error("Error writing response", "error", err)
+	}
+}
+
+// Write a result as the body itself, with its content type. Compressed results stay compressed for
+// clients that accept zstd.
+func writeRawResult(w http.ResponseWriter, r *http.Request, p resultPayload, cached bool) error {
+	contentType := p.ContentType
+	if contentType == "" {
+		contentType = contentText
+	}
+	data := p.Data
+	if p.Encoding != "" && acceptsEncoding(r.Header.Get("Accept-Encoding"), p.Encoding) {
+		w.Header().Set("Content-Encoding", p.Encoding)
+	} else {
+		var err error
+		if data, err = decompress(p.Encoding, p.Data); err != nil {
+			return err
+		}
+	}
+	w.Header().Set("Content-Type", contentType)
+	if cached {
+		w.Header().Set("X-Result-Cached", "true")
+	}
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(data); err != nil {
+		slog.Error("Error writing response", "error", err)
+	}
+	return nil
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"dispatcher/dispatcherpb"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Compress data with zstd for the test.
+func zstdCompress(t *testing.T, data []byte) []byte {
+	t.Helper()
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enc.Close()
+	return enc.EncodeAll(data, nil)
+}
+
+// Encode data as msgpack with JSON field names for the test.
+func msgpackEncode(t *testing.T, v any) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	enc := msgpack.NewEncoder(&out)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(v); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+// Result as a worker publishes it with a content type.
+func encodeResult(contentType, encoding string, data []byte) string {
+	header := contentType
+	if encoding != "" {
+		header += ";" + encoding
+	}
+	return resultPayloadPrefix + header + "\n" + string(data)
+}
+
+func TestNormalizeParameters(t *testing.T) {
+	tests := []struct {
+		name string
+		in   taskRequest
+		want taskRequest
+		err  bool
+	}{
+		{name: "json", in: taskRequest{Parameters: "{}"}, want: taskRequest{Parameters: "{}"}},
+		{
+			name: "json data moves to the JSON field",
+			in:   taskRequest{ParametersData: []byte("{}"), ParametersType: contentJSON},
+			want: taskRequest{Parameters: "{}"},
+		},
+		{
+			name: "compressed json",
+			in:   taskRequest{ParametersData: []byte{1}, ParametersType: contentJSON, ParametersEncoding: encodingZstd},
+			want: taskRequest{ParametersData: []byte{1}, ParametersEncoding: encodingZstd},
+		},
+		{
+			name: "msgpack",
+			in:   taskRequest{ParametersData: []byte{0x80}, ParametersType: contentMsgpack},
+			want: taskRequest{ParametersData: []byte{0x80}, ParametersType: contentMsgpack},
+		},
+		{name: "unknown type", in: taskRequest{ParametersData: []byte{1}, ParametersType: "image/png"}, err: true},
+		{name: "unknown encoding", in: taskRequest{ParametersData: []byte{1}, ParametersEncoding: "gzip"}, err: true},
+		{name: "both fields", in: taskRequest{Parameters: "{}", ParametersData: []byte("{}")}, err: true},
+		{name: "binary in the JSON field", in: taskRequest{Parameters: "{}", ParametersType: contentBinary}, err: true},
+		{name: "invalid UTF-8", in: taskRequest{ParametersData: []byte{0xff}}, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.in.normalizeParameters()
+			if tt.err {
+				if !errors.Is(err, errInvalidPayload) {
+					t.Errorf("Expected an invalid payload error, got %v", err)
+				}
+				return
+			}
+			if err != nil || !equalJSON(t, tt.in, tt.want) {
+				t.Errorf("Expected %+v, got %+v %v", tt.want, tt.in, err)
+			}
+		})
+	}
+}
+
+func equalJSON(t *testing.T, a, b any) bool {
+	t.Helper()
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	return bytes.Equal(ja, jb)
+}
+
+func TestDeliverResult(t *testing.T) {
+	packed := msgpackEncode(t, map[string]int{"a": 1})
+	tests := []struct {
+		wire string
+		want deliveredResult
+	}{
+		{"plain text", deliveredResult{Text: "plain text"}},
+		// Text that looks like the envelope's contents stays text
+		{"payload:no header", deliveredResult{Text: "payload:no header"}},
+		{"payload:image/png\nx", deliveredResult{Text: "payload:image/png\nx"}},
+		{encodeResult(contentJSON, "", []byte(`{"a": 1}`)), deliveredResult{Text: `{"a": 1}`, ContentType: contentJSON}},
+		{encodeResult(contentJSON, encodingZstd, zstdCompress(t, []byte("[]"))), deliveredResult{Text: "[]", ContentType: contentJSON}},
+		{encodeResult(contentMsgpack, encodingZstd, zstdCompress(t, packed)), deliveredResult{Data: packed, ContentType: contentMsgpack}},
+		{encodeResult(contentBinary, "", []byte{0, 0xff}), deliveredResult{Data: []byte{0, 0xff}, ContentType: contentBinary}},
+	}
+	for _, tt := range tests {
+		if got, err := deliverResult(tt.wire); err != nil || !equalJSON(t, got, tt.want) {
+			t.Errorf("Expected %+v for %q, got %+v %v", tt.want, tt.wire, got, err)
+		}
+	}
+	for _, wire := range []string{resultPayloadPrefix + "no header", resultPayloadPrefix + "image/png\nx", resultPayloadPrefix + contentBinary + ";zstd\nnot zstd"} {
+		if _, err := deliverResult(wire); !errors.Is(err, errInvalidPayload) {
+			t.Errorf("Expected an invalid payload error for %q, got %v", wire, err)
+		}
+	}
+}
+
+// Test that text results of existing workers that start like a typed or offloaded result reach the
+// caller as they are
+func TestRunTaskPlainTextPrefixes(t *testing.T) {
+	r, _ := mockRedis(true)
+	defer r.Close()
+	setPayloadStore(t, 16)
+	srv := httptest.NewServer(newRouter(r))
+	defer srv.Close()
+
+	for i, result := range []string{"payload:application/json\n{}", "payload:no header", "blob:notes", "blob:" + defaultNamespace + "/missing"} {
+		id := "t" + strconv.Itoa(i)
+		publishWhenQueued(r, id, result)
+		rsp := postRunTask(t, srv.URL, `{"task_id": "`+id+`", "task_type": "echo", "return_result": true}`)
+		if rsp.Message != result || rsp.ContentType != "" {
+			t.Errorf("Expected the text result %q, got %+v", result, rsp)
+		}
+	}
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := map[string]string{
+		"":                              contentJSON,
+		"*/*":                           contentJSON,
+		"text/html":                     contentJSON,
+		"application/msgpack":           contentMsgpack,
+		"application/octet-stream, */*": contentBinary,
+		"application/json;q=0.5, application/msgpack":       contentMsgpack,
+		"application/msgpack;q=0.2, application/json;q=0.9": contentJSON,
+	}
+	for accept, want := range tests {
+		if got := negotiateFormat(accept); got != want {
+			t.Errorf("Expected %s for Accept %q, got %s", want, accept, got)
+		}
+	}
+	if !acceptsEncoding("gzip, zstd", encodingZstd) || acceptsEncoding("zstd;q=0", encodingZstd) || acceptsEncoding("gzip", encodingZstd) {
+		t.Error("Expected zstd to be accepted only when listed without q=0")
+	}
+}
+
+// Test that compressed bodies too large once decompressed are rejected before they are read whole
+func TestDecompressedBodyLimit(t *testing.T) {
+	r, _ := mockRedis(true)
+	defer r.Close()
+	srv := httptest.NewServer(newRouter(r))
+	defer srv.Close()
+	limit := maxDecompressedBytes
+	maxDecompressedBytes = 1024
+	t.Cleanup(func() { maxDecompressedBytes = limit })
+
+	// Streamed frames with a small window declare no size, so only the body limit stops them
+	streamed := func(data []byte) []byte {
+		var buf bytes.Buffer
+		enc, _ := zstd.NewWriter(&buf, zstd.WithWindowSize(zstd.MinWindowSize))
+		enc.Write(data)
+		enc.Close()
+		return buf.Bytes()
+	}
+	large := []byte(`{"task_id": "t1", "task_type": "embed", "parameters_json": "` + strings.Repeat("a", 2048) + `"}`)
+	for _, tc := range []struct {
+		body   []byte
+		status int
+	}{
+		{zstdCompress(t, large), http.StatusRequestEntityTooLarge},
+		{streamed(large), http.StatusRequestEntityTooLarge},
+		{zstdCompress(t, []byte(`{"task_id": "t1", "task_type": "embed"`)), http.StatusBadRequest},
+	} {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/send-task", bytes.NewReader(tc.body))
+		req.Header.Set("Content-Encoding", encodingZstd)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.status {
+			t.Errorf("Expected %d for a body of %d bytes, got %d", tc.status, len(tc.body), resp.StatusCode)
+		}
+	}
+}
+
+// Test a msgpack task with binary parameters, compressed with zstd, through /run-task, and the
+// negotiated formats of its binary result
+func TestRunTaskContentTypes(t *testing.T) {
+	r, c := mockRedis(true)
+	defer r.Close()
+	setCacheConfig(t, map[string]int{"embed": 60})
+	srv := httptest.NewServer(newRouter(r))
+	defer srv.Close()
+
+	params := msgpackEncode(t, map[string]any{"image": []byte{0, 1, 2}})
+	body := msgpackEncode(t, map[string]any{
+		"task_id": "t1", "task_type": "embed", "label": "label-1", "return_result": true,
+		"parameters_data": params, "parameters_type": contentMsgpack,
+	})
+	vector := msgpackEncode(t, []float64{0.5, 0.25})
+	result := encodeResult(contentMsgpack, encodingZstd, zstdCompress(t, vector))
+	publishWhenQueued(r, "t1", result)
+
+	post := func(accept, acceptEncoding string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/run-task", bytes.NewReader(zstdCompress(t, body)))
+		req.Header.Set("Content-Type", contentMsgpack)
+		req.Header.Set("Content-Encoding", encodingZstd)
+		req.Header.Set("Accept", accept)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		resp, err := http.DefaultTransport.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected the task to run, got %d", resp.StatusCode)
+		}
+		return resp
+	}
+
+	resp := post(contentMsgpack, "")
+	var out runTaskResponse
+	dec := msgpack.NewDecoder(resp.Body)
+	dec.SetCustomStructTag("json")
+	if err := dec.Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.Header.Get("Content-Type") != contentMsgpack || !bytes.Equal(out.Data, vector) || out.ContentType != contentMsgpack || out.Message != "" {
+		t.Errorf("Expected the decompressed msgpack result, got %+v", out)
+	}
+	r.HSet(c, r.keys.taskStatus("t1"), "status", taskCompleted, "result", result)
+	if info, _ := getTaskInfo(r, c, "t1"); info == nil || !bytes.Equal(info.ResultData, vector) || info.ContentType != contentMsgpack {
+		t.Errorf("Expected the binary result in the task status, got %+v", info)
+	}
+	queued, _ := r.LPop(c, r.keys.queue("work1")).Result()
+	var wire taskRequest
+	if err := json.Unmarshal([]byte(queued), &wire); err != nil || !bytes.Equal(wire.ParametersData, params) || wire.ParametersType != contentMsgpack {
+		t.Errorf("Expected the binary parameters on the queue, got %s %v", queued, err)
+	}
+
+	// The result is now cached, compressed as the worker published it
+	resp = post(contentBinary, "zstd")
+	raw, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.Header.Get("Content-Type") != contentMsgpack || resp.Header.Get("Content-Encoding") != encodingZstd || resp.Header.Get("X-Result-Cached") != "true" {
+		t.Errorf("Expected a cached, compressed msgpack body, got headers %v", resp.Header)
+	}
+	if plain, err := zstdDecoder.DecodeAll(raw, nil); err != nil || !bytes.Equal(plain, vector) {
+		t.Errorf("Expected the compressed result, got %v %v", plain, err)
+	}
+	resp = post(contentBinary, "")
+	raw, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.Header.Get("Content-Encoding") != "" || !bytes.Equal(raw, vector) {
+		t.Errorf("Expected the decompressed result, got %v %v", resp.Header, raw)
+	}
+	resp = post("", "")
+	json.NewDecoder(resp.Body).Decode(&out)
+	resp.Body.Close()
+	if !bytes.Equal(out.Data, vector) || !out.Cached {
+		t.Errorf("Expected the result base64 encoded in JSON, got %+v", out)
+	}
+
+	// Invalid parameters are rejected
+	bad, _ := http.Post(srv.URL+"/run-task", contentJSON, bytes.NewReader([]byte(`{"task_id": "t2", "task_type": "embed", "return_result": true, "parameters_type": "image/png"}`)))
+	bad.Body.Close()
+	if bad.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected invalid parameters to be rejected, got %d", bad.StatusCode)
+	}
+}
+
+// Test that gRPC calls take binary parameters and return binary results as bytes
+func TestGRPCBinaryPayloads(t *testing.T) {
+	conn, r, cleanup := grpcTestServer(t)
+	defer cleanup()
+	client := dispatcherpb.NewDispatcherClient(conn)
+
+	publishWhenQueued(r, "g1", encodeResult(contentBinary, "", []byte{0xff, 0}))
+	rsp, err := client.RunTask(context.Background(), &dispatcherpb.RunTaskRequest{Task: &dispatcherpb.TaskSpec{
+		TaskId: "g1", TaskType: "test", Label: "label-1", ParametersData: []byte{1, 2}, ParametersType: contentBinary,
+	}})
+	if err != nil || !bytes.Equal(rsp.ResultData, []byte{0xff, 0}) || rsp.ContentType != contentBinary || rsp.Result != "" {
+		t.Errorf("Expected the binary result, got %v %v", rsp, err)
+	}
+	_, err = client.SendTask(context.Background(), &dispatcherpb.SendTaskRequest{Task: &dispatcherpb.TaskSpec{
+		TaskId: "g2", TaskType: "test", ParametersJson: "{}", ParametersEncoding: "gzip",
+	}})
+	if err == nil {
+		t.Error("Expected invalid parameters to be rejected")
+	}
+}
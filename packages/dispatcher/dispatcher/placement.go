@@ -0,0 +1,196 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"log/slog"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Reasons a worker was chosen to load a label, logged with the decision and used as the "reason"
+// label of the placement counter. Each names the criterion that set the chosen worker apart from the
+// runner-up.
+const (
+	reasonOnlyCandidate   = "only_candidate"
+	reasonMostHeld        = "most_held"
+	reasonNoEviction      = "no_eviction"
+	reasonLowestDemand    = "lowest_demand"
+	reasonMostReplicas    = "most_replicas"
+	reasonFewestBytes     = "fewest_bytes"
+	reasonFewestEvictions = "fewest_evictions"
+	reasonFewestLabels    = "fewest_labels"
+	reasonFirstInOrder    = "first_in_order"
+)
+
+// Count a task routed for the labels in the current demand bucket. Errors are only logged, since
+// demand only guides evictions.
+func (r *redisClient) recordDemand(c context.Context, labels ...string) {
+	if len(labels) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(c, opTimeout())
+	defer cancel()
+	key := r.keys.demand(time.Now())
+	ttl := time.Duration(currentConfig().Routing.DemandWindowSeconds+demandBucketSeconds) * time.Second
+	pipe := r.Pipeline()
+	for _, l := range labels {
+		pipe.HIncrBy(ctx, key, l, 1)
+	}
+	pipe.Expire(ctx, key, ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		observeRedisError("record_demand", err)
+		slog.Warn("Unable to record label demand", "error", err, "labels", labels)
+	}
+}
+
+// Recent demand and replica counts of the labels a placement could evict.
+type evictionStats struct {
+	Demand  map[string]int64 // Tasks routed for each label within the demand window
+	Holders map[string]int64 // Workers holding each label
+}
+
+// Load the demand over the configured window, and the number of workers holding each label.
+func (r *redisClient) loadEvictionStats(c context.Context, labels []string) (evictionStats, error) {
+	stats := evictionStats{Demand: map[string]int64{}, Holders: map[string]int64{}}
+	if len(labels) == 0 {
+		return stats, nil
+	}
+	ctx, cancel := context.WithTimeout(c, opTimeout())
+	defer cancel()
+	now := time.Now()
+	buckets := currentConfig().Routing.DemandWindowSeconds / demandBucketSeconds
+	pipe := r.Pipeline()
+	demand := make([]*redis.SliceCmd, buckets)
+	for i := range demand {
+		demand[i] = pipe.HMGet(ctx, r.keys.demand(now.Add(-time.Duration(i*demandBucketSeconds)*time.Second)), labels...)
+	}
+	holders := make([]*redis.IntCmd, len(labels))
+	for i, l := range labels {
+		holders[i] = pipe.SCard(ctx, r.keys.label(l))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		observeRedisError("eviction_stats", err)
+		return stats, err
+	}
+
+	for _, b := range demand {
+		for i, v := range b.Val() {
+			if s, ok := v.(string); ok {
+				n, _ := strconv.ParseInt(s, 10, 64)
+				stats.Demand[labels[i]] += n
+			}
+		}
+	}
+	for i, l := range labels {
+		stats.Holders[l] = holders[i].Val()
+	}
+	return stats, nil
+}
+
+// A worker that can load the labels of a task it does not hold, with the labels it would evict for
+// them.
+type placement struct {
+	Worker workerId
+	// Number of the task's labels the worker already holds
+	Held         int
+	Evicted      []string
+	EvictedBytes int64
+	// Tasks routed for the evicted labels within the demand window
+	EvictedDemand int64
+	// Fewest other workers holding one of the evicted labels, or -1 if nothing is evicted
+	MinReplicas int64
+	LabelCount  int
+	// Criterion that set this worker apart from the runner-up
+	Reason string
+}
+
+// Criteria to rank placements, in order of priority. Each returns a negative number when a is better.
+var placementCriteria = []struct {
+	reason  string
+	compare func(a, b *placement) int
+}{
+	{reasonMostHeld, func(a, b *placement) int { return cmp.Compare(b.Held, a.Held) }},
+	{reasonNoEviction, func(a, b *placement) int { return cmp.Compare(min(len(a.Evicted), 1), min(len(b.Evicted), 1)) }},
+	{reasonLowestDemand, func(a, b *placement) int { return cmp.Compare(a.EvictedDemand, b.EvictedDemand) }},
+	{reasonMostReplicas, func(a, b *placement) int { return cmp.Compare(b.MinReplicas, a.MinReplicas) }},
+	{reasonFewestBytes, func(a, b *placement) int { return cmp.Compare(a.EvictedBytes, b.EvictedBytes) }},
+	{reasonFewestEvictions, func(a, b *placement) int { return cmp.Compare(len(a.Evicted), len(b.Evicted)) }},
+	{reasonFewestLabels, func(a, b *placement) int { return cmp.Compare(a.LabelCount, b.LabelCount) }},
+}
+
+// Compare two placements by the ranking criteria. Returns the first criterion that tells them apart,
+// or reasonFirstInOrder if none does.
+func comparePlacements(a, b *placement) (int, string) {
+	for _, c := range placementCriteria {
+		if n := c.compare(a, b); n != 0 {
+			return n, c.reason
+		}
+	}
+	return 0, reasonFirstInOrder
+}
+
+// Labels a worker would evict to hold the given labels, with their demand and replicas.
+func planPlacement(w *workerCapacity, labels []string, stats evictionStats) (placement, bool) {
+	evicted, bytes, ok := w.evictionFor(labels)
+	if !ok {
+		return placement{}, false
+	}
+	p := placement{
+		Worker:       w.ID,
+		Held:         len(w.Holds),
+		Evicted:      evicted,
+		EvictedBytes: bytes,
+		MinReplicas:  -1,
+		LabelCount:   w.LabelCount,
+	}
+	for _, l := range evicted {
+		p.EvictedDemand += stats.Demand[l]
+		replicas := max(stats.Holders[l]-1, 0)
+		if p.MinReplicas < 0 || replicas < p.MinReplicas {
+			p.MinReplicas = replicas
+		}
+	}
+	return p, true
+}
+
+// Choose the worker that can hold all the labels at the lowest cost. Workers already holding the most
+// of them come first, then workers with room for the rest. Otherwise the worker whose evicted labels
+// have the lowest recent demand wins, then the one whose evicted labels are held by the most other
+// workers, then the one evicting the fewest bytes and labels. Remaining ties go to the worker with
+// the fewest labels, then to the first in order. Each worker is checked against its own label limit.
+// Returns nil if the labels fit on none of them.
+func choosePlacement(workers []workerCapacity, labels []string, stats evictionStats) *placement {
+	candidates := []placement{}
+	for i := range workers {
+		if p, ok := planPlacement(&workers[i], labels, stats); ok {
+			candidates = append(candidates, p)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	slices.SortStableFunc(candidates, func(a, b placement) int {
+		n, _ := comparePlacements(&a, &b)
+		return n
+	})
+	best := candidates[0]
+	best.Reason = reasonOnlyCandidate
+	if len(candidates) > 1 {
+		_, best.Reason = comparePlacements(&best, &candidates[1])
+	}
+	return &best
+}
+
+// Labels held by any of the workers, without duplicates.
+func heldLabels(workers []workerCapacity) []string {
+	out := []string{}
+	for _, w := range workers {
+		out = append(out, w.Labels...)
+	}
+	slices.Sort(out)
+	return slices.Compact(out)
+}
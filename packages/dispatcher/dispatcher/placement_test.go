@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// Test that a full worker is chosen by the demand and replicas of the label it would evict
+func TestEvictionAwarePlacement(t *testing.T) {
+	r, c := mockRedis(false)
+	defer r.Close()
+
+	// Every worker is full with a single label of the same size
+	for wid, label := range map[workerId]string{"w-hot": "hot", "w-cold": "cold"} {
+		publishWorker(t, r, c, wid, 0, label)
+		r.HSet(c, r.keys.info(wid), maxLabelsField, 1)
+	}
+	for range 5 {
+		r.recordDemand(c, "hot")
+	}
+	route := func() workerId {
+		t.Helper()
+		wid, err := selectLabeledQueue(&taskRequest{TaskID: "t", Label: "new"}, r, c)
+		if err != nil {
+			t.Fatalf("Error selecting queue: %v", err)
+		}
+		return wid
+	}
+
+	before := testutil.ToFloat64(placementCount.WithLabelValues(reasonLowestDemand))
+	if wid := route(); wid != "w-cold" {
+		t.Errorf("Expected the worker evicting the cold label, got %s", wid)
+	}
+	if testutil.ToFloat64(placementCount.WithLabelValues(reasonLowestDemand))-before != 1 {
+		t.Error("Expected the placement to be counted with its reason")
+	}
+
+	// A worker whose label is also held elsewhere beats one holding the only replica
+	publishWorker(t, r, c, "w-shared", 0, "shared")
+	r.HSet(c, r.keys.info(workerId("w-shared")), maxLabelsField, 1)
+	r.SAdd(c, r.keys.label("shared"), "u-other")
+	caps, err := r.loadCapacities(c, workerIds{"w-cold", "w-hot", "w-shared"}, "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats, err := r.loadEvictionStats(c, heldLabels(caps))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := choosePlacement(caps, []string{"new"}, stats)
+	if p == nil || p.Worker != "w-shared" || p.Reason != reasonMostReplicas || p.MinReplicas != 1 {
+		t.Errorf("Expected w-shared for its replicated label, got %+v", p)
+	}
+}
+
+// Test that demand is only counted within the configured window
+func TestLabelDemandWindow(t *testing.T) {
+	r, c := mockRedis(false)
+	defer r.Close()
+
+	r.recordDemand(c, "l1")
+	r.recordDemand(c, "l1")
+	r.recordDemand(c, "")
+	old := r.keys.demand(time.Now().Add(-time.Duration(defaultDemandWindowSeconds+demandBucketSeconds) * time.Second))
+	r.HSet(c, old, "l1", 100)
+	r.SAdd(c, r.keys.label("l1"), "w1", "w2")
+
+	stats, err := r.loadEvictionStats(c, []string{"l1", "l2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Demand["l1"] != 2 || stats.Demand["l2"] != 0 {
+		t.Errorf("Expected demand within the window only, got %v", stats.Demand)
+	}
+	if stats.Holders["l1"] != 2 || stats.Holders["l2"] != 0 {
+		t.Errorf("Unexpected holder counts: %v", stats.Holders)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+)
+
+// Common queue of a worker pool, which every worker in the pool reads once its own queue is empty. The
+// default pool uses the "all" queue.
+func poolQueue(pool string) workerId {
+	if pool == "" {
+		return workerId("all")
+	}
+	return workerId(poolQueuePrefix + pool)
+}
+
+// Whether a queue is the common queue of a pool rather than a worker's queue.
+func isCommonQueue(wid workerId) bool {
+	return wid == poolQueue("") || strings.HasPrefix(string(wid), poolQueuePrefix)
+}
+
+// Common queues of the default pool, of every pool the given workers belong to, and of every pool
+// that was sent tasks, whether or not its workers run.
+func commonQueues(r *redisClient, c context.Context, wids workerIds) (workerIds, error) {
+	res, err := r.loadWorkerResources(c, wids)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(c, opTimeout())
+	defer cancel()
+	sent, err := r.SMembers(ctx, r.keys.pools()).Result()
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(sent)
+	out := workerIds{poolQueue("")}
+	for _, w := range res {
+		if q := poolQueue(w.Attributes[poolAttribute]); !slices.Contains(out, q) {
+			out = append(out, q)
+		}
+	}
+	for _, q := range sent {
+		if !slices.Contains(out, workerId(q)) {
+			out = append(out, workerId(q))
+		}
+	}
+	return out, nil
+}
+
+// Available workers that match the task's selector, split into tiers by the number of preferred
+// attributes they have, most first. Workers are sorted by ID within each tier.
+func eligibleWorkers(t *taskRequest, b broker, c context.Context) ([]workerIds, error) {
+	av, err := b.availableWorkers(c, true)
+	if err != nil {
+		return nil, err
+	}
+	res, err := b.loadWorkerResources(c, av)
+	if err != nil {
+		return nil, err
+	}
+	byScore := map[int]workerIds{}
+	for i, w := range av {
+		if score, ok := t.Selector.match(res[i].Attributes); ok {
+			byScore[score] = append(byScore[score], w)
+		}
+	}
+	scores := make([]int, 0, len(byScore))
+	for s := range byScore {
+		scores = append(scores, s)
+	}
+	slices.Sort(scores)
+	slices.Reverse(scores)
+	tiers := make([]workerIds, len(scores))
+	for i, s := range scores {
+		tiers[i] = byScore[s]
+	}
+	return tiers, nil
+}
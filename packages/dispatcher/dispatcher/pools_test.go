@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSelectorMatch(t *testing.T) {
+	sel := &taskSelector{
+		Required:  map[string]string{"pool": "highmem", "arch": "amd64"},
+		Preferred: map[string]string{"gpu": "a100", "zone": "a"},
+	}
+	for _, tc := range []struct {
+		attrs     map[string]string
+		preferred int
+		ok        bool
+	}{
+		{map[string]string{"pool": "highmem", "arch": "amd64", "gpu": "a100", "zone": "a"}, 2, true},
+		{map[string]string{"pool": "highmem", "arch": "amd64", "zone": "b"}, 0, true},
+		{map[string]string{"pool": "highmem", "arch": "arm64"}, 0, false},
+		{map[string]string{"arch": "amd64"}, 0, false},
+	} {
+		if n, ok := sel.match(tc.attrs); n != tc.preferred || ok != tc.ok {
+			t.Errorf("Expected %d %v for %v, got %d %v", tc.preferred, tc.ok, tc.attrs, n, ok)
+		}
+	}
+
+	// Tasks without a selector run on any worker of the default pool
+	var none *taskSelector
+	if _, ok := none.match(map[string]string{"gpu": "a100"}); !ok {
+		t.Error("Expected a worker in the default pool to match a task without a selector")
+	}
+	if _, ok := none.match(map[string]string{"pool": "highmem"}); ok {
+		t.Error("Expected a pooled worker not to match a task without a selector")
+	}
+}
+
+// Test that the selector narrows the workers before label affinity, and that tasks nobody can take
+// wait in the common queue of their pool, or in the queue of a busy worker with the attributes they
+// require
+func TestSelectWorkerPools(t *testing.T) {
+	r, c := mockRedis(false)
+	defer r.Close()
+
+	publishWorker(t, r, c, "w-cpu", 16, "model-a")
+	publishWorker(t, r, c, "w-gpu", 16)
+	publishWorker(t, r, c, "w-mem", 64, "model-a")
+	r.HSet(c, r.keys.info(workerId("w-gpu")), attributesField, `{"gpu": "a100"}`)
+	r.HSet(c, r.keys.info(workerId("w-mem")), attributesField, `{"pool": "highmem"}`)
+
+	route := func(label string, sel *taskSelector) workerId {
+		t.Helper()
+		wid, err := selectWorkerQueue(&taskRequest{TaskID: "t", Label: label, Selector: sel}, r, c)
+		if err != nil {
+			t.Fatalf("Error selecting queue: %v", err)
+		}
+		return wid
+	}
+
+	if wid := route("model-a", nil); wid != "w-cpu" {
+		t.Errorf("Expected w-cpu, the only default pool worker holding the label, got %s", wid)
+	}
+	if wid := route("model-a", &taskSelector{Preferred: map[string]string{"gpu": "a100"}}); wid != "w-gpu" {
+		t.Errorf("Expected w-gpu, which has the preferred attribute, got %s", wid)
+	}
+	if wid := route("model-a", &taskSelector{Preferred: map[string]string{"gpu": "h100"}}); wid != "w-cpu" {
+		t.Errorf("Expected label affinity when no worker has the preferred attribute, got %s", wid)
+	}
+	if wid := route("model-b", &taskSelector{Required: map[string]string{"pool": "highmem"}}); wid != "w-mem" {
+		t.Errorf("Expected w-mem, the only worker in the pool, got %s", wid)
+	}
+
+	// Unavailable workers leave the tasks in the common queue of their pool, unless they require
+	// attributes that only some workers of the pool have
+	r.SRem(c, r.keys.available(), "w-gpu", "w-mem")
+	if wid := route("model-a", nil); wid != "w-cpu" {
+		t.Errorf("Expected w-cpu, which is still available, got %s", wid)
+	}
+	if wid := route("model-a", &taskSelector{Required: map[string]string{"gpu": "a100"}}); wid != "w-gpu" {
+		t.Errorf("Expected the queue of the busy worker with the required attribute, got %s", wid)
+	}
+	for _, sel := range []*taskSelector{{Required: map[string]string{"gpu": "h100"}}, {Required: map[string]string{"pool": "highmem", "gpu": "a100"}}} {
+		if _, err := selectWorkerQueue(&taskRequest{TaskID: "t", Selector: sel}, r, c); !errors.Is(err, errNoEligibleWorker) {
+			t.Errorf("Expected no eligible worker for %v, got %v", sel.Required, err)
+		}
+	}
+	if wid := route("model-a", &taskSelector{Required: map[string]string{"pool": "highmem"}}); wid != "pool:highmem" {
+		t.Errorf("Expected the pool common queue, got %s", wid)
+	}
+
+	queues, err := commonQueues(r, c, workerIds{"w-cpu", "w-gpu", "w-mem"})
+	if err != nil || !slices.Equal(queues, workerIds{"all", "pool:highmem"}) {
+		t.Errorf("Expected the default and highmem common queues, got %v %v", queues, err)
+	}
+
+	// Pools whose workers are all gone still report the tasks sent to them
+	if err := poolQueue("offline").sendTask(&taskRequest{TaskID: "t1", TaskType: "test", Parameters: "{}"}, r, c); err != nil {
+		t.Fatal(err)
+	}
+	queues, err = commonQueues(r, c, workerIds{"w-cpu"})
+	if err != nil || !slices.Equal(queues, workerIds{"all", "pool:offline"}) {
+		t.Errorf("Expected the common queues of the pools sent tasks, got %v %v", queues, err)
+	}
+
+	cfg := defaultConfig()
+	cfg.Routing.RandomDispatch = true
+	setConfig(cfg)
+	defer setConfig(defaultConfig())
+	if wid := route("model-a", &taskSelector{Required: map[string]string{"pool": "highmem"}}); wid != "pool:highmem" {
+		t.Errorf("Expected random dispatch to the pool common queue, got %s", wid)
+	}
+	if wid := route("model-a", &taskSelector{Required: map[string]string{"gpu": "a100"}}); wid != "w-gpu" {
+		t.Errorf("Expected random dispatch to keep the required attribute, got %s", wid)
+	}
+
+	// Draining workers take no new tasks
+	r.SAdd(c, r.keys.draining(), "w-gpu")
+	if _, err := selectWorkerQueue(&taskRequest{TaskID: "t", Selector: &taskSelector{Required: map[string]string{"gpu": "a100"}}}, r, c); !errors.Is(err, errNoEligibleWorker) {
+		t.Errorf("Expected no eligible worker once w-gpu drains, got %v", err)
+	}
+	srv := httptest.NewServer(newRouter(r))
+	defer srv.Close()
+	body := `{"task_id": "t", "task_type": "test", "selector": {"required": {"gpu": "a100"}}}`
+	resp, err := http.Post(srv.URL+"/send-task", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 without an eligible worker, got %d", resp.StatusCode)
+	}
+}
@@ -2,35 +2,44 @@ package main
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"log/slog"
 	"math/rand"
 	"slices"
 	"time"
 
-	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-// Get the IDs for workers that are currently available with the given label
-func availableWorkersLabel(r *redis.Client, c context.Context, l string) (workerIds, error) {
-	ctx, cancel := context.WithTimeout(c, opTimeoutMilliseconds*time.Millisecond)
+// Get the IDs for workers that are currently available with all the given labels, excluding draining
+// workers
+func (r *redisClient) availableWorkersLabel(c context.Context, labels ...string) (workerIds, error) {
+	ctx, cancel := context.WithTimeout(c, opTimeout())
 	defer cancel()
-	lk := fmt.Sprintf("task-runners:labels:%s:workers", l)
-	m, err := r.SInter(ctx, availableWorkersKey, lk).Result()
-	if err != nil {
+	keys := []string{r.keys.available()}
+	for _, l := range labels {
+		keys = append(keys, r.keys.label(l))
+	}
+	pipe := r.Pipeline()
+	inter := pipe.SInter(ctx, keys...)
+	drained := pipe.SMembers(ctx, r.keys.draining())
+	if _, err := pipe.Exec(ctx); err != nil {
+		observeRedisError("available_workers_label", err)
 		slog.Error("Unable to get available workers!", "error", err)
 		return []workerId{}, err
 	}
+	m := slices.DeleteFunc(inter.Val(), func(w string) bool { return slices.Contains(drained.Val(), w) })
 	return stringToWidSlice(m), nil
 }
 
-// Get all available worker IDs.
-func availableWorkers(r *redis.Client, c context.Context, sorted bool) (workerIds, error) {
-	ctx, cancel := context.WithTimeout(c, opTimeoutMilliseconds*time.Millisecond)
+// Get all available worker IDs. Draining workers are never reported as available.
+func (r *redisClient) availableWorkers(c context.Context, sorted bool) (workerIds, error) {
+	ctx, cancel := context.WithTimeout(c, opTimeout())
 	defer cancel()
 
-	m, err := r.SMembers(ctx, availableWorkersKey).Result()
+	m, err := r.SDiff(ctx, r.keys.available(), r.keys.draining()).Result()
 	if err != nil {
+		observeRedisError("available_workers", err)
 		slog.Error("Unable to get available workers!", "error", err)
 		return []workerId{}, err
 	}
@@ -42,91 +51,191 @@ func availableWorkers(r *redis.Client, c context.Context, sorted bool) (workerId
 }
 
 // Get the IDs for all currently running workers
-func getRunningWorkerIds(r *redis.Client, c context.Context) (workerIds, error) {
-	ctx, cancel := context.WithTimeout(c, opTimeoutMilliseconds*time.Millisecond)
+func (r *redisClient) runningWorkers(c context.Context) (workerIds, error) {
+	ctx, cancel := context.WithTimeout(c, opTimeout())
 	defer cancel()
-	m, err := r.SMembers(ctx, runningWorkerskey).Result()
+	m, err := r.SMembers(ctx, r.keys.running()).Result()
 	if err != nil {
+		observeRedisError("running_workers", err)
 		slog.Error("Unable to get running workers!", "error", err)
 		return []workerId{}, err
 	}
 	return stringToWidSlice(m), nil
 }
 
+func (r *redisClient) activeWorkers(c context.Context) (workerIds, error) {
+	ctx, cancel := context.WithTimeout(c, opTimeout())
+	defer cancel()
+	m, err := r.SDiff(ctx, r.keys.running(), r.keys.draining()).Result()
+	if err != nil {
+		observeRedisError("active_workers", err)
+		slog.Error("Unable to get active workers!", "error", err)
+		return []workerId{}, err
+	}
+	return stringToWidSlice(m), nil
+}
+
+// Returned when a task requires attributes that no running worker has.
+var errNoEligibleWorker = errors.New("no running worker matches the task's selector")
+
 // Select a worker to process the given task request.
-func selectWorkerQueue(t *taskRequest, r *redis.Client, c context.Context) (workerId, error) {
-	if randomDispatch {
-		// Send to common queue
-		return workerId("all"), nil
+func selectWorkerQueue(t *taskRequest, b broker, c context.Context) (wid workerId, err error) {
+	c, span := tracer().Start(c, "route")
+	defer func(start time.Time) {
+		routingLatency.Observe(time.Since(start).Seconds())
+		span.SetAttributes(attribute.String("worker.id", string(wid)))
+		endSpan(span, err)
+	}(time.Now())
+
+	if currentConfig().Routing.RandomDispatch {
+		// Send to the common queue of the task's pool
+		if t.Selector.requiresAttributes() {
+			return fallbackQueue(t, b, c)
+		}
+		dispatchCount.WithLabelValues(outcomeRandomDispatch).Inc()
+		return poolQueue(t.Selector.pool()), nil
 	}
-	return selectLabeledQueue(t, r, c)
+	return selectLabeledQueue(t, b, c)
 }
 
-// Select a worker based on the task label and worker labels
-func selectLabeledQueue(t *taskRequest, r *redis.Client, c context.Context) (workerId, error) {
-	available, err := availableWorkersLabel(r, c, t.Label)
+// Select a worker based on the task selector, then the task labels and worker labels. Workers with
+// the most preferred attributes are tried first, by label affinity and then by the cost of loading the
+// labels, and the next tier only if none of them can take the task.
+func selectLabeledQueue(t *taskRequest, b broker, c context.Context) (workerId, error) {
+	labels := t.labels()
+	b.recordDemand(c, labels...)
+	tiers, err := eligibleWorkers(t, b, c)
 	if err != nil {
-		slog.Error("Error getting available workers", "error", err, "label", t.Label)
+		slog.Error("Error getting available workers", "error", err)
 		return "", err
 	}
-	if len(available) > 0 {
-		return available[rand.Intn(len(available))], nil
+	var holders workerIds
+	if len(labels) > 0 {
+		holders, err = b.availableWorkersLabel(c, labels...)
+		if err != nil {
+			slog.Error("Error getting available workers", "error", err, "labels", labels)
+			return "", err
+		}
+	}
+	for _, tier := range tiers {
+		hits := slices.DeleteFunc(slices.Clone(holders), func(w workerId) bool { return !slices.Contains(tier, w) })
+		if len(hits) > 0 {
+			dispatchCount.WithLabelValues(outcomeLabelHit).Inc()
+			return hits[rand.Intn(len(hits))], nil
+		}
+		slog.Warn("No available workers found with labels", "labels", labels, "task_id", t.TaskID, "workers", len(tier))
+
+		// Select the worker that can load the missing labels at the lowest cost
+		wid, ok, err := placeLabels(t, labels, tier, b, c)
+		if err != nil || ok {
+			return wid, err
+		}
 	}
-	slog.Warn("No available workers found with label", "label", t.Label, "task_id", t.TaskID)
+	return fallbackQueue(t, b, c)
+}
 
-	// Select available worker with capacity for additional labels
-	capable, err := workersWithLabelCapacity(r, c)
+// Queue for a task no available worker can take: the common queue of its pool. Any worker of the pool
+// takes tasks from that queue, so tasks that require other attributes wait in the queue of a busy
+// worker that has them instead, among those with the most preferred attributes and, if possible, the
+// task's labels. Returns errNoEligibleWorker if no running worker has them.
+func fallbackQueue(t *taskRequest, b broker, c context.Context) (workerId, error) {
+	if !t.Selector.requiresAttributes() {
+		dispatchCount.WithLabelValues(outcomeCommonQueue).Inc()
+		return poolQueue(t.Selector.pool()), nil
+	}
+	active, err := b.activeWorkers(c)
 	if err != nil {
-		slog.Error("Error getting workers with label capacity", "error", err)
 		return "", err
 	}
-	av, err := availableWorkers(r, c, true)
+	res, err := b.loadWorkerResources(c, active)
 	if err != nil {
-		slog.Error("Error getting available workers", "error", err)
 		return "", err
 	}
-	for _, w := range capable {
-		if av.containsSorted(w) {
-			slog.Info(
-				"Selecting worker with label capacity",
-				"worker", w,
-				"label", t.Label,
-				"task_id", t.TaskID,
-			)
-			return w, nil
+	best, candidates := -1, workerIds{}
+	for i, w := range active {
+		score, ok := t.Selector.match(res[i].Attributes)
+		switch {
+		case !ok || score < best:
+		case score > best:
+			best, candidates = score, workerIds{w}
+		default:
+			candidates = append(candidates, w)
 		}
 	}
-	return workerId("all"), nil
+	if len(candidates) == 0 {
+		return "", errNoEligibleWorker
+	}
+	for _, l := range t.labels() {
+		holders, err := b.workersWithLabel(c, l)
+		if err != nil {
+			return "", err
+		}
+		hits := slices.DeleteFunc(slices.Clone(candidates), func(w workerId) bool { return !slices.Contains(holders, w) })
+		if len(hits) == 0 {
+			break
+		}
+		candidates = hits
+	}
+	dispatchCount.WithLabelValues(outcomeBusyWorker).Inc()
+	return candidates[rand.Intn(len(candidates))], nil
 }
 
-// Get the list of workers that have a specific label
-func getWorkersWithLabel(label string, r *redis.Client, c context.Context) (workerIds, error) {
-	key := fmt.Sprintf("task-runners:labels:%s:workers", label)
-	ctx, cancel := context.WithTimeout(c, opTimeoutMilliseconds*time.Millisecond)
-	defer cancel()
-
-	m, err := r.SMembers(ctx, key).Result()
+// Select the worker among the given ones that can load the missing labels at the lowest cost. Returns
+// false if none of them can hold the labels.
+func placeLabels(t *taskRequest, labels []string, wids workerIds, b broker, c context.Context) (workerId, bool, error) {
+	caps, err := b.loadCapacities(c, wids, labels...)
 	if err != nil {
-		slog.Error("Unable to get workers with label!", "error", err, "label", label)
-		return []workerId{}, err
+		slog.Error("Error getting worker label capacity", "error", err)
+		return "", false, err
 	}
-	return stringToWidSlice(m), nil
+	p := choosePlacement(caps, labels, evictionStats{})
+	if p == nil {
+		return "", false, nil
+	}
+	if len(p.Evicted) > 0 {
+		// The best candidate has to evict, so weigh what each would throw out
+		stats, err := b.loadEvictionStats(c, heldLabels(caps))
+		if err != nil {
+			slog.Error("Error getting label demand", "error", err)
+			return "", false, err
+		}
+		p = choosePlacement(caps, labels, stats)
+	}
+
+	outcome := outcomeCapacityWorker
+	switch {
+	case p.Held > 0:
+		outcome = outcomePartialHit
+	case len(p.Evicted) > 0:
+		outcome = outcomeEvictionWorker
+	}
+	slog.Info(
+		"Selecting worker to load labels",
+		"worker", p.Worker,
+		"labels", labels,
+		"task_id", t.TaskID,
+		"reason", p.Reason,
+		"held", p.Held,
+		"evicted", p.Evicted,
+		"evicted_bytes", p.EvictedBytes,
+		"evicted_demand", p.EvictedDemand,
+		"evicted_replicas", p.MinReplicas,
+	)
+	placementCount.WithLabelValues(p.Reason).Inc()
+	dispatchCount.WithLabelValues(outcome).Inc()
+	return p.Worker, true, nil
 }
 
-// Get the list of workers that can take on an additional label
-func workersWithLabelCapacity(r *redis.Client, c context.Context) (workerIds, error) {
-	ctx, cancel := context.WithTimeout(c, opTimeoutMilliseconds*time.Millisecond)
+// Get the list of workers that have a specific label
+func (r *redisClient) workersWithLabel(c context.Context, label string) (workerIds, error) {
+	key := r.keys.label(label)
+	ctx, cancel := context.WithTimeout(c, opTimeout())
 	defer cancel()
 
-	opts := redis.ZRangeBy{
-		Min:    "0",
-		Max:    fmt.Sprintf("%d", maxLabelsPerWorker-1),
-		Offset: 0,
-		Count:  20,
-	}
-	m, err := r.ZRangeByScore(ctx, workersLabelCountKey, &opts).Result()
+	m, err := r.SMembers(ctx, key).Result()
 	if err != nil {
-		slog.Error("Unable to get workers with label capacity!", "error", err)
+		observeRedisError("workers_with_label", err)
+		slog.Error("Unable to get workers with label!", "error", err, "label", label)
 		return []workerId{}, err
 	}
 	return stringToWidSlice(m), nil
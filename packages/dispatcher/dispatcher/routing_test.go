@@ -10,7 +10,7 @@ func TestGetAvailableWorkersWithLabel(t *testing.T) {
 	r, c := mockRedis(true)
 	defer r.Close()
 
-	ws, err := availableWorkersLabel(r, c, "label-1")
+	ws, err := r.availableWorkersLabel(c, "label-1")
 	if err != nil {
 		t.Fatalf("Error getting available workers with label: %v", err)
 	}
@@ -26,7 +26,7 @@ func TestGetAvailableWorkers(t *testing.T) {
 	r, c := mockRedis(true)
 	defer r.Close()
 
-	ws, err := availableWorkers(r, c, false)
+	ws, err := r.availableWorkers(c, false)
 	if err != nil {
 		t.Fatalf("Error getting available workers: %v", err)
 	}
@@ -44,7 +44,7 @@ func TestGetWorkersWithLabel(t *testing.T) {
 	defer r.Close()
 
 	label := "label-1"
-	workers, err := getWorkersWithLabel(label, r, c)
+	workers, err := r.workersWithLabel(c, label)
 	if err != nil {
 		t.Fatalf("Error getting workers with label %s: %v", label, err)
 	}
@@ -98,7 +98,7 @@ func TestSelectWorkerWithLabel(t *testing.T) {
 	if wid != "work1" {
 		t.Errorf("Expected worker work1, got: %s", wid)
 	}
-	a, err := wid.isAvailable(r, c)
+	a, err := r.isAvailable(c, wid)
 	if err != nil {
 		t.Fatalf("Error checking worker availability: %v", err)
 	}
@@ -128,19 +128,53 @@ func TestSelectWorkerWithoutLabel(t *testing.T) {
 	}
 }
 
-// Test getting workers that have capacity for more labels
-func TestGetWorkersWithCapacity(t *testing.T) {
-	r, c := mockRedis(true)
-	defer r.Close()
+// Test that the single label and the labels list are merged, keeping the single label first
+func TestTaskLabels(t *testing.T) {
+	tr := taskRequest{Label: "a", Labels: []string{"b", "a", ""}}
+	tr.normalizeLabels()
+	if !slices.Equal(tr.Labels, []string{"a", "b"}) || tr.Label != "a" {
+		t.Errorf("Unexpected labels: %q %v", tr.Label, tr.Labels)
+	}
 
-	ws, err := workersWithLabelCapacity(r, c)
-	if err != nil {
-		t.Fatalf("Error getting workers with capacity: %v", err)
+	tr = taskRequest{Labels: []string{"c", "d"}}
+	tr.normalizeLabels()
+	if tr.Label != "c" {
+		t.Errorf("Expected the first label as the single label, got %q", tr.Label)
 	}
-	if len(ws) != 2 {
-		t.Errorf("Expected 2 workers with capacity, got %d", len(ws))
+
+	tr = taskRequest{}
+	tr.normalizeLabels()
+	if tr.Label != "" || tr.Labels != nil {
+		t.Errorf("Expected no labels, got %q %v", tr.Label, tr.Labels)
 	}
-	if !slices.Contains(ws, "work2") || !slices.Contains(ws, "u-work2") {
-		t.Errorf("Expected workers work2 and u-work2 with capacity, got: %v", ws)
+}
+
+// Test routing tasks that need several labels on the same worker
+func TestSelectWorkerMultipleLabels(t *testing.T) {
+	r, c := mockRedis(true)
+	defer r.Close()
+
+	cases := []struct {
+		label  string
+		labels []string
+		want   workerId
+	}{
+		// work1 holds both labels
+		{"", []string{"label-1", "label-3"}, "work1"},
+		// Nobody holds both, but work2 holds label-2 and has room for one more
+		{"label-2", []string{"label-9"}, "work2"},
+		// work1 holds label-1 but is full, and work2 has no room for two labels
+		{"", []string{"label-1", "label-9"}, "all"},
+	}
+	for _, tc := range cases {
+		tr := taskRequest{TaskID: "multi", Label: tc.label, Labels: tc.labels}
+		tr.normalizeLabels()
+		wid, err := selectLabeledQueue(&tr, r, c)
+		if err != nil {
+			t.Fatalf("Error selecting labeled queue: %v", err)
+		}
+		if wid != tc.want {
+			t.Errorf("Labels %v: expected %s, got %s", tr.Labels, tc.want, wid)
+		}
 	}
 }
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Wakes up the fair scheduler when a task is queued for a tenant, without blocking the request.
+type feedSignal chan struct{}
+
+var fairFeed = make(feedSignal, 1)
+
+func (f feedSignal) notify() {
+	select {
+	case f <- struct{}{}:
+	default:
+	}
+}
+
+// Deficit round-robin over the tenants waiting for one common queue. On its turn, a tenant earns its
+// weight in credit and moves one task per whole credit. A tenant whose queue empties loses its credit,
+// so idle tenants cannot save it up for a burst.
+type drrQueue struct {
+	ring    []string
+	next    int
+	deficit map[string]float64
+}
+
+// Follow the tenants that have tasks waiting: drop the ones that left, and add the new ones at the
+// end of the round.
+func (d *drrQueue) sync(tenants []string) {
+	for i := len(d.ring) - 1; i >= 0; i-- {
+		if !slices.Contains(tenants, d.ring[i]) {
+			d.remove(d.ring[i])
+		}
+	}
+	slices.Sort(tenants)
+	for _, t := range tenants {
+		if !slices.Contains(d.ring, t) {
+			d.ring = append(d.ring, t)
+		}
+	}
+}
+
+func (d *drrQueue) remove(tenant string) {
+	i := slices.Index(d.ring, tenant)
+	if i < 0 {
+		return
+	}
+	d.ring = slices.Delete(d.ring, i, i+1)
+	delete(d.deficit, tenant)
+	if i < d.next {
+		d.next--
+	}
+	if d.next >= len(d.ring) {
+		d.next = 0
+	}
+}
+
+// Feeds the common queues from the tenant queues, keeping a few tasks in each common queue for the
+// workers to take. Each dispatcher replica runs one, with its own round-robin state. The tenant
+// queues are shared and every task is moved atomically, so replicas only compete for the tasks.
+type fairScheduler struct {
+	r      *redisClient
+	queues map[workerId]*drrQueue
+}
+
+func newFairScheduler(r *redisClient) *fairScheduler {
+	return &fairScheduler{r: r, queues: map[workerId]*drrQueue{}}
+}
+
+// Feed the common queues periodically, and whenever a task is queued for a tenant, until the context
+// is done. It keeps running when fair scheduling is disabled, to drain the tenant queues.
+func (s *fairScheduler) run(c context.Context) {
+	ticker := time.NewTicker(fairFeedIntervalMilliseconds * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.Done():
+			return
+		case <-ticker.C:
+		case <-fairFeed:
+		}
+		s.feed(c)
+	}
+}
+
+// Top up every common queue that has tenant queues. Errors are logged, and the next pass retries.
+func (s *fairScheduler) feed(c context.Context) {
+	ctx, cancel := context.WithTimeout(c, opTimeout())
+	queues, err := s.r.SMembers(ctx, s.r.keys.fairQueues()).Result()
+	cancel()
+	if err != nil {
+		observeRedisError("fair_feed", err)
+		slog.Error("Unable to list fair queues!", "error", err)
+		return
+	}
+	for _, q := range queues {
+		if _, err := s.feedQueue(c, workerId(q)); err != nil {
+			observeRedisError("fair_feed", err)
+			slog.Error("Unable to feed common queue!", "error", err, "queue", q)
+		}
+	}
+}
+
+// Move tasks from the tenant queues to a common queue by deficit round-robin, until the common queue
+// holds the configured depth or the tenant queues are empty. Returns the number of tasks moved.
+func (s *fairScheduler) feedQueue(c context.Context, q workerId) (int, error) {
+	cfg := currentConfig().Scheduling
+	ctx, cancel := context.WithTimeout(c, opTimeout())
+	defer cancel()
+	n, err := s.r.LLen(ctx, s.r.keys.queue(q)).Result()
+	if err != nil {
+		return 0, err
+	}
+	space := min(int64(cfg.CommonQueueDepth)-n, fairFeedBatch)
+	if space <= 0 {
+		return 0, nil
+	}
+	tenants, err := s.r.SMembers(ctx, s.r.keys.tenants(q)).Result()
+	if err != nil {
+		return 0, err
+	}
+	d, ok := s.queues[q]
+	if !ok {
+		d = &drrQueue{deficit: map[string]float64{}}
+		s.queues[q] = d
+	}
+	d.sync(tenants)
+
+	moved := 0
+	for space > 0 && len(d.ring) > 0 {
+		tenant := d.ring[d.next]
+		if d.deficit[tenant] < 1 {
+			d.deficit[tenant] += cfg.weight(tenant)
+		}
+		empty := false
+		for d.deficit[tenant] >= 1 && space > 0 {
+			raw, err := s.r.LMove(ctx, s.r.keys.tenantQueue(q, tenant), s.r.keys.queue(q), "LEFT", "RIGHT").Result()
+			if errors.Is(err, redis.Nil) {
+				empty = true
+				break
+			}
+			if err != nil {
+				return moved, err
+			}
+			d.deficit[tenant]--
+			space--
+			moved++
+			s.observeWait(ctx, tenant, raw)
+		}
+		if empty {
+			d.remove(tenant)
+			if err := s.release(ctx, s.r.keys.tenants(q), tenant, s.r.keys.tenantQueue(q, tenant)); err != nil {
+				return moved, err
+			}
+			continue
+		}
+		if d.deficit[tenant] < 1 {
+			d.next = (d.next + 1) % len(d.ring)
+		}
+	}
+	if len(d.ring) == 0 {
+		delete(s.queues, q)
+		return moved, s.release(ctx, s.r.keys.fairQueues(), string(q), s.r.keys.tenants(q))
+	}
+	return moved, nil
+}
+
+// Remove a member from a set once the key it tracks is empty. A task queued concurrently either adds
+// the member back after the removal, or is seen by the check, which restores it.
+func (s *fairScheduler) release(ctx context.Context, set, member, tracked string) error {
+	if err := s.r.SRem(ctx, set, member).Err(); err != nil {
+		return err
+	}
+	n, err := s.r.Exists(ctx, tracked).Result()
+	if err != nil || n == 0 {
+		return err
+	}
+	return s.r.SAdd(ctx, set, member).Err()
+}
+
+// Record how long a task waited in its tenant queue.
+func (s *fairScheduler) observeWait(ctx context.Context, tenant, raw string) {
+	var t taskRequest
+	if err := json.Unmarshal([]byte(raw), &t); err != nil {
+		return
+	}
+	pipe := s.r.Pipeline()
+	at := pipe.HGet(ctx, s.r.keys.tenantEnqueued(), t.TaskID)
+	pipe.HDel(ctx, s.r.keys.tenantEnqueued(), t.TaskID)
+	if _, err := pipe.Exec(ctx); err != nil {
+		observeRedisError("fair_feed", err)
+		return
+	}
+	ms, err := strconv.ParseInt(at.Val(), 10, 64)
+	if err != nil {
+		return
+	}
+	tenantQueueWait.WithLabelValues(tenantMetricLabel(tenant)).Observe(time.Since(time.UnixMilli(ms)).Seconds())
+}
+
+// Tasks waiting in the tenant queues of each of the given common queues, by tenant.
+func tenantBacklogs(r *redisClient, c context.Context, queues workerIds) (map[workerId]map[string]int64, error) {
+	ctx, cancel := context.WithTimeout(c, opTimeout())
+	defer cancel()
+	pipe := r.Pipeline()
+	members := make([]*redis.StringSliceCmd, len(queues))
+	for i, q := range queues {
+		members[i] = pipe.SMembers(ctx, r.keys.tenants(q))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	lens := make([][]*redis.IntCmd, len(queues))
+	for i, q := range queues {
+		for _, t := range members[i].Val() {
+			lens[i] = append(lens[i], pipe.LLen(ctx, r.keys.tenantQueue(q, t)))
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	out := make(map[workerId]map[string]int64, len(queues))
+	for i, q := range queues {
+		out[q] = map[string]int64{}
+		for j, t := range members[i].Val() {
+			out[q][t] = lens[i][j].Val()
+		}
+	}
+	return out, nil
+}
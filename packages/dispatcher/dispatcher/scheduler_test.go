@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Enable fair scheduling with the given common queue depth and tenant weights for the test.
+func setFairConfig(t *testing.T, depth int, weights map[string]float64) {
+	t.Helper()
+	cfg := defaultConfig()
+	cfg.Scheduling.Fair = true
+	cfg.Scheduling.CommonQueueDepth = depth
+	cfg.Scheduling.TenantWeights = weights
+	setConfig(cfg)
+	t.Cleanup(func() { setConfig(defaultConfig()) })
+}
+
+// Queue tasks for a tenant on the common queue, with IDs <tenant>-<n>.
+func queueTenantTasks(t *testing.T, r *redisClient, c context.Context, tenant string, n int) {
+	t.Helper()
+	for i := range n {
+		tr := &taskRequest{TaskID: tenant + "-" + string(rune('0'+i)), TaskType: "test-task", Tenant: tenant}
+		if err := poolQueue("").sendTask(tr, r, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Run the scheduler like workers taking one task at a time from the common queue, and return the
+// tenants of the tasks in the order the workers got them.
+func drainFairly(t *testing.T, r *redisClient, c context.Context, s *fairScheduler) []string {
+	t.Helper()
+	order := []string{}
+	for range 100 {
+		if _, err := s.feedQueue(c, poolQueue("")); err != nil {
+			t.Fatal(err)
+		}
+		raw, err := r.LPop(c, r.keys.queue(poolQueue(""))).Result()
+		if err != nil {
+			return order
+		}
+		var tr taskRequest
+		json.Unmarshal([]byte(raw), &tr)
+		order = append(order, tr.tenant())
+	}
+	t.Fatal("Expected the tenant queues to drain")
+	return nil
+}
+
+// Test that a burst from one tenant does not delay the others
+func TestFairSchedulingRoundRobin(t *testing.T) {
+	r, c := mockRedis(false)
+	defer r.Close()
+	setFairConfig(t, 1, map[string]float64{})
+
+	queueTenantTasks(t, r, c, "a", 4)
+	queueTenantTasks(t, r, c, "b", 2)
+	if err := poolQueue("").sendTask(&taskRequest{TaskID: "anon", TaskType: "test-task"}, r, c); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := r.LLen(c, r.keys.queue(poolQueue(""))).Result(); n != 0 {
+		t.Fatalf("Expected the tasks to wait in their tenant queues, got %d in the common queue", n)
+	}
+	if info, _ := getTaskInfo(r, c, "a-0"); info == nil || info.Status != taskQueued || info.Queue != "all" {
+		t.Errorf("Expected the task to be queued for the common queue, got %+v", info)
+	}
+
+	order := drainFairly(t, r, c, newFairScheduler(r))
+	exp := []string{"a", "b", "default", "a", "b", "a", "a"}
+	if !slices.Equal(order, exp) {
+		t.Errorf("Expected round-robin order %v, got %v", exp, order)
+	}
+	if queues, _ := r.SMembers(c, r.keys.fairQueues()).Result(); len(queues) != 0 {
+		t.Errorf("Expected the drained common queue to be released, got %v", queues)
+	}
+	if waiting, _ := r.HLen(c, r.keys.tenantEnqueued()).Result(); waiting != 0 {
+		t.Errorf("Expected no enqueue times left, got %d", waiting)
+	}
+}
+
+// Test that tenants get the common queue in proportion to their weights
+func TestFairSchedulingWeights(t *testing.T) {
+	r, c := mockRedis(false)
+	defer r.Close()
+	setFairConfig(t, 1, map[string]float64{"gold": 2, "bronze": 0.5})
+
+	queueTenantTasks(t, r, c, "gold", 6)
+	queueTenantTasks(t, r, c, "silver", 3)
+	queueTenantTasks(t, r, c, "bronze", 2)
+	order := drainFairly(t, r, c, newFairScheduler(r))
+	exp := []string{
+		"gold", "gold", "silver",
+		"bronze", "gold", "gold", "silver",
+		"gold", "gold", "silver",
+		"bronze",
+	}
+	if !slices.Equal(order, exp) {
+		t.Errorf("Expected weighted order %v, got %v", exp, order)
+	}
+}
+
+// Test that the scheduler keeps the configured number of tasks in the common queue
+func TestFairSchedulingDepth(t *testing.T) {
+	r, c := mockRedis(false)
+	defer r.Close()
+	setFairConfig(t, 3, map[string]float64{})
+	queueTenantTasks(t, r, c, "a", 5)
+
+	s := newFairScheduler(r)
+	s.feed(c)
+	if n, _ := r.LLen(c, r.keys.queue(poolQueue(""))).Result(); n != 3 {
+		t.Errorf("Expected 3 tasks in the common queue, got %d", n)
+	}
+	s.feed(c)
+	if n, _ := r.LLen(c, r.keys.tenantQueue(poolQueue(""), "a")).Result(); n != 2 {
+		t.Errorf("Expected 2 tasks to keep waiting, got %d", n)
+	}
+
+	// Tasks already waiting are still fed after fair scheduling is disabled, while new ones skip it
+	setConfig(defaultConfig())
+	r.Del(c, r.keys.queue(poolQueue("")))
+	queueTenantTasks(t, r, c, "b", 1)
+	s.feed(c)
+	if n, _ := r.LLen(c, r.keys.queue(poolQueue(""))).Result(); n != 3 {
+		t.Errorf("Expected the new task and the waiting ones in the common queue, got %d", n)
+	}
+}
+
+// Test that tasks for workers and pools are only queued per tenant for common queues
+func TestFairSchedulingWorkerQueues(t *testing.T) {
+	r, c := mockRedis(true)
+	defer r.Close()
+	setFairConfig(t, 1, map[string]float64{})
+
+	tr := &taskRequest{TaskID: "direct", TaskType: "test-task", Label: "label-1", Tenant: "a"}
+	if err := workerId("work1").sendTask(tr, r, c); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := r.LLen(c, r.keys.queue("work1")).Result(); n != 1 {
+		t.Errorf("Expected the task in the worker queue, got %d", n)
+	}
+	tr = &taskRequest{TaskID: "pooled", TaskType: "test-task", Tenant: "a"}
+	if err := poolQueue("gpu").sendTask(tr, r, c); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := r.LLen(c, r.keys.tenantQueue(poolQueue("gpu"), "a")).Result(); n != 1 {
+		t.Errorf("Expected the task in the pool's tenant queue, got %d", n)
+	}
+}
+
+// Sample count of the tenant wait histogram for a tenant label.
+func tenantWaitCount(t *testing.T, tenant string) uint64 {
+	t.Helper()
+	m := &dto.Metric{}
+	if err := tenantQueueWait.WithLabelValues(tenant).(prometheus.Metric).Write(m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+// Test the tenant wait and depth metrics, and that the backlog check counts the tenant queues
+func TestFairSchedulingMetrics(t *testing.T) {
+	r, c := mockRedis(true)
+	defer r.Close()
+	setFairConfig(t, 1, map[string]float64{"gold": 2})
+	cfg := *currentConfig()
+	cfg.Health.BacklogDegraded = 3
+	setConfig(&cfg)
+
+	queueTenantTasks(t, r, c, "gold", 2)
+	queueTenantTasks(t, r, c, "t1", 1)
+	queueTenantTasks(t, r, c, "t2", 1)
+
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(newClusterCollector(r))
+	exp := `
+# HELP dispatcher_tenant_queue_depth Number of tasks waiting in tenant queues for each common queue, by tenant.
+# TYPE dispatcher_tenant_queue_depth gauge
+dispatcher_tenant_queue_depth{queue="all",tenant="gold"} 2
+dispatcher_tenant_queue_depth{queue="all",tenant="other"} 2
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(exp), "dispatcher_tenant_queue_depth"); err != nil {
+		t.Error(err)
+	}
+	if h := checkBacklog(r, c, currentConfig().Health); h.Status != statusDegraded || h.Details["length"] != int64(4) {
+		t.Errorf("Expected the tenant queues to count as backlog, got %+v", h)
+	}
+
+	gold, other := tenantWaitCount(t, "gold"), tenantWaitCount(t, otherTenantsLabel)
+	drainFairly(t, r, c, newFairScheduler(r))
+	if n := tenantWaitCount(t, "gold") - gold; n != 2 {
+		t.Errorf("Expected 2 waits for the configured tenant, got %d", n)
+	}
+	if n := tenantWaitCount(t, otherTenantsLabel) - other; n != 2 {
+		t.Errorf("Expected the other tenants to share a series, got %d waits", n)
+	}
+}
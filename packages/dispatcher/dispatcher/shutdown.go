@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Set when the dispatcher is shutting down. While draining, the health check fails and no new tasks are accepted.
+var draining atomic.Bool
+
+// Wrap a task handler so that it rejects new work while the dispatcher is draining. Callers get a 503
+// with a Retry-After header, so they can retry against another replica.
+func rejectWhenDraining(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if draining.Load() {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Dispatcher is shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// Serve on the listener until the context is cancelled, then drain: mark the dispatcher as draining,
+// wait for the drain delay so load balancers can stop sending traffic, and shut the server down,
+// letting in-flight requests finish. Synchronous waits are bounded by the task timeout.
+func serveUntilDone(c context.Context, srv *http.Server, ln net.Listener) error {
+	errs := make(chan error, 1)
+	go func() {
+		if srv.TLSConfig != nil {
+			errs <- srv.ServeTLS(ln, "", "")
+		} else {
+			errs <- srv.Serve(ln)
+		}
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-c.Done():
+	}
+
+	draining.Store(true)
+	cfg := currentConfig()
+	delay := time.Duration(cfg.Timeouts.DrainDelaySeconds) * time.Second
+	slog.Info("Shutdown requested, draining", "drain_delay", delay)
+	time.Sleep(delay)
+
+	// Leave a little room beyond the task timeout for waits that started just before draining
+	ctx, cancel := context.WithTimeout(context.Background(), taskTimeout()+shutdownMarginSeconds*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Error("In-flight requests did not finish before shutdown", "error", err)
+		return err
+	}
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	slog.Info("All in-flight requests finished")
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Test that health and task endpoints report draining with a 503
+func TestRejectWhenDraining(t *testing.T) {
+	draining.Store(true)
+	defer draining.Store(false)
+
+	w := httptest.NewRecorder()
+	healthCheckAPI(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected health check to fail while draining, got %d", w.Code)
+	}
+
+	called := false
+	h := rejectWhenDraining(func(w http.ResponseWriter, r *http.Request) { called = true })
+	w = httptest.NewRecorder()
+	h(w, httptest.NewRequest(http.MethodPost, "/send-task", nil))
+	if called || w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected task to be rejected while draining, got %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("Expected Retry-After header on rejected task")
+	}
+}
+
+// Test that a synchronous task in flight when shutdown starts still receives its result
+func TestShutdownWaitsForInFlightTasks(t *testing.T) {
+	defer setConfig(defaultConfig())
+	defer draining.Store(false)
+	cfg := defaultConfig()
+	cfg.Timeouts.DrainDelaySeconds = 0
+	setConfig(cfg)
+
+	r, c := mockRedis(true)
+	defer r.Close()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: newRouter(r)}
+	ctx, cancel := context.WithCancel(c)
+	served := make(chan error, 1)
+	go func() { served <- serveUntilDone(ctx, srv, ln) }()
+
+	// Start a synchronous task, then request shutdown while it waits for its result
+	body, _ := json.Marshal(taskRequest{TaskID: "drain-task", Label: "label-1", TaskType: "test", Parameters: "{}", ReturnResult: true})
+	type result struct {
+		code int
+		body string
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		rsp, err := http.Post(fmt.Sprintf("http://%s/run-task", ln.Addr()), "application/json", bytes.NewReader(body))
+		if err != nil {
+			done <- result{err: err}
+			return
+		}
+		defer rsp.Body.Close()
+		b, _ := io.ReadAll(rsp.Body)
+		done <- result{code: rsp.StatusCode, body: string(b)}
+	}()
+
+	// Wait until the task is queued before shutting down
+	for i := 0; ; i++ {
+		if n, _ := r.LLen(c, r.keys.queue(workerId("work1"))).Result(); n == 1 {
+			break
+		}
+		if i > 100 {
+			t.Fatal("Task was never queued")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	time.Sleep(100 * time.Millisecond)
+	if !draining.Load() {
+		t.Error("Expected dispatcher to be draining")
+	}
+
+	if _, err := r.Publish(c, r.keys.results("drain-task"), "finished").Result(); err != nil {
+		t.Fatal(err)
+	}
+	res := <-done
+	if res.err != nil || res.code != http.StatusOK {
+		t.Fatalf("Expected in-flight task to succeed, got %d %v", res.code, res.err)
+	}
+	if !bytes.Contains([]byte(res.body), []byte("finished")) {
+		t.Errorf("Expected task result in response, got %s", res.body)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("Expected clean shutdown, got: %v", err)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Task states recorded in the task status hash. The dispatcher records queued tasks, and workers
+// record the rest.
+const (
+	taskQueued    = "queued"
+	taskRunning   = "running"
+	taskCompleted = "completed"
+	taskFailed    = "failed"
+)
+
+// Last known status of a task, returned by GET /tasks/{id}.
+type taskInfo struct {
+	TaskID   string `json:"task_id"`
+	Status   string `json:"status"`
+	Queue    string `json:"queue"`
+	WorkerID string `json:"worker_id,omitempty"`
+	Result   string `json:"result,omitempty"`
+	// Results that are not text, with their content type, like in /run-task responses.
+	ResultData  []byte `json:"result_data,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+}
+
+// Record that a task was queued, as part of a pipeline that pushes it.
+func recordQueued(pipe redis.Pipeliner, ctx context.Context, keys keyspace, id string, wid workerId) {
+	key := keys.taskStatus(id)
+	pipe.HSet(ctx, key, "status", taskQueued, "queue", string(wid))
+	pipe.Expire(ctx, key, taskStatusTTLSeconds*time.Second)
+}
+
+// Get the status of a task. Returns nil if there is no record of it.
+func getTaskInfo(r *redisClient, c context.Context, id string) (*taskInfo, error) {
+	ctx, cancel := context.WithTimeout(c, opTimeout())
+	defer cancel()
+	m, err := r.HGetAll(ctx, r.keys.taskStatus(id)).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		observeRedisError("task_status", err)
+		return nil, err
+	}
+	if len(m) == 0 {
+		return nil, nil
+	}
+	result, err := payloads.resolveResult(c, m["result"])
+	if err != nil {
+		return nil, err
+	}
+	d, err := deliverResult(result)
+	if err != nil {
+		return nil, err
+	}
+	return &taskInfo{
+		TaskID:      id,
+		Status:      m["status"],
+		Queue:       m["queue"],
+		WorkerID:    m["worker"],
+		Result:      d.Text,
+		ResultData:  d.Data,
+		ContentType: d.ContentType,
+	}, nil
+}
+
+// Which of the given blobs hold the parameters of tasks whose status is still queued. Every tracked
+// blob is checked on each sweep: the statuses of queued tasks are refreshed, so they do not expire
+// while the task waits, and blobs of tasks that left the queue or whose status expired are no longer
+// tracked.
+func (r *redisClient) queuedParameters(c context.Context, keys []string) (map[string]bool, error) {
+	ctx, cancel := context.WithTimeout(c, opTimeout())
+	defer cancel()
+	index := r.keys.queuedParameters()
+	tracked, err := r.HGetAll(ctx, index).Result()
+	if err != nil {
+		observeRedisError("queued_parameters", err)
+		return nil, err
+	}
+	queued := map[string]bool{}
+	if len(tracked) == 0 {
+		return queued, nil
+	}
+	pipe := r.Pipeline()
+	statuses := map[string]*redis.StringCmd{}
+	for ref, id := range tracked {
+		statuses[ref] = pipe.HGet(ctx, r.keys.taskStatus(id), "status")
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		observeRedisError("queued_parameters", err)
+		return nil, err
+	}
+	pipe = r.Pipeline()
+	done := []string{}
+	for ref, cmd := range statuses {
+		if cmd.Val() == taskQueued {
+			pipe.Expire(ctx, r.keys.taskStatus(tracked[ref]), taskStatusTTLSeconds*time.Second)
+			if slices.Contains(keys, ref) {
+				queued[ref] = true
+			}
+		} else {
+			done = append(done, ref)
+		}
+	}
+	if len(done) > 0 {
+		pipe.HDel(ctx, index, done...)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		observeRedisError("queued_parameters", err)
+		return nil, err
+	}
+	return queued, nil
+}
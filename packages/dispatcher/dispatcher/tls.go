@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Paths to the certificate, key, and CA bundle used for a TLS connection.
+type tlsFiles struct {
+	CertFile string
+	KeyFile  string
+	CAFile   string
+}
+
+// Holds the currently loaded certificates and reloads them when the files change.
+type certStore struct {
+	files    tlsFiles
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	pool     *x509.CertPool
+	modTimes map[string]time.Time
+}
+
+// Create a certificate store and load the initial certificates from disk.
+func newCertStore(f tlsFiles) (*certStore, error) {
+	if (f.CertFile == "") != (f.KeyFile == "") {
+		return nil, errors.New("certificate and key files must be provided together")
+	}
+	s := &certStore{files: f, modTimes: map[string]time.Time{}}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Load the certificates from disk, keeping the previous ones if loading fails.
+func (s *certStore) reload() error {
+	var cert *tls.Certificate
+	var pool *x509.CertPool
+	if s.files.CertFile != "" {
+		c, err := tls.LoadX509KeyPair(s.files.CertFile, s.files.KeyFile)
+		if err != nil {
+			return fmt.Errorf("loading key pair: %w", err)
+		}
+		cert = &c
+	}
+	if s.files.CAFile != "" {
+		pem, err := os.ReadFile(s.files.CAFile)
+		if err != nil {
+			return fmt.Errorf("reading CA file: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in CA file %s", s.files.CAFile)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cert = cert
+	s.pool = pool
+	for _, p := range s.paths() {
+		if st, err := os.Stat(p); err == nil {
+			s.modTimes[p] = st.ModTime()
+		}
+	}
+	return nil
+}
+
+// Paths of all the files tracked by the store.
+func (s *certStore) paths() []string {
+	out := []string{}
+	for _, p := range []string{s.files.CertFile, s.files.KeyFile, s.files.CAFile} {
+		if p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// Check whether any of the tracked files changed since they were last loaded.
+func (s *certStore) changed() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, p := range s.paths() {
+		st, err := os.Stat(p)
+		if err != nil {
+			continue
+		}
+		if !st.ModTime().Equal(s.modTimes[p]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *certStore) certificate() *tls.Certificate {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cert
+}
+
+func (s *certStore) caPool() *x509.CertPool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.pool
+}
+
+// Build a server TLS config that always uses the most recently loaded certificates.
+func (s *certStore) serverConfig(clientAuth tls.ClientAuthType) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert := s.certificate()
+			if cert == nil {
+				return nil, errors.New("no server certificate loaded")
+			}
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+				ClientCAs:    s.caPool(),
+				ClientAuth:   clientAuth,
+			}, nil
+		},
+	}
+}
+
+// Build a client TLS config from the currently loaded certificates. The CA bundle, if any,
+// replaces the system roots, and the certificate, if any, is presented to the server.
+func (s *certStore) clientConfig(serverName string) *tls.Config {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+		RootCAs:    s.caPool(),
+	}
+	if cert := s.certificate(); cert != nil {
+		cfg.Certificates = []tls.Certificate{*cert}
+	}
+	return cfg
+}
+
+// Reload the certificates on SIGHUP or when the files change on disk, until the context is done.
+func (s *certStore) watch(c context.Context, interval time.Duration) {
+	watchForReload(c, interval, s.changed, s.reloadAndLog)
+}
+
+func (s *certStore) reloadAndLog(reason string) {
+	if err := s.reload(); err != nil {
+		slog.Error("Unable to reload certificates!", "error", err, "reason", reason)
+		return
+	}
+	slog.Info("Reloaded certificates", "reason", reason, "files", s.paths())
+}
+
+// Parse the client certificate verification mode for the HTTP server.
+func parseClientAuth(mode string) (tls.ClientAuthType, error) {
+	switch strings.ToLower(mode) {
+	case "", "none":
+		return tls.NoClientCert, nil
+	case "optional":
+		return tls.VerifyClientCertIfGiven, nil
+	case "require":
+		return tls.RequireAndVerifyClientCert, nil
+	}
+	return tls.NoClientCert, fmt.Errorf("invalid client auth mode: %q", mode)
+}
+
+// Dial function for the Redis client that builds a fresh TLS config on every new connection,
+// so that reloaded certificates are picked up without recreating the client.
+func tlsDialer(s *certStore, serverName string) func(context.Context, string, string) (net.Conn, error) {
+	d := &net.Dialer{Timeout: 5 * time.Second, KeepAlive: 5 * time.Minute}
+	return func(c context.Context, network, addr string) (net.Conn, error) {
+		name := serverName
+		if name == "" {
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			name = host
+		}
+		td := &tls.Dialer{NetDialer: d, Config: s.clientConfig(name)}
+		return td.DialContext(c, network, addr)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCert creates a certificate for the given common name, signed by the parent (or
+// self-signed when parent is nil), and writes the PEM encoded cert and key to dir.
+func writeTestCert(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, tlsFiles) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("Error creating certificate: %v", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Error marshalling key: %v", err)
+	}
+
+	f := tlsFiles{
+		CertFile: filepath.Join(dir, name+".crt"),
+		KeyFile:  filepath.Join(dir, name+".key"),
+	}
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := os.WriteFile(f.CertFile, certPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(f.KeyFile, keyPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key, f
+}
+
+// Test that the server requires and verifies client certificates signed by the CA.
+func TestServerMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey, caFiles := writeTestCert(t, dir, "ca", nil, nil)
+	_, _, srvFiles := writeTestCert(t, dir, "server", ca, caKey)
+	_, _, cliFiles := writeTestCert(t, dir, "client", ca, caKey)
+	srvFiles.CAFile = caFiles.CertFile
+	cliFiles.CAFile = caFiles.CertFile
+
+	srvStore, err := newCertStore(srvFiles)
+	if err != nil {
+		t.Fatalf("Error loading server certificates: %v", err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(healthCheckAPI))
+	srv.TLS = srvStore.serverConfig(tls.RequireAndVerifyClientCert)
+	srv.StartTLS()
+	defer srv.Close()
+
+	cliStore, err := newCertStore(cliFiles)
+	if err != nil {
+		t.Fatalf("Error loading client certificates: %v", err)
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: cliStore.clientConfig("localhost")}}
+	rsp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("Expected mutual TLS request to succeed: %v", err)
+	}
+	rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", rsp.StatusCode)
+	}
+
+	// Without a client certificate the handshake must fail
+	anon := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: cliStore.caPool()}}}
+	if rsp, err := anon.Get(srv.URL); err == nil {
+		rsp.Body.Close()
+		t.Error("Expected request without client certificate to fail")
+	}
+}
+
+// Test that the store picks up certificates replaced on disk.
+func TestCertStoreReload(t *testing.T) {
+	dir := t.TempDir()
+	first, _, files := writeTestCert(t, dir, "server", nil, nil)
+	s, err := newCertStore(files)
+	if err != nil {
+		t.Fatalf("Error loading certificates: %v", err)
+	}
+	if s.changed() {
+		t.Error("Expected no changes right after loading")
+	}
+
+	// Overwrite the same files with a new certificate, making sure the mod time moves
+	second, _, _ := writeTestCert(t, dir, "server", nil, nil)
+	later := time.Now().Add(time.Minute)
+	for _, p := range s.paths() {
+		if err := os.Chtimes(p, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !s.changed() {
+		t.Fatal("Expected store to detect changed files")
+	}
+	s.reloadAndLog("test")
+
+	leaf, err := x509.ParseCertificate(s.certificate().Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf.SerialNumber.Cmp(second.SerialNumber) != 0 || leaf.SerialNumber.Cmp(first.SerialNumber) == 0 {
+		t.Error("Expected the reloaded certificate to be the new one")
+	}
+}
+
+func TestParseClientAuth(t *testing.T) {
+	cases := map[string]tls.ClientAuthType{
+		"":         tls.NoClientCert,
+		"none":     tls.NoClientCert,
+		"optional": tls.VerifyClientCertIfGiven,
+		"REQUIRE":  tls.RequireAndVerifyClientCert,
+	}
+	for in, exp := range cases {
+		got, err := parseClientAuth(in)
+		if err != nil || got != exp {
+			t.Errorf("parseClientAuth(%q) = %v, %v; expected %v", in, got, err, exp)
+		}
+	}
+	if _, err := parseClientAuth("sometimes"); err == nil {
+		t.Error("Expected error for invalid client auth mode")
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "dispatcher"
+
+// Get the tracer from the current global provider, so spans follow a provider set after startup.
+func tracer() trace.Tracer {
+	return otel.GetTracerProvider().Tracer(tracerName)
+}
+
+// W3C trace context propagator used for incoming requests and queued tasks.
+var propagator = propagation.TraceContext{}
+
+// Set up the global tracer provider. The exporter is chosen with OTEL_TRACES_EXPORTER: "otlp" sends spans
+// over OTLP/HTTP (configured with the standard OTEL_EXPORTER_OTLP_* variables), "file" appends them as JSON
+// to TRACES_FILE, and "none" or empty disables exporting. Returns a function to flush and stop the provider.
+func setupTracing(c context.Context) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagator)
+
+	var exp sdktrace.SpanExporter
+	var err error
+	switch os.Getenv("OTEL_TRACES_EXPORTER") {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		exp, err = otlptracehttp.New(c)
+	case "file":
+		exp, err = fileExporter(os.Getenv("TRACES_FILE"))
+	default:
+		err = fmt.Errorf("unknown traces exporter: %q", os.Getenv("OTEL_TRACES_EXPORTER"))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(
+		resource.Default(),
+		resource.NewSchemaless(semconv.ServiceName(tracerName)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exp), sdktrace.WithResource(res))
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// Create an exporter that appends spans as JSON lines to a file.
+func fileExporter(path string) (sdktrace.SpanExporter, error) {
+	if path == "" {
+		path = "traces.jsonl"
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return stdouttrace.New(stdouttrace.WithWriter(f))
+}
+
+// Store the trace context of the current span on the task, so that workers can continue the trace.
+func (t *taskRequest) injectTraceContext(c context.Context) {
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(c, carrier)
+	if len(carrier) == 0 {
+		t.TraceContext = nil
+		return
+	}
+	t.TraceContext = map[string]string(carrier)
+}
+
+// Mark the span as failed if there was an error, and end it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Test that the trace from an incoming traceparent header is continued and injected into the queued task
+func TestTracePropagation(t *testing.T) {
+	r, c := mockRedis(true)
+	defer r.Close()
+
+	rec := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	otel.SetTracerProvider(tp)
+	defer tp.Shutdown(c)
+
+	traceId := "4bf92f3577b34da6a3ce929d0e0e4736"
+	body, _ := json.Marshal(taskRequest{TaskID: "trace-task", Label: "label-1", TaskType: "test-task", Parameters: "{}"})
+	req := httptest.NewRequest(http.MethodPost, "/send-task", bytes.NewReader(body))
+	req.Header.Set("traceparent", "00-"+traceId+"-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	dispatchTaskAPI(w, req, r)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d", w.Code)
+	}
+
+	// The queued task carries the trace context
+	raw, err := r.LPop(c, r.keys.queue(workerId("work1"))).Result()
+	if err != nil {
+		t.Fatalf("Error reading queued task: %v", err)
+	}
+	var queued taskRequest
+	if err := json.Unmarshal([]byte(raw), &queued); err != nil {
+		t.Fatal(err)
+	}
+	sc := propagator.Extract(c, propagation.MapCarrier(queued.TraceContext))
+	if got := trace.SpanContextFromContext(sc).TraceID().String(); got != traceId {
+		t.Errorf("Expected queued task trace ID %s, got %s", traceId, got)
+	}
+
+	// Spans for the request, routing, and enqueue belong to the same trace
+	names := []string{}
+	for _, s := range rec.Ended() {
+		if s.SpanContext().TraceID().String() != traceId {
+			t.Errorf("Span %s has unexpected trace ID %s", s.Name(), s.SpanContext().TraceID())
+		}
+		names = append(names, s.Name())
+	}
+	for _, exp := range []string{"send-task", "route", "enqueue"} {
+		if !slices.Contains(names, exp) {
+			t.Errorf("Expected span %s to be recorded, got %v", exp, names)
+		}
+	}
+}
@@ -1,14 +1,126 @@
 package main
 
+import "slices"
+
 // String alias to represent a running worker's ID.
 type workerId string
 type workerIds []workerId
 
+// An admin command pushed to a worker's control queue.
+type workerCommand struct {
+	Command string `json:"command"`
+	Label   string `json:"label,omitempty"`
+}
+
+const commandEvictLabel = "evict_label"
+
 // A request to run a task on a worker.
 type taskRequest struct {
-	TaskID       string `json:"task_id"`
-	TaskType     string `json:"task_type"`
-	Label        string `json:"label"`
-	Parameters   string `json:"parameters_json"`
-	ReturnResult bool   `json:"return_result"`
+	TaskID       string   `json:"task_id"`
+	TaskType     string   `json:"task_type"`
+	Label        string   `json:"label"`
+	Labels       []string `json:"labels,omitempty"`
+	Parameters   string   `json:"parameters_json"`
+	ReturnResult bool     `json:"return_result"`
+	// Parameters in another format than JSON, or compressed, in place of Parameters. Set whenever
+	// ParametersType or ParametersEncoding is; base64 in the JSON wire format.
+	ParametersData []byte `json:"parameters_data,omitempty"`
+	// Content type of ParametersData, msgpack or raw bytes. Empty for JSON.
+	ParametersType string `json:"parameters_type,omitempty"`
+	// Compression of ParametersData: empty or "zstd".
+	ParametersEncoding string `json:"parameters_encoding,omitempty"`
+	// Blob key of the parameters, set by the dispatcher in place of parameters that exceed the blob
+	// threshold. Workers read the parameters from the blob store.
+	ParametersRef string `json:"parameters_ref,omitempty"`
+	// Worker attributes the task requires or prefers. Tasks without one run in the default pool.
+	Selector *taskSelector `json:"selector,omitempty"`
+	// Tenant the task is scheduled for when it waits in a common queue. Tasks without one share the
+	// default tenant.
+	Tenant string `json:"tenant,omitempty"`
+
+	// W3C trace context of the dispatch, set by the dispatcher so that workers can continue the trace.
+	TraceContext map[string]string `json:"trace_context,omitempty"`
+}
+
+// Labels the task needs, from both the single label and the labels list, without duplicates.
+func (t *taskRequest) labels() []string {
+	out := []string{}
+	for _, l := range append([]string{t.Label}, t.Labels...) {
+		if l != "" && !slices.Contains(out, l) {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+// Tenant the task is scheduled for, or the default tenant.
+func (t *taskRequest) tenant() string {
+	if t.Tenant == "" {
+		return defaultTenant
+	}
+	return t.Tenant
+}
+
+// Fill in both label fields from whichever the client set, so that workers that only read the single
+// label still get the first one.
+func (t *taskRequest) normalizeLabels() {
+	t.Labels = t.labels()
+	if len(t.Labels) == 0 {
+		t.Labels = nil
+		return
+	}
+	t.Label = t.Labels[0]
+}
+
+// Worker attributes a task is matched against. Workers must have every required attribute, and
+// workers with the most preferred attributes are tried first.
+type taskSelector struct {
+	Required  map[string]string `json:"required,omitempty"`
+	Preferred map[string]string `json:"preferred,omitempty"`
+}
+
+// Pool the task runs in, from the required pool attribute. Empty for the default pool.
+func (s *taskSelector) pool() string {
+	if s == nil {
+		return ""
+	}
+	return s.Required[poolAttribute]
+}
+
+// Whether the selector requires attributes besides the pool, which the common queue of the pool does
+// not enforce.
+func (s *taskSelector) requiresAttributes() bool {
+	if s == nil {
+		return false
+	}
+	for k := range s.Required {
+		if k != poolAttribute {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether a worker with the given attributes can run the task, and how many preferred attributes it
+// has. Workers only run tasks from their own pool, so workers in a pool never match a selector that
+// does not require it.
+func (s *taskSelector) match(attrs map[string]string) (int, bool) {
+	if attrs[poolAttribute] != s.pool() {
+		return 0, false
+	}
+	if s == nil {
+		return 0, true
+	}
+	for k, v := range s.Required {
+		if attrs[k] != v {
+			return 0, false
+		}
+	}
+	preferred := 0
+	for k, v := range s.Preferred {
+		if attrs[k] == v {
+			preferred++
+		}
+	}
+	return preferred, true
 }
@@ -3,67 +3,123 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"log/slog"
 	"time"
 
-	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func (wid workerId) getQueue() string {
-	return fmt.Sprintf("task-runners:%s:jobs", wid)
-}
-
 // Check if the worker is available by checking if it is in the available workers set
-func (wid workerId) isAvailable(r *redis.Client, c context.Context) (bool, error) {
-	ctx, cancel := context.WithTimeout(c, opTimeoutMilliseconds*time.Millisecond)
+func (r *redisClient) isAvailable(c context.Context, wid workerId) (bool, error) {
+	ctx, cancel := context.WithTimeout(c, opTimeout())
 	defer cancel()
 
-	a, err := r.SIsMember(ctx, availableWorkersKey, string(wid)).Result()
+	a, err := r.SIsMember(ctx, r.keys.available(), string(wid)).Result()
 	if err != nil {
+		observeRedisError("is_available", err)
 		slog.Error("Unable to check worker availability!", "error", err)
 		return false, err
 	}
 	return a, nil
 }
 
-func (wid workerId) sendTask(t *taskRequest, r *redis.Client, c context.Context) error {
-	ctx, cancel := context.WithTimeout(c, opTimeoutMilliseconds*time.Millisecond)
-	defer cancel()
+func (wid workerId) sendTask(t *taskRequest, b broker, c context.Context) (err error) {
+	c, span := tracer().Start(
+		c,
+		"enqueue",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attribute.String("worker.id", string(wid))),
+	)
+	defer func() { endSpan(span, err) }()
+	t.injectTraceContext(c)
 
-	tJson, jsonErr := json.Marshal(t)
+	wire, err := payloads.offloadParameters(c, t)
+	if err != nil {
+		slog.Error("Unable to offload task parameters!", "error", err, "task_id", t.TaskID)
+		return err
+	}
+	tJson, jsonErr := json.Marshal(wire)
 	if jsonErr != nil {
 		slog.Error("JSON serialization error", "error", jsonErr, "task_id", t.TaskID)
 		return jsonErr
 	}
-	_, err := r.RPush(ctx, wid.getQueue(), tJson).Result()
-	if err != nil {
+	return b.enqueue(c, wid, wire, tJson)
+}
+
+// Append a serialized task to a queue and record it as queued, in one transaction. With fair
+// scheduling, tasks for a common queue wait in their tenant's queue until the fair scheduler moves
+// them.
+func (r *redisClient) enqueue(c context.Context, wid workerId, t *taskRequest, raw []byte) error {
+	ctx, cancel := context.WithTimeout(c, opTimeout())
+	defer cancel()
+	fair := currentConfig().Scheduling.Fair && isCommonQueue(wid)
+	pipe := r.TxPipeline()
+	if fair {
+		pipe.RPush(ctx, r.keys.tenantQueue(wid, t.tenant()), raw)
+		pipe.SAdd(ctx, r.keys.tenants(wid), t.tenant())
+		pipe.SAdd(ctx, r.keys.fairQueues(), string(wid))
+		pipe.HSet(ctx, r.keys.tenantEnqueued(), t.TaskID, time.Now().UnixMilli())
+	} else {
+		pipe.RPush(ctx, r.keys.queue(wid), raw)
+	}
+	if wid != poolQueue("") && isCommonQueue(wid) {
+		pipe.SAdd(ctx, r.keys.pools(), string(wid))
+	}
+	recordQueued(pipe, ctx, r.keys, t.TaskID, wid)
+	if t.ParametersRef != "" {
+		pipe.HSet(ctx, r.keys.queuedParameters(), t.ParametersRef, t.TaskID)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		observeRedisError("send_task", err)
 		slog.Error("Unable to send task!", "error", err, "task_id", t.TaskID)
 		return err
 	}
+	if fair {
+		fairFeed.notify()
+	}
 	return nil
 }
 
 // Run a task until completion or timeout, and return the result
-func (wid workerId) runTask(t *taskRequest, r *redis.Client, c context.Context) (string, error) {
-	err := wid.sendTask(t, r, c)
-	if err != nil {
+func (wid workerId) runTask(t *taskRequest, b broker, c context.Context) (result string, err error) {
+	if err := wid.sendTask(t, b, c); err != nil {
 		return "", err
 	}
+	return awaitResult(t, b, c)
+}
 
-	// Wait for task result
-	key := fmt.Sprintf("task-runners:results:%s", t.TaskID)
-	pubsub := r.Subscribe(c, key)
-	defer pubsub.Close()
+// Wait for the result of a queued task, up to the task timeout
+func awaitResult(t *taskRequest, b broker, c context.Context) (result string, err error) {
+	defer func(start time.Time) {
+		observeRunTask(start, err)
+	}(time.Now())
 
-	ctx, cancel := context.WithTimeout(c, taskTimeoutSeconds*time.Second)
+	// Wait for task result
+	c, span := tracer().Start(c, "await-result")
+	defer func() { endSpan(span, err) }()
+	ctx, cancel := context.WithTimeout(c, taskTimeout())
 	defer cancel()
 
-	m, err := pubsub.ReceiveMessage(ctx)
+	result, err = b.receiveResult(ctx, t.TaskID)
+	if err == nil {
+		result, err = payloads.resolveResult(ctx, result)
+	}
 	if err != nil {
 		slog.Error("Error receiving task result", "error", err, "task_id", t.TaskID)
 		return "", err
 	}
+	return result, nil
+}
+
+// Wait for the result of a task on its result channel.
+func (r *redisClient) receiveResult(c context.Context, taskID string) (string, error) {
+	pubsub := r.Subscribe(c, r.keys.results(taskID))
+	defer pubsub.Close()
+	m, err := pubsub.ReceiveMessage(c)
+	if err != nil {
+		return "", err
+	}
 	return m.Payload, nil
 }
 
@@ -13,21 +13,21 @@ func TestIsAvailable(t *testing.T) {
 	r, c := mockRedis(false)
 	defer r.Close()
 
-	_, err := r.SAdd(c, availableWorkersKey, "worker1").Result()
+	_, err := r.SAdd(c, r.keys.available(), "worker1").Result()
 	if err != nil {
 		t.Fatalf("Failed to add worker to available workers: %v", err)
 	}
 	wid := workerId("worker1")
 	wid2 := workerId("worker2")
 
-	a, cErr := wid.isAvailable(r, c)
+	a, cErr := r.isAvailable(c, wid)
 	if cErr != nil {
 		t.Fatalf("Error checking worker availability: %v", cErr)
 	} else if !a {
 		t.Error("Expected worker1 to be available, but it was not")
 	}
 
-	a, cErr = wid2.isAvailable(r, c)
+	a, cErr = r.isAvailable(c, wid2)
 	if cErr != nil {
 		t.Fatalf("Error checking worker2 availability: %v", cErr)
 	} else if a {
@@ -41,7 +41,7 @@ func TestSendTask(t *testing.T) {
 	defer r.Close()
 
 	wid := workerId("worker1")
-	q := wid.getQueue()
+	q := r.keys.queue(wid)
 	tr := taskRequest{
 		TaskID:       "test-task",
 		Label:        "test-label",
@@ -86,7 +86,7 @@ func TestRunTaskSync(t *testing.T) {
 		ReturnResult: true,
 	}
 	wid := workerId("work1")
-	rspChan := fmt.Sprintf("task-runners:results:%s", tr.TaskID)
+	rspChan := r.keys.results(tr.TaskID)
 	msg := "Task completed successfully - TEST"
 
 	// dispatch to simulate worker responding
@@ -0,0 +1,54 @@
+package taskrunner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Storage for payloads too large to pass through the broker, shared with the dispatcher. Keys are
+// "<namespace>/<name>", using letters, digits, '.', '_' and '-'. The dispatcher deletes blobs once
+// they are older than its blob TTL. Implement it to use a store other than a shared directory, such
+// as an S3 bucket.
+type BlobStore interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+// Blob store on a directory shared with the dispatcher, such as a mounted volume.
+type FileBlobStore struct {
+	dir string
+}
+
+func NewFileBlobStore(dir string) *FileBlobStore {
+	return &FileBlobStore{dir: dir}
+}
+
+// Write the blob to a temporary file and rename it, so that readers never see a partial blob.
+func (s *FileBlobStore) Put(_ context.Context, key string, data []byte) error {
+	path := filepath.Join(s.dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func (s *FileBlobStore) Get(_ context.Context, key string) ([]byte, error) {
+	if !filepath.IsLocal(filepath.FromSlash(key)) {
+		return nil, fmt.Errorf("invalid blob key %q", key)
+	}
+	return os.ReadFile(filepath.Join(s.dir, filepath.FromSlash(key)))
+}
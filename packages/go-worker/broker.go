@@ -0,0 +1,51 @@
+package taskrunner
+
+import (
+	"context"
+	"time"
+)
+
+// Backend a runner takes its tasks from and reports to: its registration and availability, its
+// labels, and the status and results of its tasks. RedisBroker speaks the dispatcher's Redis
+// protocol. MemoryBroker keeps everything in the process, to run tasks without Redis in local
+// development and tests.
+type Broker interface {
+	// Register the runner as running and available, and publish its capacity and attributes.
+	Register(ctx context.Context, id string, info WorkerInfo) error
+	// Remove the runner's registration, draining state, and control queue. Its labels are removed
+	// separately.
+	Deregister(ctx context.Context, id string) error
+	// Mark the runner as available for tasks or busy. Becoming available records its activity.
+	SetAvailable(ctx context.Context, id string, available bool) error
+	// Whether the runner was asked to drain.
+	Draining(ctx context.Context, id string) (bool, error)
+	// Wait up to the timeout for the next message on the runner's control queue, its own queue, and,
+	// if common is set, the common queue of its pool, in that order. The default pool has an empty
+	// name. Returns nil if no message arrived.
+	Next(ctx context.Context, id, pool string, common bool, timeout time.Duration) (*Message, error)
+	// Size of a label in the label registry, or zero if it is not registered.
+	LabelSize(ctx context.Context, label string) (int64, error)
+	// Register a label the runner loaded, or mark it as the most recently used if it is registered.
+	AddLabel(ctx context.Context, id, label string, refresh bool) error
+	// Deregister a label. Labels that were not loaded only have a stale membership dropped.
+	RemoveLabel(ctx context.Context, id, label string, loaded bool) error
+	// Record the status, and the result if any, of a task the runner took, kept for the TTL.
+	RecordStatus(ctx context.Context, id string, taskID, status string, result *string, ttl time.Duration) error
+	// Send the result of a task back to the dispatcher.
+	PublishResult(ctx context.Context, taskID, result string) error
+}
+
+// Capacity and attributes a runner publishes for the dispatcher at registration.
+type WorkerInfo struct {
+	MaxLabels         int
+	MemoryBudgetBytes int64
+	Attributes        map[string]string
+}
+
+// A message taken from a runner's queues: an admin command or a serialized task.
+type Message struct {
+	// Queue the message was taken from
+	Queue   string
+	Control bool
+	Body    string
+}
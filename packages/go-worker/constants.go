@@ -0,0 +1,20 @@
+package taskrunner
+
+const (
+	// Namespace of the keys when Options.Namespace is empty.
+	defaultNamespace  = "task-runners"
+	poolAttribute     = "pool"
+	commandEvictLabel = "evict_label"
+	// Typed and offloaded results start with this envelope: a byte that cannot start valid UTF-8 text,
+	// then the envelope version. Results without it are plain text to the dispatcher.
+	resultEnvelope = "\xff\x01"
+	// Results stored as blobs are published as this prefix followed by the blob key.
+	blobRefPrefix = resultEnvelope + "blob:"
+)
+
+// Task states recorded in the task status hash.
+const (
+	statusRunning   = "running"
+	statusCompleted = "completed"
+	statusFailed    = "failed"
+)
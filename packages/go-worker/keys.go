@@ -0,0 +1,74 @@
+package taskrunner
+
+import "fmt"
+
+// Builds the Redis keys of the task-runner protocol, shared with the dispatcher and the Python
+// worker. Every key starts with the namespace as a hash tag, so that all keys share one Redis Cluster
+// slot and BLPOP over several queues works on a cluster.
+type keyspace struct {
+	prefix string
+}
+
+func newKeyspace(namespace string) keyspace {
+	return keyspace{prefix: "{" + namespace + "}:"}
+}
+
+func (k keyspace) running() string {
+	return k.prefix + "running"
+}
+
+func (k keyspace) available() string {
+	return k.prefix + "available"
+}
+
+func (k keyspace) draining() string {
+	return k.prefix + "draining"
+}
+
+func (k keyspace) lastActivity() string {
+	return k.prefix + "last-activity"
+}
+
+func (k keyspace) labelCounts() string {
+	return k.prefix + "labels:count"
+}
+
+func (k keyspace) labelSizes() string {
+	return k.prefix + "labels:sizes"
+}
+
+func (k keyspace) commonQueue() string {
+	return k.prefix + "all:jobs"
+}
+
+func (k keyspace) poolQueue(pool string) string {
+	return fmt.Sprintf("%spool:%s:jobs", k.prefix, pool)
+}
+
+func (k keyspace) label(label string) string {
+	return fmt.Sprintf("%slabels:%s:workers", k.prefix, label)
+}
+
+func (k keyspace) jobQueue(id string) string {
+	return k.prefix + id + ":jobs"
+}
+
+func (k keyspace) controlQueue(id string) string {
+	return k.prefix + id + ":control"
+}
+
+func (k keyspace) workerInfo(id string) string {
+	return k.prefix + id + ":info"
+}
+
+func (k keyspace) workerLabels(id string) string {
+	return k.prefix + id + ":labels"
+}
+
+func (k keyspace) resultChannel(taskID string) string {
+	return k.prefix + "results:" + taskID
+}
+
+func (k keyspace) taskStatus(taskID string) string {
+	return k.prefix + "tasks:" + taskID
+}
@@ -0,0 +1,201 @@
+package taskrunner
+
+import (
+	"container/list"
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// The labels loaded by a task runner. It works as an LRU cache with a maximum number of labels and
+// an optional memory budget, and keeps the label membership sets, the label count and the runner's
+// label list up to date through the broker, like the Python LabelHandler, so the dispatcher can route
+// tasks to runners that already hold their label or have room for it.
+type LabelSet struct {
+	mu       sync.Mutex
+	broker   Broker
+	runnerID string
+	max      int
+	budget   int64
+	order    *list.List // Least recently used label at the front
+	items    map[string]*list.Element
+	sizes    map[string]int64
+}
+
+func newLabelSet(b Broker, runnerID string, max int, budget int64) *LabelSet {
+	return &LabelSet{
+		broker:   b,
+		runnerID: runnerID,
+		max:      max,
+		budget:   budget,
+		order:    list.New(),
+		items:    map[string]*list.Element{},
+		sizes:    map[string]int64{},
+	}
+}
+
+// Maximum number of labels the runner holds at once.
+func (l *LabelSet) Max() int {
+	return l.max
+}
+
+// Memory budget for the labels in bytes, or zero if only the number of labels is limited.
+func (l *LabelSet) MemoryBudget() int64 {
+	return l.budget
+}
+
+// Bytes used by the loaded labels, according to the label registry when they were loaded.
+func (l *LabelSet) MemoryUsed() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.memoryUsed()
+}
+
+func (l *LabelSet) memoryUsed() int64 {
+	var used int64
+	for _, s := range l.sizes {
+		used += s
+	}
+	return used
+}
+
+// Whether a label of the given size needs an eviction to fit.
+func (l *LabelSet) full(size int64) bool {
+	return l.order.Len() >= l.max || (l.budget > 0 && l.memoryUsed()+size > l.budget)
+}
+
+// Add a label, evicting the least recently used ones until it fits. Adding a label that is already
+// loaded marks it as recently used. A label larger than the whole budget is loaded once every other
+// label is evicted.
+func (l *LabelSet) Add(ctx context.Context, label string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if e, ok := l.items[label]; ok {
+		l.order.MoveToBack(e)
+		if err := l.broker.AddLabel(ctx, l.runnerID, label, true); err != nil {
+			return err
+		}
+		slog.Debug("Label refreshed", "label", label)
+		return nil
+	}
+
+	size, err := l.broker.LabelSize(ctx, label)
+	if err != nil {
+		return err
+	}
+	for l.order.Len() > 0 && l.full(size) {
+		oldest := l.order.Front()
+		old := oldest.Value.(string)
+		if err := l.deregister(ctx, old); err != nil {
+			return err
+		}
+		l.order.Remove(oldest)
+		delete(l.items, old)
+		delete(l.sizes, old)
+		slog.Info("Removed oldest label", "label", old)
+	}
+
+	if err := l.broker.AddLabel(ctx, l.runnerID, label, false); err != nil {
+		return err
+	}
+	l.items[label] = l.order.PushBack(label)
+	l.sizes[label] = size
+	slog.Info("Label added", "label", label, "size", size)
+	return nil
+}
+
+// Add all the labels a task needs. The labels already loaded are refreshed first, so that loading the
+// others does not evict them. The load function, if not nil, is called before each missing label is
+// added, to load its resources.
+func (l *LabelSet) AddAll(ctx context.Context, labels []string, load func(label string) error) error {
+	missing := []string{}
+	for _, label := range labels {
+		if !l.Has(label) {
+			missing = append(missing, label)
+			continue
+		}
+		if err := l.Add(ctx, label); err != nil {
+			return err
+		}
+	}
+	for _, label := range missing {
+		if load != nil {
+			if err := load(label); err != nil {
+				return err
+			}
+		}
+		if err := l.Add(ctx, label); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove a label. Returns false if the label was not loaded, in which case the broker is still
+// cleaned up for it.
+func (l *LabelSet) Remove(ctx context.Context, label string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e, ok := l.items[label]
+	if !ok {
+		return false, l.broker.RemoveLabel(ctx, l.runnerID, label, false)
+	}
+	if err := l.deregister(ctx, label); err != nil {
+		return true, err
+	}
+	l.order.Remove(e)
+	delete(l.items, label)
+	delete(l.sizes, label)
+	return true, nil
+}
+
+// Whether the label is loaded.
+func (l *LabelSet) Has(label string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, ok := l.items[label]
+	return ok
+}
+
+// Loaded labels, from least to most recently used.
+func (l *LabelSet) List() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make([]string, 0, l.order.Len())
+	for e := l.order.Front(); e != nil; e = e.Next() {
+		out = append(out, e.Value.(string))
+	}
+	return out
+}
+
+// Number of loaded labels.
+func (l *LabelSet) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.order.Len()
+}
+
+// Remove all labels.
+func (l *LabelSet) Clear(ctx context.Context) error {
+	for _, label := range l.List() {
+		if _, err := l.Remove(ctx, label); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Deregister a label from the broker.
+func (l *LabelSet) deregister(ctx context.Context, label string) error {
+	if err := l.broker.RemoveLabel(ctx, l.runnerID, label, true); err != nil {
+		return err
+	}
+	slog.Debug("Label deregistered", "label", label)
+	return nil
+}
+
+// Current time as fractional Unix seconds, used to order the published label list.
+func now() float64 {
+	return float64(time.Now().UnixMicro()) / 1e6
+}
@@ -0,0 +1,264 @@
+package taskrunner
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Broker that keeps queues, labels, and results in the process, so runners can take tasks without
+// Redis, in local development and tests. There is no dispatcher in between: tasks are sent with Send
+// to the common queue of a pool, or with SendTo to a runner, and their results are read with Result.
+type MemoryBroker struct {
+	mu        sync.Mutex
+	running   map[string]WorkerInfo
+	available map[string]bool
+	draining  map[string]bool
+	// Labels of each runner, least recently used first
+	labels  map[string][]string
+	sizes   map[string]int64
+	queues  map[string][]Message
+	status  map[string]map[string]string
+	results map[string]chan string
+	// Closed and replaced whenever a message is queued, to wake up the runners waiting for one
+	queued chan struct{}
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		running:   map[string]WorkerInfo{},
+		available: map[string]bool{},
+		draining:  map[string]bool{},
+		labels:    map[string][]string{},
+		sizes:     map[string]int64{},
+		queues:    map[string][]Message{},
+		status:    map[string]map[string]string{},
+		results:   map[string]chan string{},
+		queued:    make(chan struct{}),
+	}
+}
+
+// Names of the queues, the same as the worker IDs and common queues of the dispatcher. The NATS
+// broker names its queues the same way.
+func memoryCommonQueue(pool string) string {
+	if pool == "" {
+		return "all"
+	}
+	return "pool:" + pool
+}
+
+func memoryControlQueue(id string) string {
+	return id + ":control"
+}
+
+func (b *MemoryBroker) push(queue string, msg Message) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	msg.Queue = queue
+	b.queues[queue] = append(b.queues[queue], msg)
+	close(b.queued)
+	b.queued = make(chan struct{})
+}
+
+func (b *MemoryBroker) sendTask(queue string, t Task) error {
+	raw, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	b.push(queue, Message{Body: string(raw)})
+	return nil
+}
+
+// Queue a task on the common queue of a pool, read by every runner in the pool. The default pool has
+// an empty name.
+func (b *MemoryBroker) Send(pool string, t Task) error {
+	return b.sendTask(memoryCommonQueue(pool), t)
+}
+
+// Queue a task on a runner's own queue.
+func (b *MemoryBroker) SendTo(id string, t Task) error {
+	return b.sendTask(id, t)
+}
+
+// Ask a runner to drain, so that it stops taking tasks from the common queue, or return it to rotation.
+func (b *MemoryBroker) Drain(id string, on bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.draining[id] = on
+}
+
+// Ask a runner to drop a label, through its control queue.
+func (b *MemoryBroker) Evict(id, label string) error {
+	raw, err := json.Marshal(command{Command: commandEvictLabel, Label: label})
+	if err != nil {
+		return err
+	}
+	b.push(memoryControlQueue(id), Message{Control: true, Body: string(raw)})
+	return nil
+}
+
+// Register the size of a label, checked against the memory budgets of the runners.
+func (b *MemoryBroker) SetLabelSize(label string, size int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sizes[label] = size
+}
+
+// Labels a runner holds, least recently used first.
+func (b *MemoryBroker) Labels(id string) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Clone(b.labels[id])
+}
+
+// IDs of the registered runners that are available for tasks and not draining, sorted.
+func (b *MemoryBroker) Available() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := []string{}
+	for id, ok := range b.available {
+		if ok && !b.draining[id] {
+			out = append(out, id)
+		}
+	}
+	slices.Sort(out)
+	return out
+}
+
+// Status and result recorded for a task, or empty strings if it was not taken yet.
+func (b *MemoryBroker) Status(taskID string) (status, result string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.status[taskID]["status"], b.status[taskID]["result"]
+}
+
+// Wait for the result of a task that requested one, until the context is done. Results published
+// before the call are kept until they are read.
+func (b *MemoryBroker) Result(ctx context.Context, taskID string) (string, error) {
+	ch := b.result(taskID)
+	defer func() {
+		b.mu.Lock()
+		delete(b.results, taskID)
+		b.mu.Unlock()
+	}()
+	select {
+	case result := <-ch:
+		return result, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// Channel the result of a task is sent on, created by the first of the sender and the receiver.
+func (b *MemoryBroker) result(taskID string) chan string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ch, ok := b.results[taskID]
+	if !ok {
+		ch = make(chan string, 1)
+		b.results[taskID] = ch
+	}
+	return ch
+}
+
+func (b *MemoryBroker) Register(_ context.Context, id string, info WorkerInfo) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.running[id] = info
+	b.available[id] = true
+	return nil
+}
+
+func (b *MemoryBroker) Deregister(_ context.Context, id string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.running, id)
+	delete(b.available, id)
+	delete(b.draining, id)
+	delete(b.queues, memoryControlQueue(id))
+	return nil
+}
+
+func (b *MemoryBroker) SetAvailable(_ context.Context, id string, available bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.available[id] = available
+	return nil
+}
+
+func (b *MemoryBroker) Draining(_ context.Context, id string) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.draining[id], nil
+}
+
+func (b *MemoryBroker) Next(ctx context.Context, id, pool string, common bool, timeout time.Duration) (*Message, error) {
+	queues := []string{memoryControlQueue(id), id}
+	if common {
+		queues = append(queues, memoryCommonQueue(pool))
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		b.mu.Lock()
+		for _, q := range queues {
+			if len(b.queues[q]) > 0 {
+				msg := b.queues[q][0]
+				b.queues[q] = b.queues[q][1:]
+				b.mu.Unlock()
+				return &msg, nil
+			}
+		}
+		queued := b.queued
+		b.mu.Unlock()
+
+		select {
+		case <-queued:
+		case <-timer.C:
+			return nil, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (b *MemoryBroker) LabelSize(_ context.Context, label string) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.sizes[label], nil
+}
+
+func (b *MemoryBroker) AddLabel(_ context.Context, id, label string, _ bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.labels[id] = append(slices.DeleteFunc(b.labels[id], func(l string) bool { return l == label }), label)
+	return nil
+}
+
+func (b *MemoryBroker) RemoveLabel(_ context.Context, id, label string, _ bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.labels[id] = slices.DeleteFunc(b.labels[id], func(l string) bool { return l == label })
+	return nil
+}
+
+func (b *MemoryBroker) RecordStatus(_ context.Context, id string, taskID, status string, result *string, _ time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	fields := map[string]string{"status": status, "worker": id}
+	if result != nil {
+		fields["result"] = *result
+	}
+	b.status[taskID] = fields
+	return nil
+}
+
+func (b *MemoryBroker) PublishResult(_ context.Context, taskID, result string) error {
+	select {
+	case b.result(taskID) <- result:
+	default:
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package taskrunner
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// Test running tasks on the in-memory broker, without Redis
+func TestMemoryBroker(t *testing.T) {
+	b := NewMemoryBroker()
+	rn := NewWithBroker(b, Options{MaxLabels: 1, PollTimeout: 100 * time.Millisecond, Attributes: map[string]string{"pool": "highmem"}})
+	rn.Handle("echo", func(ctx context.Context, ls *LabelSet, task *Task) (string, error) {
+		if err := ls.Add(ctx, task.Label); err != nil {
+			return "", err
+		}
+		return "echo:" + task.Parameters, nil
+	})
+	rn.Handle("fail", func(context.Context, *LabelSet, *Task) (string, error) {
+		return "", errors.New("boom")
+	})
+	stop := startRunner(t, rn)
+	c, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	eventually(t, "Expected the runner to register", func() bool {
+		return slices.Equal(b.Available(), []string{rn.ID()})
+	})
+	if err := b.Send("highmem", Task{ID: "t1", Type: "echo", Label: "l1", Parameters: "{}", ReturnResult: true}); err != nil {
+		t.Fatal(err)
+	}
+	if result, err := b.Result(c, "t1"); err != nil || result != "echo:{}" {
+		t.Fatalf("Expected the echoed result, got %q %v", result, err)
+	}
+	if err := b.SendTo(rn.ID(), Task{ID: "t2", Type: "echo", Label: "l2", Parameters: "{}", ReturnResult: true}); err != nil {
+		t.Fatal(err)
+	}
+	if result, err := b.Result(c, "t2"); err != nil || result != "echo:{}" {
+		t.Fatalf("Expected the echoed result, got %q %v", result, err)
+	}
+	if status, _ := b.Status("t2"); status != statusCompleted {
+		t.Errorf("Expected completed task status, got %q", status)
+	}
+	if labels := b.Labels(rn.ID()); !slices.Equal(labels, []string{"l2"}) {
+		t.Errorf("Expected the oldest label to be evicted, got %v", labels)
+	}
+
+	// Tasks in another pool's common queue are left alone
+	b.Send("", Task{ID: "other", Type: "fail"})
+	b.SendTo(rn.ID(), Task{ID: "t3", Type: "fail"})
+	eventually(t, "Expected failed task status", func() bool {
+		status, result := b.Status("t3")
+		return status == statusFailed && result == "boom"
+	})
+	if status, _ := b.Status("other"); status != "" {
+		t.Errorf("Expected the default pool task to wait, got %q", status)
+	}
+
+	if err := b.Evict(rn.ID(), "l2"); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "Expected the label to be evicted", func() bool {
+		return len(b.Labels(rn.ID())) == 0
+	})
+
+	if err := stop(); err != nil {
+		t.Errorf("Expected clean stop, got %v", err)
+	}
+	if av := b.Available(); len(av) != 0 {
+		t.Errorf("Expected the runner to deregister when stopped, got %v", av)
+	}
+}
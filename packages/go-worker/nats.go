@@ -0,0 +1,281 @@
+package taskrunner
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Broker on NATS JetStream, for deployments whose dispatcher runs with the NATS broker. It uses the
+// dispatcher's layout: tasks are taken from the durable consumer of each queue on the
+// "<namespace>_tasks" stream, the runner's state and labels live in the "<namespace>_workers" and
+// "<namespace>_labels" KV buckets, and results are published on "<namespace>.results.<task>". The
+// NATS layout has no control queues or task statuses, so admin commands never arrive and statuses are
+// not recorded.
+type NATSBroker struct {
+	nc      *nats.Conn
+	js      jetstream.JetStream
+	prefix  string
+	stream  string
+	workers jetstream.KeyValue
+	labels  jetstream.KeyValue
+	sizes   jetstream.KeyValue
+
+	mu        sync.Mutex
+	consumers map[string]jetstream.Consumer
+}
+
+// State of a runner in the workers bucket, as the dispatcher reads it.
+type natsWorker struct {
+	Available bool              `json:"available"`
+	Draining  bool              `json:"draining"`
+	MaxLabels int               `json:"max_labels,omitempty"`
+	Budget    int64             `json:"memory_budget_bytes,omitempty"`
+	Attrs     map[string]string `json:"attributes,omitempty"`
+	// Labels held by the runner, least recently used first
+	Labels []string `json:"labels"`
+}
+
+// Tasks are taken by polling the consumers of the queues in order, like BLPOP over several lists.
+const natsPollInterval = 50 * time.Millisecond
+
+// Create a broker on the given NATS connection. An empty namespace uses the default one. The stream
+// and the KV buckets are created if the dispatcher has not created them yet.
+func NewNATSBroker(ctx context.Context, nc *nats.Conn, namespace string) (*NATSBroker, error) {
+	if namespace == "" {
+		namespace = defaultNamespace
+	}
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.ReplaceAll(namespace, ".", "_")
+	b := &NATSBroker{
+		nc:        nc,
+		js:        js,
+		prefix:    name + ".",
+		stream:    name + "_tasks",
+		consumers: map[string]jetstream.Consumer{},
+	}
+	_, err = js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:      b.stream,
+		Subjects:  []string{b.prefix + "tasks.>"},
+		Retention: jetstream.WorkQueuePolicy,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating the task stream: %w", err)
+	}
+	for _, kv := range []struct {
+		bucket *jetstream.KeyValue
+		name   string
+	}{
+		{&b.workers, "workers"},
+		{&b.labels, "labels"},
+		{&b.sizes, "label-sizes"},
+	} {
+		*kv.bucket, err = js.CreateOrUpdateKeyValue(ctx, jetstream.KeyValueConfig{Bucket: name + "_" + kv.name})
+		if err != nil {
+			return nil, fmt.Errorf("creating the %s bucket: %w", kv.name, err)
+		}
+	}
+	return b, nil
+}
+
+// Encode a name as a single subject token or key segment, since NATS reserves '.', '*', '>' and
+// whitespace.
+func natsToken(s string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+func (b *NATSBroker) taskSubject(queue string) string {
+	return b.prefix + "tasks." + natsToken(queue)
+}
+
+// Key of a label holder in the labels bucket.
+func natsHolderKey(label, id string) string {
+	return natsToken(label) + "." + natsToken(id)
+}
+
+// Change the runner's entry with compare-and-set, retrying when the dispatcher changed it first. The
+// entry is created if missing, unless create is false.
+func (b *NATSBroker) updateWorker(ctx context.Context, id string, create bool, update func(*natsWorker)) error {
+	key := natsToken(id)
+	for {
+		w := natsWorker{Labels: []string{}}
+		var rev uint64
+		entry, err := b.workers.Get(ctx, key)
+		switch {
+		case err == nil:
+			if err := json.Unmarshal(entry.Value(), &w); err != nil {
+				return err
+			}
+			rev = entry.Revision()
+		case !errors.Is(err, jetstream.ErrKeyNotFound):
+			return err
+		case !create:
+			return nil
+		}
+		update(&w)
+		raw, err := json.Marshal(&w)
+		if err != nil {
+			return err
+		}
+		if rev == 0 {
+			_, err = b.workers.Create(ctx, key, raw)
+		} else {
+			_, err = b.workers.Update(ctx, key, raw, rev)
+		}
+		var apiErr *jetstream.APIError
+		conflict := errors.Is(err, jetstream.ErrKeyExists) ||
+			errors.As(err, &apiErr) && apiErr.ErrorCode == jetstream.JSErrCodeStreamWrongLastSequence
+		if !conflict {
+			return err
+		}
+	}
+}
+
+func (b *NATSBroker) Register(ctx context.Context, id string, info WorkerInfo) error {
+	err := b.updateWorker(ctx, id, true, func(w *natsWorker) {
+		w.Available = true
+		w.MaxLabels, w.Budget, w.Attrs = info.MaxLabels, info.MemoryBudgetBytes, info.Attributes
+	})
+	if err != nil {
+		return fmt.Errorf("registering runner: %w", err)
+	}
+	return nil
+}
+
+func (b *NATSBroker) Deregister(ctx context.Context, id string) error {
+	if err := b.workers.Delete(ctx, natsToken(id)); err != nil {
+		return fmt.Errorf("deregistering runner: %w", err)
+	}
+	return nil
+}
+
+func (b *NATSBroker) SetAvailable(ctx context.Context, id string, available bool) error {
+	return b.updateWorker(ctx, id, false, func(w *natsWorker) { w.Available = available })
+}
+
+func (b *NATSBroker) Draining(ctx context.Context, id string) (bool, error) {
+	entry, err := b.workers.Get(ctx, natsToken(id))
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	var w natsWorker
+	if err := json.Unmarshal(entry.Value(), &w); err != nil {
+		return false, err
+	}
+	return w.Draining, nil
+}
+
+// Durable consumer of a queue, shared with every runner that reads it and created with the same
+// settings as the dispatcher's.
+func (b *NATSBroker) consumer(ctx context.Context, queue string) (jetstream.Consumer, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if cons, ok := b.consumers[queue]; ok {
+		return cons, nil
+	}
+	cons, err := b.js.CreateOrUpdateConsumer(ctx, b.stream, jetstream.ConsumerConfig{
+		Durable:       "queue-" + natsToken(queue),
+		FilterSubject: b.taskSubject(queue),
+		AckPolicy:     jetstream.AckExplicitPolicy,
+	})
+	if err != nil {
+		return nil, err
+	}
+	b.consumers[queue] = cons
+	return cons, nil
+}
+
+func (b *NATSBroker) Next(ctx context.Context, id, pool string, common bool, timeout time.Duration) (*Message, error) {
+	queues := []string{id}
+	if common {
+		queues = append(queues, memoryCommonQueue(pool))
+	}
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		for _, q := range queues {
+			cons, err := b.consumer(ctx, q)
+			if err != nil {
+				return nil, err
+			}
+			batch, err := cons.FetchNoWait(1)
+			if err != nil {
+				return nil, err
+			}
+			for msg := range batch.Messages() {
+				if err := msg.Ack(); err != nil {
+					return nil, err
+				}
+				return &Message{Queue: q, Body: string(msg.Data())}, nil
+			}
+			if err := batch.Error(); err != nil && !errors.Is(err, nats.ErrTimeout) {
+				return nil, err
+			}
+		}
+
+		select {
+		case <-time.After(natsPollInterval):
+		case <-deadline.C:
+			return nil, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (b *NATSBroker) LabelSize(ctx context.Context, label string) (int64, error) {
+	entry, err := b.sizes.Get(ctx, natsToken(label))
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(string(entry.Value()), 10, 64)
+}
+
+func (b *NATSBroker) AddLabel(ctx context.Context, id, label string, _ bool) error {
+	err := b.updateWorker(ctx, id, false, func(w *natsWorker) {
+		w.Labels = append(slices.DeleteFunc(w.Labels, func(l string) bool { return l == label }), label)
+	})
+	if err != nil {
+		return err
+	}
+	_, err = b.labels.Put(ctx, natsHolderKey(label, id), nil)
+	return err
+}
+
+// Deregister a label. The runner's entry is left alone once it is deregistered.
+func (b *NATSBroker) RemoveLabel(ctx context.Context, id, label string, _ bool) error {
+	err := b.updateWorker(ctx, id, false, func(w *natsWorker) {
+		w.Labels = slices.DeleteFunc(w.Labels, func(l string) bool { return l == label })
+	})
+	if err != nil {
+		return err
+	}
+	return b.labels.Delete(ctx, natsHolderKey(label, id))
+}
+
+func (b *NATSBroker) RecordStatus(context.Context, string, string, string, *string, time.Duration) error {
+	return nil
+}
+
+func (b *NATSBroker) PublishResult(_ context.Context, taskID, result string) error {
+	return b.nc.Publish(b.prefix+"results."+natsToken(taskID), []byte(result))
+}
@@ -0,0 +1,105 @@
+package taskrunner
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Start an embedded NATS server with JetStream and connect to it.
+func mockNATS(t *testing.T) *nats.Conn {
+	t.Helper()
+	srv, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, JetStream: true, StoreDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Start()
+	t.Cleanup(srv.Shutdown)
+	if !srv.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server did not start")
+	}
+	nc, err := nats.Connect(srv.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(nc.Close)
+	return nc
+}
+
+// Test a runner on NATS: it registers in the workers bucket, takes tasks from the common queue of its
+// pool, publishes results, and releases its labels when it stops
+func TestNATSBroker(t *testing.T) {
+	nc := mockNATS(t)
+	ctx := t.Context()
+	b, err := NewNATSBroker(ctx, nc, "prod.eu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rn := NewWithBroker(b, Options{MaxLabels: 2, PollTimeout: 100 * time.Millisecond, Attributes: map[string]string{"pool": "gpu"}})
+	rn.Handle("echo", func(ctx context.Context, labels *LabelSet, task *Task) (string, error) {
+		if err := labels.AddAll(ctx, task.AllLabels(), nil); err != nil {
+			return "", err
+		}
+		return task.Parameters, nil
+	})
+	stop := startRunner(t, rn)
+
+	js, _ := jetstream.New(nc)
+	workers, err := js.KeyValue(ctx, "prod_eu_workers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := func() *natsWorker {
+		e, err := workers.Get(ctx, natsToken(rn.ID()))
+		if err != nil {
+			return nil
+		}
+		var w natsWorker
+		json.Unmarshal(e.Value(), &w)
+		return &w
+	}
+	for deadline := time.Now().Add(5 * time.Second); entry() == nil; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Runner did not register")
+		}
+	}
+	if w := entry(); !w.Available || w.MaxLabels != 2 || w.Attrs["pool"] != "gpu" {
+		t.Errorf("Expected the runner's capacity and attributes, got %+v", w)
+	}
+
+	sub, err := nc.SubscribeSync("prod_eu.results." + natsToken("t1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := json.Marshal(Task{ID: "t1", Type: "echo", Label: "org/model:v1", Parameters: `{"a": 1}`, ReturnResult: true})
+	if _, err := js.Publish(ctx, b.taskSubject("pool:gpu"), raw); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := sub.NextMsg(5 * time.Second)
+	if err != nil || string(msg.Data) != `{"a": 1}` {
+		t.Fatalf("Expected the echoed result, got %v %v", msg, err)
+	}
+	if w := entry(); !slices.Equal(w.Labels, []string{"org/model:v1"}) {
+		t.Errorf("Expected the runner to hold the label, got %+v", w)
+	}
+	labels, _ := js.KeyValue(ctx, "prod_eu_labels")
+	if _, err := labels.Get(ctx, natsHolderKey("org/model:v1", rn.ID())); err != nil {
+		t.Errorf("Expected the label membership, got %v", err)
+	}
+
+	if err := stop(); err != nil {
+		t.Fatal(err)
+	}
+	if w := entry(); w != nil {
+		t.Errorf("Expected the runner to deregister, got %+v", w)
+	}
+	if _, err := labels.Get(ctx, natsHolderKey("org/model:v1", rn.ID())); err == nil {
+		t.Error("Expected the label membership to be released")
+	}
+}
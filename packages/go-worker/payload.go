@@ -0,0 +1,55 @@
+package taskrunner
+
+import (
+	"fmt"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Content types of task parameters and results, besides plain text results.
+const (
+	ContentJSON    = "application/json"
+	ContentMsgpack = "application/msgpack"
+	ContentBinary  = "application/octet-stream"
+	EncodingZstd   = "zstd"
+)
+
+// Results published with a content type start with this prefix, followed by
+// "<content type>[;<encoding>]\n" and the result's bytes.
+const resultPayloadPrefix = resultEnvelope + "payload:"
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+)
+
+// The task's parameters as bytes, decompressed: the binary parameters when the task has a content
+// type or encoding, and the JSON parameters otherwise.
+func (t *Task) ParametersBytes() ([]byte, error) {
+	if t.ParametersType == "" && t.ParametersEncoding == "" {
+		return []byte(t.Parameters), nil
+	}
+	switch t.ParametersEncoding {
+	case "":
+		return t.ParametersData, nil
+	case EncodingZstd:
+		data, err := zstdDecoder.DecodeAll(t.ParametersData, nil)
+		if err != nil {
+			return nil, fmt.Errorf("decompressing task parameters: %w", err)
+		}
+		return data, nil
+	default:
+		return nil, fmt.Errorf("unsupported parameters encoding %q", t.ParametersEncoding)
+	}
+}
+
+// Encode a result with its content type, optionally compressed with zstd, for a handler to return.
+// The dispatcher decodes it, and returns it to callers as bytes or in the format they ask for.
+func EncodeResult(contentType string, data []byte, compress bool) string {
+	header := contentType
+	if compress {
+		header += ";" + EncodingZstd
+		data = zstdEncoder.EncodeAll(data, nil)
+	}
+	return resultPayloadPrefix + header + "\n" + string(data)
+}
@@ -0,0 +1,168 @@
+package taskrunner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Broker on the dispatcher's Redis, with the keys of one namespace.
+type RedisBroker struct {
+	redis redis.UniversalClient
+	keys  keyspace
+}
+
+// Create a broker on the given Redis client. An empty namespace uses the default one.
+func NewRedisBroker(r redis.UniversalClient, namespace string) *RedisBroker {
+	if namespace == "" {
+		namespace = defaultNamespace
+	}
+	return &RedisBroker{redis: r, keys: newKeyspace(namespace)}
+}
+
+// Queue name of a pool's common queue. The default pool, with an empty name, uses the "all" queue.
+func (b *RedisBroker) commonQueue(pool string) string {
+	if pool != "" {
+		return b.keys.poolQueue(pool)
+	}
+	return b.keys.commonQueue()
+}
+
+func (b *RedisBroker) Register(ctx context.Context, id string, info WorkerInfo) error {
+	attrs := info.Attributes
+	if attrs == nil {
+		attrs = map[string]string{}
+	}
+	raw, err := json.Marshal(attrs)
+	if err != nil {
+		return fmt.Errorf("encoding attributes: %w", err)
+	}
+	fields := []any{"max_labels", info.MaxLabels, "attributes", string(raw)}
+	if info.MemoryBudgetBytes > 0 {
+		fields = append(fields, "memory_budget_bytes", info.MemoryBudgetBytes)
+	}
+
+	pipe := b.redis.TxPipeline()
+	pipe.SAdd(ctx, b.keys.available(), id)
+	pipe.SAdd(ctx, b.keys.running(), id)
+	pipe.HSet(ctx, b.keys.workerInfo(id), fields...)
+	pipe.HSet(ctx, b.keys.lastActivity(), id, activityTimestamp())
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("registering runner: %w", err)
+	}
+	return nil
+}
+
+func (b *RedisBroker) Deregister(ctx context.Context, id string) error {
+	pipe := b.redis.TxPipeline()
+	pipe.SRem(ctx, b.keys.available(), id)
+	pipe.SRem(ctx, b.keys.running(), id)
+	pipe.HDel(ctx, b.keys.lastActivity(), id)
+	pipe.SRem(ctx, b.keys.draining(), id)
+	pipe.Del(ctx, b.keys.controlQueue(id))
+	pipe.Del(ctx, b.keys.workerInfo(id))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("deregistering runner: %w", err)
+	}
+	return nil
+}
+
+func (b *RedisBroker) SetAvailable(ctx context.Context, id string, available bool) error {
+	if !available {
+		return b.redis.SRem(ctx, b.keys.available(), id).Err()
+	}
+	pipe := b.redis.TxPipeline()
+	pipe.SAdd(ctx, b.keys.available(), id)
+	pipe.HSet(ctx, b.keys.lastActivity(), id, activityTimestamp())
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (b *RedisBroker) Draining(ctx context.Context, id string) (bool, error) {
+	return b.redis.SIsMember(ctx, b.keys.draining(), id).Result()
+}
+
+func (b *RedisBroker) Next(ctx context.Context, id, pool string, common bool, timeout time.Duration) (*Message, error) {
+	control := b.keys.controlQueue(id)
+	queues := []string{control, b.keys.jobQueue(id)}
+	if common {
+		queues = append(queues, b.commonQueue(pool))
+	}
+	res, err := b.redis.BLPop(ctx, timeout, queues...).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Message{Queue: res[0], Control: res[0] == control, Body: res[1]}, nil
+}
+
+func (b *RedisBroker) LabelSize(ctx context.Context, label string) (int64, error) {
+	raw, err := b.redis.HGet(ctx, b.keys.labelSizes(), label).Result()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("reading size of label %s: %w", label, err)
+	}
+	return strconv.ParseInt(raw, 10, 64)
+}
+
+// Add the runner to the label's membership set and its label list, scored by the time the label was
+// last used, and increment its label count.
+func (b *RedisBroker) AddLabel(ctx context.Context, id, label string, refresh bool) error {
+	if refresh {
+		if err := b.redis.ZAdd(ctx, b.keys.workerLabels(id), redis.Z{Score: now(), Member: label}).Err(); err != nil {
+			return fmt.Errorf("refreshing label %s: %w", label, err)
+		}
+		return nil
+	}
+	pipe := b.redis.TxPipeline()
+	pipe.SAdd(ctx, b.keys.label(label), id)
+	pipe.ZIncrBy(ctx, b.keys.labelCounts(), 1, id)
+	pipe.ZAdd(ctx, b.keys.workerLabels(id), redis.Z{Score: now(), Member: label})
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("registering label %s: %w", label, err)
+	}
+	return nil
+}
+
+// Remove the runner from the label's membership set and its label list, and decrement its label
+// count.
+func (b *RedisBroker) RemoveLabel(ctx context.Context, id, label string, loaded bool) error {
+	if !loaded {
+		// Only drop a stale membership; the count belongs to loaded labels
+		return b.redis.SRem(ctx, b.keys.label(label), id).Err()
+	}
+	pipe := b.redis.TxPipeline()
+	pipe.SRem(ctx, b.keys.label(label), id)
+	pipe.ZIncrBy(ctx, b.keys.labelCounts(), -1, id)
+	pipe.ZRem(ctx, b.keys.workerLabels(id), label)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("deregistering label %s: %w", label, err)
+	}
+	return nil
+}
+
+func (b *RedisBroker) RecordStatus(ctx context.Context, id string, taskID, status string, result *string, ttl time.Duration) error {
+	key := b.keys.taskStatus(taskID)
+	fields := []any{"status", status, "worker", id}
+	if result != nil {
+		fields = append(fields, "result", *result)
+	}
+	pipe := b.redis.TxPipeline()
+	pipe.HSet(ctx, key, fields...)
+	pipe.Expire(ctx, key, ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (b *RedisBroker) PublishResult(ctx context.Context, taskID, result string) error {
+	return b.redis.Publish(ctx, b.keys.resultChannel(taskID), result).Err()
+}
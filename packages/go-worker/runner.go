@@ -0,0 +1,355 @@
+/*
+Package taskrunner lets Go services consume tasks from the dispatcher. It implements the same Redis
+protocol as the Python TaskRunner: runners register themselves, keep their loaded labels up to date
+for label-aware routing, take tasks from their own queue and the common queue, and publish results.
+Runners reach Redis through a Broker; a MemoryBroker runs them in the same process as the code that
+sends the tasks, without Redis.
+*/
+package taskrunner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// A task received from the dispatcher.
+type Task struct {
+	ID           string            `json:"task_id"`
+	Type         string            `json:"task_type"`
+	Label        string            `json:"label"`
+	Labels       []string          `json:"labels,omitempty"`
+	Parameters   string            `json:"parameters_json"`
+	ReturnResult bool              `json:"return_result"`
+	TraceContext map[string]string `json:"trace_context,omitempty"`
+	// Blob key of parameters the dispatcher offloaded. The runner reads them into Parameters before
+	// calling the handler.
+	ParametersRef string `json:"parameters_ref,omitempty"`
+	// Binary parameters, in place of the JSON parameters, with their content type and compression.
+	// ParametersBytes returns them decompressed.
+	ParametersData     []byte `json:"parameters_data,omitempty"`
+	ParametersType     string `json:"parameters_type,omitempty"`
+	ParametersEncoding string `json:"parameters_encoding,omitempty"`
+}
+
+// Every label the task needs, from both the single label and the labels list, without duplicates and
+// with the single label first.
+func (t *Task) AllLabels() []string {
+	out := []string{}
+	for _, l := range append([]string{t.Label}, t.Labels...) {
+		if l != "" && !slices.Contains(out, l) {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+// Admin command received on the runner's control queue.
+type command struct {
+	Command string `json:"command"`
+	Label   string `json:"label"`
+}
+
+// Function that runs a task type. Handlers load the task's label through the label set when they
+// need it, like the Python task functions do.
+type HandlerFunc func(ctx context.Context, labels *LabelSet, t *Task) (string, error)
+
+// Returned by the runner when a task type has no registered handler.
+var ErrUnknownTask = errors.New("unknown task type")
+
+// Runner settings. Zero values are replaced with the defaults.
+type Options struct {
+	// Maximum number of labels to hold at once. Default: 2.
+	MaxLabels int
+	// Memory in bytes the loaded labels may use, checked against the label sizes registered on the
+	// dispatcher. Zero only limits the number of labels.
+	MemoryBudgetBytes int64
+	// Attributes published for the dispatcher, such as {"gpu": "a100"}. The "pool" attribute puts the
+	// runner in a worker pool, whose common queue it reads instead of the default one.
+	Attributes map[string]string
+	// How long to block waiting for a task before checking whether the runner is draining. Default: 5s.
+	PollTimeout time.Duration
+	// How long task status records are kept after a task finishes. Default: 30m.
+	ResultTTL time.Duration
+	// Namespace of the keys, shared with the dispatcher of the deployment. Deployments with different
+	// namespaces can share a Redis instance. Use letters, digits, '.', '_' and '-'. Default:
+	// "task-runners". Only used by New; brokers from NewRedisBroker take their own. Blobs the runner
+	// stores are named under it too.
+	Namespace string
+	// Blob store shared with the dispatcher, to read offloaded parameters and offload large results.
+	// Tasks with offloaded parameters fail without one.
+	Blobs BlobStore
+	// Results larger than this many bytes are stored in Blobs, and only a reference is published.
+	// Zero never offloads results.
+	BlobThresholdBytes int
+}
+
+func (o Options) withDefaults() Options {
+	if o.MaxLabels <= 0 {
+		o.MaxLabels = 2
+	}
+	if o.PollTimeout <= 0 {
+		o.PollTimeout = 5 * time.Second
+	}
+	if o.ResultTTL <= 0 {
+		o.ResultTTL = 30 * time.Minute
+	}
+	if o.Namespace == "" {
+		o.Namespace = defaultNamespace
+	}
+	return o
+}
+
+// Task runner that consumes tasks from the dispatcher's queues.
+type Runner struct {
+	broker   Broker
+	id       string
+	opts     Options
+	labels   *LabelSet
+	mu       sync.RWMutex
+	handlers map[string]HandlerFunc
+}
+
+// Create a runner with a new random ID, on the dispatcher's Redis.
+func New(r redis.UniversalClient, opts Options) *Runner {
+	return NewWithBroker(NewRedisBroker(r, opts.Namespace), opts)
+}
+
+// Create a runner with a new random ID, on the given broker.
+func NewWithBroker(b Broker, opts Options) *Runner {
+	opts = opts.withDefaults()
+	id := uuid.NewString()
+	return &Runner{
+		broker:   b,
+		id:       id,
+		opts:     opts,
+		labels:   newLabelSet(b, id, opts.MaxLabels, opts.MemoryBudgetBytes),
+		handlers: map[string]HandlerFunc{},
+	}
+}
+
+// Unique ID of the runner, used as its worker ID by the dispatcher.
+func (r *Runner) ID() string {
+	return r.id
+}
+
+// Labels currently held by the runner.
+func (r *Runner) Labels() *LabelSet {
+	return r.labels
+}
+
+// Register the handler for a task type, replacing any previous one.
+func (r *Runner) Handle(taskType string, h HandlerFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers[taskType] = h
+	slog.Info("Registered task handler", "task_type", taskType)
+}
+
+// Register the runner as running and available, and publish its capacity and attributes.
+func (r *Runner) Register(ctx context.Context) error {
+	info := WorkerInfo{MaxLabels: r.opts.MaxLabels, MemoryBudgetBytes: r.opts.MemoryBudgetBytes, Attributes: r.opts.Attributes}
+	if err := r.broker.Register(ctx, r.id, info); err != nil {
+		return err
+	}
+	slog.Info("Task runner registered", "worker_id", r.id)
+	return nil
+}
+
+// Deregister the runner and release its labels.
+func (r *Runner) Deregister(ctx context.Context) error {
+	if err := r.broker.Deregister(ctx, r.id); err != nil {
+		return err
+	}
+	if err := r.labels.Clear(ctx); err != nil {
+		return err
+	}
+	slog.Info("Task runner deregistered", "worker_id", r.id)
+	return nil
+}
+
+// Register the runner and process tasks until the context is cancelled, then deregister. Tasks are
+// run one at a time. Returns the Redis error that stopped the runner, or nil on cancellation.
+func (r *Runner) Run(ctx context.Context) error {
+	if err := r.Register(ctx); err != nil {
+		return err
+	}
+	defer func() {
+		// The run context is done at this point, so clean up with a fresh one
+		if err := r.Deregister(context.WithoutCancel(ctx)); err != nil {
+			slog.Error("Error deregistering task runner", "error", err)
+		}
+	}()
+
+	slog.Info("Task runner listening for tasks", "worker_id", r.id, "pool", r.opts.Attributes[poolAttribute])
+	for ctx.Err() == nil {
+		if err := r.next(ctx); err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Wait for the next task or control command and process it. A draining runner only reads its own
+// queues.
+func (r *Runner) next(ctx context.Context) error {
+	draining, err := r.broker.Draining(ctx, r.id)
+	if err != nil {
+		return err
+	}
+	msg, err := r.broker.Next(ctx, r.id, r.opts.Attributes[poolAttribute], !draining, r.opts.PollTimeout)
+	if err != nil || msg == nil {
+		return err
+	}
+
+	if msg.Control {
+		r.handleControl(ctx, msg.Body)
+		return nil
+	}
+	slog.Info("Received task from queue", "queue", msg.Queue)
+	var t Task
+	if err := json.Unmarshal([]byte(msg.Body), &t); err != nil {
+		slog.Error("Invalid task received", "error", err)
+		return nil
+	}
+	if _, err := r.Process(ctx, &t); err != nil {
+		slog.Error("Task failed", "task_id", t.ID, "error", err)
+	}
+	return nil
+}
+
+// Execute an admin command from the control queue.
+func (r *Runner) handleControl(ctx context.Context, raw string) {
+	var cmd command
+	if err := json.Unmarshal([]byte(raw), &cmd); err != nil {
+		slog.Error("Invalid control command received", "error", err)
+		return
+	}
+	if cmd.Command == commandEvictLabel && cmd.Label != "" {
+		loaded, err := r.labels.Remove(ctx, cmd.Label)
+		if err != nil {
+			slog.Error("Error evicting label", "label", cmd.Label, "error", err)
+			return
+		}
+		slog.Info("Evicted label on admin request", "label", cmd.Label, "loaded", loaded)
+	}
+}
+
+// Run a task with its registered handler, while marking the runner as unavailable. The task status
+// is recorded for the dispatcher, and the result is published if the task requests it. Tasks that
+// fail do not publish a result.
+func (r *Runner) Process(ctx context.Context, t *Task) (result string, err error) {
+	r.mu.RLock()
+	h, ok := r.handlers[t.Type]
+	r.mu.RUnlock()
+	if !ok {
+		err := fmt.Errorf("%w: %s", ErrUnknownTask, t.Type)
+		msg := err.Error()
+		r.recordStatus(ctx, t, statusFailed, &msg)
+		return "", err
+	}
+
+	start := time.Now()
+	if err := r.broker.SetAvailable(ctx, r.id, false); err != nil {
+		return "", err
+	}
+	defer func() {
+		if availErr := r.broker.SetAvailable(ctx, r.id, true); availErr != nil {
+			err = errors.Join(err, availErr)
+		}
+	}()
+
+	r.recordStatus(ctx, t, statusRunning, nil)
+	published := ""
+	err = r.loadParameters(ctx, t)
+	if err == nil {
+		result, err = runHandler(ctx, h, r.labels, t)
+	}
+	if err == nil {
+		published, err = r.offloadResult(ctx, result)
+	}
+	if err != nil {
+		msg := err.Error()
+		r.recordStatus(ctx, t, statusFailed, &msg)
+		return "", err
+	}
+	r.recordStatus(ctx, t, statusCompleted, &published)
+
+	if t.ReturnResult {
+		if err := r.broker.PublishResult(ctx, t.ID, published); err != nil {
+			return result, err
+		}
+	}
+	slog.Info("Task completed", "task_id", t.ID, "worker_id", r.id, "duration", time.Since(start))
+	return result, nil
+}
+
+// Read the parameters the dispatcher offloaded to the blob store, into the binary parameters when
+// the task has a content type or encoding.
+func (r *Runner) loadParameters(ctx context.Context, t *Task) error {
+	if t.ParametersRef == "" {
+		return nil
+	}
+	if r.opts.Blobs == nil {
+		return fmt.Errorf("task parameters are in blob %s, but the runner has no blob store", t.ParametersRef)
+	}
+	data, err := r.opts.Blobs.Get(ctx, t.ParametersRef)
+	if err != nil {
+		return fmt.Errorf("reading task parameters: %w", err)
+	}
+	if t.ParametersType != "" || t.ParametersEncoding != "" {
+		t.ParametersData = data
+	} else {
+		t.Parameters = string(data)
+	}
+	t.ParametersRef = ""
+	return nil
+}
+
+// Store a result larger than the blob threshold in the blob store, and return what to publish for it:
+// the result itself, or a reference the dispatcher resolves.
+func (r *Runner) offloadResult(ctx context.Context, result string) (string, error) {
+	if r.opts.Blobs == nil || r.opts.BlobThresholdBytes <= 0 || len(result) <= r.opts.BlobThresholdBytes {
+		return result, nil
+	}
+	key := r.opts.Namespace + "/" + uuid.NewString()
+	if err := r.opts.Blobs.Put(ctx, key, []byte(result)); err != nil {
+		return "", fmt.Errorf("storing task result: %w", err)
+	}
+	return blobRefPrefix + key, nil
+}
+
+// Call a handler, turning panics into errors so one bad task does not stop the runner.
+func runHandler(ctx context.Context, h HandlerFunc, labels *LabelSet, t *Task) (result string, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("handler panicked: %v", p)
+		}
+	}()
+	return h(ctx, labels, t)
+}
+
+// Record the status of a task, for the dispatcher's task status API. Errors are only logged, since
+// the record is informational.
+func (r *Runner) recordStatus(ctx context.Context, t *Task, status string, result *string) {
+	if err := r.broker.RecordStatus(ctx, r.id, t.ID, status, result, r.opts.ResultTTL); err != nil {
+		slog.Warn("Unable to record task status", "task_id", t.ID, "error", err)
+	}
+}
+
+// Current time as fractional Unix seconds, like Python's time.time().
+func activityTimestamp() string {
+	return strconv.FormatFloat(now(), 'f', 6, 64)
+}
@@ -0,0 +1,432 @@
+package taskrunner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// Keys of the default namespace.
+var keys = newKeyspace(defaultNamespace)
+
+// Create a runner on a mock Redis server, with a short poll timeout so tests stop quickly.
+func mockRunner(t *testing.T) (*Runner, *miniredis.Miniredis, *redis.Client) {
+	mr := miniredis.RunT(t)
+	r := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { r.Close() })
+	return New(r, Options{MaxLabels: 2, PollTimeout: 100 * time.Millisecond}), mr, r
+}
+
+// Start the runner in the background. Returns a function that stops it and returns its error.
+func startRunner(t *testing.T, rn *Runner) func() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- rn.Run(ctx) }()
+	return func() error {
+		cancel()
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(2 * time.Second):
+			t.Fatal("Runner did not stop")
+			return nil
+		}
+	}
+}
+
+// Wait until the condition holds, or fail the test.
+func eventually(t *testing.T, msg string, cond func() bool) {
+	t.Helper()
+	for range 200 {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal(msg)
+}
+
+func pushTask(t *testing.T, r *redis.Client, queue string, task Task) {
+	raw, _ := json.Marshal(task)
+	if err := r.RPush(context.Background(), queue, raw).Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRegisterDeregister(t *testing.T) {
+	rn, mr, _ := mockRunner(t)
+	c := context.Background()
+
+	if err := rn.Register(c); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := mr.SIsMember(keys.running(), rn.ID()); !ok {
+		t.Error("Expected runner to be registered")
+	}
+	if ok, _ := mr.SIsMember(keys.available(), rn.ID()); !ok {
+		t.Error("Expected runner to be available")
+	}
+	if mr.HGet(keys.lastActivity(), rn.ID()) == "" {
+		t.Error("Expected last activity to be recorded")
+	}
+	if mr.HGet(keys.workerInfo(rn.ID()), "max_labels") != "2" || mr.HGet(keys.workerInfo(rn.ID()), "attributes") != "{}" {
+		t.Error("Expected capacity and attributes to be published")
+	}
+
+	rn.Labels().Add(c, "l1")
+	if err := rn.Deregister(c); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := mr.SIsMember(keys.running(), rn.ID()); ok {
+		t.Error("Expected runner to be deregistered")
+	}
+	if ok, _ := mr.SIsMember(keys.label("l1"), rn.ID()); ok || rn.Labels().Len() != 0 {
+		t.Error("Expected labels to be released on deregister")
+	}
+	if mr.Exists(keys.workerInfo(rn.ID())) {
+		t.Error("Expected info to be removed on deregister")
+	}
+}
+
+// Test that the label set keeps the Redis membership sets and counts like the Python LabelHandler
+func TestLabelSetLRU(t *testing.T) {
+	rn, mr, _ := mockRunner(t)
+	c := context.Background()
+	ls := rn.Labels()
+
+	count := func() float64 {
+		s, _ := mr.ZScore(keys.labelCounts(), rn.ID())
+		return s
+	}
+	ls.Add(c, "a")
+	ls.Add(c, "b")
+	if count() != 2 || !ls.Has("a") || !ls.Has("b") {
+		t.Fatalf("Expected 2 labels, got %v (count %v)", ls.List(), count())
+	}
+
+	// Refreshing "a" makes "b" the least recently used, so it is evicted for "c"
+	ls.Add(c, "a")
+	ls.Add(c, "c")
+	if !slices.Equal(ls.List(), []string{"a", "c"}) || count() != 2 {
+		t.Errorf("Expected labels [a c] with count 2, got %v (count %v)", ls.List(), count())
+	}
+	if ok, _ := mr.SIsMember(keys.label("b"), rn.ID()); ok {
+		t.Error("Expected evicted label to be deregistered")
+	}
+	if ok, _ := mr.SIsMember(keys.label("c"), rn.ID()); !ok {
+		t.Error("Expected new label to be registered")
+	}
+
+	if removed, _ := ls.Remove(c, "a"); !removed || count() != 1 {
+		t.Errorf("Expected label to be removed, count %v", count())
+	}
+	if removed, _ := ls.Remove(c, "missing"); removed || count() != 1 {
+		t.Errorf("Expected missing label to leave the count unchanged, count %v", count())
+	}
+}
+
+// Test that adding a set of labels keeps the ones already loaded
+func TestLabelSetAddAll(t *testing.T) {
+	rn, _, _ := mockRunner(t)
+	c := context.Background()
+	ls := rn.Labels()
+	ls.Add(c, "a")
+	ls.Add(c, "b")
+
+	task := Task{Label: "a", Labels: []string{"c", "a"}}
+	if !slices.Equal(task.AllLabels(), []string{"a", "c"}) {
+		t.Fatalf("Unexpected task labels: %v", task.AllLabels())
+	}
+	loaded := []string{}
+	err := ls.AddAll(c, task.AllLabels(), func(label string) error {
+		loaded = append(loaded, label)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(ls.List(), []string{"a", "c"}) || !slices.Equal(loaded, []string{"c"}) {
+		t.Errorf("Expected b to be evicted and only c loaded, got %v (loaded %v)", ls.List(), loaded)
+	}
+}
+
+// Test that labels are evicted, oldest first, to stay within the memory budget, and that the runner
+// publishes its budget and label list for the dispatcher
+func TestLabelSetMemoryBudget(t *testing.T) {
+	mr := miniredis.RunT(t)
+	r := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer r.Close()
+	rn := New(r, Options{MaxLabels: 10, MemoryBudgetBytes: 10, Attributes: map[string]string{"gpu": "a100"}})
+	c := context.Background()
+	ls := rn.Labels()
+
+	if err := rn.Register(c); err != nil {
+		t.Fatal(err)
+	}
+	if mr.HGet(keys.workerInfo(rn.ID()), "memory_budget_bytes") != "10" || mr.HGet(keys.workerInfo(rn.ID()), "attributes") != `{"gpu":"a100"}` {
+		t.Error("Expected memory budget and attributes to be published")
+	}
+	mr.HSet(keys.labelSizes(), "small", "2", "medium", "4", "large", "7")
+	ls.Add(c, "small")
+	ls.Add(c, "medium")
+	ls.Add(c, "small")
+	ls.Add(c, "large")
+	if !slices.Equal(ls.List(), []string{"small", "large"}) || ls.MemoryUsed() != 9 {
+		t.Errorf("Expected medium to be evicted, got %v using %d bytes", ls.List(), ls.MemoryUsed())
+	}
+	if published, _ := r.ZRange(c, keys.workerLabels(rn.ID()), 0, -1).Result(); !slices.Equal(published, ls.List()) {
+		t.Errorf("Expected published labels in LRU order, got %v", published)
+	}
+
+	if err := rn.Deregister(c); err != nil {
+		t.Fatal(err)
+	}
+	if mr.Exists(keys.workerInfo(rn.ID())) || mr.Exists(keys.workerLabels(rn.ID())) {
+		t.Error("Expected worker info and label list to be removed on deregister")
+	}
+}
+
+// Test that tasks from the runner's queue and the common queue are run, and results published
+func TestRunProcessesTasks(t *testing.T) {
+	rn, mr, r := mockRunner(t)
+	c := context.Background()
+	rn.Handle("echo", func(ctx context.Context, ls *LabelSet, task *Task) (string, error) {
+		if err := ls.Add(ctx, task.Label); err != nil {
+			return "", err
+		}
+		return "echo:" + task.Parameters, nil
+	})
+	rn.Handle("fail", func(ctx context.Context, ls *LabelSet, task *Task) (string, error) {
+		return "", errors.New("boom")
+	})
+
+	sub := r.Subscribe(c, keys.resultChannel("t1"))
+	defer sub.Close()
+	if _, err := sub.Receive(c); err != nil {
+		t.Fatal(err)
+	}
+
+	stop := startRunner(t, rn)
+	pushTask(t, r, keys.jobQueue(rn.ID()), Task{ID: "t1", Type: "echo", Label: "l1", Parameters: "{}", ReturnResult: true})
+	pushTask(t, r, keys.commonQueue(), Task{ID: "t2", Type: "fail"})
+
+	msg, err := sub.ReceiveMessage(c)
+	if err != nil || msg.Payload != "echo:{}" {
+		t.Fatalf("Expected published result, got %v %v", msg, err)
+	}
+	eventually(t, "Expected failed task status", func() bool {
+		return mr.HGet(keys.taskStatus("t2"), "status") == statusFailed
+	})
+	if mr.HGet(keys.taskStatus("t1"), "status") != statusCompleted || mr.HGet(keys.taskStatus("t1"), "worker") != rn.ID() {
+		t.Error("Expected completed task status with the runner ID")
+	}
+	if mr.HGet(keys.taskStatus("t2"), "result") != "boom" {
+		t.Error("Expected the error in the failed task status")
+	}
+	if ok, _ := mr.SIsMember(keys.label("l1"), rn.ID()); !ok {
+		t.Error("Expected the handler's label to be registered")
+	}
+	eventually(t, "Expected runner to be available again", func() bool {
+		ok, _ := mr.SIsMember(keys.available(), rn.ID())
+		return ok
+	})
+
+	if err := stop(); err != nil {
+		t.Errorf("Expected clean stop, got %v", err)
+	}
+	if ok, _ := mr.SIsMember(keys.running(), rn.ID()); ok {
+		t.Error("Expected runner to deregister when stopped")
+	}
+}
+
+// Test that a draining runner ignores the common queue, and that control commands evict labels
+func TestRunDrainingAndControl(t *testing.T) {
+	rn, mr, r := mockRunner(t)
+	c := context.Background()
+	rn.Handle("noop", func(context.Context, *LabelSet, *Task) (string, error) { return "", nil })
+	rn.Labels().Add(c, "l1")
+
+	mr.SAdd(keys.draining(), rn.ID())
+	stop := startRunner(t, rn)
+	defer stop()
+	pushTask(t, r, keys.commonQueue(), Task{ID: "common", Type: "noop"})
+	pushTask(t, r, keys.jobQueue(rn.ID()), Task{ID: "own", Type: "noop"})
+	r.RPush(c, keys.controlQueue(rn.ID()), `{"command": "evict_label", "label": "l1"}`)
+
+	eventually(t, "Expected own task to run", func() bool {
+		return mr.HGet(keys.taskStatus("own"), "status") == statusCompleted
+	})
+	eventually(t, "Expected label to be evicted", func() bool { return !rn.Labels().Has("l1") })
+	time.Sleep(200 * time.Millisecond)
+	if n, _ := r.LLen(c, keys.commonQueue()).Result(); n != 1 {
+		t.Errorf("Expected draining runner to leave the common queue alone, got %d tasks", n)
+	}
+	if ok, _ := mr.SIsMember(keys.label("l1"), rn.ID()); ok {
+		t.Error("Expected evicted label to be deregistered")
+	}
+}
+
+// Test that a runner in a pool takes tasks from the pool's common queue instead of the default one
+func TestRunPoolQueue(t *testing.T) {
+	mr := miniredis.RunT(t)
+	r := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer r.Close()
+	rn := New(r, Options{PollTimeout: 100 * time.Millisecond, Attributes: map[string]string{"pool": "highmem"}})
+	c := context.Background()
+	rn.Handle("noop", func(context.Context, *LabelSet, *Task) (string, error) { return "", nil })
+
+	stop := startRunner(t, rn)
+	defer stop()
+	pushTask(t, r, keys.commonQueue(), Task{ID: "default", Type: "noop"})
+	pushTask(t, r, keys.poolQueue("highmem"), Task{ID: "pooled", Type: "noop"})
+
+	eventually(t, "Expected pool task to run", func() bool {
+		return mr.HGet(keys.taskStatus("pooled"), "status") == statusCompleted
+	})
+	time.Sleep(200 * time.Millisecond)
+	if n, _ := r.LLen(c, keys.commonQueue()).Result(); n != 1 {
+		t.Errorf("Expected pooled runner to leave the default common queue alone, got %d tasks", n)
+	}
+}
+
+// Test that runners only register and take tasks in their own namespace
+func TestRunNamespace(t *testing.T) {
+	mr := miniredis.RunT(t)
+	r := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer r.Close()
+	rn := New(r, Options{PollTimeout: 100 * time.Millisecond, Namespace: "staging"})
+	staging := newKeyspace("staging")
+	c := context.Background()
+	rn.Handle("load", func(ctx context.Context, ls *LabelSet, task *Task) (string, error) {
+		return "", ls.Add(ctx, task.Label)
+	})
+
+	stop := startRunner(t, rn)
+	defer stop()
+	pushTask(t, r, keys.commonQueue(), Task{ID: "default", Type: "load", Label: "l1"})
+	pushTask(t, r, staging.commonQueue(), Task{ID: "staged", Type: "load", Label: "l1"})
+
+	eventually(t, "Expected staging task to run", func() bool {
+		return mr.HGet(staging.taskStatus("staged"), "status") == statusCompleted
+	})
+	if ok, _ := mr.SIsMember(staging.running(), rn.ID()); !ok {
+		t.Error("Expected runner to register in its namespace")
+	}
+	if ok, _ := mr.SIsMember(staging.label("l1"), rn.ID()); !ok {
+		t.Error("Expected label to be registered in the namespace")
+	}
+	if mr.Exists(keys.running()) || mr.Exists(keys.label("l1")) {
+		t.Error("Expected nothing registered in the default namespace")
+	}
+	if n, _ := r.LLen(c, keys.commonQueue()).Result(); n != 1 {
+		t.Errorf("Expected runner to leave the default common queue alone, got %d tasks", n)
+	}
+}
+
+// Test that a task of an unknown type fails and is recorded as failed, so that its status does not
+// stay queued
+func TestProcessUnknownTask(t *testing.T) {
+	b := NewMemoryBroker()
+	rn := NewWithBroker(b, Options{})
+	if _, err := rn.Process(context.Background(), &Task{ID: "x", Type: "missing"}); !errors.Is(err, ErrUnknownTask) {
+		t.Errorf("Expected ErrUnknownTask, got %v", err)
+	}
+	if status, _ := b.Status("x"); status != statusFailed {
+		t.Errorf("Expected the task to be recorded as failed, got %q", status)
+	}
+}
+
+// Test that offloaded parameters are read from the blob store, and that large results are offloaded
+// with only a reference published and recorded
+func TestProcessBlobs(t *testing.T) {
+	dir := t.TempDir()
+	blobs := NewFileBlobStore(dir)
+	b := NewMemoryBroker()
+	rn := NewWithBroker(b, Options{Blobs: blobs, BlobThresholdBytes: 8})
+	rn.Handle("echo", func(_ context.Context, _ *LabelSet, task *Task) (string, error) {
+		return task.Parameters, nil
+	})
+	c := context.Background()
+	blobs.Put(c, "task-runners/params", []byte(`{"text": "large parameters"}`))
+
+	result, err := rn.Process(c, &Task{ID: "t1", Type: "echo", ParametersRef: "task-runners/params", ReturnResult: true})
+	if err != nil || result != `{"text": "large parameters"}` {
+		t.Fatalf("Expected the parameters from the blob, got %q %v", result, err)
+	}
+	published, err := b.Result(c, "t1")
+	key, ok := strings.CutPrefix(published, blobRefPrefix)
+	if err != nil || !ok || !strings.HasPrefix(key, defaultNamespace+"/") {
+		t.Fatalf("Expected a blob reference to be published, got %q %v", published, err)
+	}
+	if data, err := blobs.Get(c, key); err != nil || string(data) != result {
+		t.Errorf("Expected the result in the blob, got %q %v", data, err)
+	}
+
+	// Small results are published as they are
+	if _, err := rn.Process(c, &Task{ID: "t2", Type: "echo", Parameters: "{}", ReturnResult: true}); err != nil {
+		t.Fatal(err)
+	}
+	if published, _ := b.Result(c, "t2"); published != "{}" {
+		t.Errorf("Expected the result itself, got %q", published)
+	}
+
+	for _, task := range []*Task{
+		{ID: "t3", Type: "echo", ParametersRef: "task-runners/missing"},
+		{ID: "t4", Type: "echo", ParametersRef: "../outside"},
+	} {
+		if _, err := rn.Process(c, task); err == nil {
+			t.Errorf("Expected task %s to fail", task.ID)
+		}
+		if status, _ := b.Status(task.ID); status != statusFailed {
+			t.Errorf("Expected task %s to be recorded as failed, got %q", task.ID, status)
+		}
+	}
+}
+
+// Test that binary parameters reach the handler decompressed, from the task or a blob, and that
+// encoded results are published in the format the dispatcher decodes
+func TestProcessBinaryPayloads(t *testing.T) {
+	blobs := NewFileBlobStore(t.TempDir())
+	b := NewMemoryBroker()
+	rn := NewWithBroker(b, Options{Blobs: blobs})
+	rn.Handle("reverse", func(_ context.Context, _ *LabelSet, task *Task) (string, error) {
+		data, err := task.ParametersBytes()
+		if err != nil {
+			return "", err
+		}
+		out := slices.Clone(data)
+		slices.Reverse(out)
+		return EncodeResult(ContentBinary, out, task.ID == "t1"), nil
+	})
+	c := context.Background()
+
+	compressed := zstdEncoder.EncodeAll([]byte{1, 2, 0xff}, nil)
+	if _, err := rn.Process(c, &Task{ID: "t1", Type: "reverse", ParametersData: compressed, ParametersType: ContentBinary, ParametersEncoding: EncodingZstd, ReturnResult: true}); err != nil {
+		t.Fatal(err)
+	}
+	published, _ := b.Result(c, "t1")
+	header, data, _ := strings.Cut(strings.TrimPrefix(published, resultPayloadPrefix), "\n")
+	if plain, err := zstdDecoder.DecodeAll([]byte(data), nil); header != ContentBinary+";zstd" || err != nil || !bytes.Equal(plain, []byte{0xff, 2, 1}) {
+		t.Errorf("Expected the compressed binary result, got %q %v", published, err)
+	}
+
+	blobs.Put(c, "task-runners/params", []byte{3, 4})
+	if _, err := rn.Process(c, &Task{ID: "t2", Type: "reverse", ParametersRef: "task-runners/params", ParametersType: ContentBinary, ReturnResult: true}); err != nil {
+		t.Fatal(err)
+	}
+	if published, _ := b.Result(c, "t2"); published != resultPayloadPrefix+ContentBinary+"\n\x04\x03" {
+		t.Errorf("Expected the binary result, got %q", published)
+	}
+
+	if _, err := rn.Process(c, &Task{ID: "t3", Type: "reverse", ParametersData: []byte("x"), ParametersEncoding: EncodingZstd}); err == nil {
+		t.Error("Expected invalid compressed parameters to fail the task")
+	}
+}
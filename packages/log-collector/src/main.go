@@ -3,6 +3,8 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -11,12 +13,40 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"time"
 )
 
+const certReloadIntervalSeconds = 30
+
 type response struct {
 	Message string `json:"message"`
 }
 
+// Start serving HTTP, using TLS when TLS_CERT_FILE and TLS_KEY_FILE are set. TLS_CLIENT_AUTH can be
+// "optional" or "require" to verify client certificates against TLS_CA_FILE.
+func listenAndServe(addr string) error {
+	certFile, keyFile, caFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE"), os.Getenv("TLS_CA_FILE")
+	if certFile == "" && keyFile == "" {
+		return http.ListenAndServe(addr, nil)
+	}
+	clientAuth, err := parseClientAuth(os.Getenv("TLS_CLIENT_AUTH"))
+	if err != nil {
+		return err
+	}
+	if clientAuth != tls.NoClientCert && caFile == "" {
+		return fmt.Errorf("TLS_CA_FILE is required to verify client certificates")
+	}
+	store, err := newCertStore(certFile, keyFile, caFile)
+	if err != nil {
+		return fmt.Errorf("server TLS setup: %w", err)
+	}
+	go store.watch(context.Background(), certReloadIntervalSeconds*time.Second)
+
+	srv := &http.Server{Addr: addr, TLSConfig: store.serverConfig(clientAuth)}
+	slog.Info("TLS enabled", "client_auth", clientAuth.String())
+	return srv.ListenAndServeTLS("", "")
+}
+
 // Check if parent directory exists, if not, create it
 func chekParentDir(path string) {
 	dir := filepath.Dir(path)
@@ -82,5 +112,5 @@ func main() {
 	// Start HTTP server
 	addr := fmt.Sprintf("0.0.0.0:%s", os.Getenv("PORT"))
 	slog.Info("Starting server", "address", addr)
-	log.Fatal(http.ListenAndServe(addr, nil))
+	log.Fatal(listenAndServe(addr))
 }
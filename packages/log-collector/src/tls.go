@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Holds the server certificate and client CA bundle, reloading them when the files change.
+type certStore struct {
+	certFile string
+	keyFile  string
+	caFile   string
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	pool     *x509.CertPool
+	modTimes map[string]time.Time
+}
+
+// Create a certificate store and load the initial certificates from disk.
+func newCertStore(certFile, keyFile, caFile string) (*certStore, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("certificate and key files must be provided together")
+	}
+	s := &certStore{certFile: certFile, keyFile: keyFile, caFile: caFile, modTimes: map[string]time.Time{}}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Load the certificates from disk, keeping the previous ones if loading fails.
+func (s *certStore) reload() error {
+	c, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading key pair: %w", err)
+	}
+	var pool *x509.CertPool
+	if s.caFile != "" {
+		pem, err := os.ReadFile(s.caFile)
+		if err != nil {
+			return fmt.Errorf("reading CA file: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in CA file %s", s.caFile)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cert = &c
+	s.pool = pool
+	for _, p := range s.paths() {
+		if st, err := os.Stat(p); err == nil {
+			s.modTimes[p] = st.ModTime()
+		}
+	}
+	return nil
+}
+
+func (s *certStore) paths() []string {
+	out := []string{s.certFile, s.keyFile}
+	if s.caFile != "" {
+		out = append(out, s.caFile)
+	}
+	return out
+}
+
+// Check whether any of the tracked files changed since they were last loaded.
+func (s *certStore) changed() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, p := range s.paths() {
+		if st, err := os.Stat(p); err == nil && !st.ModTime().Equal(s.modTimes[p]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Build a server TLS config that always uses the most recently loaded certificates.
+func (s *certStore) serverConfig(clientAuth tls.ClientAuthType) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			s.mu.RLock()
+			defer s.mu.RUnlock()
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*s.cert},
+				ClientCAs:    s.pool,
+				ClientAuth:   clientAuth,
+			}, nil
+		},
+	}
+}
+
+// Reload the certificates on SIGHUP or when the files change on disk, until the context is done.
+func (s *certStore) watch(c context.Context, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		reason := ""
+		select {
+		case <-c.Done():
+			return
+		case <-hup:
+			reason = "signal"
+		case <-ticker.C:
+			if !s.changed() {
+				continue
+			}
+			reason = "file change"
+		}
+		if err := s.reload(); err != nil {
+			slog.Error("Unable to reload certificates!", "error", err, "reason", reason)
+			continue
+		}
+		slog.Info("Reloaded certificates", "reason", reason)
+	}
+}
+
+// Parse the client certificate verification mode for the HTTP server.
+func parseClientAuth(mode string) (tls.ClientAuthType, error) {
+	switch strings.ToLower(mode) {
+	case "", "none":
+		return tls.NoClientCert, nil
+	case "optional":
+		return tls.VerifyClientCertIfGiven, nil
+	case "require":
+		return tls.RequireAndVerifyClientCert, nil
+	}
+	return tls.NoClientCert, fmt.Errorf("invalid client auth mode: %q", mode)
+}
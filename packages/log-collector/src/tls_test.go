@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCert creates a self-signed certificate for the given common name and writes the PEM
+// encoded cert and key to dir, returning the certificate and the paths of the files.
+func writeTestCert(t *testing.T, dir, name string) (*x509.Certificate, string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Error creating certificate: %v", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Error marshalling key: %v", err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := os.WriteFile(certFile, certPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, keyPem, 0600); err != nil {
+		t.Fatal(err)
+	}
+	// Move the mod time forward, so files rewritten within the file system's time resolution are
+	// still seen as changed
+	later := time.Now().Add(time.Minute)
+	for _, p := range []string{certFile, keyFile} {
+		if err := os.Chtimes(p, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert, certFile, keyFile
+}
+
+// Get the serial number of the certificate the server presents.
+func servedSerial(t *testing.T, url string) *big.Int {
+	t.Helper()
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	rsp, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("Error connecting to the server: %v", err)
+	}
+	rsp.Body.Close()
+	return rsp.TLS.PeerCertificates[0].SerialNumber
+}
+
+// Test that the server presents certificates replaced on disk once the watcher reloads them, and
+// keeps the previous ones when the new files cannot be loaded.
+func TestCertStoreReload(t *testing.T) {
+	dir := t.TempDir()
+	first, certFile, keyFile := writeTestCert(t, dir, "server")
+	s, err := newCertStore(certFile, keyFile, "")
+	if err != nil {
+		t.Fatalf("Error loading certificates: %v", err)
+	}
+	if s.changed() {
+		t.Error("Expected no changes right after loading")
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	srv.TLS = s.serverConfig(tls.NoClientCert)
+	srv.StartTLS()
+	defer srv.Close()
+	if got := servedSerial(t, srv.URL); got.Cmp(first.SerialNumber) != 0 {
+		t.Fatalf("Expected the loaded certificate to be served, got serial %v", got)
+	}
+
+	c, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.watch(c, 10*time.Millisecond)
+
+	second, _, _ := writeTestCert(t, dir, "server")
+	deadline := time.Now().Add(5 * time.Second)
+	for servedSerial(t, srv.URL).Cmp(second.SerialNumber) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the replaced certificate to be served")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// A broken key pair is reported and the current certificate stays in use
+	if err := os.WriteFile(keyFile, []byte("not a key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.reload(); err == nil {
+		t.Error("Expected reloading a broken key pair to fail")
+	}
+	if got := servedSerial(t, srv.URL); got.Cmp(second.SerialNumber) != 0 {
+		t.Errorf("Expected the previous certificate to stay in use, got serial %v", got)
+	}
+}
+
+func TestParseClientAuth(t *testing.T) {
+	cases := map[string]tls.ClientAuthType{
+		"":         tls.NoClientCert,
+		"none":     tls.NoClientCert,
+		"optional": tls.VerifyClientCertIfGiven,
+		"REQUIRE":  tls.RequireAndVerifyClientCert,
+	}
+	for in, exp := range cases {
+		got, err := parseClientAuth(in)
+		if err != nil || got != exp {
+			t.Errorf("parseClientAuth(%q) = %v, %v; expected %v", in, got, err, exp)
+		}
+	}
+	if _, err := parseClientAuth("sometimes"); err == nil {
+		t.Error("Expected error for invalid client auth mode")
+	}
+}
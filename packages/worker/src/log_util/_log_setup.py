@@ -7,16 +7,26 @@ from functools import partial
 from ._settings import LoggingSettings
 
 
-def _send_to_collector(log_msg: str, host: str):
+def _send_to_collector(
+    log_msg: str,
+    host: str,
+    verify: str | bool = True,
+    cert: tuple[str, str] | None = None,
+):
     """
     Send a log message to the log collector service.
     :param log_msg: Log message to send.
     :param host: Host URL of the log collector service.
+    :param verify: CA bundle path to verify the collector, or True to use
+        the system defaults.
+    :param cert: Optional (certificate, key) paths for mutual TLS.
     """
     requests.post(
         f"{host}/log",
         data=formatter(log_msg.record),
         headers={"Content-Type": "text/plain"},
+        verify=verify,
+        cert=cert,
     )
 
 
@@ -52,8 +62,16 @@ def setup_logs(settings: LoggingSettings | None = None):
     logger.add(sys.stderr, level=settings.base_level)
 
     if settings.collector_enabled:
+        cert = None
+        if settings.collector_cert_file and settings.collector_key_file:
+            cert = (settings.collector_cert_file, settings.collector_key_file)
         logger.add(
-            partial(_send_to_collector, host=settings.collector_host),
+            partial(
+                _send_to_collector,
+                host=settings.collector_host,
+                verify=settings.collector_ca_file or True,
+                cert=cert,
+            ),
             level=settings.collector_level,
             serialize=False,
             enqueue=True,
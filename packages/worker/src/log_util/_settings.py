@@ -25,5 +25,17 @@ class LoggingSettings(BaseSettings):
         default="INFO",
         description="Logging level to send to the collector service",
     )
+    collector_ca_file: str | None = Field(
+        default=None,
+        description="CA bundle to verify the collector's TLS certificate",
+    )
+    collector_cert_file: str | None = Field(
+        default=None,
+        description="Client certificate to present to the collector",
+    )
+    collector_key_file: str | None = Field(
+        default=None,
+        description="Client key to present to the collector",
+    )
 
     model_config = SettingsConfigDict(env_prefix="LOG_")
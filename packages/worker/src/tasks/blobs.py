@@ -0,0 +1,99 @@
+import os
+import re
+import tempfile
+from typing import Protocol
+
+from .constants import BLOB_KEY_PATTERN
+from .settings import WorkerSettings
+
+
+def check_key(key: str) -> str:
+    """
+    Check that a blob key is safe to use as a file path and object name.
+    :param key: Blob key, as "<namespace>/<name>".
+    :return: The key.
+    """
+    if not re.match(BLOB_KEY_PATTERN, key) or ".." in key:
+        raise ValueError(f"Invalid blob key '{key}'")
+    return key
+
+
+class BlobStore(Protocol):
+    """
+    Storage for payloads too large to pass through Redis, shared with the
+    dispatcher. The dispatcher deletes blobs once they are older than its
+    blob TTL.
+    """
+
+    def put(self, key: str, data: bytes) -> None: ...
+
+    def get(self, key: str) -> bytes: ...
+
+
+class FileBlobStore:
+    """
+    Blob store on a directory shared with the dispatcher, such as a mounted
+    volume.
+    """
+
+    def __init__(self, directory: str):
+        self.__dir = directory
+
+    def put(self, key: str, data: bytes) -> None:
+        """
+        Write the blob to a temporary file and rename it, so that readers
+        never see a partial blob.
+        """
+        path = os.path.join(self.__dir, check_key(key))
+        os.makedirs(os.path.dirname(path), exist_ok=True)
+        fd, tmp = tempfile.mkstemp(prefix=".tmp-", dir=os.path.dirname(path))
+        try:
+            with os.fdopen(fd, "wb") as f:
+                f.write(data)
+            os.replace(tmp, path)
+        except BaseException:
+            os.unlink(tmp)
+            raise
+
+    def get(self, key: str) -> bytes:
+        with open(os.path.join(self.__dir, check_key(key)), "rb") as f:
+            return f.read()
+
+
+class S3BlobStore:
+    """
+    Blob store on a bucket of an S3-compatible object store. Needs boto3,
+    from the worker's 's3' extra.
+    """
+
+    def __init__(self, settings: WorkerSettings):
+        import boto3
+
+        self.__bucket = settings.blob_s3_bucket
+        self.__client = boto3.client(
+            "s3",
+            endpoint_url=settings.blob_s3_endpoint,
+            region_name=settings.blob_s3_region,
+            aws_access_key_id=settings.blob_s3_access_key_id,
+            aws_secret_access_key=settings.blob_s3_secret_access_key,
+        )
+
+    def put(self, key: str, data: bytes) -> None:
+        self.__client.put_object(
+            Bucket=self.__bucket, Key=check_key(key), Body=data
+        )
+
+    def get(self, key: str) -> bytes:
+        obj = self.__client.get_object(Bucket=self.__bucket, Key=check_key(key))
+        return obj["Body"].read()
+
+
+def create_blob_store(settings: WorkerSettings) -> BlobStore:
+    """
+    Create the blob store configured in the settings.
+    :param settings: Worker settings with the blob store options.
+    :return: The blob store.
+    """
+    if settings.blob_backend == "s3":
+        return S3BlobStore(settings)
+    return FileBlobStore(settings.blob_dir)
@@ -0,0 +1,64 @@
+import redis
+from redis.cluster import RedisCluster
+from redis.sentinel import Sentinel, SentinelManagedSSLConnection
+
+from .settings import WorkerSettings
+
+
+def connect(settings: WorkerSettings) -> redis.Redis | RedisCluster:
+    """
+    Create the Redis client: a single node client, a client for the master
+    monitored by Sentinel that follows failovers, or a cluster client.
+    :param settings: Worker settings with the Redis connection options.
+    :return: The Redis client, decoding responses to strings.
+    """
+    auth = {
+        "username": settings.redis_username,
+        "password": settings.redis_password,
+        "decode_responses": True,
+    }
+    tls = {}
+    if settings.redis_ssl:
+        tls = {
+            "ssl": True,
+            "ssl_ca_certs": settings.redis_ssl_ca_certs,
+            "ssl_certfile": settings.redis_ssl_certfile,
+            "ssl_keyfile": settings.redis_ssl_keyfile,
+        }
+
+    if settings.redis_master_name:
+        sentinels = []
+        for addr in settings.redis_sentinels:
+            host, _, port = addr.rpartition(":")
+            sentinels.append((host, int(port)))
+        sentinel = Sentinel(
+            sentinels,
+            sentinel_kwargs={
+                "password": settings.redis_sentinel_password,
+                **tls,
+            },
+        )
+        master_tls = {}
+        if tls:
+            master_tls = {k: v for k, v in tls.items() if k != "ssl"}
+            master_tls["connection_class"] = SentinelManagedSSLConnection
+        return sentinel.master_for(
+            settings.redis_master_name,
+            db=settings.redis_db,
+            **auth,
+            **master_tls,
+        )
+    if settings.redis_cluster:
+        return RedisCluster(
+            host=settings.redis_host,
+            port=settings.redis_port,
+            **auth,
+            **tls,
+        )
+    return redis.Redis(
+        host=settings.redis_host,
+        port=settings.redis_port,
+        db=settings.redis_db,
+        **auth,
+        **tls,
+    )
@@ -1,9 +1,36 @@
-REGISTER_KEY: str = "task-runners:running"
+# Namespace of the keys when none is configured. Deployments with different
+# namespaces can share one Redis without seeing each other's workers.
+DEFAULT_NAMESPACE: str = "task-runners"
 
-COMMON_QUEUE: str = "task-runners:all:jobs"
+# Namespaces are used in key patterns and hash tags, so they cannot contain
+# glob characters or braces.
+NAMESPACE_PATTERN: str = r"^[A-Za-z0-9._-]+$"
 
-AVAILABLE_KEY: str = "task-runners:available"
+POOL_ATTRIBUTE: str = "pool"
 
-LABEL_KEY_FMT: str = "task-runners:labels:{label}:workers"
+# Typed and offloaded results start with this envelope: a byte that cannot
+# start valid UTF-8 text, then the envelope version. Results without it are
+# plain text to the dispatcher.
+RESULT_ENVELOPE: bytes = b"\xff\x01"
 
-LABEL_COUNTS_KEY: str = "task-runners:labels:count"
+# Results stored as blobs are published as this prefix followed by the blob
+# key, which the dispatcher resolves.
+BLOB_REF_PREFIX: bytes = RESULT_ENVELOPE + b"blob:"
+
+# Blob keys are "<namespace>/<name>", safe to use as file paths and object
+# names.
+BLOB_KEY_PATTERN: str = r"^[A-Za-z0-9._-]+/[A-Za-z0-9._-]+$"
+
+# Content types of task parameters and results, besides plain text results,
+# and the compression they may use.
+CONTENT_JSON: str = "application/json"
+CONTENT_MSGPACK: str = "application/msgpack"
+CONTENT_BINARY: str = "application/octet-stream"
+ENCODING_ZSTD: str = "zstd"
+
+# Results with a content type are published as this prefix, followed by
+# "<content type>[;<encoding>]\n" and the result's bytes.
+RESULT_PAYLOAD_PREFIX: bytes = RESULT_ENVELOPE + b"payload:"
+
+# Limit on the size of decompressed parameters.
+MAX_DECOMPRESSED_BYTES: int = 1 << 30
@@ -0,0 +1,76 @@
+from .constants import DEFAULT_NAMESPACE
+
+
+class Keyspace:
+    """
+    Builds the Redis keys and channels of one deployment. Every name starts
+    with the namespace as a Redis Cluster hash tag, so that all keys share
+    one slot and multi-key commands, such as BLPOP over several queues, work
+    on a cluster.
+    """
+
+    def __init__(self, namespace: str = DEFAULT_NAMESPACE):
+        """
+        :param namespace: Namespace shared with the dispatcher of the
+            deployment.
+        """
+        self.__prefix = f"{{{namespace}}}:"
+
+    @property
+    def prefix(self) -> str:
+        """
+        Get the prefix of every key in the namespace.
+        """
+        return self.__prefix
+
+    @property
+    def running(self) -> str:
+        return self.__prefix + "running"
+
+    @property
+    def available(self) -> str:
+        return self.__prefix + "available"
+
+    @property
+    def draining(self) -> str:
+        return self.__prefix + "draining"
+
+    @property
+    def last_activity(self) -> str:
+        return self.__prefix + "last-activity"
+
+    @property
+    def label_counts(self) -> str:
+        return self.__prefix + "labels:count"
+
+    @property
+    def label_sizes(self) -> str:
+        return self.__prefix + "labels:sizes"
+
+    @property
+    def common_queue(self) -> str:
+        return self.__prefix + "all:jobs"
+
+    def pool_queue(self, pool: str) -> str:
+        return f"{self.__prefix}pool:{pool}:jobs"
+
+    def label(self, label: str) -> str:
+        return f"{self.__prefix}labels:{label}:workers"
+
+    def job_queue(self, uuid: str) -> str:
+        return f"{self.__prefix}{uuid}:jobs"
+
+    def control_queue(self, uuid: str) -> str:
+        return f"{self.__prefix}{uuid}:control"
+
+    def worker_info(self, uuid: str) -> str:
+        return f"{self.__prefix}{uuid}:info"
+
+    def worker_labels(self, uuid: str) -> str:
+        return f"{self.__prefix}{uuid}:labels"
+
+    def result_channel(self, task_id: str) -> str:
+        return f"{self.__prefix}results:{task_id}"
+
+    def task_status(self, task_id: str) -> str:
+        return f"{self.__prefix}tasks:{task_id}"
@@ -1,29 +1,46 @@
+import time
+
 import redis
+from redis.cluster import RedisCluster
 from loguru import logger
 from datetime import datetime, UTC
 from collections import OrderedDict
 
-from . import constants as const
+from .keys import Keyspace
 
 
 class LabelHandler:
     """
     Class for handling the worker's labels. This works as an LRU cache with
-    a maximum number of labels that can be stored.
+    a maximum number of labels that can be stored and, optionally, a memory
+    budget shared by the labels, using the sizes in the label registry.
     """
 
     def __init__(
-        self, runner_uuid: str, redis_client: redis.Redis, max_labels: int = 2
+        self,
+        runner_uuid: str,
+        redis_client: redis.Redis | RedisCluster,
+        max_labels: int = 2,
+        memory_budget: int | None = None,
+        keys: Keyspace | None = None,
     ):
         """
         :param runner_uuid: Unique identifier for the task runner.
         :param redis_client: Redis client instance.
         :param max_labels: Maximum number of labels to store.
+        :param memory_budget: Memory in bytes the labels may use, or None
+            to only limit the number of labels.
+        :param keys: Keys of the worker's namespace, the default namespace
+            if None.
         """
         self.__redis = redis_client
         self.__loaded_labels: OrderedDict[str, datetime] = OrderedDict()
+        self.__sizes: dict[str, int] = {}
         self.__max_labels = max_labels
+        self.__memory_budget = memory_budget
         self.__runner_uuid = runner_uuid
+        self.__keys = keys or Keyspace()
+        self.__labels_key = self.__keys.worker_labels(runner_uuid)
 
     @property
     def runner_uuid(self) -> str:
@@ -34,7 +51,7 @@ class LabelHandler:
         return self.__runner_uuid
 
     @property
-    def redis(self) -> redis.Redis:
+    def redis(self) -> redis.Redis | RedisCluster:
         """
         Get the Redis client.
         """
@@ -47,18 +64,56 @@ class LabelHandler:
         """
         return self.__max_labels
 
+    @property
+    def memory_budget(self) -> int | None:
+        """
+        Get the memory budget for the labels in bytes, if any.
+        """
+        return self.__memory_budget
+
+    @property
+    def memory_used(self) -> int:
+        """
+        Get the memory used by the loaded labels in bytes, according to the
+        label registry when they were loaded.
+        """
+        return sum(self.__sizes.values())
+
+    def label_size(self, label: str) -> int:
+        """
+        Get the size of a label from the label registry.
+        :param label: Label to look up.
+        :return: Size in bytes, or 0 if the label is not registered.
+        """
+        size = self.redis.hget(self.__keys.label_sizes, label)
+        return int(size) if size is not None else 0
+
+    def __is_full(self, size: int) -> bool:
+        """
+        Check whether a label of the given size requires an eviction.
+        """
+        if len(self.__loaded_labels) >= self.max_labels:
+            return True
+        budget = self.memory_budget
+        return budget is not None and self.memory_used + size > budget
+
     def add_label(self, label: str):
         """
-        Register a label for the worker.
+        Register a label for the worker. The least recently used labels are
+        evicted until the new label fits within the limits. A label larger
+        than the whole budget is loaded once every other label is evicted.
         :param label:
         :return:
         """
         if label in self.__loaded_labels:
             self.__loaded_labels.pop(label)
             self.__loaded_labels[label] = datetime.now(UTC)
+            self.redis.zadd(self.__labels_key, {label: time.time()})
             logger.debug("Label refreshed [{}]", label)
+            return
 
-        elif len(self.__loaded_labels) >= self.max_labels:
+        size = self.label_size(label)
+        while self.__loaded_labels and self.__is_full(size):
             old, loaded_at = self.__loaded_labels.popitem(last=False)
             self.__deregister_label(old)
             logger.info(
@@ -66,17 +121,16 @@ class LabelHandler:
                 old,
                 loaded_at,
             )
-            self.__loaded_labels[label] = datetime.now(UTC)
-            logger.debug("Label added [{}]", label)
 
-        else:
-            self.__loaded_labels[label] = datetime.now(UTC)
-            logger.info("Label added [{}]", label)
+        self.__loaded_labels[label] = datetime.now(UTC)
+        self.__sizes[label] = size
+        logger.info("Label added [{}] size [{}]", label, size)
 
         self.redis.sadd(
-            const.LABEL_KEY_FMT.format(label=label), self.runner_uuid
+            self.__keys.label(label), self.runner_uuid
         )
-        self.redis.zincrby(const.LABEL_COUNTS_KEY, 1, self.runner_uuid)
+        self.redis.zincrby(self.__keys.label_counts, 1, self.runner_uuid)
+        self.redis.zadd(self.__labels_key, {label: time.time()})
 
     def remove_label(self, label: str) -> datetime | None:
         """
@@ -101,9 +155,11 @@ class LabelHandler:
         :param label: Label to deregister.
         """
         self.redis.srem(
-            const.LABEL_KEY_FMT.format(label=label), self.runner_uuid
+            self.__keys.label(label), self.runner_uuid
         )
-        self.redis.zincrby(const.LABEL_COUNTS_KEY, -1, self.runner_uuid)
+        self.redis.zincrby(self.__keys.label_counts, -1, self.runner_uuid)
+        self.redis.zrem(self.__labels_key, label)
+        self.__sizes.pop(label, None)
         logger.debug("Label deregistered [{}]", label)
 
     def clear_all(self):
@@ -0,0 +1,62 @@
+from dataclasses import dataclass
+
+from . import constants as const
+
+
+def decompress(encoding: str | None, data: bytes) -> bytes:
+    """
+    Decompress a payload. Needs zstandard, from the worker's 'binary' extra,
+    for compressed payloads.
+    :param encoding: The payload's encoding, 'zstd' or none.
+    :param data: The payload's bytes.
+    :return: The decompressed bytes.
+    """
+    if not encoding:
+        return data
+    if encoding != const.ENCODING_ZSTD:
+        raise ValueError(f"Unsupported payload encoding '{encoding}'")
+    import zstandard
+
+    return zstandard.ZstdDecompressor().decompress(
+        data, max_output_size=const.MAX_DECOMPRESSED_BYTES
+    )
+
+
+@dataclass(frozen=True)
+class Payload:
+    """
+    A task result with its content type, for results that are not plain
+    text. Task functions return it in place of a string, and the dispatcher
+    returns it to callers as bytes or in the format they ask for.
+    """
+
+    data: bytes
+    content_type: str = const.CONTENT_BINARY
+    compress: bool = False
+
+    @classmethod
+    def msgpack(cls, value, compress: bool = False) -> "Payload":
+        """
+        Create a msgpack payload. Needs msgpack, from the worker's 'binary'
+        extra.
+        :param value: The value to serialize.
+        :param compress: Whether to compress the payload with zstd.
+        :return: The payload.
+        """
+        import msgpack
+
+        return cls(msgpack.packb(value), const.CONTENT_MSGPACK, compress)
+
+    def encode(self) -> bytes:
+        """
+        Encode the payload as the dispatcher reads results: the result
+        envelope, "payload:<content type>[;zstd]\\n", then the bytes.
+        """
+        header = self.content_type
+        data = self.data
+        if self.compress:
+            import zstandard
+
+            header += ";" + const.ENCODING_ZSTD
+            data = zstandard.ZstdCompressor().compress(data)
+        return const.RESULT_PAYLOAD_PREFIX + f"{header}\n".encode() + data
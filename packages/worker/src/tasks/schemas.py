@@ -1,4 +1,9 @@
-from pydantic import Field, BaseModel
+import json
+from typing import Any, Literal
+from pydantic import Base64Bytes, Field, BaseModel
+
+from . import constants as const
+from .payload import decompress
 
 
 class TaskSchema(BaseModel):
@@ -11,10 +16,110 @@ class TaskSchema(BaseModel):
     label: str | None = Field(
         default=None, description="Label associated with the task, if any"
     )
+    labels: list[str] = Field(
+        default_factory=list,
+        description="Labels the task needs together, including the label",
+    )
     parameters_json: str = Field(
         description="JSON string containing the parameters for the task"
     )
+    parameters_ref: str | None = Field(
+        default=None,
+        description="Blob key of parameters the dispatcher offloaded, read "
+        "into parameters_json before the task runs",
+    )
+    parameters_data: Base64Bytes | None = Field(
+        default=None,
+        description="Binary parameters, in place of parameters_json, "
+        "base64 encoded on the wire",
+    )
+    parameters_type: str | None = Field(
+        default=None,
+        description="Content type of the binary parameters: "
+        "'application/json', 'application/msgpack' or "
+        "'application/octet-stream'",
+    )
+    parameters_encoding: str | None = Field(
+        default=None,
+        description="Compression of the binary parameters, 'zstd' if any",
+    )
     return_result: bool = Field(
         default=False,
         description="Flag indicating whether to return the result of the task",
     )
+    trace_context: dict[str, str] | None = Field(
+        default=None,
+        description="W3C trace context (traceparent / tracestate) of the "
+        "dispatch, used to continue the trace on the worker",
+    )
+
+    def all_labels(self) -> list[str]:
+        """
+        Get every label the task needs, from both the single label and the
+        labels list, without duplicates and with the single label first.
+        :return: List of labels.
+        """
+        out: list[str] = []
+        for label in [self.label, *self.labels]:
+            if label and label not in out:
+                out.append(label)
+        return out
+
+    def is_binary(self) -> bool:
+        """
+        Check whether the task has binary parameters, rather than JSON ones.
+        """
+        return bool(self.parameters_type or self.parameters_encoding)
+
+    def parameters_bytes(self) -> bytes:
+        """
+        Get the task's parameters as bytes, decompressed.
+        :return: The binary parameters, or the JSON parameters encoded.
+        """
+        if not self.is_binary():
+            return self.parameters_json.encode()
+        return decompress(self.parameters_encoding, self.parameters_data or b"")
+
+    def parameters(self) -> Any:
+        """
+        Decode the task's parameters by their content type: JSON and msgpack
+        parameters are deserialized, and others are returned as bytes.
+        Decoding msgpack needs msgpack, from the worker's 'binary' extra.
+        :return: The decoded parameters.
+        """
+        data = self.parameters_bytes()
+        content_type = self.parameters_type or const.CONTENT_JSON
+        if content_type == const.CONTENT_JSON:
+            return json.loads(data)
+        if content_type == const.CONTENT_MSGPACK:
+            import msgpack
+
+            return msgpack.unpackb(data)
+        return data
+
+    def trace_fields(self) -> dict[str, str]:
+        """
+        Get the trace and parent span IDs from the task's traceparent, for
+        binding to log records.
+        :return: Dictionary with 'trace_id' and 'parent_span_id', or empty if
+            the task has no valid trace context.
+        """
+        parent = (self.trace_context or {}).get("traceparent", "")
+        parts = parent.split("-")
+        if len(parts) != 4 or len(parts[1]) != 32 or len(parts[2]) != 16:
+            return {}
+        return {"trace_id": parts[1], "parent_span_id": parts[2]}
+
+
+class ControlSchema(BaseModel):
+    """
+    Schema for admin commands sent by the dispatcher on the worker's control
+    queue.
+    """
+
+    command: Literal["evict_label"] = Field(
+        description="Command to execute on the worker"
+    )
+    label: str | None = Field(
+        default=None, description="Label the command applies to, if any"
+    )
@@ -1,6 +1,9 @@
-from pydantic import Field
+from typing import Literal, Self
+from pydantic import Field, model_validator
 from pydantic_settings import BaseSettings, SettingsConfigDict
 
+from .constants import DEFAULT_NAMESPACE, NAMESPACE_PATTERN
+
 
 class WorkerSettings(BaseSettings):
     """
@@ -11,15 +14,130 @@ class WorkerSettings(BaseSettings):
         default="localhost", description="Redis server host"
     )
     redis_port: int = Field(default=6379, description="Redis server port")
+    redis_db: int = Field(default=0, ge=0, description="Redis database number")
+    redis_username: str | None = Field(
+        default=None, description="Username for Redis ACL authentication"
+    )
+    redis_password: str | None = Field(
+        default=None, description="Password for Redis authentication"
+    )
+    redis_ssl: bool = Field(
+        default=False, description="Connect to Redis over TLS"
+    )
+    redis_ssl_ca_certs: str | None = Field(
+        default=None, description="CA bundle to verify the Redis server"
+    )
+    redis_ssl_certfile: str | None = Field(
+        default=None, description="Client certificate for mutual TLS"
+    )
+    redis_ssl_keyfile: str | None = Field(
+        default=None, description="Client key for mutual TLS"
+    )
+    redis_sentinels: list[str] = Field(
+        default_factory=list,
+        description="Sentinel addresses as host:port, used instead of the "
+        "Redis host and port to follow failovers of redis_master_name",
+    )
+    redis_master_name: str | None = Field(
+        default=None, description="Name of the master monitored by Sentinel"
+    )
+    redis_sentinel_password: str | None = Field(
+        default=None, description="Password for Sentinel authentication"
+    )
+    redis_cluster: bool = Field(
+        default=False,
+        description="Connect to a Redis Cluster through the Redis host and "
+        "port",
+    )
+    namespace: str = Field(
+        default=DEFAULT_NAMESPACE,
+        pattern=NAMESPACE_PATTERN,
+        description="Namespace of the keys, shared with the dispatcher of "
+        "the deployment",
+    )
     max_labels: int = Field(
         default=2,
         gt=0,
         description="Maximum number of labels to store per worker",
     )
+    memory_budget_bytes: int | None = Field(
+        default=None,
+        gt=0,
+        description="Memory in bytes the loaded labels may use, checked "
+        "against the label sizes registered on the dispatcher",
+    )
+    attributes: dict[str, str] = Field(
+        default_factory=dict,
+        description="Attributes published for the dispatcher, as a JSON "
+        'object such as {"gpu": "a100"}',
+    )
     result_ttl: int = Field(
         default=1800,  # 30 minutes
         gt=0,
         description="Time to live for results in seconds",
     )
+    poll_timeout: int = Field(
+        default=5,
+        gt=0,
+        description="Seconds to block waiting for a job before re-checking "
+        "whether the worker is draining",
+    )
+    blob_threshold_bytes: int = Field(
+        default=0,
+        ge=0,
+        description="Results larger than this many bytes are stored in the "
+        "blob store, and only a reference is published. 0 never offloads "
+        "results",
+    )
+    blob_backend: Literal["filesystem", "s3"] = Field(
+        default="filesystem",
+        description="Blob store shared with the dispatcher, for large "
+        "parameters and results",
+    )
+    blob_dir: str = Field(
+        default="/var/lib/task-runners/blobs",
+        description="Directory of the filesystem blob store",
+    )
+    blob_s3_endpoint: str | None = Field(
+        default=None,
+        description="URL of an S3-compatible API, or None for AWS S3",
+    )
+    blob_s3_bucket: str | None = Field(
+        default=None, description="Bucket of the S3 blob store"
+    )
+    blob_s3_region: str | None = Field(
+        default=None, description="Region of the S3 blob store"
+    )
+    blob_s3_access_key_id: str | None = Field(
+        default=None, description="Access key ID for the S3 blob store"
+    )
+    blob_s3_secret_access_key: str | None = Field(
+        default=None, description="Secret access key for the S3 blob store"
+    )
 
     model_config = SettingsConfigDict(env_prefix="WORKER_")
+
+    @model_validator(mode="after")
+    def check_redis_mode(self) -> Self:
+        """
+        Check that at most one of Sentinel and cluster mode is configured,
+        with everything it needs.
+        """
+        if bool(self.redis_sentinels) != bool(self.redis_master_name):
+            raise ValueError(
+                "redis_sentinels and redis_master_name must be set together"
+            )
+        if self.redis_master_name and self.redis_cluster:
+            raise ValueError("Sentinel and cluster mode are exclusive")
+        if self.redis_cluster and self.redis_db != 0:
+            raise ValueError("redis_db must be 0 on a cluster")
+        return self
+
+    @model_validator(mode="after")
+    def check_blob_store(self) -> Self:
+        """
+        Check that the S3 blob store has a bucket.
+        """
+        if self.blob_backend == "s3" and not self.blob_s3_bucket:
+            raise ValueError("blob_s3_bucket is required for the s3 backend")
+        return self
@@ -2,7 +2,7 @@ import time
 import random
 from loguru import logger
 
-from .util import acquire_label
+from .util import acquire_labels
 from .schemas import TaskSchema
 from .task_runner import get_runner
 from .label_handler import LabelHandler
@@ -20,7 +20,7 @@ def sample_task_1(lh: LabelHandler, task: TaskSchema) -> str:
     :return: A string indicating the task completion.
     """
     logger.info("Executing sample_task_1 with task_id: {}", task.task_id)
-    acquire_label(lh, task.label, task.task_id, lh.runner_uuid)
+    acquire_labels(lh, task.all_labels(), task.task_id, lh.runner_uuid)
 
     time.sleep(
         max(0.1, random.normalvariate(1.0, 0.5))
@@ -38,7 +38,7 @@ def sample_task_2(lh: LabelHandler, task: TaskSchema) -> str:
     :return: A string indicating the task completion.
     """
     logger.debug("Executing sample_task_2 with task_id: {}", task.task_id)
-    acquire_label(lh, task.label, task.task_id, lh.runner_uuid)
+    acquire_labels(lh, task.all_labels(), task.task_id, lh.runner_uuid)
 
     time.sleep(
         max(0.5, random.normalvariate(5.0, 1.0))
@@ -1,3 +1,4 @@
+import json
 import time
 import redis
 from uuid import uuid4
@@ -8,10 +9,14 @@ from functools import wraps, lru_cache
 from typing import ContextManager, Type, Optional, Iterator, Callable
 
 from . import exceptions as err
-from .schemas import TaskSchema
+from .schemas import TaskSchema, ControlSchema
 from . import constants as const
+from .keys import Keyspace
 from .settings import WorkerSettings
+from .connection import connect
 from .label_handler import LabelHandler
+from .blobs import BlobStore, create_blob_store
+from .payload import Payload
 
 
 TASK_TYPE = Callable[[LabelHandler, TaskSchema], ...]
@@ -27,19 +32,27 @@ class TaskRunner(ContextManager):
         Initialize the task runner with settings.
         """
         self.__settings = settings or WorkerSettings()
-        self.__redis = redis.Redis(
-            host=self.__settings.redis_host,
-            port=self.__settings.redis_port,
-            decode_responses=True,
-        )
+        self.__redis = connect(self.__settings)
         self.__uuid = str(uuid4())
+        self.__keys = Keyspace(self.__settings.namespace)
         self.__label_handler = LabelHandler(
             runner_uuid=self.uuid,
             redis_client=self.__redis,
             max_labels=self.__settings.max_labels,
+            memory_budget=self.__settings.memory_budget_bytes,
+            keys=self.__keys,
+        )
+        self.__queue = self.__keys.job_queue(self.uuid)
+        self.__control_queue = self.__keys.control_queue(self.uuid)
+        self.__info_key = self.__keys.worker_info(self.uuid)
+        pool = self.__settings.attributes.get(const.POOL_ATTRIBUTE)
+        self.__common_queue = (
+            self.__keys.pool_queue(pool)
+            if pool
+            else self.__keys.common_queue
         )
-        self.__queue = f"task-runners:{self.uuid}:jobs"
         self.__task_handlers: dict[str, TASK_TYPE] = {}
+        self.__blobs = create_blob_store(self.__settings)
 
     @property
     def uuid(self) -> str:
@@ -48,6 +61,21 @@ class TaskRunner(ContextManager):
         """
         return self.__uuid
 
+    @property
+    def keys(self) -> Keyspace:
+        """
+        Get the key builder of the task runner's namespace.
+        """
+        return self.__keys
+
+    @property
+    def common_queue(self) -> str:
+        """
+        Get the common queue of the task runner's pool, shared by every
+        task runner in the pool.
+        """
+        return self.__common_queue
+
     @property
     def label_handler(self) -> LabelHandler:
         """
@@ -55,6 +83,13 @@ class TaskRunner(ContextManager):
         """
         return self.__label_handler
 
+    @property
+    def blobs(self) -> BlobStore:
+        """
+        Get the blob store shared with the dispatcher.
+        """
+        return self.__blobs
+
     def __enter__(self):
         """
         Enter the runtime context related to this object.
@@ -86,7 +121,9 @@ class TaskRunner(ContextManager):
         Register task runner on redis.
         """
         self.update_availability(True)
-        self.__redis.sadd(const.REGISTER_KEY, self.uuid)
+        self.__redis.sadd(self.__keys.running, self.uuid)
+        self.publish_info()
+        self.record_activity()
         logger.info("Task runner registered [{}]", self.uuid)
 
     def deregister(self):
@@ -94,19 +131,122 @@ class TaskRunner(ContextManager):
         Deregister task runner from redis.
         """
         self.update_availability(False)
-        self.__redis.srem(const.REGISTER_KEY, self.uuid)
+        self.__redis.srem(self.__keys.running, self.uuid)
+        self.__redis.hdel(self.__keys.last_activity, self.uuid)
+        self.__redis.srem(self.__keys.draining, self.uuid)
+        self.__redis.delete(self.__control_queue)
+        self.__redis.delete(self.__info_key)
         self.label_handler.clear_all()
         logger.info("Task runner deregistered [{}]", self.uuid)
 
+    def publish_info(self):
+        """
+        Publish the task runner's capacity and attributes, so the dispatcher
+        can check each worker against its own limits.
+        """
+        info = {
+            "max_labels": self.__settings.max_labels,
+            "attributes": json.dumps(self.__settings.attributes),
+        }
+        if self.__settings.memory_budget_bytes is not None:
+            info["memory_budget_bytes"] = self.__settings.memory_budget_bytes
+        self.__redis.hset(self.__info_key, mapping=info)
+
     def update_availability(self, available: bool = True):
         """
         Mark the task runner as available.
         :param available: Whether the task runner is available or not.
         """
         if available:
-            self.__redis.sadd(const.AVAILABLE_KEY, self.uuid)
+            self.__redis.sadd(self.__keys.available, self.uuid)
+        else:
+            self.__redis.srem(self.__keys.available, self.uuid)
+
+    def record_activity(self):
+        """
+        Record the current time as the task runner's last activity, for the
+        dispatcher's introspection API.
+        """
+        self.__redis.hset(self.__keys.last_activity, self.uuid, time.time())
+
+    def record_status(
+        self, task: TaskSchema, status: str, result: str | bytes | None = None
+    ):
+        """
+        Record the status of a task, for the dispatcher's task status API.
+        The record expires after the result TTL.
+        :param task: The task being executed.
+        :param status: One of 'running', 'completed' or 'failed'.
+        :param result: The task result, or the error of a failed task.
+        """
+        key = self.__keys.task_status(task.task_id)
+        mapping = {"status": status, "worker": self.uuid}
+        if result is not None:
+            mapping["result"] = (
+                result if isinstance(result, bytes) else str(result)
+            )
+        pipe = self.__redis.pipeline()
+        pipe.hset(key, mapping=mapping)
+        pipe.expire(key, self.__settings.result_ttl)
+        pipe.execute()
+
+    def load_parameters(self, task: TaskSchema) -> TaskSchema:
+        """
+        Read the parameters the dispatcher offloaded to the blob store, into
+        the binary parameters when the task has a content type or encoding.
+        :param task: The task, possibly with a parameters reference.
+        :return: The task with its parameters.
+        """
+        if not task.parameters_ref:
+            return task
+        data = self.__blobs.get(task.parameters_ref)
+        if task.is_binary():
+            update = {"parameters_data": data, "parameters_ref": None}
         else:
-            self.__redis.srem(const.AVAILABLE_KEY, self.uuid)
+            update = {"parameters_json": data.decode(), "parameters_ref": None}
+        return task.model_copy(update=update)
+
+    def offload_result(self, result) -> str | bytes | None:
+        """
+        Store a result larger than the blob threshold in the blob store.
+        Payload results are encoded with their content type first.
+        :param result: The task result.
+        :return: What to publish and record for the result: the result
+            itself, or a reference the dispatcher resolves.
+        """
+        threshold = self.__settings.blob_threshold_bytes
+        if result is None:
+            return None
+        if isinstance(result, Payload):
+            result = result.encode()
+        data = result if isinstance(result, bytes) else str(result).encode()
+        if not threshold or len(data) <= threshold:
+            return result if isinstance(result, bytes) else str(result)
+        key = f"{self.__settings.namespace}/{uuid4().hex}"
+        self.__blobs.put(key, data)
+        return const.BLOB_REF_PREFIX + key.encode()
+
+    def is_draining(self) -> bool:
+        """
+        Check whether the dispatcher has marked this task runner as draining.
+        A draining runner only works through its own queue, and stops taking
+        tasks from the common queue.
+        """
+        return bool(self.__redis.sismember(self.__keys.draining, self.uuid))
+
+    def handle_control(self, command_raw: str):
+        """
+        Execute an admin command received on the control queue.
+        :param command_raw: JSON encoded command.
+        """
+        command = ControlSchema.model_validate_json(command_raw)
+        if command.command == "evict_label" and command.label:
+            loaded_at = self.label_handler.remove_label(command.label)
+            logger.info(
+                "Evicted label [{}] on admin request (loaded: {})",
+                command.label,
+                loaded_at is not None,
+            )
 
     def get_task_handler(self, task_type: str) -> TASK_TYPE:
         """
@@ -128,9 +268,18 @@ class TaskRunner(ContextManager):
         while True:
             task = None
             try:
-                queue, task_raw = self.__redis.blpop(
-                    [self.__queue, const.COMMON_QUEUE], timeout=0
+                queues = [self.__control_queue, self.__queue]
+                if not self.is_draining():
+                    queues.append(self.__common_queue)
+                popped = self.__redis.blpop(
+                    queues, timeout=self.__settings.poll_timeout
                 )
+                if not popped:
+                    continue
+                queue, task_raw = popped
+                if queue == self.__control_queue:
+                    self.handle_control(task_raw)
+                    continue
                 logger.info("Received task from queue [{}]", queue)
                 if not task_raw:
                     continue
@@ -193,27 +342,38 @@ class TaskRunner(ContextManager):
                 start = time.perf_counter()
                 try:
                     self.update_availability(False)
-                    result = func(lh, task)
+                    self.record_status(task, "running")
+                    task = self.load_parameters(task)
+                    with logger.contextualize(**task.trace_fields()):
+                        result = func(lh, task)
+                    published = self.offload_result(result)
+                    self.record_status(task, "completed", published)
                 except Exception as e:
                     logger.error(
                         "Error while executing task [{}]: {}",
                         task_type,
                         e,
                     )
+                    self.record_status(task, "failed", str(e))
                     raise err.TaskFailedError(
                         f"Task [{task.task_id}] failed with error: {e}"
                     )
                 finally:
                     self.update_availability(True)
+                    self.record_activity()
 
                 if task.return_result:
                     self.__redis.publish(
-                        f"task-runners:results:{task.task_id}",
-                        result,
+                        self.__keys.result_channel(task.task_id),
+                        published,
                     )
 
                 end = time.perf_counter()
-                logger.bind(task_id=task.task_id, worker_id=self.uuid).info(
+                logger.bind(
+                    task_id=task.task_id,
+                    worker_id=self.uuid,
+                    **task.trace_fields(),
+                ).info(
                     "Task completed in {:.6f} seconds",
                     end - start,
                 )
@@ -35,3 +35,27 @@ def acquire_label(
     time.sleep(duration)
     lh.add_label(label)
     return False
+
+
+def acquire_labels(
+    lh: LabelHandler,
+    labels: list[str],
+    task_id: str | None = None,
+    worker_id: str | None = None,
+) -> bool:
+    """
+    Function to 'acquire' all the labels a task needs. The labels already
+    held are refreshed first, so loading the others does not evict them.
+    :param lh: LabelHandler instance for managing labels.
+    :param labels: Labels to acquire.
+    :param task_id: Optional task ID for logging purposes.
+    :param worker_id: Optional worker ID for logging purposes.
+    :return: True if all the labels were already acquired.
+    """
+    held = [label for label in labels if lh.has_label(label)]
+    for label in held:
+        lh.add_label(label)
+    for label in labels:
+        if label not in held:
+            acquire_label(lh, label, task_id, worker_id)
+    return len(held) == len(labels)
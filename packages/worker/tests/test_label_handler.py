@@ -1,7 +1,9 @@
 import pytest
 
 from tasks.label_handler import LabelHandler
-from tasks.constants import LABEL_KEY_FMT as LKM
+from tasks.keys import Keyspace
+
+KEYS = Keyspace()
 
 
 @pytest.fixture(scope="session")
@@ -63,7 +65,7 @@ def test_add_label(label_handler):
         f"Label '{label}' should be added to the handler."
     )
     assert len(label_handler) == 1, "There should be one label in the handler."
-    # assert label_handler.redis.sismember(LKM.format(label=label), label), f"Label '{label}' should be in Redis set."
+    # assert label_handler.redis.sismember(KEYS.label(label), label), f"Label '{label}' should be in Redis set."
 
     label2 = "label-2"
     label_handler.add_label(label2)
@@ -149,7 +151,7 @@ def test_add_label_register(live_handler):
         "There should be one label in the live handler."
     )
     assert live_handler.redis.sismember(
-        LKM.format(label=label), live_handler.runner_uuid
+        KEYS.label(label), live_handler.runner_uuid
     ), f"Label '{label}' should be registered in Redis set."
 
 
@@ -169,13 +171,13 @@ def test_remove_label_register(live_handler):
         "Label 'live-label-1' should still exist after removing 'live-label-2'."
     )
     assert not live_handler.redis.sismember(
-        LKM.format(label="live-label-2"), live_handler.runner_uuid
+        KEYS.label("live-label-2"), live_handler.runner_uuid
     ), (
         "Label 'live-label-2' should not be registered in Redis set after removal."
     )
 
     assert live_handler.redis.sismember(
-        LKM.format(label="live-label-1"), live_handler.runner_uuid
+        KEYS.label("live-label-1"), live_handler.runner_uuid
     ), (
         "Label 'live-label-1' should still be registered in Redis set after removal of 'live-label-2'."
     )
@@ -195,7 +197,7 @@ def test_label_limit_redis(live_handler):
     )
     for i in range(4):
         label = f"live-label-{i}"
-        key = LKM.format(label=label)
+        key = KEYS.label(label)
         assert not live_handler.redis.sismember(
             key, live_handler.runner_uuid
         ), (
@@ -204,12 +206,75 @@ def test_label_limit_redis(live_handler):
 
     label = "live-label-4"
     assert live_handler.redis.sismember(
-        LKM.format(label=label), live_handler.runner_uuid
+        KEYS.label(label), live_handler.runner_uuid
     ), (
         f"Label '{label}' should be registered in Redis set as the second most recent."
     )
 
     label = "live-label-5"
     assert live_handler.redis.sismember(
-        LKM.format(label=label), live_handler.runner_uuid
+        KEYS.label(label), live_handler.runner_uuid
     ), f"Label '{label}' should be registered in Redis set as the most recent."
+
+
+def test_memory_budget(redis_client):
+    """
+    Test that labels are evicted, oldest first, to keep the loaded labels
+    within the memory budget.
+    """
+    handler = LabelHandler(
+        redis_client=redis_client,
+        max_labels=10,
+        memory_budget=10,
+        runner_uuid="unit-test",
+    )
+    redis_client.hset(
+        KEYS.label_sizes, mapping={"small": 2, "medium": 4, "large": 7}
+    )
+    handler.add_label("small")
+    handler.add_label("medium")
+    assert handler.memory_used == 6, "Both labels should fit the budget."
+
+    handler.add_label("small")
+    handler.add_label("large")
+    assert not handler.has_label("medium"), (
+        "The least recently used label should be evicted."
+    )
+    assert handler.has_label("small") and handler.has_label("large")
+    assert handler.memory_used == 9
+    assert redis_client.zrange(
+        KEYS.worker_labels("unit-test"), 0, -1
+    ) == [b"small", b"large"], "The published labels should be in LRU order."
+    assert redis_client.zscore(KEYS.label_counts, "unit-test") == 2, (
+        "Refreshing a label should not change the label count."
+    )
+    redis_client.flushdb()
+
+
+def test_acquire_labels(label_handler, monkeypatch):
+    """
+    Test that acquiring a set of labels keeps the ones already held, and
+    evicts other labels for the missing ones.
+    """
+    from tasks import util
+    from tasks.schemas import TaskSchema
+
+    monkeypatch.setattr(util.time, "sleep", lambda _: None)
+    task = TaskSchema(
+        task_id="t",
+        task_type="sample_task_1",
+        label="label-1",
+        labels=["label-3", "label-1"],
+        parameters_json="{}",
+    )
+    assert task.all_labels() == ["label-1", "label-3"]
+
+    label_handler.add_label("label-1")
+    label_handler.add_label("label-2")
+    assert not util.acquire_labels(label_handler, task.all_labels())
+    assert label_handler.has_label("label-1"), (
+        "A held label of the set should not be evicted."
+    )
+    assert label_handler.has_label("label-3")
+    assert not label_handler.has_label("label-2")
+    assert util.acquire_labels(label_handler, task.all_labels())
@@ -1,6 +1,5 @@
 import pytest
 
-from tasks import constants as const
 from tasks.task_runner import TaskRunner
 
 
@@ -37,28 +36,56 @@ def test_runner_startup_shutdown(runner, redis_client):
     Test the startup and shutdown of the TaskRunner.
     """
     with runner:
-        assert redis_client.sismember(const.REGISTER_KEY, runner.uuid), (
+        assert redis_client.sismember(runner.keys.running, runner.uuid), (
             "Runner should be registered in Redis"
         )
 
-    assert not redis_client.sismember(const.REGISTER_KEY, runner.uuid), (
+    assert not redis_client.sismember(runner.keys.running, runner.uuid), (
         "Runner should be unregistered after shutdown"
     )
 
 
+@pytest.mark.live_redis
+def test_runner_publish_info(redis_client):
+    """
+    Test that the runner publishes its capacity and attributes while it is
+    registered.
+    """
+    from tasks.settings import WorkerSettings
+
+    settings = WorkerSettings(
+        redis_host="localhost",
+        redis_port=6379,
+        max_labels=3,
+        attributes={"gpu": "a100"},
+    )
+    runner = TaskRunner(settings=settings)
+    key = runner.keys.worker_info(runner.uuid)
+    with runner:
+        info = redis_client.hgetall(key)
+        assert info["max_labels"] == "3"
+        assert info["attributes"] == '{"gpu": "a100"}'
+        assert "memory_budget_bytes" not in info
+
+    assert not redis_client.exists(key), (
+        "Runner info should be removed after shutdown"
+    )
+    redis_client.flushdb()
+
+
 @pytest.mark.live_redis
 def test_runner_label_register(runner, redis_client):
     """
     Test the label registration functionality of the TaskRunner.
     """
     with runner:
-        assert redis_client.sismember(const.REGISTER_KEY, runner.uuid), (
+        assert redis_client.sismember(runner.keys.running, runner.uuid), (
             "Runner should be registered in Redis"
         )
 
         label = "test-label"
         runner.label_handler.add_label(label)
-        key = const.LABEL_KEY_FMT.format(label=label)
+        key = runner.keys.label(label)
 
         assert redis_client.sismember(key, runner.uuid), (
             f"Runner UUID should be registered under label '{label}'"
@@ -71,14 +98,200 @@ def test_runner_label_deregister(runner, redis_client):
     Test the label deregistration functionality of the TaskRunner.
     """
     with runner:
-        assert redis_client.sismember(const.REGISTER_KEY, runner.uuid), (
+        assert redis_client.sismember(runner.keys.running, runner.uuid), (
             "Runner should be registered in Redis"
         )
 
         label = "test-label"
         runner.label_handler.add_label(label)
-        key = const.LABEL_KEY_FMT.format(label=label)
+        key = runner.keys.label(label)
 
     assert not redis_client.sismember(key, runner.uuid), (
         "Runner UUID should be deregistered from label on shutdown"
     )
+
+
+@pytest.mark.live_redis
+def test_runner_control_evict_label(runner, redis_client):
+    """
+    Test that an evict command on the control queue removes the label.
+    """
+    with runner:
+        label = "test-label"
+        runner.label_handler.add_label(label)
+        runner.handle_control(
+            '{"command": "evict_label", "label": "test-label"}'
+        )
+
+        assert not runner.label_handler.has_label(label)
+        assert not redis_client.sismember(
+            runner.keys.label(label), runner.uuid
+        ), "Runner UUID should be deregistered from the evicted label"
+
+
+@pytest.mark.live_redis
+def test_runner_draining(runner, redis_client):
+    """
+    Test that the runner reports draining, and clears it on shutdown.
+    """
+    with runner:
+        assert not runner.is_draining()
+        redis_client.sadd(runner.keys.draining, runner.uuid)
+        assert runner.is_draining()
+
+    assert not redis_client.sismember(runner.keys.draining, runner.uuid)
+
+
+def test_runner_common_queue():
+    """
+    Test that runners in a pool read the pool's common queue, and the others
+    the default one.
+    """
+    from tasks.settings import WorkerSettings
+
+    pooled = TaskRunner(
+        settings=WorkerSettings(attributes={"pool": "highmem", "gpu": "a100"})
+    )
+    assert pooled.common_queue == "{task-runners}:pool:highmem:jobs"
+    default = TaskRunner(settings=WorkerSettings(attributes={"gpu": "a100"}))
+    assert default.common_queue == "{task-runners}:all:jobs"
+
+
+def test_redis_modes():
+    """
+    Test that Sentinel settings connect through a Sentinel-managed pool, and
+    that conflicting Redis modes are rejected.
+    """
+    from pydantic import ValidationError
+    from redis.sentinel import SentinelConnectionPool
+    from tasks.connection import connect
+    from tasks.settings import WorkerSettings
+
+    settings = WorkerSettings(
+        redis_sentinels=["sentinel-1:26379", "sentinel-2:26379"],
+        redis_master_name="tasks",
+    )
+    client = connect(settings)
+    assert isinstance(client.connection_pool, SentinelConnectionPool)
+    assert client.connection_pool.service_name == "tasks"
+
+    with pytest.raises(ValidationError):
+        WorkerSettings(redis_master_name="tasks")
+    with pytest.raises(ValidationError):
+        WorkerSettings(redis_cluster=True, redis_db=1)
+
+
+def test_runner_namespace():
+    """
+    Test that every key of a runner is in its namespace, so that deployments
+    with different namespaces can share one Redis.
+    """
+    from pydantic import ValidationError
+    from tasks.settings import WorkerSettings
+
+    staging = TaskRunner(
+        settings=WorkerSettings(
+            namespace="staging", attributes={"pool": "highmem"}
+        )
+    )
+    assert staging.common_queue == "{staging}:pool:highmem:jobs"
+    assert staging.keys.label("model-a") == "{staging}:labels:model-a:workers"
+    prod = TaskRunner(settings=WorkerSettings(namespace="prod"))
+    assert prod.common_queue == "{prod}:all:jobs"
+    assert prod.keys.worker_info(prod.uuid) == f"{{prod}}:{prod.uuid}:info"
+
+    with pytest.raises(ValidationError):
+        WorkerSettings(namespace="tenant:*")
+
+
+def test_runner_blobs(tmp_path):
+    """
+    Test that offloaded parameters are read from the blob store, and that
+    results above the threshold are offloaded and replaced by a reference.
+    """
+    from pydantic import ValidationError
+    from tasks.schemas import TaskSchema
+    from tasks.settings import WorkerSettings
+
+    runner = TaskRunner(
+        settings=WorkerSettings(blob_dir=str(tmp_path), blob_threshold_bytes=8)
+    )
+    runner.blobs.put("task-runners/params", b'{"text": "large"}')
+    task = TaskSchema(
+        task_id="t1",
+        task_type="echo",
+        parameters_json="",
+        parameters_ref="task-runners/params",
+    )
+    loaded = runner.load_parameters(task)
+    assert loaded.parameters_json == '{"text": "large"}'
+    assert loaded.parameters_ref is None
+
+    assert runner.offload_result("small") == "small"
+    # Text results are published as they are, even when they look like a reference
+    assert runner.offload_result("blob:notes") == "blob:notes"
+    ref = runner.offload_result("a result above the threshold")
+    assert ref.startswith(b"\xff\x01blob:task-runners/")
+    assert runner.blobs.get(ref.removeprefix(b"\xff\x01blob:").decode()) == (
+        b"a result above the threshold"
+    )
+
+    with pytest.raises(ValueError):
+        runner.blobs.get("../outside")
+    with pytest.raises(ValidationError):
+        WorkerSettings(blob_backend="s3")
+
+
+def test_runner_payloads(tmp_path):
+    """
+    Test that binary parameters are decoded by their content type, from the
+    task or a blob, and that payload results are encoded with theirs.
+    """
+    msgpack = pytest.importorskip("msgpack")
+    zstandard = pytest.importorskip("zstandard")
+    from base64 import b64encode
+    from tasks.payload import Payload
+    from tasks.schemas import TaskSchema
+    from tasks.settings import WorkerSettings
+
+    packed = msgpack.packb({"image": b"\x00\x01"})
+    compressed = zstandard.ZstdCompressor().compress(packed)
+    task = TaskSchema.model_validate(
+        {
+            "task_id": "t1",
+            "task_type": "embed",
+            "parameters_json": "",
+            "parameters_data": b64encode(compressed).decode(),
+            "parameters_type": "application/msgpack",
+            "parameters_encoding": "zstd",
+        }
+    )
+    assert task.parameters() == {"image": b"\x00\x01"}
+    assert TaskSchema(
+        task_id="t2", task_type="embed", parameters_json='{"a": 1}'
+    ).parameters() == {"a": 1}
+
+    runner = TaskRunner(
+        settings=WorkerSettings(blob_dir=str(tmp_path), blob_threshold_bytes=64)
+    )
+    runner.blobs.put("task-runners/params", b"\xff\x00")
+    blob_task = TaskSchema(
+        task_id="t3",
+        task_type="embed",
+        parameters_json="",
+        parameters_ref="task-runners/params",
+        parameters_type="application/octet-stream",
+    )
+    assert runner.load_parameters(blob_task).parameters() == b"\xff\x00"
+
+    assert (
+        runner.offload_result(Payload(b"\x01\x02"))
+        == b"\xff\x01payload:application/octet-stream\n\x01\x02"
+    )
+    header, data = runner.offload_result(
+        Payload.msgpack([0.5], compress=True)
+    ).split(b"\n", 1)
+    assert header == b"\xff\x01payload:application/msgpack;zstd"
+    assert msgpack.unpackb(zstandard.ZstdDecompressor().decompress(data)) == [
+        0.5
+    ]